@@ -20,6 +20,7 @@ func init() {
 	}
 
 	config.InitDatabase()
+	config.InitRedis()
 }
 
 func main() {
@@ -54,6 +55,7 @@ func main() {
 		<-c
 		fmt.Println("\nGracefully shutting down Transit Backend...")
 		config.ShutdownDatabase()
+		config.ShutdownRedis()
 		if err := app.Shutdown(); err != nil {
 			log.Printf("Error during shutdown: %v", err)
 		}
@@ -0,0 +1,62 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const kafkaDialTimeout = 10 * time.Second
+
+type KafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+// NewKafkaPublisher connects to the cluster once so a wrong broker list
+// fails at startup instead of on the first event.
+func NewKafkaPublisher(brokers []string, clientID string) (*KafkaPublisher, error) {
+	transport := &kafka.Transport{
+		ClientID:    clientID,
+		DialTimeout: kafkaDialTimeout,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaDialTimeout)
+	defer cancel()
+
+	client := &kafka.Client{Addr: kafka.TCP(brokers...), Transport: transport}
+	if _, err := client.Metadata(ctx, &kafka.MetadataRequest{}); err != nil {
+		return nil, fmt.Errorf("kafka: unable to reach brokers %v: %w", brokers, err)
+	}
+
+	return &KafkaPublisher{
+		writer: &kafka.Writer{
+			Addr:      kafka.TCP(brokers...),
+			Transport: transport,
+			// murmur2 matches the Java client's default partitioner, so
+			// events for a key land on the same partition either way.
+			Balancer:     &kafka.Murmur2Balancer{},
+			RequiredAcks: kafka.RequireAll,
+			BatchTimeout: 10 * time.Millisecond,
+		},
+	}, nil
+}
+
+func (p *KafkaPublisher) Publish(ctx context.Context, msg Message) error {
+	headers := make([]kafka.Header, 0, len(msg.Headers))
+	for k, v := range msg.Headers {
+		headers = append(headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+
+	return p.writer.WriteMessages(ctx, kafka.Message{
+		Topic:   msg.Topic,
+		Key:     []byte(msg.Key),
+		Value:   msg.Value,
+		Headers: headers,
+	})
+}
+
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
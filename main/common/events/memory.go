@@ -0,0 +1,48 @@
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+const maxMemoryMessages = 1000
+
+// MemoryPublisher keeps the most recent published messages in memory. It is
+// used when Kafka is disabled and in local development.
+type MemoryPublisher struct {
+	mu       sync.RWMutex
+	messages []Message
+}
+
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+func (p *MemoryPublisher) Publish(_ context.Context, msg Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.messages = append(p.messages, msg)
+	if len(p.messages) > maxMemoryMessages {
+		p.messages = p.messages[len(p.messages)-maxMemoryMessages:]
+	}
+	return nil
+}
+
+// Messages returns a copy of every message published to topic.
+func (p *MemoryPublisher) Messages(topic string) []Message {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var out []Message
+	for _, msg := range p.messages {
+		if msg.Topic == topic {
+			out = append(out, msg)
+		}
+	}
+	return out
+}
+
+func (p *MemoryPublisher) Close() error {
+	return nil
+}
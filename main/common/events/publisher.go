@@ -0,0 +1,41 @@
+package events
+
+import (
+	"context"
+	"strings"
+
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+)
+
+// Message is a single keyed event bound for a topic.
+type Message struct {
+	Topic   string
+	Key     string
+	Value   []byte
+	Headers map[string]string
+}
+
+type Publisher interface {
+	Publish(ctx context.Context, msg Message) error
+	Close() error
+}
+
+// NewPublisherFromEnv returns a Kafka publisher when FEATURE_KAFKA is enabled
+// and the in-memory publisher otherwise. When Kafka is enabled but can't be
+// set up it returns an error rather than silently dropping events.
+func NewPublisherFromEnv() (Publisher, error) {
+	if utils.GetENV("FEATURE_KAFKA", "false") != "true" {
+		logger.Log.Info("Kafka disabled, using in-memory event publisher")
+		return NewMemoryPublisher(), nil
+	}
+
+	brokers := strings.Split(utils.GetENV("KAFKA_BROKER", "localhost:9092"), ",")
+	publisher, err := NewKafkaPublisher(brokers, utils.GetENV("KAFKA_CLIENT_ID", "transit-backend"))
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Log.Info("Kafka event publisher configured for brokers %v", brokers)
+	return publisher, nil
+}
@@ -0,0 +1,24 @@
+package events
+
+import "testing"
+
+func TestNewPublisherFromEnvFailsWhenKafkaIsUnreachable(t *testing.T) {
+	t.Setenv("FEATURE_KAFKA", "true")
+	t.Setenv("KAFKA_BROKER", "127.0.0.1:1")
+
+	if publisher, err := NewPublisherFromEnv(); err == nil {
+		t.Fatalf("got %T, want an error", publisher)
+	}
+}
+
+func TestNewPublisherFromEnvWithoutKafka(t *testing.T) {
+	t.Setenv("FEATURE_KAFKA", "false")
+
+	publisher, err := NewPublisherFromEnv()
+	if err != nil {
+		t.Fatalf("NewPublisherFromEnv: %v", err)
+	}
+	if _, ok := publisher.(*MemoryPublisher); !ok {
+		t.Fatalf("got %T, want *MemoryPublisher", publisher)
+	}
+}
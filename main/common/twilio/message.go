@@ -1 +1,25 @@
-package twilio
\ No newline at end of file
+package twilio
+
+import "fmt"
+
+// Message is the part of Twilio's Message resource the API reads back.
+type Message struct {
+	SID          string  `json:"sid"`
+	Status       string  `json:"status"`
+	To           string  `json:"to"`
+	From         string  `json:"from"`
+	ErrorCode    *int    `json:"error_code"`
+	ErrorMessage *string `json:"error_message"`
+}
+
+// APIError is the error body Twilio returns with 4xx and 5xx responses.
+type APIError struct {
+	Status   int    `json:"status"`
+	Code     int    `json:"code"`
+	Message  string `json:"message"`
+	MoreInfo string `json:"more_info"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("twilio: %d %s (code %d)", e.Status, e.Message, e.Code)
+}
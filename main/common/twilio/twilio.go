@@ -1 +1,125 @@
-package twilio
\ No newline at end of file
+package twilio
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+)
+
+const (
+	defaultBaseURL = "https://api.twilio.com"
+	requestTimeout = 10 * time.Second
+)
+
+type Config struct {
+	AccountSID string
+	AuthToken  string
+	// From is the sending phone number in E.164 format.
+	From string
+	// BaseURL overrides the API host, e.g. to point at a stand-in server.
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// Client sends SMS through the Twilio Messages REST API.
+type Client struct {
+	accountSID string
+	authToken  string
+	from       string
+	baseURL    string
+	httpClient *http.Client
+}
+
+func NewClient(cfg Config) (*Client, error) {
+	if cfg.AccountSID == "" || cfg.AuthToken == "" || cfg.From == "" {
+		return nil, fmt.Errorf("twilio: account SID, auth token and sender number are required")
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = defaultBaseURL
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: requestTimeout}
+	}
+
+	return &Client{
+		accountSID: cfg.AccountSID,
+		authToken:  cfg.AuthToken,
+		from:       cfg.From,
+		baseURL:    strings.TrimRight(cfg.BaseURL, "/"),
+		httpClient: cfg.HTTPClient,
+	}, nil
+}
+
+// NewClientFromEnv returns a client when FEATURE_TWILIO is enabled and
+// the TWILIO_* settings are present, and nil otherwise.
+func NewClientFromEnv() *Client {
+	if utils.GetENV("FEATURE_TWILIO", "false") != "true" {
+		logger.Log.Info("Twilio disabled, SMS features are unavailable")
+		return nil
+	}
+
+	client, err := NewClient(Config{
+		AccountSID: utils.GetENV("TWILIO_ACCOUNT_SID", ""),
+		AuthToken:  utils.GetENV("TWILIO_AUTH_TOKEN", ""),
+		From:       utils.GetENV("TWILIO_PHONE_NUMBER", ""),
+		BaseURL:    utils.GetENV("TWILIO_API_URL", ""),
+	})
+	if err != nil {
+		logger.Log.Error("Failed to configure Twilio, SMS features are unavailable: %v", err)
+		return nil
+	}
+
+	logger.Log.Info("Twilio SMS configured")
+	return client
+}
+
+// SendSMS queues a text message to the E.164 number to.
+func (c *Client) SendSMS(ctx context.Context, to, body string) (*Message, error) {
+	form := url.Values{}
+	form.Set("To", to)
+	form.Set("From", c.from)
+	form.Set("Body", body)
+
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", c.baseURL, url.PathEscape(c.accountSID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.accountSID, c.authToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("twilio: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("twilio: reading response: %w", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		apiErr := &APIError{Status: resp.StatusCode}
+		if err := json.Unmarshal(data, apiErr); err != nil || apiErr.Message == "" {
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+		apiErr.Status = resp.StatusCode
+		return nil, apiErr
+	}
+
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("twilio: decoding response: %w", err)
+	}
+	return &msg, nil
+}
@@ -0,0 +1,73 @@
+package twilio
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSendSMSRequest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/2010-04-01/Accounts/AC123/Messages.json" {
+			t.Errorf("request = %s %s, want POST to the account's Messages resource", r.Method, r.URL.Path)
+		}
+		if sid, token, ok := r.BasicAuth(); !ok || sid != "AC123" || token != "secret" {
+			t.Errorf("basic auth = %q/%q, want the account SID and auth token", sid, token)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/x-www-form-urlencoded" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if r.PostForm.Get("To") != "+14155550123" || r.PostForm.Get("From") != "+15005550006" || r.PostForm.Get("Body") != "hello" {
+			t.Errorf("form = %v", r.PostForm)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"sid":"SM1","status":"queued","to":"+14155550123","from":"+15005550006"}`))
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(Config{AccountSID: "AC123", AuthToken: "secret", From: "+15005550006", BaseURL: srv.URL + "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := client.SendSMS(context.Background(), "+14155550123", "hello")
+	if err != nil {
+		t.Fatalf("SendSMS: %v", err)
+	}
+	if msg.SID != "SM1" || msg.Status != "queued" {
+		t.Errorf("message = %+v", msg)
+	}
+}
+
+func TestSendSMSAPIError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"status":400,"code":21211,"message":"The 'To' number is not a valid phone number."}`))
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(Config{AccountSID: "AC123", AuthToken: "secret", From: "+15005550006", BaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.SendSMS(context.Background(), "+1", "hello")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadRequest || apiErr.Code != 21211 {
+		t.Fatalf("SendSMS error = %v, want APIError 400/21211", err)
+	}
+}
+
+func TestNewClientRequiresCredentials(t *testing.T) {
+	if _, err := NewClient(Config{AccountSID: "AC123", From: "+15005550006"}); err == nil {
+		t.Fatal("NewClient accepted a config without an auth token")
+	}
+}
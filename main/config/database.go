@@ -4,9 +4,11 @@ import (
 	"sync"
 
 	"github.com/inlovewithgo/transit-backend/main/models"
+	"github.com/inlovewithgo/transit-backend/main/utils"
 	"github.com/inlovewithgo/transit-backend/pkg/db"
 	"github.com/inlovewithgo/transit-backend/pkg/logger"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var (
@@ -40,6 +42,29 @@ func runMigrations(db *gorm.DB) error {
 	err := db.AutoMigrate(
 		&models.User{},
 		&models.Waitlist{},
+		&models.WebhookSubscription{},
+		&models.WebhookDelivery{},
+		&models.Transaction{},
+		&models.OutboxMessage{},
+		&models.Wallet{},
+		&models.SpendingRule{},
+		&models.SpendingLimitOverride{},
+		&models.WithdrawalAddress{},
+		&models.RefreshToken{},
+		&models.Session{},
+		&models.RecoveryCode{},
+		&models.PasswordResetToken{},
+		&models.Role{},
+		&models.Permission{},
+		&models.APIKey{},
+		&models.UserIdentity{},
+		&models.MagicLinkToken{},
+		&models.EmailChangeRequest{},
+		&models.DataExport{},
+		&models.WebAuthnCredential{},
+		&models.WebAuthnChallenge{},
+		&models.AuditEvent{},
+		&models.SMSCode{},
 		// Add other models here as you create them
 	)
 
@@ -47,6 +72,18 @@ func runMigrations(db *gorm.DB) error {
 		return err
 	}
 
+	if err := seedRoles(db); err != nil {
+		return err
+	}
+
+	if err := dropUserEmailIndex(db); err != nil {
+		return err
+	}
+
+	if err := protectAuditLog(db); err != nil {
+		return err
+	}
+
 	logger.Log.Info("Database migrations completed successfully")
 	return nil
 }
@@ -64,3 +101,70 @@ func ShutdownDatabase() {
 		DB = nil
 	}
 }
+
+// dropUserEmailIndex removes the unique index that also covered soft-deleted
+// accounts. Emails are now only unique among live accounts, through the
+// partial index AutoMigrate has already created, so a deleted account's
+// address can be used again.
+func dropUserEmailIndex(db *gorm.DB) error {
+	return db.Exec(`DROP INDEX IF EXISTS idx_users_email`).Error
+}
+
+// protectAuditLog makes audit_events append-only for the application's
+// database role: updates, deletes and truncates raise an error.
+func protectAuditLog(db *gorm.DB) error {
+	return db.Exec(`
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only
+	BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_events
+	FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
+`).Error
+}
+
+// seedRoles makes sure the built-in roles exist with at least their default
+// permissions. ADMIN_BOOTSTRAP_EMAIL promotes an existing account to admin so
+// a fresh deployment has someone who can assign roles.
+func seedRoles(db *gorm.DB) error {
+	for _, name := range models.AllPermissions {
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Permission{Name: name}).Error; err != nil {
+			return err
+		}
+	}
+
+	for name, permissionNames := range models.DefaultRolePermissions {
+		role := models.Role{Name: name}
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&role).Error; err != nil {
+			return err
+		}
+
+		if len(permissionNames) == 0 {
+			continue
+		}
+
+		permissions := make([]models.Permission, len(permissionNames))
+		for i, permission := range permissionNames {
+			permissions[i] = models.Permission{Name: permission}
+		}
+		if err := db.Model(&role).Association("Permissions").Append(permissions); err != nil {
+			return err
+		}
+	}
+
+	if email := utils.GetENV("ADMIN_BOOTSTRAP_EMAIL", ""); email != "" {
+		result := db.Model(&models.User{}).Where("email = ? AND role <> ?", email, models.RoleAdmin).Update("role", models.RoleAdmin)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			logger.Log.Info("Granted admin role to %s", email)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+)
+
+var (
+	Redis     *redis.Client
+	redisOnce sync.Once
+)
+
+// InitRedis connects the shared Redis client. Redis is optional: when it is
+// unreachable Redis stays nil and callers fall back to degraded behaviour.
+func InitRedis() {
+	redisOnce.Do(func() {
+		rdb := redis.NewClient(&redis.Options{
+			Addr:     utils.GetENV("REDIS_ADDR", "localhost:6379"),
+			Password: utils.GetENV("REDIS_PASSWORD", ""),
+			DB:       0,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		if err := rdb.Ping(ctx).Err(); err != nil {
+			logger.Log.Error("Failed to connect to Redis: %v", err)
+			rdb.Close()
+			return
+		}
+
+		Redis = rdb
+		logger.Log.Info("Connected to Redis successfully")
+	})
+}
+
+func GetRedis() *redis.Client {
+	InitRedis()
+	return Redis
+}
+
+func ShutdownRedis() {
+	if Redis != nil {
+		if err := Redis.Close(); err != nil {
+			logger.Log.Error("Failed to close Redis connection: %v", err)
+		}
+		Redis = nil
+	}
+}
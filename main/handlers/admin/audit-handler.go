@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/inlovewithgo/transit-backend/main/middlewares"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"github.com/inlovewithgo/transit-backend/main/service"
+)
+
+type AuditHandler struct {
+	audit *service.AuditService
+}
+
+func NewAuditHandler(audit *service.AuditService) *AuditHandler {
+	return &AuditHandler{
+		audit: audit,
+	}
+}
+
+// SearchEvents handles GET /api/v1/admin/audit
+func (h *AuditHandler) SearchEvents(c *fiber.Ctx) error {
+	filter := models.AuditEventFilter{
+		UserID:    uint(c.QueryInt("user_id", 0)),
+		ActorID:   uint(c.QueryInt("actor_id", 0)),
+		Action:    c.Query("action"),
+		IPAddress: c.Query("ip"),
+		RequestID: c.Query("request_id"),
+		Limit:     c.QueryInt("limit", 50),
+		Offset:    c.QueryInt("offset", 0),
+	}
+	if filter.Limit < 1 || filter.Limit > 100 {
+		filter.Limit = 50
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	for param, bound := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+				Error:   "Invalid request",
+				Message: "from and to must be RFC 3339 timestamps",
+			})
+		}
+		*bound = &t
+	}
+
+	events, err := h.audit.Search(filter)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"events": events,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+	})
+}
+
+// VerifyChain handles GET /api/v1/admin/audit/verify
+func (h *AuditHandler) VerifyChain(c *fiber.Ctx) error {
+	report, err := h.audit.VerifyChain()
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(report)
+}
+
+// recordAdminAction audits a change made by an operator. userID is the
+// affected account, if any.
+func recordAdminAction(c *fiber.Ctx, audit *service.AuditService, userID *uint, action, target string, changes map[string]interface{}) {
+	actorID, actorType := middlewares.GetAuditActor(c)
+	audit.Record(middlewares.GetClientInfo(c), &models.AuditEvent{
+		ActorID:   actorID,
+		ActorType: actorType,
+		UserID:    userID,
+		Action:    action,
+		Target:    target,
+		Changes:   changes,
+	})
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/inlovewithgo/transit-backend/main/middlewares"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"github.com/inlovewithgo/transit-backend/main/service"
+)
+
+type LimitsHandler struct {
+	spendingPolicy *service.SpendingPolicyService
+	audit          *service.AuditService
+}
+
+func NewLimitsHandler(spendingPolicy *service.SpendingPolicyService, audit *service.AuditService) *LimitsHandler {
+	return &LimitsHandler{
+		spendingPolicy: spendingPolicy,
+		audit:          audit,
+	}
+}
+
+// ListRules handles GET /api/v1/admin/limits/rules
+func (h *LimitsHandler) ListRules(c *fiber.Ctx) error {
+	rules, err := h.spendingPolicy.ListRules()
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to list spending rules",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"rules": rules,
+	})
+}
+
+// UpsertRule handles PUT /api/v1/admin/limits/rules
+func (h *LimitsHandler) UpsertRule(c *fiber.Ctx) error {
+	var rule models.SpendingRule
+	if err := c.BodyParser(&rule); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide valid JSON data",
+		})
+	}
+	rule.ID = 0
+
+	if err := h.spendingPolicy.UpsertRule(&rule); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid spending rule",
+			Message: err.Error(),
+		})
+	}
+
+	recordAdminAction(c, h.audit, nil, models.AuditActionAdminLimitRule, models.AuditTarget("spending_rule", rule.ID), map[string]interface{}{
+		"tier":           rule.Tier,
+		"currency":       rule.Currency,
+		"scope":          rule.Scope,
+		"period":         rule.Period,
+		"max_amount":     rule.MaxAmount,
+		"max_fiat_cents": rule.MaxFiatCents,
+	})
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"rule": rule,
+	})
+}
+
+// CreateOverride handles POST /api/v1/admin/limits/overrides
+func (h *LimitsHandler) CreateOverride(c *fiber.Ctx) error {
+	var req models.SpendingLimitOverrideRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide valid JSON data",
+		})
+	}
+
+	var actor string
+	if claims, ok := middlewares.GetClaims(c); ok {
+		actor = fmt.Sprintf("user:%d", claims.UserID)
+	}
+
+	override, err := h.spendingPolicy.CreateOverride(&req, actor)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid override",
+			Message: err.Error(),
+		})
+	}
+
+	recordAdminAction(c, h.audit, &override.UserID, models.AuditActionAdminOverrideAdd, models.AuditTarget("spending_override", override.ID), map[string]interface{}{
+		"wallet_id":      override.WalletID,
+		"scope":          override.Scope,
+		"period":         override.Period,
+		"max_amount":     override.MaxAmount,
+		"max_fiat_cents": override.MaxFiatCents,
+		"reason":         override.Reason,
+		"expires_at":     override.ExpiresAt,
+	})
+
+	return c.Status(http.StatusCreated).JSON(fiber.Map{
+		"override": override,
+	})
+}
+
+// DeleteOverride handles DELETE /api/v1/admin/limits/overrides/:id
+func (h *LimitsHandler) DeleteOverride(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: "Invalid override ID",
+		})
+	}
+
+	if err := h.spendingPolicy.DeleteOverride(uint(id)); err != nil {
+		return c.Status(http.StatusNotFound).JSON(models.ErrorResponse{
+			Error:   "Override not found",
+			Message: err.Error(),
+		})
+	}
+
+	recordAdminAction(c, h.audit, nil, models.AuditActionAdminOverrideDel, models.AuditTarget("spending_override", id), nil)
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Override deleted",
+	})
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"github.com/inlovewithgo/transit-backend/main/service"
+)
+
+type TransactionsHandler struct {
+	transactionService *service.TransactionService
+	audit              *service.AuditService
+}
+
+func NewTransactionsHandler(transactionService *service.TransactionService, audit *service.AuditService) *TransactionsHandler {
+	return &TransactionsHandler{
+		transactionService: transactionService,
+		audit:              audit,
+	}
+}
+
+// UpdateStatus handles PUT /api/v1/admin/transactions/:id/status
+func (h *TransactionsHandler) UpdateStatus(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: "Invalid transaction ID",
+		})
+	}
+
+	var req models.TransactionStatusUpdate
+	if err := c.BodyParser(&req); err != nil || req.Status == "" {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide a status",
+		})
+	}
+
+	tx, previous, err := h.transactionService.UpdateStatus(uint(id), req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, service.ErrTransactionNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, service.ErrInvalidStatusTransition):
+			status = http.StatusConflict
+		}
+		return c.Status(status).JSON(models.ErrorResponse{
+			Error:   "Status update failed",
+			Message: err.Error(),
+		})
+	}
+
+	if previous != tx.Status {
+		recordAdminAction(c, h.audit, &tx.UserID, models.AuditActionAdminTxStatus, models.AuditTarget("transaction", tx.ID), map[string]interface{}{
+			"status": models.AuditChange(previous, tx.Status),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"transaction": tx,
+	})
+}
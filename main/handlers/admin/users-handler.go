@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"github.com/inlovewithgo/transit-backend/main/service"
+)
+
+type UsersHandler struct {
+	roleService *service.RoleService
+	audit       *service.AuditService
+}
+
+func NewUsersHandler(roleService *service.RoleService, audit *service.AuditService) *UsersHandler {
+	return &UsersHandler{
+		roleService: roleService,
+		audit:       audit,
+	}
+}
+
+// ListRoles handles GET /api/v1/admin/roles
+func (h *UsersHandler) ListRoles(c *fiber.Ctx) error {
+	roles, err := h.roleService.ListRoles()
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"roles": roles,
+	})
+}
+
+// GetUser handles GET /api/v1/admin/users/:id
+func (h *UsersHandler) GetUser(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: "Invalid user ID",
+		})
+	}
+
+	user, err := h.roleService.GetUser(uint(id))
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(models.ErrorResponse{
+			Error:   "Not found",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"user": user,
+	})
+}
+
+// UpdateRole handles PUT /api/v1/admin/users/:id/role
+func (h *UsersHandler) UpdateRole(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: "Invalid user ID",
+		})
+	}
+
+	var req models.UpdateUserRoleRequest
+	if err := c.BodyParser(&req); err != nil || req.Role == "" {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide a role",
+		})
+	}
+
+	var previousRole string
+	if before, err := h.roleService.GetUser(uint(id)); err == nil {
+		previousRole = before.Role
+	}
+
+	user, err := h.roleService.AssignRole(uint(id), req.Role)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, service.ErrRoleNotFound):
+			status = http.StatusBadRequest
+		case errors.Is(err, service.ErrUserNotFound):
+			status = http.StatusNotFound
+		}
+		return c.Status(status).JSON(models.ErrorResponse{
+			Error:   "Role update failed",
+			Message: err.Error(),
+		})
+	}
+
+	recordAdminAction(c, h.audit, &user.ID, models.AuditActionAdminRoleChange, models.AuditTarget("user", user.ID), map[string]interface{}{
+		"role": models.AuditChange(previousRole, user.Role),
+	})
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"user": user,
+	})
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"github.com/inlovewithgo/transit-backend/main/service"
+)
+
+type WaitlistHandler struct {
+	waitlistService *service.WaitlistService
+}
+
+func NewWaitlistHandler(waitlistService *service.WaitlistService) *WaitlistHandler {
+	return &WaitlistHandler{
+		waitlistService: waitlistService,
+	}
+}
+
+// ListEntries handles GET /api/v1/admin/waitlist
+func (h *WaitlistHandler) ListEntries(c *fiber.Ctx) error {
+	entries, err := h.waitlistService.ListEntries()
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"entries": entries,
+		"count":   len(entries),
+	})
+}
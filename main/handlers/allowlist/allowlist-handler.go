@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/inlovewithgo/transit-backend/main/middlewares"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"github.com/inlovewithgo/transit-backend/main/service"
+)
+
+// cancelPage asks the owner to confirm a cancellation, or shows the outcome
+// once Message is set.
+var cancelPage = template.Must(template.New("cancel").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><meta name="robots" content="noindex"><title>Cancel withdrawal address</title></head>
+<body>
+{{if .Message}}<p>{{.Message}}</p>{{else}}<form method="post" action="/api/v1/withdrawal-addresses/cancel">
+<p>Cancel the withdrawal address that was just added to your account?</p>
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">Cancel address</button>
+</form>{{end}}
+</body>
+</html>
+`))
+
+type cancelPageData struct {
+	Token   string
+	Message string
+}
+
+type AllowlistHandler struct {
+	allowlistService *service.WithdrawalAllowlistService
+	audit            *service.AuditService
+}
+
+func NewAllowlistHandler(allowlistService *service.WithdrawalAllowlistService, audit *service.AuditService) *AllowlistHandler {
+	return &AllowlistHandler{
+		allowlistService: allowlistService,
+		audit:            audit,
+	}
+}
+
+// GetAllowlist handles GET /api/v1/withdrawal-addresses
+func (h *AllowlistHandler) GetAllowlist(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	status, err := h.allowlistService.Status(userID)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(status)
+}
+
+// AddAddress handles POST /api/v1/withdrawal-addresses
+func (h *AllowlistHandler) AddAddress(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	var req models.AddWithdrawalAddressRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide valid JSON data",
+		})
+	}
+
+	entry, err := h.allowlistService.AddAddress(userID, &req)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Failed to add address",
+			Message: err.Error(),
+		})
+	}
+
+	h.record(c, userID, models.AuditActionAllowlistAdd, entry.ID, map[string]interface{}{
+		"currency":     entry.Currency,
+		"address":      entry.Address,
+		"label":        entry.Label,
+		"activates_at": entry.ActivatesAt,
+	})
+
+	return c.Status(http.StatusCreated).JSON(fiber.Map{
+		"message": "Address added. It can be used for withdrawals once it activates.",
+		"address": entry,
+	})
+}
+
+// RemoveAddress handles DELETE /api/v1/withdrawal-addresses/:id
+func (h *AllowlistHandler) RemoveAddress(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: "Invalid address ID",
+		})
+	}
+
+	if err := h.allowlistService.RemoveAddress(userID, uint(id)); err != nil {
+		return c.Status(http.StatusNotFound).JSON(models.ErrorResponse{
+			Error:   "Address not found",
+			Message: err.Error(),
+		})
+	}
+
+	h.record(c, userID, models.AuditActionAllowlistRemove, uint(id), nil)
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Address removed",
+	})
+}
+
+// UpdateSettings handles PUT /api/v1/withdrawal-addresses/settings
+func (h *AllowlistHandler) UpdateSettings(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	var req models.WithdrawalAllowlistSettingsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide valid JSON data",
+		})
+	}
+
+	before, err := h.allowlistService.Status(userID)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: err.Error(),
+		})
+	}
+
+	status, err := h.allowlistService.SetEnabled(userID, req.Enabled)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: err.Error(),
+		})
+	}
+
+	h.audit.Record(middlewares.GetClientInfo(c), &models.AuditEvent{
+		ActorID: &userID,
+		UserID:  &userID,
+		Action:  models.AuditActionAllowlistSettings,
+		Target:  models.AuditTarget("user", userID),
+		Changes: map[string]interface{}{"enabled": models.AuditChange(before.Enabled, status.Enabled)},
+	})
+
+	return c.Status(http.StatusOK).JSON(status)
+}
+
+// CancelAddressPage handles GET /api/v1/withdrawal-addresses/cancel?token=...
+// It is linked from the confirmation email. It only shows a form that posts
+// the token back, so mail scanners and link prefetchers that follow the
+// link can't cancel the address.
+func (h *AllowlistHandler) CancelAddressPage(c *fiber.Ctx) error {
+	return renderCancelPage(c, http.StatusOK, cancelPageData{Token: c.Query("token")})
+}
+
+// CancelAddress handles POST /api/v1/withdrawal-addresses/cancel. It needs no
+// session; the token from the email is the credential. The form on the
+// cancel page gets an HTML reply, other clients get JSON.
+func (h *AllowlistHandler) CancelAddress(c *fiber.Ctx) error {
+	var req models.CancelWithdrawalAddressRequest
+	_ = c.BodyParser(&req)
+	fromPage := strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEApplicationForm)
+
+	entry, err := h.allowlistService.CancelByToken(req.Token)
+	if err != nil {
+		if fromPage {
+			return renderCancelPage(c, http.StatusBadRequest, cancelPageData{Message: "This cancel link is invalid or has expired."})
+		}
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Cancel failed",
+			Message: err.Error(),
+		})
+	}
+
+	// The link comes from email, so there is no authenticated actor.
+	h.audit.Record(middlewares.GetClientInfo(c), &models.AuditEvent{
+		ActorType: models.AuditActorAnonymous,
+		UserID:    &entry.UserID,
+		Action:    models.AuditActionAllowlistCancel,
+		Target:    models.AuditTarget("withdrawal_address", entry.ID),
+		Changes:   map[string]interface{}{"address": entry.Address},
+	})
+
+	if fromPage {
+		return renderCancelPage(c, http.StatusOK, cancelPageData{Message: "Withdrawal address " + entry.Address + " was cancelled."})
+	}
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Withdrawal address cancelled",
+		"address": entry.Address,
+	})
+}
+
+func renderCancelPage(c *fiber.Ctx, status int, data cancelPageData) error {
+	var page bytes.Buffer
+	if err := cancelPage.Execute(&page, data); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to render page",
+		})
+	}
+
+	// The page carries the token.
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderReferrerPolicy, "no-referrer")
+	c.Type("html", "utf-8")
+	return c.Status(status).Send(page.Bytes())
+}
+
+// record audits a change the signed-in user made to one of their addresses.
+func (h *AllowlistHandler) record(c *fiber.Ctx, userID uint, action string, addressID uint, changes map[string]interface{}) {
+	h.audit.Record(middlewares.GetClientInfo(c), &models.AuditEvent{
+		ActorID: &userID,
+		UserID:  &userID,
+		Action:  action,
+		Target:  models.AuditTarget("withdrawal_address", addressID),
+		Changes: changes,
+	})
+}
+
+func unauthorized(c *fiber.Ctx) error {
+	return c.Status(http.StatusUnauthorized).JSON(models.ErrorResponse{
+		Error:   "Unauthorized",
+		Message: "Invalid or missing token",
+	})
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/inlovewithgo/transit-backend/main/middlewares"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"github.com/inlovewithgo/transit-backend/main/service"
+)
+
+type APIKeyHandler struct {
+	apiKeyService *service.APIKeyService
+}
+
+func NewAPIKeyHandler(apiKeyService *service.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyService: apiKeyService,
+	}
+}
+
+// CreateKey handles POST /api/v1/api-keys
+func (h *APIKeyHandler) CreateKey(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	var req models.CreateAPIKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide valid JSON data",
+		})
+	}
+
+	key, rawKey, err := h.apiKeyService.Create(userID, &req)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Failed to create API key",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusCreated).JSON(models.CreateAPIKeyResponse{
+		APIKey: key,
+		Key:    rawKey,
+	})
+}
+
+// ListKeys handles GET /api/v1/api-keys
+func (h *APIKeyHandler) ListKeys(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	keys, err := h.apiKeyService.List(userID)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"api_keys": keys,
+	})
+}
+
+// RevokeKey handles DELETE /api/v1/api-keys/:id
+func (h *APIKeyHandler) RevokeKey(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: "Invalid API key ID",
+		})
+	}
+
+	if err := h.apiKeyService.Revoke(userID, uint(id)); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrAPIKeyNotFound) {
+			status = http.StatusNotFound
+		}
+		return c.Status(status).JSON(models.ErrorResponse{
+			Error:   "Failed to revoke API key",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "API key revoked",
+	})
+}
+
+func unauthorized(c *fiber.Ctx) error {
+	return c.Status(http.StatusUnauthorized).JSON(models.ErrorResponse{
+		Error:   "Unauthorized",
+		Message: "Invalid or missing token",
+	})
+}
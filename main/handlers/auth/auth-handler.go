@@ -1,21 +1,27 @@
 package handlers
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/inlovewithgo/transit-backend/main/middlewares"
 	"github.com/inlovewithgo/transit-backend/main/models"
 	"github.com/inlovewithgo/transit-backend/main/service"
 	"github.com/inlovewithgo/transit-backend/main/utils"
 )
 
 type AuthHandler struct {
-	authService *service.AuthService
+	authService  *service.AuthService
+	tokenService *service.TokenRevocationService
 }
 
-func NewAuthHandler(authService *service.AuthService) *AuthHandler {
+func NewAuthHandler(authService *service.AuthService, tokenService *service.TokenRevocationService) *AuthHandler {
 	return &AuthHandler{
-		authService: authService,
+		authService:  authService,
+		tokenService: tokenService,
 	}
 }
 
@@ -36,16 +42,17 @@ func (h *AuthHandler) Register(c *fiber.Ctx) error {
 		})
 	}
 
-	if len(req.Password) < 6 {
-		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
-			Error:   "Password too short",
-			Message: "Password must be at least 6 characters long",
-		})
-	}
-
-	response, err := h.authService.Register(&req)
+	response, err := h.authService.Register(&req, middlewares.GetClientInfo(c))
 	if err != nil {
-		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+		var policyErr *service.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			return weakPassword(c, policyErr)
+		}
+		status := http.StatusBadRequest
+		if errors.Is(err, service.ErrEmailTaken) {
+			status = http.StatusConflict
+		}
+		return c.Status(status).JSON(models.ErrorResponse{
 			Error:   "Registration failed",
 			Message: err.Error(),
 		})
@@ -71,7 +78,15 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 		})
 	}
 
-	response, err := h.authService.Login(&req)
+	response, err := h.authService.Login(&req, middlewares.GetClientInfo(c))
+	var throttled *service.LoginThrottledError
+	if errors.As(err, &throttled) {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		return c.Status(http.StatusTooManyRequests).JSON(models.ErrorResponse{
+			Error:   "Login failed",
+			Message: err.Error(),
+		})
+	}
 	if err != nil {
 		return c.Status(http.StatusUnauthorized).JSON(models.ErrorResponse{
 			Error:   "Login failed",
@@ -82,6 +97,197 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 	return c.Status(http.StatusOK).JSON(response)
 }
 
+// VerifyMFA handles POST /api/v1/auth/mfa/verify
+func (h *AuthHandler) VerifyMFA(c *fiber.Ctx) error {
+	var req models.MFAVerifyRequest
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide valid JSON data",
+		})
+	}
+
+	if req.MFAToken == "" || req.Code == "" {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Missing required fields",
+			Message: "MFA token and code are required",
+		})
+	}
+
+	response, err := h.authService.VerifyMFA(&req, middlewares.GetClientInfo(c))
+	if err != nil {
+		status := http.StatusUnauthorized
+		if errors.Is(err, service.ErrTooManyMFAAttempts) {
+			status = http.StatusTooManyRequests
+		}
+		return c.Status(status).JSON(models.ErrorResponse{
+			Error:   "Verification failed",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// Refresh handles POST /api/v1/auth/refresh
+func (h *AuthHandler) Refresh(c *fiber.Ctx) error {
+	var req models.RefreshRequest
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide valid JSON data",
+		})
+	}
+
+	if req.RefreshToken == "" {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Missing required fields",
+			Message: "Refresh token is required",
+		})
+	}
+
+	response, err := h.authService.Refresh(req.RefreshToken, middlewares.GetClientInfo(c))
+	if err != nil {
+		status := http.StatusUnauthorized
+		if !errors.Is(err, service.ErrInvalidRefreshToken) && !errors.Is(err, service.ErrRefreshTokenReused) {
+			status = http.StatusInternalServerError
+		}
+		return c.Status(status).JSON(models.ErrorResponse{
+			Error:   "Refresh failed",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// VerifyEmail handles GET /api/v1/auth/verify-email?token=...
+func (h *AuthHandler) VerifyEmail(c *fiber.Ctx) error {
+	user, err := h.authService.VerifyEmail(c.Query("token"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrInvalidVerification) {
+			status = http.StatusBadRequest
+		}
+		return c.Status(status).JSON(models.ErrorResponse{
+			Error:   "Verification failed",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message":           "Email verified",
+		"email_verified_at": user.EmailVerifiedAt,
+	})
+}
+
+// JWKS handles GET /.well-known/jwks.json
+func (h *AuthHandler) JWKS(c *fiber.Ctx) error {
+	keys, err := utils.AccessTokenJWKS()
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Signing keys are unavailable",
+		})
+	}
+
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.Status(http.StatusOK).JSON(keys)
+}
+
+// UnlockAccount handles GET /api/v1/auth/unlock
+func (h *AuthHandler) UnlockAccount(c *fiber.Ctx) error {
+	if err := h.authService.UnlockAccount(c.Query("token")); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrInvalidUnlockToken) {
+			status = http.StatusBadRequest
+		}
+		return c.Status(status).JSON(models.ErrorResponse{
+			Error:   "Unlock failed",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Account unlocked, you can log in again",
+	})
+}
+
+// ResendVerification handles POST /api/v1/auth/verify-email/resend
+func (h *AuthHandler) ResendVerification(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return c.Status(http.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error:   "Unauthorized",
+			Message: "Invalid or missing token",
+		})
+	}
+
+	if err := h.authService.ResendVerificationEmail(userID); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrEmailAlreadyVerified) {
+			status = http.StatusConflict
+		}
+		return c.Status(status).JSON(models.ErrorResponse{
+			Error:   "Resend failed",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusAccepted).JSON(fiber.Map{
+		"message": "Verification email sent",
+	})
+}
+
+// StepUp handles POST /api/v1/auth/step-up
+func (h *AuthHandler) StepUp(c *fiber.Ctx) error {
+	claims, ok := middlewares.GetClaims(c)
+	if !ok {
+		return c.Status(http.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error:   "Unauthorized",
+			Message: "Invalid or missing token",
+		})
+	}
+
+	var req models.StepUpRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide valid JSON data",
+		})
+	}
+
+	if req.Password == "" && req.Code == "" {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Missing required fields",
+			Message: "Password or code is required",
+		})
+	}
+
+	response, err := h.authService.StepUp(claims, &req, middlewares.GetClientInfo(c))
+	if err != nil {
+		status := http.StatusUnauthorized
+		var throttled *service.LoginThrottledError
+		switch {
+		case errors.As(err, &throttled):
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			status = http.StatusTooManyRequests
+		case errors.Is(err, service.ErrTooManyMFAAttempts):
+			status = http.StatusTooManyRequests
+		case errors.Is(err, service.ErrMFANotEnabled):
+			status = http.StatusBadRequest
+		}
+		return c.Status(status).JSON(models.ErrorResponse{
+			Error:   "Step-up failed",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
 func (h *AuthHandler) GetProfile(c *fiber.Ctx) error {
 	userID, err := getUserIDFromToken(c)
 	if err != nil {
@@ -123,8 +329,71 @@ func getUserIDFromToken(c *fiber.Ctx) (uint, error) {
 	return claims.UserID, nil
 }
 
+// Logout revokes the access token used for this request. Passing the
+// refresh token in the body revokes it as well.
 func (h *AuthHandler) Logout(c *fiber.Ctx) error {
+	claims, ok := middlewares.GetClaims(c)
+	if !ok {
+		return c.Status(http.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error:   "Unauthorized",
+			Message: "Invalid or missing token",
+		})
+	}
+
+	var req models.RefreshRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+				Error:   "Invalid request format",
+				Message: "Please provide valid JSON data",
+			})
+		}
+	}
+
+	if err := h.tokenService.Logout(claims, req.RefreshToken); err != nil {
+		return c.Status(logoutErrorStatus(err)).JSON(models.ErrorResponse{
+			Error:   "Logout failed",
+			Message: err.Error(),
+		})
+	}
+
 	return c.Status(http.StatusOK).JSON(fiber.Map{
 		"message": "Logout successful",
 	})
 }
+
+// LogoutAll invalidates every token issued to the user, on all devices.
+func (h *AuthHandler) LogoutAll(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return c.Status(http.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error:   "Unauthorized",
+			Message: "Invalid or missing token",
+		})
+	}
+
+	if err := h.tokenService.LogoutAll(userID); err != nil {
+		return c.Status(logoutErrorStatus(err)).JSON(models.ErrorResponse{
+			Error:   "Logout failed",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Logged out of all sessions",
+	})
+}
+
+func logoutErrorStatus(err error) int {
+	if errors.Is(err, service.ErrRevocationUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func weakPassword(c *fiber.Ctx, err *service.PasswordPolicyError) error {
+	return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+		Error:   "Weak password",
+		Message: err.Reason,
+	})
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/inlovewithgo/transit-backend/main/middlewares"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"github.com/inlovewithgo/transit-backend/main/service"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+)
+
+const nonceCookie = "magic_link_nonce"
+
+type MagicLinkHandler struct {
+	magicLinkService *service.MagicLinkService
+	secureCookie     bool
+}
+
+func NewMagicLinkHandler(magicLinkService *service.MagicLinkService) *MagicLinkHandler {
+	return &MagicLinkHandler{
+		magicLinkService: magicLinkService,
+		secureCookie:     strings.HasPrefix(utils.GetENV("APP_BASE_URL", ""), "https://"),
+	}
+}
+
+// RequestLink handles POST /api/v1/auth/magic-link
+func (h *MagicLinkHandler) RequestLink(c *fiber.Ctx) error {
+	var req models.MagicLinkRequest
+	if err := c.BodyParser(&req); err != nil || req.Email == "" {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Email is required",
+		})
+	}
+
+	nonce, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to send sign-in link",
+		})
+	}
+
+	if err := h.magicLinkService.RequestLink(req.Email, nonce); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: err.Error(),
+		})
+	}
+
+	// Set whether or not the email exists so the response gives nothing away.
+	h.setNonceCookie(c, nonce, time.Now().Add(h.magicLinkService.TTL()))
+
+	return c.Status(http.StatusAccepted).JSON(fiber.Map{
+		"message": "If an account exists for this email, a sign-in link has been sent",
+	})
+}
+
+// Verify handles GET /api/v1/auth/magic-link/verify
+func (h *MagicLinkHandler) Verify(c *fiber.Ctx) error {
+	response, err := h.magicLinkService.Consume(c.Query("token"), c.Cookies(nonceCookie), middlewares.GetClientInfo(c))
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, service.ErrInvalidMagicLink):
+			status = http.StatusUnauthorized
+		case errors.Is(err, service.ErrMagicLinkWrongBrowser):
+			status = http.StatusForbidden
+		}
+		return c.Status(status).JSON(models.ErrorResponse{
+			Error:   "Sign-in failed",
+			Message: err.Error(),
+		})
+	}
+
+	// ClearCookie doesn't carry the Path, so the browser would keep the
+	// original cookie.
+	h.setNonceCookie(c, "", time.Now().Add(-time.Hour))
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+func (h *MagicLinkHandler) setNonceCookie(c *fiber.Ctx, value string, expires time.Time) {
+	c.Cookie(&fiber.Cookie{
+		Name:     nonceCookie,
+		Value:    value,
+		Path:     "/api/v1/auth/magic-link",
+		Expires:  expires,
+		Secure:   h.secureCookie,
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/inlovewithgo/transit-backend/main/middlewares"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"github.com/inlovewithgo/transit-backend/main/service"
+)
+
+type MFAHandler struct {
+	mfaService *service.MFAService
+	audit      *service.AuditService
+}
+
+func NewMFAHandler(mfaService *service.MFAService, audit *service.AuditService) *MFAHandler {
+	return &MFAHandler{
+		mfaService: mfaService,
+		audit:      audit,
+	}
+}
+
+// SetupTOTP handles POST /api/v1/mfa/totp/setup
+func (h *MFAHandler) SetupTOTP(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	setup, err := h.mfaService.SetupTOTP(userID)
+	if err != nil {
+		return mfaError(c, "Two-factor setup failed", err)
+	}
+
+	return c.Status(http.StatusOK).JSON(setup)
+}
+
+// ConfirmTOTP handles POST /api/v1/mfa/totp/confirm
+func (h *MFAHandler) ConfirmTOTP(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	req, ok := parseCode(c)
+	if !ok {
+		return nil
+	}
+
+	codes, err := h.mfaService.ConfirmTOTP(userID, req.Code)
+	if err != nil {
+		return mfaError(c, "Two-factor setup failed", err)
+	}
+
+	h.record(c, userID, models.AuditActionTOTPEnabled, models.AuditChange(false, true))
+
+	return c.Status(http.StatusOK).JSON(models.RecoveryCodesResponse{
+		RecoveryCodes: codes,
+		Message:       "Two-factor authentication enabled. Store these recovery codes somewhere safe; they will not be shown again.",
+	})
+}
+
+// DisableTOTP handles POST /api/v1/mfa/totp/disable
+func (h *MFAHandler) DisableTOTP(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	req, ok := parseCode(c)
+	if !ok {
+		return nil
+	}
+
+	if err := h.mfaService.DisableTOTP(userID, req.Code); err != nil {
+		return mfaError(c, "Failed to disable two-factor authentication", err)
+	}
+
+	h.record(c, userID, models.AuditActionTOTPDisabled, models.AuditChange(true, false))
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+// RegenerateRecoveryCodes handles POST /api/v1/mfa/recovery-codes
+func (h *MFAHandler) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	req, ok := parseCode(c)
+	if !ok {
+		return nil
+	}
+
+	codes, err := h.mfaService.RegenerateRecoveryCodes(userID, req.Code)
+	if err != nil {
+		return mfaError(c, "Failed to regenerate recovery codes", err)
+	}
+
+	h.record(c, userID, models.AuditActionRecoveryCodesReset, nil)
+
+	return c.Status(http.StatusOK).JSON(models.RecoveryCodesResponse{
+		RecoveryCodes: codes,
+		Message:       "Recovery codes regenerated. Previous codes no longer work.",
+	})
+}
+
+// record audits a change to the user's own TOTP settings.
+func (h *MFAHandler) record(c *fiber.Ctx, userID uint, action string, totpEnabled map[string]interface{}) {
+	event := &models.AuditEvent{
+		ActorID: &userID,
+		UserID:  &userID,
+		Action:  action,
+		Target:  models.AuditTarget("user", userID),
+	}
+	if totpEnabled != nil {
+		event.Changes = map[string]interface{}{"totp_enabled": totpEnabled}
+	}
+	h.audit.Record(middlewares.GetClientInfo(c), event)
+}
+
+// parseCode reads the code from the body. When it returns false the error
+// response has already been written.
+func parseCode(c *fiber.Ctx) (*models.MFACodeRequest, bool) {
+	var req models.MFACodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide valid JSON data",
+		})
+		return nil, false
+	}
+
+	if req.Code == "" {
+		c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Missing required fields",
+			Message: "Code is required",
+		})
+		return nil, false
+	}
+
+	return &req, true
+}
+
+func mfaErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidMFACode):
+		return http.StatusUnauthorized
+	case errors.Is(err, service.ErrTooManyMFAAttempts):
+		return http.StatusTooManyRequests
+	case errors.Is(err, service.ErrMFAAlreadyEnabled), errors.Is(err, service.ErrMFANotEnabled), errors.Is(err, service.ErrMFANotSetUp):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func mfaError(c *fiber.Ctx, title string, err error) error {
+	return c.Status(mfaErrorStatus(err)).JSON(models.ErrorResponse{
+		Error:   title,
+		Message: err.Error(),
+	})
+}
+
+func unauthorized(c *fiber.Ctx) error {
+	return c.Status(http.StatusUnauthorized).JSON(models.ErrorResponse{
+		Error:   "Unauthorized",
+		Message: "Invalid or missing token",
+	})
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/inlovewithgo/transit-backend/main/middlewares"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"github.com/inlovewithgo/transit-backend/main/service"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+)
+
+const stateCookie = "oidc_state"
+
+type OIDCHandler struct {
+	oidcService  *service.OIDCService
+	secureCookie bool
+}
+
+func NewOIDCHandler(oidcService *service.OIDCService) *OIDCHandler {
+	return &OIDCHandler{
+		oidcService:  oidcService,
+		secureCookie: strings.HasPrefix(utils.GetENV("APP_BASE_URL", ""), "https://"),
+	}
+}
+
+// Start handles GET /api/v1/auth/oidc/:provider
+func (h *OIDCHandler) Start(c *fiber.Ctx) error {
+	authURL, stateToken, err := h.oidcService.AuthCodeURL(c.Params("provider"))
+	if err != nil {
+		return c.Status(oidcErrorStatus(err)).JSON(models.ErrorResponse{
+			Error:   "Login failed",
+			Message: err.Error(),
+		})
+	}
+
+	h.setStateCookie(c, stateToken, time.Now().Add(utils.OIDCStateTTL))
+
+	return c.Redirect(authURL, http.StatusFound)
+}
+
+// Callback handles GET /api/v1/auth/oidc/:provider/callback
+func (h *OIDCHandler) Callback(c *fiber.Ctx) error {
+	stateToken := c.Cookies(stateCookie)
+	// The state is single-use. Expire it on the path it was set for;
+	// ClearCookie would leave it in place.
+	h.setStateCookie(c, "", time.Now().Add(-time.Hour))
+
+	if providerErr := c.Query("error"); providerErr != "" {
+		return c.Status(http.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error:   "Login failed",
+			Message: "The provider returned: " + providerErr,
+		})
+	}
+
+	response, err := h.oidcService.Callback(c.Params("provider"), stateToken, c.Query("state"), c.Query("code"), middlewares.GetClientInfo(c))
+	if err != nil {
+		return c.Status(oidcErrorStatus(err)).JSON(models.ErrorResponse{
+			Error:   "Login failed",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+func oidcErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrOIDCProviderNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrOIDCLinkUnverified):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrOIDCLoginFailed), errors.Is(err, service.ErrOIDCEmailUnverified):
+		return http.StatusUnauthorized
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// setStateCookie writes the state cookie. It is Lax so it comes back on the
+// provider's top-level redirect.
+func (h *OIDCHandler) setStateCookie(c *fiber.Ctx, value string, expires time.Time) {
+	c.Cookie(&fiber.Cookie{
+		Name:     stateCookie,
+		Value:    value,
+		Path:     "/api/v1/auth/oidc",
+		Expires:  expires,
+		Secure:   h.secureCookie,
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/inlovewithgo/transit-backend/main/middlewares"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"github.com/inlovewithgo/transit-backend/main/service"
+)
+
+type PasswordHandler struct {
+	passwordService *service.PasswordService
+}
+
+func NewPasswordHandler(passwordService *service.PasswordService) *PasswordHandler {
+	return &PasswordHandler{
+		passwordService: passwordService,
+	}
+}
+
+// ForgotPassword handles POST /api/v1/auth/forgot-password
+func (h *PasswordHandler) ForgotPassword(c *fiber.Ctx) error {
+	var req models.ForgotPasswordRequest
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide valid JSON data",
+		})
+	}
+
+	if req.Email == "" {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Missing required fields",
+			Message: "Email is required",
+		})
+	}
+
+	if err := h.passwordService.ForgotPassword(req.Email); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusAccepted).JSON(fiber.Map{
+		"message": "If an account exists for that email, a reset link has been sent",
+	})
+}
+
+// ResetPassword handles POST /api/v1/auth/reset-password
+func (h *PasswordHandler) ResetPassword(c *fiber.Ctx) error {
+	var req models.ResetPasswordRequest
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide valid JSON data",
+		})
+	}
+
+	if req.Token == "" || req.NewPassword == "" {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Missing required fields",
+			Message: "Token and new password are required",
+		})
+	}
+
+	if err := h.passwordService.ResetPassword(req.Token, req.NewPassword); err != nil {
+		var policyErr *service.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			return weakPassword(c, policyErr)
+		}
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrInvalidResetToken) {
+			status = http.StatusBadRequest
+		}
+		return c.Status(status).JSON(models.ErrorResponse{
+			Error:   "Password reset failed",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Password has been reset. Please log in with your new password.",
+	})
+}
+
+// ChangePassword handles POST /api/v1/auth/change-password
+func (h *PasswordHandler) ChangePassword(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return c.Status(http.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error:   "Unauthorized",
+			Message: "Invalid or missing token",
+		})
+	}
+
+	var req models.ChangePasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide valid JSON data",
+		})
+	}
+
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Missing required fields",
+			Message: "Current and new password are required",
+		})
+	}
+
+	if err := h.passwordService.ChangePassword(userID, req.CurrentPassword, req.NewPassword, middlewares.GetClientInfo(c)); err != nil {
+		var policyErr *service.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			return weakPassword(c, policyErr)
+		}
+		status := http.StatusInternalServerError
+		var throttled *service.LoginThrottledError
+		switch {
+		case errors.As(err, &throttled):
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			status = http.StatusTooManyRequests
+		case errors.Is(err, service.ErrInvalidCredentials):
+			status = http.StatusUnauthorized
+		}
+		return c.Status(status).JSON(models.ErrorResponse{
+			Error:   "Password change failed",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Password changed. All sessions have been signed out.",
+	})
+}
+
+func weakPassword(c *fiber.Ctx, err *service.PasswordPolicyError) error {
+	return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+		Error:   "Weak password",
+		Message: err.Reason,
+	})
+}
@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/inlovewithgo/transit-backend/main/middlewares"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"github.com/inlovewithgo/transit-backend/main/service"
+)
+
+type PhoneHandler struct {
+	phoneService *service.PhoneService
+	audit        *service.AuditService
+}
+
+func NewPhoneHandler(phoneService *service.PhoneService, audit *service.AuditService) *PhoneHandler {
+	return &PhoneHandler{
+		phoneService: phoneService,
+		audit:        audit,
+	}
+}
+
+// StartVerification handles POST /api/v1/profile/phone
+func (h *PhoneHandler) StartVerification(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	var req models.PhoneNumberRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide valid JSON data",
+		})
+	}
+
+	if req.PhoneNumber == "" {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Missing required fields",
+			Message: "Phone number is required",
+		})
+	}
+
+	response, err := h.phoneService.StartVerification(userID, req.PhoneNumber)
+	if err != nil {
+		return phoneError(c, "Failed to send verification code", err)
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// ConfirmVerification handles POST /api/v1/profile/phone/verify
+func (h *PhoneHandler) ConfirmVerification(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	req, ok := parseCode(c)
+	if !ok {
+		return nil
+	}
+
+	phone, err := h.phoneService.ConfirmVerification(userID, req.Code)
+	if err != nil {
+		return phoneError(c, "Phone verification failed", err)
+	}
+
+	h.record(c, userID, models.AuditActionPhoneVerified, map[string]interface{}{
+		"phone_number": service.MaskPhoneNumber(phone),
+	})
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message":      "Phone number verified",
+		"phone_number": phone,
+	})
+}
+
+// RemovePhone handles DELETE /api/v1/profile/phone
+func (h *PhoneHandler) RemovePhone(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	smsMFAWasEnabled, err := h.phoneService.RemovePhone(userID)
+	if err != nil {
+		return phoneError(c, "Failed to remove phone number", err)
+	}
+
+	h.record(c, userID, models.AuditActionPhoneRemoved, nil)
+	if smsMFAWasEnabled {
+		h.record(c, userID, models.AuditActionSMSMFADisabled, map[string]interface{}{
+			"sms_mfa_enabled": models.AuditChange(true, false),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Phone number removed",
+	})
+}
+
+// EnableSMSMFA handles POST /api/v1/mfa/sms/enable
+func (h *PhoneHandler) EnableSMSMFA(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	if err := h.phoneService.EnableSMSMFA(userID); err != nil {
+		return phoneError(c, "Failed to enable SMS two-factor authentication", err)
+	}
+
+	h.record(c, userID, models.AuditActionSMSMFAEnabled, map[string]interface{}{
+		"sms_mfa_enabled": models.AuditChange(false, true),
+	})
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "SMS two-factor authentication enabled",
+	})
+}
+
+// DisableSMSMFA handles POST /api/v1/mfa/sms/disable
+func (h *PhoneHandler) DisableSMSMFA(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	if err := h.phoneService.DisableSMSMFA(userID); err != nil {
+		return phoneError(c, "Failed to disable SMS two-factor authentication", err)
+	}
+
+	h.record(c, userID, models.AuditActionSMSMFADisabled, map[string]interface{}{
+		"sms_mfa_enabled": models.AuditChange(true, false),
+	})
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "SMS two-factor authentication disabled",
+	})
+}
+
+// SendLoginCode handles POST /api/v1/auth/mfa/sms/send
+func (h *PhoneHandler) SendLoginCode(c *fiber.Ctx) error {
+	var req models.SMSMFASendRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide valid JSON data",
+		})
+	}
+
+	if req.MFAToken == "" {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Missing required fields",
+			Message: "MFA token is required",
+		})
+	}
+
+	response, err := h.phoneService.SendLoginCode(req.MFAToken)
+	if err != nil {
+		return phoneError(c, "Failed to send code", err)
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// VerifyLoginCode handles POST /api/v1/auth/mfa/sms/verify
+func (h *PhoneHandler) VerifyLoginCode(c *fiber.Ctx) error {
+	var req models.MFAVerifyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide valid JSON data",
+		})
+	}
+
+	if req.MFAToken == "" || req.Code == "" {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Missing required fields",
+			Message: "MFA token and code are required",
+		})
+	}
+
+	response, err := h.phoneService.VerifyLoginCode(req.MFAToken, req.Code, middlewares.GetClientInfo(c))
+	if err != nil {
+		return phoneError(c, "Verification failed", err)
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// SendStepUpCode handles POST /api/v1/auth/step-up/sms/send
+func (h *PhoneHandler) SendStepUpCode(c *fiber.Ctx) error {
+	claims, ok := middlewares.GetClaims(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	response, err := h.phoneService.SendStepUpCode(claims)
+	if err != nil {
+		return phoneError(c, "Failed to send code", err)
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// VerifyStepUpCode handles POST /api/v1/auth/step-up/sms/verify
+func (h *PhoneHandler) VerifyStepUpCode(c *fiber.Ctx) error {
+	claims, ok := middlewares.GetClaims(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	req, ok := parseCode(c)
+	if !ok {
+		return nil
+	}
+
+	response, err := h.phoneService.VerifyStepUpCode(claims, req.Code)
+	if err != nil {
+		return phoneError(c, "Step-up failed", err)
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// record audits a change to the user's own phone or SMS settings.
+func (h *PhoneHandler) record(c *fiber.Ctx, userID uint, action string, changes map[string]interface{}) {
+	h.audit.Record(middlewares.GetClientInfo(c), &models.AuditEvent{
+		ActorID: &userID,
+		UserID:  &userID,
+		Action:  action,
+		Target:  models.AuditTarget("user", userID),
+		Changes: changes,
+	})
+}
+
+// parseCode reads the code from the body. When it returns false the error
+// response has already been written.
+func parseCode(c *fiber.Ctx) (*models.MFACodeRequest, bool) {
+	var req models.MFACodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide valid JSON data",
+		})
+		return nil, false
+	}
+
+	if req.Code == "" {
+		c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Missing required fields",
+			Message: "Code is required",
+		})
+		return nil, false
+	}
+
+	return &req, true
+}
+
+func phoneErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidPhoneNumber):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrInvalidSMSCode), errors.Is(err, service.ErrInvalidMFAToken):
+		return http.StatusUnauthorized
+	case errors.Is(err, service.ErrPhoneNotVerified), errors.Is(err, service.ErrPhoneAlreadyVerified),
+		errors.Is(err, service.ErrSMSMFANotEnabled), errors.Is(err, service.ErrSMSMFAAlreadyEnabled):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrNoPhoneNumber), errors.Is(err, service.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrSMSRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, service.ErrSMSUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func phoneError(c *fiber.Ctx, title string, err error) error {
+	return c.Status(phoneErrorStatus(err)).JSON(models.ErrorResponse{
+		Error:   title,
+		Message: err.Error(),
+	})
+}
+
+func unauthorized(c *fiber.Ctx) error {
+	return c.Status(http.StatusUnauthorized).JSON(models.ErrorResponse{
+		Error:   "Unauthorized",
+		Message: "Invalid or missing token",
+	})
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/inlovewithgo/transit-backend/main/middlewares"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"github.com/inlovewithgo/transit-backend/main/service"
+)
+
+type ProfileHandler struct {
+	profileService *service.ProfileService
+	exportService  *service.DataExportService
+}
+
+func NewProfileHandler(profileService *service.ProfileService, exportService *service.DataExportService) *ProfileHandler {
+	return &ProfileHandler{
+		profileService: profileService,
+		exportService:  exportService,
+	}
+}
+
+// UpdateProfile handles PATCH /api/v1/profile
+func (h *ProfileHandler) UpdateProfile(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	var req models.UpdateProfileRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide valid JSON data",
+		})
+	}
+
+	user, err := h.profileService.UpdateProfile(userID, &req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, service.ErrInvalidProfile):
+			status = http.StatusBadRequest
+		case errors.Is(err, service.ErrUserNotFound):
+			status = http.StatusNotFound
+		}
+		return c.Status(status).JSON(models.ErrorResponse{
+			Error:   "Profile update failed",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"user": user,
+	})
+}
+
+// RequestEmailChange handles POST /api/v1/profile/email
+func (h *ProfileHandler) RequestEmailChange(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	var req models.ChangeEmailRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide valid JSON data",
+		})
+	}
+
+	if err := h.profileService.RequestEmailChange(userID, req.NewEmail); err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, service.ErrInvalidEmail), errors.Is(err, service.ErrEmailUnchanged):
+			status = http.StatusBadRequest
+		case errors.Is(err, service.ErrEmailTaken):
+			status = http.StatusConflict
+		case errors.Is(err, service.ErrUserNotFound):
+			status = http.StatusNotFound
+		}
+		return c.Status(status).JSON(models.ErrorResponse{
+			Error:   "Email change failed",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusAccepted).JSON(fiber.Map{
+		"message": "Confirmation links have been sent to your current and new email addresses",
+	})
+}
+
+// ConfirmEmailChange handles GET /api/v1/profile/email/confirm
+func (h *ProfileHandler) ConfirmEmailChange(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Missing required fields",
+			Message: "Token is required",
+		})
+	}
+
+	completed, err := h.profileService.ConfirmEmailChange(token)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, service.ErrInvalidEmailChangeToken):
+			status = http.StatusBadRequest
+		case errors.Is(err, service.ErrEmailTaken):
+			status = http.StatusConflict
+		}
+		return c.Status(status).JSON(models.ErrorResponse{
+			Error:   "Email change failed",
+			Message: err.Error(),
+		})
+	}
+
+	if !completed {
+		return c.Status(http.StatusAccepted).JSON(fiber.Map{
+			"message": "Confirmed. Open the link sent to your other address to finish the change.",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Email changed. Please log in again.",
+	})
+}
+
+// DeleteAccount handles DELETE /api/v1/profile
+func (h *ProfileHandler) DeleteAccount(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	purgeAt, err := h.profileService.DeleteAccount(userID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, service.ErrWalletsNotEmpty):
+			status = http.StatusConflict
+		case errors.Is(err, service.ErrUserNotFound):
+			status = http.StatusNotFound
+		}
+		return c.Status(status).JSON(models.ErrorResponse{
+			Error:   "Account deletion failed",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message":  "Account deleted",
+		"purge_at": purgeAt,
+	})
+}
+
+// RequestExport handles POST /api/v1/profile/export
+func (h *ProfileHandler) RequestExport(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	export, err := h.exportService.RequestExport(userID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrExportInProgress) {
+			status = http.StatusConflict
+		}
+		return c.Status(status).JSON(models.ErrorResponse{
+			Error:   "Data export failed",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusAccepted).JSON(fiber.Map{
+		"message": "Your data export is being prepared. We'll email you a download link when it's ready.",
+		"export":  export,
+	})
+}
+
+// DownloadExport handles GET /api/v1/profile/export/download
+func (h *ProfileHandler) DownloadExport(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Missing required fields",
+			Message: "Token is required",
+		})
+	}
+
+	path, err := h.exportService.Download(token)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrInvalidExportLink) {
+			status = http.StatusNotFound
+		}
+		return c.Status(status).JSON(models.ErrorResponse{
+			Error:   "Download failed",
+			Message: err.Error(),
+		})
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Download(path, "transit-data-export.zip")
+}
+
+func unauthorized(c *fiber.Ctx) error {
+	return c.Status(http.StatusUnauthorized).JSON(models.ErrorResponse{
+		Error:   "Unauthorized",
+		Message: "Invalid or missing token",
+	})
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/inlovewithgo/transit-backend/main/middlewares"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"github.com/inlovewithgo/transit-backend/main/service"
+)
+
+type SessionHandler struct {
+	sessionService *service.SessionService
+}
+
+func NewSessionHandler(sessionService *service.SessionService) *SessionHandler {
+	return &SessionHandler{
+		sessionService: sessionService,
+	}
+}
+
+// ListSessions handles GET /api/v1/sessions
+func (h *SessionHandler) ListSessions(c *fiber.Ctx) error {
+	claims, ok := middlewares.GetClaims(c)
+	if !ok {
+		return c.Status(http.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error:   "Unauthorized",
+			Message: "Invalid or missing token",
+		})
+	}
+
+	sessions, err := h.sessionService.ListSessions(claims.UserID, claims.SessionID)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"sessions": sessions,
+	})
+}
+
+// RevokeSession handles DELETE /api/v1/sessions/:id
+func (h *SessionHandler) RevokeSession(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return c.Status(http.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error:   "Unauthorized",
+			Message: "Invalid or missing token",
+		})
+	}
+
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: "Invalid session ID",
+		})
+	}
+
+	if err := h.sessionService.RevokeSession(userID, uint(id)); err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, service.ErrSessionNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, service.ErrRevocationUnavailable):
+			status = http.StatusServiceUnavailable
+		}
+		return c.Status(status).JSON(models.ErrorResponse{
+			Error:   "Failed to revoke session",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Session revoked",
+	})
+}
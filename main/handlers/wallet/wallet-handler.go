@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/inlovewithgo/transit-backend/main/middlewares"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/main/service"
+)
+
+type WalletHandler struct {
+	walletService *service.WalletService
+	audit         *service.AuditService
+}
+
+func NewWalletHandler(walletService *service.WalletService, audit *service.AuditService) *WalletHandler {
+	return &WalletHandler{
+		walletService: walletService,
+		audit:         audit,
+	}
+}
+
+// ListWallets handles GET /api/v1/wallets
+func (h *WalletHandler) ListWallets(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return c.Status(http.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error:   "Unauthorized",
+			Message: "Invalid or missing token",
+		})
+	}
+
+	wallets, err := h.walletService.ListWallets(userID)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to list wallets",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"wallets": wallets,
+	})
+}
+
+// Send handles POST /api/v1/wallets/:id/send
+func (h *WalletHandler) Send(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return c.Status(http.StatusUnauthorized).JSON(models.ErrorResponse{
+			Error:   "Unauthorized",
+			Message: "Invalid or missing token",
+		})
+	}
+
+	walletID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: "Invalid wallet ID",
+		})
+	}
+
+	var req models.SendRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide valid JSON data",
+		})
+	}
+
+	tx, err := h.walletService.Send(userID, uint(walletID), &req, c.Locals("apiKey") != nil)
+	if err != nil {
+		var limitErr *service.LimitExceededError
+		switch {
+		case errors.As(err, &limitErr):
+			return c.Status(http.StatusForbidden).JSON(models.LimitExceededResponse{
+				Error:   "Spending limit exceeded",
+				Message: limitErr.Error(),
+				Limit:   limitErr.Detail,
+			})
+		case errors.Is(err, service.ErrDestinationNotAllowlisted), errors.Is(err, service.ErrAPIKeySendNeedsAllowlist):
+			return c.Status(http.StatusForbidden).JSON(models.ErrorResponse{
+				Error:   "Destination not allowed",
+				Message: err.Error(),
+			})
+		case errors.Is(err, service.ErrWalletNotFound):
+			return c.Status(http.StatusNotFound).JSON(models.ErrorResponse{
+				Error:   "Wallet not found",
+				Message: err.Error(),
+			})
+		case errors.Is(err, repo.ErrInsufficientBalance):
+			return c.Status(http.StatusUnprocessableEntity).JSON(models.ErrorResponse{
+				Error:   "Insufficient balance",
+				Message: err.Error(),
+			})
+		case errors.Is(err, service.ErrLimitsUnavailable):
+			return c.Status(http.StatusServiceUnavailable).JSON(models.ErrorResponse{
+				Error:   "Send failed",
+				Message: err.Error(),
+			})
+		}
+
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Send failed",
+			Message: err.Error(),
+		})
+	}
+
+	actorID, actorType := middlewares.GetAuditActor(c)
+	h.audit.Record(middlewares.GetClientInfo(c), &models.AuditEvent{
+		ActorID:   actorID,
+		ActorType: actorType,
+		UserID:    &userID,
+		Action:    models.AuditActionWalletSend,
+		Target:    models.AuditTarget("transaction", tx.ID),
+		Changes: map[string]interface{}{
+			"wallet_id":  walletID,
+			"to_address": tx.ToAddress,
+			"amount":     tx.Amount,
+			"fee":        tx.Fee,
+			"currency":   tx.Currency,
+		},
+	})
+
+	return c.Status(http.StatusAccepted).JSON(fiber.Map{
+		"message":     "Withdrawal queued",
+		"transaction": tx,
+	})
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/inlovewithgo/transit-backend/main/middlewares"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"github.com/inlovewithgo/transit-backend/main/service"
+)
+
+type WebAuthnHandler struct {
+	webAuthnService *service.WebAuthnService
+	audit           *service.AuditService
+}
+
+func NewWebAuthnHandler(webAuthnService *service.WebAuthnService, audit *service.AuditService) *WebAuthnHandler {
+	return &WebAuthnHandler{
+		webAuthnService: webAuthnService,
+		audit:           audit,
+	}
+}
+
+// BeginRegistration handles POST /api/v1/webauthn/register/begin
+func (h *WebAuthnHandler) BeginRegistration(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	response, err := h.webAuthnService.BeginRegistration(userID)
+	if err != nil {
+		return webAuthnError(c, "Passkey registration failed", err)
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// FinishRegistration handles POST /api/v1/webauthn/register/finish
+func (h *WebAuthnHandler) FinishRegistration(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	req, ok := parseFinish(c)
+	if !ok {
+		return nil
+	}
+
+	credential, err := h.webAuthnService.FinishRegistration(userID, req)
+	if err != nil {
+		return webAuthnError(c, "Passkey registration failed", err)
+	}
+
+	h.audit.Record(middlewares.GetClientInfo(c), &models.AuditEvent{
+		ActorID: &userID,
+		UserID:  &userID,
+		Action:  models.AuditActionPasskeyAdded,
+		Target:  models.AuditTarget("passkey", credential.ID),
+		Changes: map[string]interface{}{"name": credential.Name},
+	})
+
+	return c.Status(http.StatusCreated).JSON(credential)
+}
+
+// ListCredentials handles GET /api/v1/webauthn/credentials
+func (h *WebAuthnHandler) ListCredentials(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	credentials, err := h.webAuthnService.ListCredentials(userID)
+	if err != nil {
+		return webAuthnError(c, "Failed to list passkeys", err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"credentials": credentials,
+	})
+}
+
+// DeleteCredential handles DELETE /api/v1/webauthn/credentials/:id
+func (h *WebAuthnHandler) DeleteCredential(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: "Invalid passkey ID",
+		})
+	}
+
+	if err := h.webAuthnService.DeleteCredential(userID, uint(id)); err != nil {
+		return webAuthnError(c, "Failed to delete passkey", err)
+	}
+
+	h.audit.Record(middlewares.GetClientInfo(c), &models.AuditEvent{
+		ActorID: &userID,
+		UserID:  &userID,
+		Action:  models.AuditActionPasskeyRemoved,
+		Target:  models.AuditTarget("passkey", id),
+	})
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Passkey removed",
+	})
+}
+
+// BeginLogin handles POST /api/v1/auth/webauthn/login/begin
+func (h *WebAuthnHandler) BeginLogin(c *fiber.Ctx) error {
+	response, err := h.webAuthnService.BeginLogin()
+	if err != nil {
+		return webAuthnError(c, "Passkey login failed", err)
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// FinishLogin handles POST /api/v1/auth/webauthn/login/finish
+func (h *WebAuthnHandler) FinishLogin(c *fiber.Ctx) error {
+	req, ok := parseFinish(c)
+	if !ok {
+		return nil
+	}
+
+	response, err := h.webAuthnService.FinishLogin(req, middlewares.GetClientInfo(c))
+	if err != nil {
+		return webAuthnError(c, "Passkey login failed", err)
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// BeginMFA handles POST /api/v1/auth/webauthn/mfa/begin
+func (h *WebAuthnHandler) BeginMFA(c *fiber.Ctx) error {
+	var req models.WebAuthnMFABeginRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide valid JSON data",
+		})
+	}
+
+	if req.MFAToken == "" {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Missing required fields",
+			Message: "MFA token is required",
+		})
+	}
+
+	response, err := h.webAuthnService.BeginMFA(req.MFAToken)
+	if err != nil {
+		return webAuthnError(c, "Verification failed", err)
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// FinishMFA handles POST /api/v1/auth/webauthn/mfa/finish
+func (h *WebAuthnHandler) FinishMFA(c *fiber.Ctx) error {
+	req, ok := parseFinish(c)
+	if !ok {
+		return nil
+	}
+
+	if req.MFAToken == "" {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Missing required fields",
+			Message: "MFA token is required",
+		})
+	}
+
+	response, err := h.webAuthnService.FinishMFA(req, middlewares.GetClientInfo(c))
+	if err != nil {
+		return webAuthnError(c, "Verification failed", err)
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// BeginStepUp handles POST /api/v1/auth/step-up/webauthn/begin
+func (h *WebAuthnHandler) BeginStepUp(c *fiber.Ctx) error {
+	claims, ok := middlewares.GetClaims(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	response, err := h.webAuthnService.BeginStepUp(claims)
+	if err != nil {
+		return webAuthnError(c, "Step-up failed", err)
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// FinishStepUp handles POST /api/v1/auth/step-up/webauthn/finish
+func (h *WebAuthnHandler) FinishStepUp(c *fiber.Ctx) error {
+	claims, ok := middlewares.GetClaims(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	req, ok := parseFinish(c)
+	if !ok {
+		return nil
+	}
+
+	response, err := h.webAuthnService.FinishStepUp(claims, req)
+	if err != nil {
+		return webAuthnError(c, "Step-up failed", err)
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// parseFinish reads a finish request. When it returns false the error
+// response has already been written.
+func parseFinish(c *fiber.Ctx) (*models.WebAuthnFinishRequest, bool) {
+	var req models.WebAuthnFinishRequest
+	if err := c.BodyParser(&req); err != nil {
+		c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide valid JSON data",
+		})
+		return nil, false
+	}
+
+	if req.ChallengeID == "" || len(req.Credential) == 0 {
+		c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Missing required fields",
+			Message: "Challenge ID and credential are required",
+		})
+		return nil, false
+	}
+
+	return &req, true
+}
+
+func webAuthnErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidWebAuthnChallenge), errors.Is(err, service.ErrPasskeyVerification),
+		errors.Is(err, service.ErrPasskeyCloned), errors.Is(err, service.ErrInvalidMFAToken):
+		return http.StatusUnauthorized
+	case errors.Is(err, service.ErrPasskeyNotFound), errors.Is(err, service.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrTooManyPasskeys):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func webAuthnError(c *fiber.Ctx, title string, err error) error {
+	return c.Status(webAuthnErrorStatus(err)).JSON(models.ErrorResponse{
+		Error:   title,
+		Message: err.Error(),
+	})
+}
+
+func unauthorized(c *fiber.Ctx) error {
+	return c.Status(http.StatusUnauthorized).JSON(models.ErrorResponse{
+		Error:   "Unauthorized",
+		Message: "Invalid or missing token",
+	})
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/inlovewithgo/transit-backend/main/middlewares"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"github.com/inlovewithgo/transit-backend/main/service"
+)
+
+type WebhookHandler struct {
+	webhookService *service.WebhookService
+}
+
+func NewWebhookHandler(webhookService *service.WebhookService) *WebhookHandler {
+	return &WebhookHandler{
+		webhookService: webhookService,
+	}
+}
+
+// CreateWebhook handles POST /api/v1/webhooks
+func (h *WebhookHandler) CreateWebhook(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	var req models.CreateWebhookRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: "Please provide valid JSON data",
+		})
+	}
+
+	response, err := h.webhookService.CreateSubscription(userID, &req)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+			Error:   "Webhook creation failed",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusCreated).JSON(response)
+}
+
+// ListWebhooks handles GET /api/v1/webhooks
+func (h *WebhookHandler) ListWebhooks(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	subs, err := h.webhookService.ListSubscriptions(userID)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to list webhooks",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"webhooks": subs,
+	})
+}
+
+// DeleteWebhook handles DELETE /api/v1/webhooks/:id
+func (h *WebhookHandler) DeleteWebhook(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return invalidID(c)
+	}
+
+	if err := h.webhookService.DeleteSubscription(userID, uint(id)); err != nil {
+		return c.Status(http.StatusNotFound).JSON(models.ErrorResponse{
+			Error:   "Webhook not found",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Webhook deleted",
+	})
+}
+
+// ListDeliveries handles GET /api/v1/webhooks/:id/deliveries
+func (h *WebhookHandler) ListDeliveries(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return invalidID(c)
+	}
+
+	deliveries, err := h.webhookService.ListDeliveries(userID, uint(id))
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(models.ErrorResponse{
+			Error:   "Webhook not found",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"deliveries": deliveries,
+	})
+}
+
+// Redeliver handles POST /api/v1/webhooks/deliveries/:id/redeliver
+func (h *WebhookHandler) Redeliver(c *fiber.Ctx) error {
+	userID, ok := middlewares.GetUserID(c)
+	if !ok {
+		return unauthorized(c)
+	}
+
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return invalidID(c)
+	}
+
+	delivery, err := h.webhookService.Redeliver(userID, uint(id))
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(models.ErrorResponse{
+			Error:   "Redelivery failed",
+			Message: err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusAccepted).JSON(fiber.Map{
+		"message":  "Redelivery queued",
+		"delivery": delivery,
+	})
+}
+
+func unauthorized(c *fiber.Ctx) error {
+	return c.Status(http.StatusUnauthorized).JSON(models.ErrorResponse{
+		Error:   "Unauthorized",
+		Message: "Invalid or missing token",
+	})
+}
+
+func invalidID(c *fiber.Ctx) error {
+	return c.Status(http.StatusBadRequest).JSON(models.ErrorResponse{
+		Error:   "Invalid request",
+		Message: "Invalid ID",
+	})
+}
@@ -0,0 +1,72 @@
+package middlewares
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/inlovewithgo/transit-backend/main/models"
+)
+
+const APIKeyHeader = "X-API-Key"
+
+// APIKeyAuthenticator resolves the key sent in X-API-Key to its owner.
+type APIKeyAuthenticator interface {
+	Authenticate(rawKey, ip string) (*models.APIKey, *models.User, error)
+}
+
+// APIKeyMiddleware is the API key counterpart of AuthMiddleware. It admits
+// keys granted scope, applies the key's own per-minute rate limit and sets
+// the same userID and userEmail locals, so handlers work with either.
+func APIKeyMiddleware(authenticator APIKeyAuthenticator, limiter *RateLimiter, scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rawKey := c.Get(APIKeyHeader)
+		if rawKey == "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+				Error:   "Unauthorized",
+				Message: "Missing API key",
+			})
+		}
+
+		key, user, err := authenticator.Authenticate(rawKey, c.IP())
+		if err != nil {
+			status := fiber.StatusUnauthorized
+			if errors.Is(err, models.ErrAPIKeyIPNotAllowed) {
+				status = fiber.StatusForbidden
+			}
+			return c.Status(status).JSON(models.ErrorResponse{
+				Error:   "Unauthorized",
+				Message: err.Error(),
+			})
+		}
+
+		if !key.HasScope(scope) {
+			return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+				Error:   "Forbidden",
+				Message: "API key is missing scope " + scope,
+			})
+		}
+
+		if !limiter.allow(c, "api-key", strconv.FormatUint(uint64(key.ID), 10), key.RateLimit, time.Minute) {
+			return limiter.reject(c, time.Minute)
+		}
+
+		c.Locals("userID", user.ID)
+		c.Locals("userEmail", user.Email)
+		c.Locals("apiKey", key)
+
+		return c.Next()
+	}
+}
+
+// AuthOrAPIKey runs apiKey when the request carries X-API-Key and bearer
+// otherwise.
+func AuthOrAPIKey(bearer, apiKey fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Get(APIKeyHeader) != "" {
+			return apiKey(c)
+		}
+		return bearer(c)
+	}
+}
@@ -1,12 +1,41 @@
 package middlewares
 
 import (
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/inlovewithgo/transit-backend/main/models"
 	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
 )
 
-func AuthMiddleware() fiber.Handler {
+// TokenRevocationChecker reports whether a validly signed access token has
+// since been revoked by logout.
+type TokenRevocationChecker interface {
+	IsRevoked(claims *utils.Claims) (bool, error)
+}
+
+type authOptions struct {
+	stepUpMaxAge time.Duration
+}
+
+// AuthOption adds requirements on top of a valid access token.
+type AuthOption func(*authOptions)
+
+// RequireStepUp only admits tokens whose step-up happened within maxAge.
+// Clients get a new token from POST /auth/step-up.
+func RequireStepUp(maxAge time.Duration) AuthOption {
+	return func(o *authOptions) {
+		o.stepUpMaxAge = maxAge
+	}
+}
+
+func AuthMiddleware(revocations TokenRevocationChecker, opts ...AuthOption) fiber.Handler {
+	var options authOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
 
@@ -34,9 +63,71 @@ func AuthMiddleware() fiber.Handler {
 			})
 		}
 
+		revoked, err := revocations.IsRevoked(claims)
+		if err != nil {
+			logger.Log.Error("Error checking token revocation for user %d: %v", claims.UserID, err)
+		}
+		if revoked {
+			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+				Error:   "Unauthorized",
+				Message: "Token has been revoked",
+			})
+		}
+
+		if options.stepUpMaxAge > 0 {
+			stepUpAt := time.Unix(claims.StepUpAt, 0)
+			if claims.StepUpAt == 0 || time.Since(stepUpAt) > options.stepUpMaxAge {
+				return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+					Error:   "Step-up required",
+					Message: "Please confirm your identity again to continue",
+				})
+			}
+		}
+
 		c.Locals("userID", claims.UserID)
 		c.Locals("userEmail", claims.Email)
+		c.Locals("claims", claims)
 
 		return c.Next()
 	}
 }
+
+// GetUserID returns the ID of the user authenticated by AuthMiddleware
+func GetUserID(c *fiber.Ctx) (uint, bool) {
+	userID, ok := c.Locals("userID").(uint)
+	return userID, ok && userID != 0
+}
+
+// GetClaims returns the access token claims validated by AuthMiddleware
+func GetClaims(c *fiber.Ctx) (*utils.Claims, bool) {
+	claims, ok := c.Locals("claims").(*utils.Claims)
+	return claims, ok && claims != nil
+}
+
+// GetRequestID returns the ID assigned by the requestid middleware
+func GetRequestID(c *fiber.Ctx) string {
+	requestID, _ := c.Locals("requestid").(string)
+	return requestID
+}
+
+// GetClientInfo describes the client for services and the audit log
+func GetClientInfo(c *fiber.Ctx) models.ClientInfo {
+	return models.ClientInfo{
+		IPAddress: c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		RequestID: GetRequestID(c),
+	}
+}
+
+// GetAuditActor returns the authenticated user and whether they used an
+// access token or an API key
+func GetAuditActor(c *fiber.Ctx) (*uint, string) {
+	userID, ok := GetUserID(c)
+	if !ok {
+		return nil, models.AuditActorAnonymous
+	}
+	if c.Locals("apiKey") != nil {
+		return &userID, models.AuditActorAPIKey
+	}
+	return &userID, models.AuditActorUser
+}
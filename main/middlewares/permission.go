@@ -0,0 +1,29 @@
+package middlewares
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/inlovewithgo/transit-backend/main/models"
+)
+
+// RequirePermission admits requests whose access token grants permission.
+// It must run after AuthMiddleware.
+func RequirePermission(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, ok := GetClaims(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+				Error:   "Unauthorized",
+				Message: "Invalid or missing token",
+			})
+		}
+
+		if !claims.HasPermission(permission) {
+			return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+				Error:   "Forbidden",
+				Message: "Missing permission " + permission,
+			})
+		}
+
+		return c.Next()
+	}
+}
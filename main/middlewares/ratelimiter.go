@@ -7,7 +7,6 @@ import (
 
 	"github.com/go-redis/redis/v8"
 	"github.com/gofiber/fiber/v2"
-	"github.com/inlovewithgo/transit-backend/main/utils"
 	"github.com/inlovewithgo/transit-backend/pkg/logger"
 )
 
@@ -15,82 +14,81 @@ type RateLimiter struct {
 	redisClient *redis.Client
 }
 
-func NewRateLimiter() *RateLimiter {
-	redisAddr := utils.GetENV("REDIS_ADDR", "localhost:6379")
-	redisPassword := utils.GetENV("REDIS_PASSWORD", "")
-
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     redisAddr,
-		Password: redisPassword,
-		DB:       0,
-	})
-
-	ctx := context.Background()
-	_, err := rdb.Ping(ctx).Result()
-	if err != nil {
-		logger.Log.Error("Failed to connect to Redis for rate limiting: %v", err)
-		return &RateLimiter{redisClient: nil}
+func NewRateLimiter(redisClient *redis.Client) *RateLimiter {
+	if redisClient == nil {
+		logger.Log.Error("Rate limiter created without Redis, rate limiting disabled")
 	}
 
-	logger.Log.Info("Rate limiter connected to Redis successfully")
-	return &RateLimiter{redisClient: rdb}
+	return &RateLimiter{redisClient: redisClient}
 }
 
 func (rl *RateLimiter) WaitlistRateLimit() fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		if rl.redisClient == nil {
-			logger.Log.Warn("Rate limiting disabled - Redis not available")
-			return c.Next()
-		}
+	return rl.Limit("waitlist", 5, time.Minute, func(c *fiber.Ctx) string {
+		return c.IP()
+	})
+}
 
-		clientIP := c.IP()
-		if clientIP == "" {
-			clientIP = "unknown"
+// Limit allows limit requests per window for each key returned by keyFunc.
+// Requests are let through when Redis is unavailable.
+func (rl *RateLimiter) Limit(name string, limit int, window time.Duration, keyFunc func(*fiber.Ctx) string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !rl.allow(c, name, keyFunc(c), limit, window) {
+			return rl.reject(c, window)
 		}
+		return c.Next()
+	}
+}
 
-		key := fmt.Sprintf("ratelimit:waitlist:%s", clientIP)
-		ctx := context.Background()
+// allow counts a request against name/id and sets the X-RateLimit headers.
+// It reports false once limit requests were made within window.
+func (rl *RateLimiter) allow(c *fiber.Ctx, name, id string, limit int, window time.Duration) bool {
+	if rl.redisClient == nil {
+		logger.Log.Warn("Rate limiting disabled - Redis not available")
+		return true
+	}
 
-		current, err := rl.redisClient.Get(ctx, key).Int()
-		if err != nil && err != redis.Nil {
-			logger.Log.Error("Failed to get rate limit count: %v", err)
-			return c.Next()
-		}
+	if id == "" {
+		id = "unknown"
+	}
 
-		limit := 5
-		if current >= limit {
-			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
-				"error":       true,
-				"message":     "Rate limit exceeded. Please try again later.",
-				"retry_after": 60,
-			})
-		}
+	key := fmt.Sprintf("ratelimit:%s:%s", name, id)
+	ctx := context.Background()
 
-		pipe := rl.redisClient.Pipeline()
-		pipe.Incr(ctx, key)
-		pipe.Expire(ctx, key, time.Minute)
-		_, err = pipe.Exec(ctx)
+	current, err := rl.redisClient.Get(ctx, key).Int()
+	if err != nil && err != redis.Nil {
+		logger.Log.Error("Failed to get rate limit count: %v", err)
+		return true
+	}
 
-		if err != nil {
-			logger.Log.Error("Failed to update rate limit count: %v", err)
-		}
+	if current >= limit {
+		return false
+	}
 
-		remaining := limit - (current + 1)
-		if remaining < 0 {
-			remaining = 0
-		}
+	pipe := rl.redisClient.Pipeline()
+	pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, window)
+	_, err = pipe.Exec(ctx)
 
-		c.Set("X-RateLimit-Limit", fmt.Sprintf("%d", limit))
-		c.Set("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
-		c.Set("X-RateLimit-Reset", fmt.Sprintf("%d", time.Now().Add(time.Minute).Unix()))
+	if err != nil {
+		logger.Log.Error("Failed to update rate limit count: %v", err)
+	}
 
-		return c.Next()
+	remaining := limit - (current + 1)
+	if remaining < 0 {
+		remaining = 0
 	}
+
+	c.Set("X-RateLimit-Limit", fmt.Sprintf("%d", limit))
+	c.Set("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
+	c.Set("X-RateLimit-Reset", fmt.Sprintf("%d", time.Now().Add(window).Unix()))
+
+	return true
 }
 
-func (rl *RateLimiter) Close() error {
-	if rl.redisClient != nil {
-		return rl.redisClient.Close()
-	}
-	return nil
+func (rl *RateLimiter) reject(c *fiber.Ctx, window time.Duration) error {
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error":       true,
+		"message":     "Rate limit exceeded. Please try again later.",
+		"retry_after": int(window.Seconds()),
+	})
 }
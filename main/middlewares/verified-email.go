@@ -0,0 +1,52 @@
+package middlewares
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+)
+
+// EmailVerificationChecker reports whether a user has confirmed their email.
+type EmailVerificationChecker interface {
+	IsEmailVerified(userID uint) (bool, error)
+}
+
+// RequireVerifiedEmail blocks users who haven't verified their email address.
+// It must run after AuthMiddleware. Set REQUIRE_VERIFIED_EMAIL=false to turn
+// the policy off.
+func RequireVerifiedEmail(checker EmailVerificationChecker) fiber.Handler {
+	enforced := utils.GetENV("REQUIRE_VERIFIED_EMAIL", "true") != "false"
+
+	return func(c *fiber.Ctx) error {
+		if !enforced {
+			return c.Next()
+		}
+
+		userID, ok := GetUserID(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+				Error:   "Unauthorized",
+				Message: "Invalid or missing token",
+			})
+		}
+
+		verified, err := checker.IsEmailVerified(userID)
+		if err != nil {
+			logger.Log.Error("Error checking email verification for user %d: %v", userID, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+				Error:   "Internal server error",
+				Message: "Failed to check email verification",
+			})
+		}
+
+		if !verified {
+			return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+				Error:   "Email not verified",
+				Message: "Please verify your email address to continue",
+			})
+		}
+
+		return c.Next()
+	}
+}
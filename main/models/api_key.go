@@ -0,0 +1,66 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	APIKeyScopeRead     = "read"
+	APIKeyScopeSend     = "send"
+	APIKeyScopeInvoices = "invoices"
+)
+
+// ErrAPIKeyIPNotAllowed is returned by key authentication for a request from
+// outside the key's allowed_ips.
+var ErrAPIKeyIPNotAllowed = errors.New("API key is not allowed from this IP address")
+
+// APIKeyScopes lists every scope a key may be granted.
+var APIKeyScopes = []string{
+	APIKeyScopeRead,
+	APIKeyScopeSend,
+	APIKeyScopeInvoices,
+}
+
+// APIKey lets scripts act for a user without their password. The full key is
+// shown once at creation; only its hash is stored. Prefix is the public part
+// used to find the key and to tell keys apart in listings.
+type APIKey struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	UserID     uint       `json:"user_id" gorm:"not null;index"`
+	Name       string     `json:"name" gorm:"not null"`
+	Prefix     string     `json:"prefix" gorm:"uniqueIndex;not null"`
+	KeyHash    string     `json:"-" gorm:"not null"`
+	Scopes     []string   `json:"scopes" gorm:"serializer:json;type:text;not null"`
+	AllowedIPs []string   `json:"allowed_ips" gorm:"serializer:json;type:text"`
+	RateLimit  int        `json:"rate_limit" gorm:"not null"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// AllowedIPs holds addresses or CIDR ranges; empty allows any IP.
+	AllowedIPs []string   `json:"allowed_ips"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	// RateLimit is requests per minute; 0 uses the default.
+	RateLimit int `json:"rate_limit"`
+}
+
+type CreateAPIKeyResponse struct {
+	APIKey *APIKey `json:"api_key"`
+	Key    string  `json:"key"`
+}
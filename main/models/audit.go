@@ -0,0 +1,103 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	AuditActorUser      = "user"
+	AuditActorAPIKey    = "api_key"
+	AuditActorAnonymous = "anonymous"
+)
+
+// Login methods recorded with AuditActionLogin.
+const (
+	LoginMethodPassword   = "password"
+	LoginMethodMagicLink  = "magic_link"
+	LoginMethodOIDC       = "oidc"
+	LoginMethodPasskey    = "passkey"
+	LoginMethodMFACode    = "mfa_code"
+	LoginMethodPasskeyMFA = "passkey_mfa"
+	LoginMethodSMS        = "sms"
+)
+
+// Audit actions. Keep them stable: support and exports filter on them.
+const (
+	AuditActionLogin              = "auth.login"
+	AuditActionLoginFailed        = "auth.login_failed"
+	AuditActionMFAFailed          = "auth.mfa_failed"
+	AuditActionTOTPEnabled        = "mfa.totp_enabled"
+	AuditActionTOTPDisabled       = "mfa.totp_disabled"
+	AuditActionRecoveryCodesReset = "mfa.recovery_codes_regenerated"
+	AuditActionPasskeyAdded       = "mfa.passkey_added"
+	AuditActionPasskeyRemoved     = "mfa.passkey_removed"
+	AuditActionSMSMFAEnabled      = "mfa.sms_enabled"
+	AuditActionSMSMFADisabled     = "mfa.sms_disabled"
+	AuditActionPhoneVerified      = "account.phone_verified"
+	AuditActionPhoneRemoved       = "account.phone_removed"
+	AuditActionWalletSend         = "wallet.send"
+	AuditActionAllowlistAdd       = "allowlist.address_added"
+	AuditActionAllowlistRemove    = "allowlist.address_removed"
+	AuditActionAllowlistCancel    = "allowlist.address_cancelled"
+	AuditActionAllowlistSettings  = "allowlist.settings_updated"
+	AuditActionAdminRoleChange    = "admin.role_changed"
+	AuditActionAdminLimitRule     = "admin.limit_rule_upserted"
+	AuditActionAdminOverrideAdd   = "admin.limit_override_created"
+	AuditActionAdminOverrideDel   = "admin.limit_override_deleted"
+	AuditActionAdminTxStatus      = "admin.transaction_status_updated"
+)
+
+// AuditEvent is one row of the append-only security audit log. Rows are
+// never updated or deleted; each stores the hash of the row before it, so
+// editing or removing a row breaks the chain from that point on.
+//
+// UserID is the account the event belongs to and decides whose audit
+// history it shows up in. ActorID is who did it: the same user, an admin
+// acting on them, or nobody for failed logins.
+type AuditEvent struct {
+	ID        uint                   `json:"id" gorm:"primaryKey"`
+	ActorID   *uint                  `json:"actor_id,omitempty" gorm:"index"`
+	ActorType string                 `json:"actor_type" gorm:"not null"`
+	UserID    *uint                  `json:"user_id,omitempty" gorm:"index"`
+	Action    string                 `json:"action" gorm:"not null;index"`
+	Target    string                 `json:"target,omitempty"`
+	IPAddress string                 `json:"ip_address"`
+	UserAgent string                 `json:"user_agent"`
+	RequestID string                 `json:"request_id,omitempty" gorm:"index"`
+	Changes   map[string]interface{} `json:"changes,omitempty" gorm:"serializer:json;type:text"`
+	PrevHash  string                 `json:"prev_hash" gorm:"not null"`
+	Hash      string                 `json:"hash" gorm:"not null;uniqueIndex"`
+	CreatedAt time.Time              `json:"created_at" gorm:"not null;index"`
+}
+
+// AuditTarget formats the object an event acted on, e.g. "wallet:12".
+func AuditTarget(kind string, id interface{}) string {
+	return fmt.Sprintf("%s:%v", kind, id)
+}
+
+// AuditChange records a field edit for AuditEvent.Changes.
+func AuditChange(from, to interface{}) map[string]interface{} {
+	return map[string]interface{}{"from": from, "to": to}
+}
+
+// AuditEventFilter narrows an audit log search. Zero values match anything.
+type AuditEventFilter struct {
+	UserID    uint
+	ActorID   uint
+	Action    string
+	IPAddress string
+	RequestID string
+	From      *time.Time
+	To        *time.Time
+	Limit     int
+	Offset    int
+}
+
+// AuditChainReport is the result of re-checking the hash chain.
+type AuditChainReport struct {
+	Valid   bool `json:"valid"`
+	Checked int  `json:"checked"`
+	// BrokenAt is the first event whose stored hashes don't match.
+	BrokenAt *uint `json:"broken_at,omitempty"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+const (
+	DataExportPending = "pending"
+	DataExportReady   = "ready"
+	DataExportFailed  = "failed"
+	DataExportExpired = "expired"
+)
+
+// DataExport is a user's request for a copy of their data. A worker builds
+// the ZIP archive and emails a download link; the file is deleted once the
+// link expires. Only the link token's hash is stored.
+type DataExport struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	UserID        uint       `json:"user_id" gorm:"not null;index"`
+	Status        string     `json:"status" gorm:"not null;default:pending;index"`
+	Attempts      int        `json:"-" gorm:"default:0"`
+	NextAttemptAt time.Time  `json:"-" gorm:"index"`
+	LastError     string     `json:"-"`
+	FilePath      string     `json:"-"`
+	TokenHash     string     `json:"-" gorm:"index"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// EmailHistoryEntry describes one email sent to the user, without its
+// content, which may contain single-use links.
+type EmailHistoryEntry struct {
+	Kind        string     `json:"kind"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
@@ -0,0 +1,88 @@
+package models
+
+import "time"
+
+const (
+	LimitScopeUser   = "user"
+	LimitScopeWallet = "wallet"
+)
+
+const (
+	LimitPeriodTransaction = "transaction"
+	LimitPeriodDaily       = "daily"
+	LimitPeriodMonthly     = "monthly"
+)
+
+const (
+	UserTierStandard = "standard"
+	UserTierVerified = "verified"
+	UserTierBusiness = "business"
+)
+
+// SpendingRule caps withdrawals for every user of a tier. A zero MaxAmount or
+// MaxFiatCents means that dimension is not limited.
+type SpendingRule struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	Tier         string    `json:"tier" gorm:"not null;uniqueIndex:idx_spending_rule"`
+	Currency     string    `json:"currency" gorm:"not null;uniqueIndex:idx_spending_rule"`
+	Scope        string    `json:"scope" gorm:"not null;uniqueIndex:idx_spending_rule"`
+	Period       string    `json:"period" gorm:"not null;uniqueIndex:idx_spending_rule"`
+	MaxAmount    int64     `json:"max_amount"`
+	MaxFiatCents int64     `json:"max_fiat_cents"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// WithdrawalTotals sums withdrawals for spending limits. UnpricedAmount is
+// the part of Amount with no fiat value stored.
+type WithdrawalTotals struct {
+	Amount         int64
+	FiatCents      int64
+	UnpricedAmount int64
+}
+
+// SpendingLimitOverride replaces the tier rule with the same scope and period
+// for a single user (and optionally a single wallet) until it expires.
+type SpendingLimitOverride struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	UserID       uint       `json:"user_id" gorm:"not null;index"`
+	WalletID     *uint      `json:"wallet_id,omitempty" gorm:"index"`
+	Scope        string     `json:"scope" gorm:"not null"`
+	Period       string     `json:"period" gorm:"not null"`
+	MaxAmount    int64      `json:"max_amount"`
+	MaxFiatCents int64      `json:"max_fiat_cents"`
+	Reason       string     `json:"reason"`
+	CreatedBy    string     `json:"created_by"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+type SpendingLimitOverrideRequest struct {
+	UserID       uint       `json:"user_id"`
+	WalletID     *uint      `json:"wallet_id,omitempty"`
+	Scope        string     `json:"scope"`
+	Period       string     `json:"period"`
+	MaxAmount    int64      `json:"max_amount"`
+	MaxFiatCents int64      `json:"max_fiat_cents"`
+	Reason       string     `json:"reason"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+}
+
+// LimitExceededResponse is the 403 payload returned when a withdrawal would
+// break a spending limit.
+type LimitExceededResponse struct {
+	Error   string              `json:"error"`
+	Message string              `json:"message"`
+	Limit   LimitExceededDetail `json:"limit"`
+}
+
+type LimitExceededDetail struct {
+	Scope     string     `json:"scope"`
+	Period    string     `json:"period"`
+	Unit      string     `json:"unit"`
+	Limit     int64      `json:"limit"`
+	Used      int64      `json:"used"`
+	Requested int64      `json:"requested"`
+	Remaining int64      `json:"remaining"`
+	ResetsAt  *time.Time `json:"resets_at,omitempty"`
+}
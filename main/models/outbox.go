@@ -0,0 +1,137 @@
+package models
+
+import "time"
+
+const (
+	OutboxKindWelcomeEmail         = "email.welcome"
+	OutboxKindLoginNotification    = "email.login_notification"
+	OutboxKindWaitlistConfirmation = "email.waitlist_confirmation"
+	OutboxKindWithdrawalAddress    = "email.withdrawal_address_added"
+	OutboxKindEmailVerification    = "email.verification"
+	OutboxKindPasswordReset        = "email.password_reset"
+	OutboxKindPasswordChanged      = "email.password_changed"
+	OutboxKindAccountLocked        = "email.account_locked"
+	OutboxKindMagicLink            = "email.magic_link"
+	OutboxKindEmailChange          = "email.email_change"
+	OutboxKindAccountDeleted       = "email.account_deleted"
+	OutboxKindDataExportReady      = "email.data_export_ready"
+	OutboxKindWithdrawalAlert      = "notify.withdrawal_alert"
+	OutboxKindEvent                = "event.publish"
+	OutboxKindWebhook              = "webhook.publish"
+)
+
+// OutboxRedactedPayload replaces the payload of finished messages.
+const OutboxRedactedPayload = "{}"
+
+const (
+	OutboxStatusPending   = "pending"
+	OutboxStatusDelivered = "delivered"
+	OutboxStatusFailed    = "failed"
+)
+
+// OutboxMessage is a side effect recorded in the same database transaction as
+// the change that caused it, and delivered later by the outbox relay.
+//
+// Payloads can hold single-use links, so they are cleared once the message
+// is delivered or given up on. Recipient and Channel are kept for the
+// user's email history.
+type OutboxMessage struct {
+	ID      uint   `json:"id" gorm:"primaryKey"`
+	Kind    string `json:"kind" gorm:"not null;index"`
+	Payload string `json:"payload" gorm:"type:text;not null"`
+	// Recipient is the lowercased email address or the phone number the
+	// message goes to, if any.
+	Recipient string `json:"recipient,omitempty" gorm:"not null;default:'';index"`
+	Channel   string `json:"channel,omitempty" gorm:"not null;default:''"`
+	// OrderingKey groups messages that must be delivered in order. A message
+	// waits while an earlier one with the same key is still pending.
+	OrderingKey   string     `json:"ordering_key,omitempty" gorm:"not null;default:'';index"`
+	Status        string     `json:"status" gorm:"not null;default:pending;index"`
+	Attempts      int        `json:"attempts" gorm:"default:0"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"index"`
+	LastError     string     `json:"last_error,omitempty"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+func (OutboxMessage) TableName() string {
+	return "outbox"
+}
+
+type EmailRecipientPayload struct {
+	Email     string `json:"email"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+}
+
+// LoginNotificationPayload extends EmailRecipientPayload with the device that
+// signed in.
+type LoginNotificationPayload struct {
+	Email     string `json:"email"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+	Device    string `json:"device,omitempty"`
+	IPAddress string `json:"ip_address,omitempty"`
+}
+
+// LinkEmailPayload is used by emails whose main content is a single link,
+// such as email verification.
+type LinkEmailPayload struct {
+	Email     string `json:"email"`
+	FirstName string `json:"first_name,omitempty"`
+	URL       string `json:"url"`
+}
+
+// EmailChangePayload asks the recipient, at either the current or the new
+// address, to confirm a change to NewEmail.
+type EmailChangePayload struct {
+	Email     string `json:"email"`
+	FirstName string `json:"first_name,omitempty"`
+	NewEmail  string `json:"new_email"`
+	URL       string `json:"url"`
+}
+
+type AccountDeletedPayload struct {
+	Email     string    `json:"email"`
+	FirstName string    `json:"first_name,omitempty"`
+	PurgeAt   time.Time `json:"purge_at"`
+}
+
+// DataExportReadyPayload carries the download link of a finished data export.
+type DataExportReadyPayload struct {
+	Email     string    `json:"email"`
+	FirstName string    `json:"first_name,omitempty"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// WithdrawalAlertPayload tells the user a withdrawal left their wallet, over
+// Channel. Amount is already formatted in Currency units.
+type WithdrawalAlertPayload struct {
+	Channel       string `json:"channel"`
+	Email         string `json:"email,omitempty"`
+	FirstName     string `json:"first_name,omitempty"`
+	PhoneNumber   string `json:"phone_number,omitempty"`
+	TransactionID uint   `json:"transaction_id"`
+	Amount        string `json:"amount"`
+	Currency      string `json:"currency"`
+	ToAddress     string `json:"to_address"`
+}
+
+type EventPayload struct {
+	Topic   string            `json:"topic"`
+	Key     string            `json:"key"`
+	Value   string            `json:"value"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// WebhookPayload carries its event ID so a retried outbox message fans out
+// as the same event.
+type WebhookPayload struct {
+	EventID    string      `json:"event_id"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	UserID     uint        `json:"user_id"`
+	EventType  string      `json:"event_type"`
+	Data       interface{} `json:"data"`
+}
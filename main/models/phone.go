@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+const (
+	SMSCodePurposeVerification = "phone_verification"
+	SMSCodePurposeLogin        = "login"
+	SMSCodePurposeStepUp       = "step_up"
+)
+
+const (
+	NotifyChannelEmail = "email"
+	NotifyChannelSMS   = "sms"
+)
+
+// SMSCode is a one-time code texted to a user, either to prove they own a
+// phone number or as a login second factor. Only the hash is stored, and a
+// code stops working after too many wrong guesses.
+type SMSCode struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	UserID      uint       `json:"-" gorm:"not null;index"`
+	Purpose     string     `json:"-" gorm:"not null"`
+	PhoneNumber string     `json:"-" gorm:"not null"`
+	CodeHash    string     `json:"-" gorm:"not null"`
+	Attempts    int        `json:"-" gorm:"not null;default:0"`
+	ExpiresAt   time.Time  `json:"-" gorm:"not null"`
+	UsedAt      *time.Time `json:"-"`
+	CreatedAt   time.Time  `json:"-" gorm:"index"`
+}
+
+type PhoneNumberRequest struct {
+	PhoneNumber string `json:"phone_number"`
+}
+
+type SMSCodeSentResponse struct {
+	// PhoneNumber is masked, e.g. "+44******7890".
+	PhoneNumber string    `json:"phone_number"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Message     string    `json:"message"`
+}
+
+type SMSMFASendRequest struct {
+	MFAToken string `json:"mfa_token"`
+}
@@ -0,0 +1,54 @@
+package models
+
+// Built-in roles. Every user has exactly one.
+const (
+	RoleUser       = "user"
+	RoleSupport    = "support"
+	RoleCompliance = "compliance"
+	RoleAdmin      = "admin"
+)
+
+// Permissions checked by RequirePermission.
+const (
+	PermissionWaitlistRead = "waitlist:read"
+	PermissionUsersRead    = "users:read"
+	PermissionUsersWrite   = "users:write"
+	PermissionLimitsRead   = "limits:read"
+	PermissionLimitsWrite  = "limits:write"
+	PermissionAuditRead    = "audit:read"
+	// PermissionTransactionsWrite lets the chain watcher report status changes.
+	PermissionTransactionsWrite = "transactions:write"
+)
+
+// AllPermissions is every permission known to the code; admins get all of them.
+var AllPermissions = []string{
+	PermissionWaitlistRead,
+	PermissionUsersRead,
+	PermissionUsersWrite,
+	PermissionLimitsRead,
+	PermissionLimitsWrite,
+	PermissionAuditRead,
+	PermissionTransactionsWrite,
+}
+
+// DefaultRolePermissions is seeded at startup. Seeding only adds missing
+// grants, so permissions granted by hand in the database are kept.
+var DefaultRolePermissions = map[string][]string{
+	RoleUser:       {},
+	RoleSupport:    {PermissionWaitlistRead, PermissionUsersRead, PermissionAuditRead},
+	RoleCompliance: {PermissionWaitlistRead, PermissionUsersRead, PermissionLimitsRead, PermissionAuditRead},
+	RoleAdmin:      AllPermissions,
+}
+
+type Role struct {
+	Name        string       `json:"name" gorm:"primaryKey"`
+	Permissions []Permission `json:"permissions" gorm:"many2many:role_permissions"`
+}
+
+type Permission struct {
+	Name string `json:"name" gorm:"primaryKey"`
+}
+
+type UpdateUserRoleRequest struct {
+	Role string `json:"role"`
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	DeviceTypeDesktop = "desktop"
+	DeviceTypeMobile  = "mobile"
+	DeviceTypeTablet  = "tablet"
+	DeviceTypeBot     = "bot"
+	DeviceTypeUnknown = "unknown"
+)
+
+// Session is one logged-in device. It lives as long as its refresh token
+// family, so revoking the session also revokes the family.
+type Session struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	UserID     uint       `json:"-" gorm:"not null;index"`
+	FamilyID   string     `json:"-" gorm:"not null;uniqueIndex"`
+	IPAddress  string     `json:"ip_address"`
+	UserAgent  string     `json:"user_agent"`
+	Browser    string     `json:"browser"`
+	OS         string     `json:"os"`
+	DeviceType string     `json:"device_type"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+
+	Current bool `json:"current" gorm:"-"`
+}
+
+// Device is a short human-readable description, e.g. "Chrome on Windows (desktop)".
+func (s *Session) Device() string {
+	browser, os := s.Browser, s.OS
+	if browser == "" {
+		browser = "Unknown browser"
+	}
+	if os == "" {
+		os = "unknown OS"
+	}
+	return fmt.Sprintf("%s on %s (%s)", browser, os, s.DeviceType)
+}
+
+// ClientInfo describes the client making a request. RequestID comes from
+// the requestid middleware and ties audit events to access logs.
+type ClientInfo struct {
+	IPAddress string
+	UserAgent string
+	RequestID string
+}
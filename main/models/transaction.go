@@ -1 +1,96 @@
-package models
\ No newline at end of file
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	TransactionTypeSend    = "send"
+	TransactionTypeReceive = "receive"
+)
+
+const (
+	TransactionStatusPending   = "pending"
+	TransactionStatusBroadcast = "broadcast"
+	TransactionStatusConfirmed = "confirmed"
+	TransactionStatusFailed    = "failed"
+)
+
+// Transaction amounts are stored in the currency's base unit (litoshi for LTC).
+// FiatCents is a send's value when it was made, counted against fiat spending
+// limits; it is 0 when no rate was available.
+type Transaction struct {
+	ID            uint           `json:"id" gorm:"primaryKey"`
+	UserID        uint           `json:"user_id" gorm:"not null;index"`
+	WalletID      uint           `json:"wallet_id" gorm:"not null;index"`
+	Type          string         `json:"type" gorm:"not null"`
+	Status        string         `json:"status" gorm:"not null;default:pending;index"`
+	Currency      string         `json:"currency" gorm:"not null"`
+	Amount        int64          `json:"amount" gorm:"not null"`
+	Fee           int64          `json:"fee"`
+	FiatCents     int64          `json:"-" gorm:"not null;default:0"`
+	FromAddress   string         `json:"from_address,omitempty"`
+	ToAddress     string         `json:"to_address,omitempty"`
+	TxHash        string         `json:"tx_hash,omitempty" gorm:"index"`
+	Confirmations int            `json:"confirmations"`
+	FailureReason string         `json:"failure_reason,omitempty"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	DeletedAt     gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+const TransactionEventSchemaVersion = 1
+
+const (
+	TransactionEventCreated       = "transaction.created"
+	TransactionEventStatusChanged = "transaction.status_changed"
+)
+
+// TransactionEvent is the versioned payload published for every transaction
+// state change. Consumers should ignore fields they don't recognise and branch
+// on SchemaVersion for breaking changes.
+type TransactionEvent struct {
+	SchemaVersion  int         `json:"schema_version"`
+	EventID        string      `json:"event_id"`
+	EventType      string      `json:"event_type"`
+	OccurredAt     time.Time   `json:"occurred_at"`
+	PreviousStatus string      `json:"previous_status,omitempty"`
+	Transaction    Transaction `json:"transaction"`
+}
+
+// TransactionStatusUpdate is reported by the chain watcher as a transaction
+// moves through broadcast, confirmed or failed.
+type TransactionStatusUpdate struct {
+	Status        string `json:"status"`
+	TxHash        string `json:"tx_hash"`
+	Confirmations int    `json:"confirmations"`
+	FailureReason string `json:"failure_reason"`
+}
+
+// transactionTransitions lists the statuses each status may move to. A
+// status may always be repeated to update confirmations or the hash.
+var transactionTransitions = map[string][]string{
+	TransactionStatusPending:   {TransactionStatusBroadcast, TransactionStatusConfirmed, TransactionStatusFailed},
+	TransactionStatusBroadcast: {TransactionStatusConfirmed, TransactionStatusFailed},
+	TransactionStatusConfirmed: {},
+	TransactionStatusFailed:    {},
+}
+
+// CanTransition reports whether a transaction in status from may move to to.
+func CanTransition(from, to string) bool {
+	next, ok := transactionTransitions[from]
+	if !ok {
+		return false
+	}
+	if from == to {
+		return true
+	}
+	for _, status := range next {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
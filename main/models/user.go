@@ -7,20 +7,102 @@ import (
 )
 
 type User struct {
-	ID        uint           `json:"id" gorm:"primaryKey"`
-	Email     string         `json:"email" gorm:"uniqueIndex;not null"`
-	Password  string         `json:"-" gorm:"not null"`
-	FirstName string         `json:"first_name" gorm:"not null"`
-	LastName  string         `json:"last_name" gorm:"not null"`
-	IsActive  bool           `json:"is_active" gorm:"default:true"`
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	Email     string `json:"email" gorm:"uniqueIndex:idx_users_email_active,where:deleted_at IS NULL;not null"`
+	Password  string `json:"-" gorm:"not null"`
+	FirstName string `json:"first_name" gorm:"not null"`
+	LastName  string `json:"last_name" gorm:"not null"`
+	IsActive  bool   `json:"is_active" gorm:"default:true"`
+	Tier      string `json:"tier" gorm:"not null;default:standard"`
+	// Role names a row in roles; its permissions are embedded in access tokens.
+	Role string `json:"role" gorm:"not null;default:user;index"`
+
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	// TokenVersion is embedded in access tokens; bumping it invalidates every
+	// token issued before.
+	TokenVersion int `json:"-" gorm:"not null;default:0"`
+
+	// TOTPSecret is encrypted with ENCRYPTION_KEY. It is set during setup and
+	// only enforced once TOTPEnabled is true.
+	TOTPSecret  string `json:"-" gorm:"type:text"`
+	TOTPEnabled bool   `json:"mfa_enabled" gorm:"default:false"`
+	// TOTPLastUsedStep rejects replays of a code within its validity window.
+	TOTPLastUsedStep int64 `json:"-" gorm:"default:0"`
+	// WebAuthnHandle is the random user handle given to passkeys, so they
+	// don't carry the database ID. It is set on first registration.
+	WebAuthnHandle []byte `json:"-" gorm:"uniqueIndex"`
+
+	// PhoneNumber is stored in E.164 format. It only receives SMS once
+	// PhoneVerifiedAt is set.
+	PhoneNumber     string     `json:"phone_number,omitempty"`
+	PhoneVerifiedAt *time.Time `json:"phone_verified_at,omitempty"`
+	// SMSMFAEnabled accepts a code sent to the verified phone as a second
+	// factor, alongside or instead of TOTP.
+	SMSMFAEnabled bool `json:"sms_mfa_enabled" gorm:"column:sms_mfa_enabled;default:false"`
+
+	WithdrawalAllowlistEnabled bool `json:"withdrawal_allowlist_enabled" gorm:"default:false"`
+	// WithdrawalAllowlistDisablesAt delays turning the allowlist off by the
+	// same cooling-off period used for new addresses.
+	WithdrawalAllowlistDisablesAt *time.Time `json:"withdrawal_allowlist_disables_at,omitempty"`
+
+	Preferences UserPreferences `json:"preferences" gorm:"serializer:json;type:text"`
+
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"index"`
 }
 
+// MFAEnabled reports whether logins need a second factor.
+func (u *User) MFAEnabled() bool {
+	return u.TOTPEnabled || u.SMSMFAEnabled
+}
+
+// PhoneVerified reports whether SMS can be sent to the user's phone.
+func (u *User) PhoneVerified() bool {
+	return u.PhoneNumber != "" && u.PhoneVerifiedAt != nil
+}
+
+// UserPreferences are display and notification settings chosen by the user.
+type UserPreferences struct {
+	Language        string `json:"language,omitempty"`
+	Timezone        string `json:"timezone,omitempty"`
+	DisplayCurrency string `json:"display_currency,omitempty"`
+	MarketingEmails bool   `json:"marketing_emails"`
+	// SMSWithdrawalAlerts also texts withdrawal alerts to the verified phone.
+	SMSWithdrawalAlerts bool `json:"sms_withdrawal_alerts"`
+}
+
+// UpdateProfileRequest only changes the fields that are present.
+type UpdateProfileRequest struct {
+	FirstName   *string          `json:"first_name"`
+	LastName    *string          `json:"last_name"`
+	Preferences *UserPreferences `json:"preferences"`
+}
+
+type ChangeEmailRequest struct {
+	NewEmail string `json:"new_email"`
+}
+
+// EmailChangeRequest holds a pending email change. It is applied once the
+// links sent to both the current and the new address have been opened.
+// Only token hashes are stored.
+type EmailChangeRequest struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	UserID         uint       `json:"user_id" gorm:"not null;index"`
+	NewEmail       string     `json:"new_email" gorm:"not null"`
+	OldTokenHash   string     `json:"-" gorm:"not null;uniqueIndex"`
+	NewTokenHash   string     `json:"-" gorm:"not null;uniqueIndex"`
+	OldConfirmedAt *time.Time `json:"old_confirmed_at,omitempty"`
+	NewConfirmedAt *time.Time `json:"new_confirmed_at,omitempty"`
+	ExpiresAt      time.Time  `json:"expires_at" gorm:"not null"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+	CancelledAt    *time.Time `json:"cancelled_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
 type RegisterRequest struct {
 	Email     string `json:"email" binding:"required,email"`
-	Password  string `json:"password" binding:"required,min=6"`
+	Password  string `json:"password" binding:"required"`
 	FirstName string `json:"first_name" binding:"required"`
 	LastName  string `json:"last_name" binding:"required"`
 }
@@ -31,7 +113,28 @@ type LoginRequest struct {
 }
 
 type AuthResponse struct {
-	User        User   `json:"user"`
-	AccessToken string `json:"access_token"`
-	Message     string `json:"message"`
+	User         *User  `json:"user,omitempty"`
+	AccessToken  string `json:"access_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+	// MFARequired is set instead of the tokens when the account has 2FA on;
+	// MFAToken must then be exchanged at /auth/mfa/verify.
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+	// MFAMethods lists the second factors the user can answer with:
+	// any of "totp", "sms" and "webauthn".
+	MFAMethods []string `json:"mfa_methods,omitempty"`
+	Message    string   `json:"message"`
+}
+
+// StepUpRequest re-proves identity with either the password or a TOTP code.
+type StepUpRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type StepUpResponse struct {
+	AccessToken   string    `json:"access_token"`
+	ExpiresIn     int64     `json:"expires_in"`
+	StepUpExpires time.Time `json:"step_up_expires_at"`
 }
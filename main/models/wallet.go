@@ -1 +1,25 @@
-package models
\ No newline at end of file
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Wallet balances are kept in the currency's base unit (litoshi for LTC).
+type Wallet struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`
+	UserID    uint           `json:"user_id" gorm:"not null;index"`
+	Currency  string         `json:"currency" gorm:"not null;default:LTC"`
+	Label     string         `json:"label"`
+	Address   string         `json:"address" gorm:"uniqueIndex;not null"`
+	Balance   int64          `json:"balance" gorm:"not null;default:0"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+type SendRequest struct {
+	ToAddress string `json:"to_address"`
+	Amount    int64  `json:"amount"`
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	WebAuthnCeremonyRegistration = "registration"
+	WebAuthnCeremonyLogin        = "login"
+	WebAuthnCeremonyMFA          = "mfa"
+	WebAuthnCeremonyStepUp       = "step_up"
+)
+
+// WebAuthnCredential is a passkey or security key registered by a user.
+// SignCount is the authenticator's signature counter from the last
+// assertion; a counter that doesn't increase hints at a cloned key.
+type WebAuthnCredential struct {
+	ID              uint       `json:"id" gorm:"primaryKey"`
+	UserID          uint       `json:"-" gorm:"not null;index"`
+	Name            string     `json:"name" gorm:"not null"`
+	CredentialID    []byte     `json:"-" gorm:"not null;uniqueIndex"`
+	PublicKey       []byte     `json:"-" gorm:"not null"`
+	AttestationType string     `json:"-"`
+	Transports      []string   `json:"transports" gorm:"serializer:json;type:text"`
+	AAGUID          []byte     `json:"-"`
+	SignCount       uint32     `json:"-" gorm:"not null;default:0"`
+	UserVerified    bool       `json:"-"`
+	BackupEligible  bool       `json:"backup_eligible"`
+	BackupState     bool       `json:"synced"`
+	LastUsedAt      *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// WebAuthnChallenge holds the server side of a ceremony between its begin
+// and finish calls. The client gets the ID token; only its hash is stored
+// and the challenge can be used once.
+type WebAuthnChallenge struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	TokenHash   string     `json:"-" gorm:"not null;uniqueIndex"`
+	UserID      uint       `json:"-" gorm:"index"`
+	Ceremony    string     `json:"-" gorm:"not null"`
+	SessionData string     `json:"-" gorm:"type:text;not null"`
+	ExpiresAt   time.Time  `json:"-" gorm:"not null"`
+	UsedAt      *time.Time `json:"-"`
+	CreatedAt   time.Time  `json:"-"`
+}
+
+type WebAuthnMFABeginRequest struct {
+	MFAToken string `json:"mfa_token"`
+}
+
+// WebAuthnBeginResponse carries the options to pass to
+// navigator.credentials.create() or .get(), and the challenge ID to send
+// back with the result.
+type WebAuthnBeginResponse struct {
+	ChallengeID string      `json:"challenge_id"`
+	Options     interface{} `json:"options"`
+}
+
+// WebAuthnFinishRequest wraps the PublicKeyCredential returned by the
+// browser, serialized as JSON.
+type WebAuthnFinishRequest struct {
+	ChallengeID string          `json:"challenge_id"`
+	Name        string          `json:"name,omitempty"`
+	MFAToken    string          `json:"mfa_token,omitempty"`
+	Credential  json.RawMessage `json:"credential"`
+}
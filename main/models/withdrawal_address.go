@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WithdrawalAddress is an allowlisted destination. It can only be used once
+// ActivatesAt has passed, giving the owner time to cancel unexpected additions.
+type WithdrawalAddress struct {
+	ID              uint           `json:"id" gorm:"primaryKey"`
+	UserID          uint           `json:"user_id" gorm:"not null;index"`
+	Currency        string         `json:"currency" gorm:"not null;default:LTC"`
+	Address         string         `json:"address" gorm:"not null"`
+	Label           string         `json:"label"`
+	ActivatesAt     time.Time      `json:"activates_at" gorm:"not null"`
+	CancelTokenHash string         `json:"-" gorm:"uniqueIndex"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+	DeletedAt       gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+func (a *WithdrawalAddress) IsActive(now time.Time) bool {
+	return !now.Before(a.ActivatesAt)
+}
+
+type AddWithdrawalAddressRequest struct {
+	Currency string `json:"currency"`
+	Address  string `json:"address"`
+	Label    string `json:"label"`
+}
+
+// CancelWithdrawalAddressRequest is posted by the page the cancel link in
+// the confirmation email opens.
+type CancelWithdrawalAddressRequest struct {
+	Token string `json:"token" form:"token"`
+}
+
+type WithdrawalAllowlistSettingsRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+type WithdrawalAllowlistStatus struct {
+	Enabled    bool                `json:"enabled"`
+	DisablesAt *time.Time          `json:"disables_at,omitempty"`
+	Addresses  []WithdrawalAddress `json:"addresses"`
+}
+
+type WithdrawalAddressEmailPayload struct {
+	Email       string    `json:"email"`
+	FirstName   string    `json:"first_name"`
+	Address     string    `json:"address"`
+	Label       string    `json:"label"`
+	ActivatesAt time.Time `json:"activates_at"`
+	CancelURL   string    `json:"cancel_url"`
+}
@@ -0,0 +1,21 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"gorm.io/gorm"
+)
+
+type APIKeyRepository interface {
+	WithTx(tx *gorm.DB) APIKeyRepository
+	Create(key *models.APIKey) error
+	GetByPrefix(prefix string) (*models.APIKey, error)
+	ListByUser(userID uint) ([]models.APIKey, error)
+	CountActiveByUser(userID uint, now time.Time) (int64, error)
+	// Revoke revokes an active key owned by userID and reports whether one
+	// matched.
+	Revoke(id, userID uint, at time.Time) (bool, error)
+	RevokeAllForUser(userID uint, at time.Time) error
+	TouchLastUsed(id uint, ip string, at time.Time) error
+}
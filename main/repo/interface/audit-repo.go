@@ -0,0 +1,23 @@
+package repo
+
+import (
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"gorm.io/gorm"
+)
+
+// AuditRepository only ever inserts audit events; there is no update or
+// delete.
+type AuditRepository interface {
+	WithTx(tx *gorm.DB) AuditRepository
+	// LockChain serializes appends until the transaction ends, so two events
+	// can't link to the same predecessor.
+	LockChain() error
+	// LastHash returns the hash of the newest event, or "" if there is none.
+	LastHash() (string, error)
+	Append(event *models.AuditEvent) error
+	Search(filter models.AuditEventFilter) ([]models.AuditEvent, error)
+	// ListByUser returns every event on the user's account, oldest first.
+	ListByUser(userID uint) ([]models.AuditEvent, error)
+	// ListAfter returns events with an ID above afterID in chain order.
+	ListAfter(afterID uint, limit int) ([]models.AuditEvent, error)
+}
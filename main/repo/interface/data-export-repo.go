@@ -0,0 +1,20 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"gorm.io/gorm"
+)
+
+type DataExportRepository interface {
+	WithTx(tx *gorm.DB) DataExportRepository
+	Create(export *models.DataExport) error
+	// GetPendingByUser returns the user's export that is still being built.
+	GetPendingByUser(userID uint) (*models.DataExport, error)
+	ClaimDue(now time.Time, limit int, lease time.Duration) ([]models.DataExport, error)
+	GetReadyByTokenHash(hash string) (*models.DataExport, error)
+	// ListExpired returns ready exports whose download link has expired.
+	ListExpired(now time.Time, limit int) ([]models.DataExport, error)
+	Update(export *models.DataExport) error
+}
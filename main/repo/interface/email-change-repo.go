@@ -0,0 +1,18 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"gorm.io/gorm"
+)
+
+type EmailChangeRepository interface {
+	WithTx(tx *gorm.DB) EmailChangeRepository
+	Create(change *models.EmailChangeRequest) error
+	// GetByTokenHashForUpdate matches either the old or the new address's token.
+	GetByTokenHashForUpdate(hash string) (*models.EmailChangeRequest, error)
+	Update(change *models.EmailChangeRequest) error
+	// CancelForUser cancels every pending change of the user.
+	CancelForUser(userID uint, at time.Time) error
+}
@@ -0,0 +1,16 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"gorm.io/gorm"
+)
+
+type IdentityRepository interface {
+	WithTx(tx *gorm.DB) IdentityRepository
+	Create(identity *models.UserIdentity) error
+	GetByProviderSubject(provider, subject string) (*models.UserIdentity, error)
+	TouchLogin(id uint, at time.Time) error
+	ListByUser(userID uint) ([]models.UserIdentity, error)
+}
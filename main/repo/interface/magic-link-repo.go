@@ -0,0 +1,16 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"gorm.io/gorm"
+)
+
+type MagicLinkRepository interface {
+	WithTx(tx *gorm.DB) MagicLinkRepository
+	Create(token *models.MagicLinkToken) error
+	GetByHashForUpdate(hash string) (*models.MagicLinkToken, error)
+	// InvalidateForUser marks all unused links of the user as used.
+	InvalidateForUser(userID uint, at time.Time) error
+}
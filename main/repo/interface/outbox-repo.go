@@ -0,0 +1,29 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"gorm.io/gorm"
+)
+
+// Transactor runs fn inside a database transaction. Repositories join it
+// through their WithTx method.
+type Transactor interface {
+	WithinTransaction(fn func(tx *gorm.DB) error) error
+}
+
+type OutboxRepository interface {
+	WithTx(tx *gorm.DB) OutboxRepository
+	Enqueue(msg *models.OutboxMessage) error
+	ClaimDue(now time.Time, limit int, lease time.Duration) ([]models.OutboxMessage, error)
+	Update(msg *models.OutboxMessage) error
+	// DeleteFinishedBefore removes delivered and failed messages last updated
+	// before the given time.
+	DeleteFinishedBefore(before time.Time) (int64, error)
+	// CancelPending marks undelivered messages of kind to recipient as
+	// failed and clears their payloads, for links that no longer work.
+	CancelPending(kind, recipient, reason string) error
+	// ListEmailsTo returns the emails queued for the given address.
+	ListEmailsTo(email string) ([]models.EmailHistoryEntry, error)
+}
@@ -0,0 +1,16 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"gorm.io/gorm"
+)
+
+type PasswordResetRepository interface {
+	WithTx(tx *gorm.DB) PasswordResetRepository
+	Create(token *models.PasswordResetToken) error
+	GetByHashForUpdate(hash string) (*models.PasswordResetToken, error)
+	// InvalidateForUser marks every unused token of the user as used.
+	InvalidateForUser(userID uint, at time.Time) error
+}
@@ -0,0 +1,16 @@
+package repo
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type RecoveryCodeRepository interface {
+	WithTx(tx *gorm.DB) RecoveryCodeRepository
+	// ReplaceForUser deletes any existing codes and stores the new hashes.
+	ReplaceForUser(userID uint, hashes []string) error
+	// Consume marks an unused code as used and reports whether one matched.
+	Consume(userID uint, hash string, at time.Time) (bool, error)
+	DeleteForUser(userID uint) error
+}
@@ -0,0 +1,19 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"gorm.io/gorm"
+)
+
+type RefreshTokenRepository interface {
+	WithTx(tx *gorm.DB) RefreshTokenRepository
+	Create(token *models.RefreshToken) error
+	// GetByHashForUpdate locks the row so concurrent refreshes of the same
+	// token are serialised.
+	GetByHashForUpdate(hash string) (*models.RefreshToken, error)
+	MarkRotated(id uint, at time.Time) error
+	RevokeFamily(familyID string, at time.Time) error
+	RevokeAllForUser(userID uint, at time.Time) error
+}
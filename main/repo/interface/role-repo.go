@@ -0,0 +1,15 @@
+package repo
+
+import (
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"gorm.io/gorm"
+)
+
+type RoleRepository interface {
+	WithTx(tx *gorm.DB) RoleRepository
+	GetByName(name string) (*models.Role, error)
+	List() ([]models.Role, error)
+	// PermissionNames returns the permissions granted to a role, or none if
+	// the role does not exist.
+	PermissionNames(role string) ([]string, error)
+}
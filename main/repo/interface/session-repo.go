@@ -0,0 +1,21 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"gorm.io/gorm"
+)
+
+type SessionRepository interface {
+	WithTx(tx *gorm.DB) SessionRepository
+	Create(session *models.Session) error
+	GetByID(id, userID uint) (*models.Session, error)
+	GetByFamilyID(familyID string) (*models.Session, error)
+	ListActiveByUser(userID uint) ([]models.Session, error)
+	// ListByUser includes revoked sessions.
+	ListByUser(userID uint) ([]models.Session, error)
+	Touch(id uint, ip string, at time.Time) error
+	Revoke(id uint, at time.Time) error
+	RevokeAllForUser(userID uint, at time.Time) error
+}
@@ -0,0 +1,25 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"gorm.io/gorm"
+)
+
+type SMSCodeRepository interface {
+	WithTx(tx *gorm.DB) SMSCodeRepository
+	Create(code *models.SMSCode) error
+	// GetActiveForUpdate returns the newest unused, unexpired code for the
+	// user and purpose, locking its row.
+	GetActiveForUpdate(userID uint, purpose string, now time.Time) (*models.SMSCode, error)
+	IncrementAttempts(id uint) error
+	MarkUsed(id uint, at time.Time) error
+	// InvalidateForUser marks every unused code for the purpose as used, so
+	// only the code sent last can be entered.
+	InvalidateForUser(userID uint, purpose string, at time.Time) error
+	// LatestCreatedAt returns when the last code of any purpose was sent to
+	// the user, or nil if none was.
+	LatestCreatedAt(userID uint) (*time.Time, error)
+	CountSince(userID uint, since time.Time) (int64, error)
+}
@@ -0,0 +1,17 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+)
+
+type SpendingLimitRepository interface {
+	ListRules() ([]models.SpendingRule, error)
+	ListRulesForTier(tier, currency string) ([]models.SpendingRule, error)
+	UpsertRule(rule *models.SpendingRule) error
+	CountRules() (int64, error)
+	CreateOverride(override *models.SpendingLimitOverride) error
+	DeleteOverride(id uint) error
+	ActiveOverrides(userID uint, now time.Time) ([]models.SpendingLimitOverride, error)
+}
@@ -1 +1,21 @@
-package repo
\ No newline at end of file
+package repo
+
+import (
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"gorm.io/gorm"
+)
+
+type TransactionRepository interface {
+	WithTx(tx *gorm.DB) TransactionRepository
+	Create(tx *models.Transaction) error
+	GetByID(id uint) (*models.Transaction, error)
+	GetByIDForUpdate(id uint) (*models.Transaction, error)
+	ListByUser(userID uint, limit, offset int) ([]models.Transaction, error)
+	ListByWallet(walletID uint, limit, offset int) ([]models.Transaction, error)
+	Update(tx *models.Transaction) error
+	// SumWithdrawals totals non-failed sends since the given time. Pass a zero
+	// walletID to sum across all of the user's wallets.
+	SumWithdrawals(userID, walletID uint, since time.Time) (models.WithdrawalTotals, error)
+}
@@ -1,12 +1,61 @@
 package repo
 
-import "github.com/inlovewithgo/transit-backend/main/models"
+import (
+	"errors"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"gorm.io/gorm"
+)
+
+// ErrDuplicateEmail is returned when another live account already has the
+// email address.
+var ErrDuplicateEmail = errors.New("email is already in use")
 
 type UserRepository interface {
+	WithTx(tx *gorm.DB) UserRepository
 	CreateUser(user *models.User) error
 	GetUserByEmail(email string) (*models.User, error)
 	GetUserByID(id uint) (*models.User, error)
+	// GetUserByIDForUpdate loads the user and locks the row until the
+	// transaction ends.
+	GetUserByIDForUpdate(id uint) (*models.User, error)
 	UpdateUser(user *models.User) error
 	DeleteUser(id uint) error
 	UserExists(email string) (bool, error)
+	IncrementTokenVersion(id uint) error
+	UpdatePassword(id uint, hash string) error
+	// ReplacePasswordHash swaps the hash only if it still equals oldHash, so a
+	// rehash can't overwrite a password changed in the meantime.
+	ReplacePasswordHash(id uint, oldHash, newHash string) error
+	UpdateRole(id uint, role string) error
+	// AdvanceTOTPStep records step as the last TOTP step used, only if it is
+	// later than the stored one. It reports whether the row was updated, so
+	// a code can be accepted at most once even by concurrent requests.
+	AdvanceTOTPStep(id uint, step int64) (bool, error)
+	// SetTOTPSecret stores a new, not yet confirmed secret and reports
+	// whether it was stored; it isn't while TOTP is enabled.
+	SetTOTPSecret(id uint, secret string) (bool, error)
+	// EnableTOTP turns TOTP on if secret is still the pending one and step
+	// is later than the last used. It reports whether the row was updated.
+	EnableTOTP(id uint, secret string, step int64) (bool, error)
+	DisableTOTP(id uint) error
+	GetUserByWebAuthnHandle(handle []byte) (*models.User, error)
+	// SetWebAuthnHandle assigns the handle only if the user has none yet.
+	SetWebAuthnHandle(id uint, handle []byte) error
+	UpdateProfile(id uint, firstName, lastName string, prefs models.UserPreferences) error
+	UpdateEmail(id uint, email string, verifiedAt time.Time) error
+	// MarkEmailVerified records when the email was confirmed, unless it
+	// already was.
+	MarkEmailVerified(id uint, verifiedAt time.Time) error
+	SetVerifiedPhone(id uint, phone string, verifiedAt time.Time) error
+	// ClearPhone removes the phone number and turns off SMS second factors.
+	ClearPhone(id uint) error
+	SetSMSMFAEnabled(id uint, enabled bool) error
+	SetWithdrawalAllowlist(id uint, enabled bool, disablesAt *time.Time) error
+	// ListDeletedBefore returns soft-deleted users whose grace period ended.
+	ListDeletedBefore(cutoff time.Time, limit int) ([]models.User, error)
+	// PurgeUser permanently removes a soft-deleted user and their account
+	// data. Wallets and transactions are kept as financial records.
+	PurgeUser(id uint) error
 }
@@ -2,9 +2,11 @@ package repo
 
 import (
 	"github.com/inlovewithgo/transit-backend/main/models"
+	"gorm.io/gorm"
 )
 
 type WaitlistRepository interface {
+	WithTx(tx *gorm.DB) WaitlistRepository
 	Create(waitlist *models.Waitlist) error
 	GetByEmail(email string) (*models.Waitlist, error)
 	Update(waitlist *models.Waitlist) error
@@ -1 +1,20 @@
-package repo
\ No newline at end of file
+package repo
+
+import (
+	"errors"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"gorm.io/gorm"
+)
+
+var ErrInsufficientBalance = errors.New("insufficient balance")
+
+type WalletRepository interface {
+	WithTx(tx *gorm.DB) WalletRepository
+	Create(wallet *models.Wallet) error
+	GetByID(id uint) (*models.Wallet, error)
+	GetUserWallet(id, userID uint) (*models.Wallet, error)
+	ListByUser(userID uint) ([]models.Wallet, error)
+	// DebitBalance atomically subtracts amount, failing if the balance is too low.
+	DebitBalance(id uint, amount int64) error
+}
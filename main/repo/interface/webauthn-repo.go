@@ -0,0 +1,25 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"gorm.io/gorm"
+)
+
+type WebAuthnRepository interface {
+	WithTx(tx *gorm.DB) WebAuthnRepository
+
+	CreateCredential(cred *models.WebAuthnCredential) error
+	ListCredentialsByUser(userID uint) ([]models.WebAuthnCredential, error)
+	CountCredentialsByUser(userID uint) (int64, error)
+	// UpdateCredentialUse stores the counter and flags of a successful assertion.
+	UpdateCredentialUse(id uint, signCount uint32, backupState bool, at time.Time) error
+	// DeleteCredential removes a credential owned by userID and reports
+	// whether one matched.
+	DeleteCredential(id, userID uint) (bool, error)
+
+	CreateChallenge(challenge *models.WebAuthnChallenge) error
+	GetChallengeByHashForUpdate(hash string) (*models.WebAuthnChallenge, error)
+	MarkChallengeUsed(id uint, at time.Time) error
+}
@@ -0,0 +1,23 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+)
+
+type WebhookRepository interface {
+	CreateSubscription(sub *models.WebhookSubscription) error
+	GetSubscription(id, userID uint) (*models.WebhookSubscription, error)
+	GetSubscriptionByID(id uint) (*models.WebhookSubscription, error)
+	ListSubscriptions(userID uint) ([]models.WebhookSubscription, error)
+	ListSubscriptionsForEvent(userID uint, eventType string) ([]models.WebhookSubscription, error)
+	DeleteSubscription(id, userID uint) error
+
+	CreateDelivery(delivery *models.WebhookDelivery) error
+	GetDelivery(id uint) (*models.WebhookDelivery, error)
+	ListDeliveries(subscriptionID uint, limit int) ([]models.WebhookDelivery, error)
+	ListSubscriptionIDsForEventID(eventID string) ([]uint, error)
+	ClaimDueDeliveries(now time.Time, limit int, lease time.Duration) ([]models.WebhookDelivery, error)
+	UpdateDelivery(delivery *models.WebhookDelivery) error
+}
@@ -0,0 +1,15 @@
+package repo
+
+import (
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"gorm.io/gorm"
+)
+
+type WithdrawalAddressRepository interface {
+	WithTx(tx *gorm.DB) WithdrawalAddressRepository
+	Create(address *models.WithdrawalAddress) error
+	ListByUser(userID uint) ([]models.WithdrawalAddress, error)
+	FindByAddress(userID uint, currency, address string) (*models.WithdrawalAddress, error)
+	GetByCancelTokenHash(hash string) (*models.WithdrawalAddress, error)
+	Delete(id, userID uint) error
+}
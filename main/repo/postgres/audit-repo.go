@@ -0,0 +1,88 @@
+package postgres
+
+import (
+	"errors"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"gorm.io/gorm"
+)
+
+// auditChainLockKey is the pg_advisory_xact_lock key guarding the audit
+// chain head.
+const auditChainLockKey = 7270311
+
+type auditRepository struct {
+	db *gorm.DB
+}
+
+func NewAuditRepository(db *gorm.DB) repo.AuditRepository {
+	return &auditRepository{db: db}
+}
+
+func (r *auditRepository) WithTx(tx *gorm.DB) repo.AuditRepository {
+	return &auditRepository{db: tx}
+}
+
+func (r *auditRepository) LockChain() error {
+	return r.db.Exec("SELECT pg_advisory_xact_lock(?)", auditChainLockKey).Error
+}
+
+func (r *auditRepository) LastHash() (string, error) {
+	var event models.AuditEvent
+	result := r.db.Select("hash").Order("id DESC").First(&event)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return "", nil
+		}
+		return "", result.Error
+	}
+
+	return event.Hash, nil
+}
+
+func (r *auditRepository) Append(event *models.AuditEvent) error {
+	return r.db.Create(event).Error
+}
+
+func (r *auditRepository) Search(filter models.AuditEventFilter) ([]models.AuditEvent, error) {
+	query := r.db.Model(&models.AuditEvent{})
+	if filter.UserID != 0 {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.ActorID != 0 {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.IPAddress != "" {
+		query = query.Where("ip_address = ?", filter.IPAddress)
+	}
+	if filter.RequestID != "" {
+		query = query.Where("request_id = ?", filter.RequestID)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+
+	var events []models.AuditEvent
+	err := query.Order("id DESC").Limit(filter.Limit).Offset(filter.Offset).Find(&events).Error
+	return events, err
+}
+
+func (r *auditRepository) ListByUser(userID uint) ([]models.AuditEvent, error) {
+	var events []models.AuditEvent
+	err := r.db.Where("user_id = ?", userID).Order("id ASC").Find(&events).Error
+	return events, err
+}
+
+func (r *auditRepository) ListAfter(afterID uint, limit int) ([]models.AuditEvent, error) {
+	var events []models.AuditEvent
+	err := r.db.Where("id > ?", afterID).Order("id ASC").Limit(limit).Find(&events).Error
+	return events, err
+}
@@ -0,0 +1,96 @@
+package postgres
+
+import (
+	"errors"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type dataExportRepository struct {
+	db *gorm.DB
+}
+
+func NewDataExportRepository(db *gorm.DB) repo.DataExportRepository {
+	return &dataExportRepository{db: db}
+}
+
+func (r *dataExportRepository) WithTx(tx *gorm.DB) repo.DataExportRepository {
+	return &dataExportRepository{db: tx}
+}
+
+func (r *dataExportRepository) Create(export *models.DataExport) error {
+	return r.db.Create(export).Error
+}
+
+func (r *dataExportRepository) GetPendingByUser(userID uint) (*models.DataExport, error) {
+	var export models.DataExport
+	result := r.db.Where("user_id = ? AND status = ?", userID, models.DataExportPending).First(&export)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("data export not found")
+		}
+		return nil, result.Error
+	}
+
+	return &export, nil
+}
+
+// ClaimDue locks pending exports that are due and pushes their next attempt
+// out by lease, so concurrent workers don't build the same archive.
+func (r *dataExportRepository) ClaimDue(now time.Time, limit int, lease time.Duration) ([]models.DataExport, error) {
+	var exports []models.DataExport
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", models.DataExportPending, now).
+			Order("id").
+			Limit(limit).
+			Find(&exports).Error
+		if err != nil || len(exports) == 0 {
+			return err
+		}
+
+		ids := make([]uint, len(exports))
+		for i, e := range exports {
+			ids[i] = e.ID
+		}
+
+		return tx.Model(&models.DataExport{}).
+			Where("id IN ?", ids).
+			Update("next_attempt_at", now.Add(lease)).Error
+	})
+
+	return exports, err
+}
+
+func (r *dataExportRepository) GetReadyByTokenHash(hash string) (*models.DataExport, error) {
+	var export models.DataExport
+	result := r.db.Where("token_hash = ? AND status = ?", hash, models.DataExportReady).First(&export)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("data export not found")
+		}
+		return nil, result.Error
+	}
+
+	return &export, nil
+}
+
+func (r *dataExportRepository) ListExpired(now time.Time, limit int) ([]models.DataExport, error) {
+	var exports []models.DataExport
+	err := r.db.Where("status = ? AND expires_at <= ?", models.DataExportReady, now).
+		Order("id").
+		Limit(limit).
+		Find(&exports).Error
+	return exports, err
+}
+
+func (r *dataExportRepository) Update(export *models.DataExport) error {
+	return r.db.Save(export).Error
+}
@@ -0,0 +1,53 @@
+package postgres
+
+import (
+	"errors"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type emailChangeRepository struct {
+	db *gorm.DB
+}
+
+func NewEmailChangeRepository(db *gorm.DB) repo.EmailChangeRepository {
+	return &emailChangeRepository{db: db}
+}
+
+func (r *emailChangeRepository) WithTx(tx *gorm.DB) repo.EmailChangeRepository {
+	return &emailChangeRepository{db: tx}
+}
+
+func (r *emailChangeRepository) Create(change *models.EmailChangeRequest) error {
+	return r.db.Create(change).Error
+}
+
+func (r *emailChangeRepository) GetByTokenHashForUpdate(hash string) (*models.EmailChangeRequest, error) {
+	var change models.EmailChangeRequest
+	result := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("old_token_hash = ? OR new_token_hash = ?", hash, hash).
+		First(&change)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("email change not found")
+		}
+		return nil, result.Error
+	}
+
+	return &change, nil
+}
+
+func (r *emailChangeRepository) Update(change *models.EmailChangeRequest) error {
+	return r.db.Save(change).Error
+}
+
+func (r *emailChangeRepository) CancelForUser(userID uint, at time.Time) error {
+	return r.db.Model(&models.EmailChangeRequest{}).
+		Where("user_id = ? AND completed_at IS NULL AND cancelled_at IS NULL", userID).
+		Update("cancelled_at", at).Error
+}
@@ -0,0 +1,47 @@
+package postgres
+
+import (
+	"errors"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type magicLinkRepository struct {
+	db *gorm.DB
+}
+
+func NewMagicLinkRepository(db *gorm.DB) repo.MagicLinkRepository {
+	return &magicLinkRepository{db: db}
+}
+
+func (r *magicLinkRepository) WithTx(tx *gorm.DB) repo.MagicLinkRepository {
+	return &magicLinkRepository{db: tx}
+}
+
+func (r *magicLinkRepository) Create(token *models.MagicLinkToken) error {
+	return r.db.Create(token).Error
+}
+
+func (r *magicLinkRepository) GetByHashForUpdate(hash string) (*models.MagicLinkToken, error) {
+	var token models.MagicLinkToken
+	result := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("token_hash = ?", hash).First(&token)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("magic link not found")
+		}
+		return nil, result.Error
+	}
+
+	return &token, nil
+}
+
+func (r *magicLinkRepository) InvalidateForUser(userID uint, at time.Time) error {
+	return r.db.Model(&models.MagicLinkToken{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Update("used_at", at).Error
+}
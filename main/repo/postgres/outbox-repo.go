@@ -0,0 +1,109 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type transactor struct {
+	db *gorm.DB
+}
+
+func NewTransactor(db *gorm.DB) repo.Transactor {
+	return &transactor{db: db}
+}
+
+func (t *transactor) WithinTransaction(fn func(tx *gorm.DB) error) error {
+	return t.db.Transaction(fn)
+}
+
+type outboxRepository struct {
+	db *gorm.DB
+}
+
+func NewOutboxRepository(db *gorm.DB) repo.OutboxRepository {
+	return &outboxRepository{db: db}
+}
+
+func (r *outboxRepository) WithTx(tx *gorm.DB) repo.OutboxRepository {
+	return &outboxRepository{db: tx}
+}
+
+func (r *outboxRepository) Enqueue(msg *models.OutboxMessage) error {
+	if msg.Status == "" {
+		msg.Status = models.OutboxStatusPending
+	}
+	if msg.NextAttemptAt.IsZero() {
+		msg.NextAttemptAt = time.Now()
+	}
+	return r.db.Create(msg).Error
+}
+
+// ClaimDue locks due messages and leases them to the caller by pushing their
+// next attempt out, so concurrent relays don't deliver the same row at once.
+// A message is held back while an earlier message with the same ordering key
+// is still pending, so a retry can't be overtaken by later events.
+func (r *outboxRepository) ClaimDue(now time.Time, limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	var msgs []models.OutboxMessage
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", models.OutboxStatusPending, now).
+			Where(`ordering_key = '' OR NOT EXISTS (
+				SELECT 1 FROM outbox AS earlier
+				WHERE earlier.ordering_key = outbox.ordering_key AND earlier.status = ? AND earlier.id < outbox.id)`,
+				models.OutboxStatusPending).
+			Order("id").
+			Limit(limit).
+			Find(&msgs).Error
+		if err != nil || len(msgs) == 0 {
+			return err
+		}
+
+		ids := make([]uint, len(msgs))
+		for i, m := range msgs {
+			ids[i] = m.ID
+		}
+
+		return tx.Model(&models.OutboxMessage{}).
+			Where("id IN ?", ids).
+			Update("next_attempt_at", now.Add(lease)).Error
+	})
+
+	return msgs, err
+}
+
+func (r *outboxRepository) Update(msg *models.OutboxMessage) error {
+	return r.db.Save(msg).Error
+}
+
+func (r *outboxRepository) DeleteFinishedBefore(before time.Time) (int64, error) {
+	result := r.db.Where("status IN ? AND updated_at < ?",
+		[]string{models.OutboxStatusDelivered, models.OutboxStatusFailed}, before).
+		Delete(&models.OutboxMessage{})
+	return result.RowsAffected, result.Error
+}
+
+func (r *outboxRepository) CancelPending(kind, recipient, reason string) error {
+	return r.db.Model(&models.OutboxMessage{}).
+		Where("status = ? AND kind = ? AND recipient = lower(?)", models.OutboxStatusPending, kind, recipient).
+		Updates(map[string]interface{}{
+			"status":     models.OutboxStatusFailed,
+			"payload":    models.OutboxRedactedPayload,
+			"last_error": reason,
+		}).Error
+}
+
+func (r *outboxRepository) ListEmailsTo(email string) ([]models.EmailHistoryEntry, error) {
+	var entries []models.EmailHistoryEntry
+	err := r.db.Model(&models.OutboxMessage{}).
+		Select("kind, status, created_at, delivered_at").
+		Where("channel = ? AND recipient = lower(?)", models.NotifyChannelEmail, email).
+		Order("created_at").
+		Scan(&entries).Error
+	return entries, err
+}
@@ -0,0 +1,50 @@
+package postgres
+
+import (
+	"errors"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"gorm.io/gorm"
+)
+
+type roleRepository struct {
+	db *gorm.DB
+}
+
+func NewRoleRepository(db *gorm.DB) repo.RoleRepository {
+	return &roleRepository{db: db}
+}
+
+func (r *roleRepository) WithTx(tx *gorm.DB) repo.RoleRepository {
+	return &roleRepository{db: tx}
+}
+
+func (r *roleRepository) GetByName(name string) (*models.Role, error) {
+	var role models.Role
+	result := r.db.Preload("Permissions").Where("name = ?", name).First(&role)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("role not found")
+		}
+		return nil, result.Error
+	}
+
+	return &role, nil
+}
+
+func (r *roleRepository) List() ([]models.Role, error) {
+	var roles []models.Role
+	err := r.db.Preload("Permissions").Order("name").Find(&roles).Error
+	return roles, err
+}
+
+func (r *roleRepository) PermissionNames(role string) ([]string, error) {
+	var names []string
+	err := r.db.Table("role_permissions").
+		Where("role_name = ?", role).
+		Order("permission_name").
+		Pluck("permission_name", &names).Error
+	return names, err
+}
@@ -0,0 +1,77 @@
+package postgres
+
+import (
+	"errors"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type smsCodeRepository struct {
+	db *gorm.DB
+}
+
+func NewSMSCodeRepository(db *gorm.DB) repo.SMSCodeRepository {
+	return &smsCodeRepository{db: db}
+}
+
+func (r *smsCodeRepository) WithTx(tx *gorm.DB) repo.SMSCodeRepository {
+	return &smsCodeRepository{db: tx}
+}
+
+func (r *smsCodeRepository) Create(code *models.SMSCode) error {
+	return r.db.Create(code).Error
+}
+
+func (r *smsCodeRepository) GetActiveForUpdate(userID uint, purpose string, now time.Time) (*models.SMSCode, error) {
+	var code models.SMSCode
+	result := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", userID, purpose, now).
+		Order("created_at DESC").
+		First(&code)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("sms code not found")
+		}
+		return nil, result.Error
+	}
+
+	return &code, nil
+}
+
+func (r *smsCodeRepository) IncrementAttempts(id uint) error {
+	return r.db.Model(&models.SMSCode{}).Where("id = ?", id).
+		Update("attempts", gorm.Expr("attempts + 1")).Error
+}
+
+func (r *smsCodeRepository) MarkUsed(id uint, at time.Time) error {
+	return r.db.Model(&models.SMSCode{}).Where("id = ?", id).Update("used_at", at).Error
+}
+
+func (r *smsCodeRepository) InvalidateForUser(userID uint, purpose string, at time.Time) error {
+	return r.db.Model(&models.SMSCode{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", at).Error
+}
+
+func (r *smsCodeRepository) LatestCreatedAt(userID uint) (*time.Time, error) {
+	var code models.SMSCode
+	result := r.db.Select("created_at").Where("user_id = ?", userID).Order("created_at DESC").Limit(1).Find(&code)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	return &code.CreatedAt, nil
+}
+
+func (r *smsCodeRepository) CountSince(userID uint, since time.Time) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.SMSCode{}).Where("user_id = ? AND created_at >= ?", userID, since).Count(&count).Error
+	return count, err
+}
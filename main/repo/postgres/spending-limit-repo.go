@@ -0,0 +1,67 @@
+package postgres
+
+import (
+	"errors"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type spendingLimitRepository struct {
+	db *gorm.DB
+}
+
+func NewSpendingLimitRepository(db *gorm.DB) repo.SpendingLimitRepository {
+	return &spendingLimitRepository{db: db}
+}
+
+func (r *spendingLimitRepository) ListRules() ([]models.SpendingRule, error) {
+	var rules []models.SpendingRule
+	err := r.db.Order("tier, currency, scope, period").Find(&rules).Error
+	return rules, err
+}
+
+func (r *spendingLimitRepository) ListRulesForTier(tier, currency string) ([]models.SpendingRule, error) {
+	var rules []models.SpendingRule
+	err := r.db.Where("tier = ? AND currency = ?", tier, currency).Find(&rules).Error
+	return rules, err
+}
+
+func (r *spendingLimitRepository) UpsertRule(rule *models.SpendingRule) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "tier"}, {Name: "currency"}, {Name: "scope"}, {Name: "period"}},
+		DoUpdates: clause.AssignmentColumns([]string{"max_amount", "max_fiat_cents", "updated_at"}),
+	}).Create(rule).Error
+}
+
+func (r *spendingLimitRepository) CountRules() (int64, error) {
+	var count int64
+	err := r.db.Model(&models.SpendingRule{}).Count(&count).Error
+	return count, err
+}
+
+func (r *spendingLimitRepository) CreateOverride(override *models.SpendingLimitOverride) error {
+	return r.db.Create(override).Error
+}
+
+func (r *spendingLimitRepository) DeleteOverride(id uint) error {
+	result := r.db.Delete(&models.SpendingLimitOverride{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("override not found")
+	}
+	return nil
+}
+
+func (r *spendingLimitRepository) ActiveOverrides(userID uint, now time.Time) ([]models.SpendingLimitOverride, error) {
+	var overrides []models.SpendingLimitOverride
+	err := r.db.Where("user_id = ? AND (expires_at IS NULL OR expires_at > ?)", userID, now).
+		Order("created_at DESC").
+		Find(&overrides).Error
+	return overrides, err
+}
@@ -1 +1,96 @@
-package postgres
\ No newline at end of file
+package postgres
+
+import (
+	"errors"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type transactionRepository struct {
+	db *gorm.DB
+}
+
+func NewTransactionRepository(db *gorm.DB) repo.TransactionRepository {
+	return &transactionRepository{db: db}
+}
+
+func (r *transactionRepository) WithTx(tx *gorm.DB) repo.TransactionRepository {
+	return &transactionRepository{db: tx}
+}
+
+func (r *transactionRepository) Create(tx *models.Transaction) error {
+	return r.db.Create(tx).Error
+}
+
+func (r *transactionRepository) GetByID(id uint) (*models.Transaction, error) {
+	var tx models.Transaction
+	result := r.db.First(&tx, id)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("transaction not found")
+		}
+		return nil, result.Error
+	}
+
+	return &tx, nil
+}
+
+func (r *transactionRepository) GetByIDForUpdate(id uint) (*models.Transaction, error) {
+	var tx models.Transaction
+	result := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&tx, id)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("transaction not found")
+		}
+		return nil, result.Error
+	}
+
+	return &tx, nil
+}
+
+func (r *transactionRepository) ListByUser(userID uint, limit, offset int) ([]models.Transaction, error) {
+	var txs []models.Transaction
+	err := r.db.Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&txs).Error
+	return txs, err
+}
+
+func (r *transactionRepository) ListByWallet(walletID uint, limit, offset int) ([]models.Transaction, error) {
+	var txs []models.Transaction
+	err := r.db.Where("wallet_id = ?", walletID).
+		Order("created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&txs).Error
+	return txs, err
+}
+
+func (r *transactionRepository) Update(tx *models.Transaction) error {
+	return r.db.Save(tx).Error
+}
+
+func (r *transactionRepository) SumWithdrawals(userID, walletID uint, since time.Time) (models.WithdrawalTotals, error) {
+	query := r.db.Model(&models.Transaction{}).
+		Where("user_id = ? AND type = ? AND status <> ? AND created_at >= ?",
+			userID, models.TransactionTypeSend, models.TransactionStatusFailed, since)
+
+	if walletID != 0 {
+		query = query.Where("wallet_id = ?", walletID)
+	}
+
+	var totals models.WithdrawalTotals
+	err := query.Select("COALESCE(SUM(amount), 0) AS amount, " +
+		"COALESCE(SUM(fiat_cents), 0) AS fiat_cents, " +
+		"COALESCE(SUM(CASE WHEN fiat_cents = 0 THEN amount ELSE 0 END), 0) AS unpriced_amount").
+		Scan(&totals).Error
+	return totals, err
+}
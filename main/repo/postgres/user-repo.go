@@ -2,10 +2,12 @@ package postgres
 
 import (
 	"errors"
+	"time"
 
 	"github.com/inlovewithgo/transit-backend/main/models"
 	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type userRepository struct {
@@ -16,9 +18,13 @@ func NewUserRepository(db *gorm.DB) repo.UserRepository {
 	return &userRepository{db: db}
 }
 
+func (r *userRepository) WithTx(tx *gorm.DB) repo.UserRepository {
+	return &userRepository{db: tx}
+}
+
 func (r *userRepository) CreateUser(user *models.User) error {
 	result := r.db.Create(user)
-	return result.Error
+	return r.emailError(result.Error)
 }
 
 func (r *userRepository) GetUserByEmail(email string) (*models.User, error) {
@@ -49,6 +55,20 @@ func (r *userRepository) GetUserByID(id uint) (*models.User, error) {
 	return &user, nil
 }
 
+func (r *userRepository) GetUserByIDForUpdate(id uint) (*models.User, error) {
+	var user models.User
+	result := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, id)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("user not found")
+		}
+		return nil, result.Error
+	}
+
+	return &user, nil
+}
+
 func (r *userRepository) UpdateUser(user *models.User) error {
 	result := r.db.Save(user)
 	return result.Error
@@ -69,3 +89,248 @@ func (r *userRepository) UserExists(email string) (bool, error) {
 
 	return count > 0, nil
 }
+
+func (r *userRepository) IncrementTokenVersion(id uint) error {
+	result := r.db.Model(&models.User{}).Where("id = ?", id).
+		UpdateColumn("token_version", gorm.Expr("token_version + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+func (r *userRepository) UpdatePassword(id uint, hash string) error {
+	return r.db.Model(&models.User{}).Where("id = ?", id).Update("password", hash).Error
+}
+
+func (r *userRepository) ReplacePasswordHash(id uint, oldHash, newHash string) error {
+	return r.db.Model(&models.User{}).Where("id = ? AND password = ?", id, oldHash).Update("password", newHash).Error
+}
+
+func (r *userRepository) UpdateRole(id uint, role string) error {
+	result := r.db.Model(&models.User{}).Where("id = ?", id).Update("role", role)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+func (r *userRepository) AdvanceTOTPStep(id uint, step int64) (bool, error) {
+	result := r.db.Model(&models.User{}).
+		Where("id = ? AND totp_last_used_step < ?", id, step).
+		Update("totp_last_used_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *userRepository) SetTOTPSecret(id uint, secret string) (bool, error) {
+	result := r.db.Model(&models.User{}).
+		Where("id = ? AND totp_enabled = ?", id, false).
+		Updates(map[string]interface{}{
+			"totp_secret":         secret,
+			"totp_last_used_step": 0,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *userRepository) EnableTOTP(id uint, secret string, step int64) (bool, error) {
+	result := r.db.Model(&models.User{}).
+		Where("id = ? AND totp_enabled = ? AND totp_secret = ? AND totp_last_used_step < ?", id, false, secret, step).
+		Updates(map[string]interface{}{
+			"totp_enabled":        true,
+			"totp_last_used_step": step,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *userRepository) DisableTOTP(id uint) error {
+	result := r.db.Model(&models.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"totp_enabled":        false,
+		"totp_secret":         "",
+		"totp_last_used_step": 0,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+func (r *userRepository) GetUserByWebAuthnHandle(handle []byte) (*models.User, error) {
+	var user models.User
+	result := r.db.Where("web_authn_handle = ?", handle).First(&user)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("user not found")
+		}
+		return nil, result.Error
+	}
+
+	return &user, nil
+}
+
+func (r *userRepository) SetWebAuthnHandle(id uint, handle []byte) error {
+	return r.db.Model(&models.User{}).Where("id = ? AND web_authn_handle IS NULL", id).
+		Update("web_authn_handle", handle).Error
+}
+
+func (r *userRepository) UpdateProfile(id uint, firstName, lastName string, prefs models.UserPreferences) error {
+	result := r.db.Model(&models.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"first_name":  firstName,
+		"last_name":   lastName,
+		"preferences": prefs,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+func (r *userRepository) UpdateEmail(id uint, email string, verifiedAt time.Time) error {
+	result := r.db.Model(&models.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"email":             email,
+		"email_verified_at": verifiedAt,
+	})
+	if result.Error != nil {
+		return r.emailError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+func (r *userRepository) MarkEmailVerified(id uint, verifiedAt time.Time) error {
+	return r.db.Model(&models.User{}).
+		Where("id = ? AND email_verified_at IS NULL", id).
+		Update("email_verified_at", verifiedAt).Error
+}
+
+func (r *userRepository) SetVerifiedPhone(id uint, phone string, verifiedAt time.Time) error {
+	result := r.db.Model(&models.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"phone_number":      phone,
+		"phone_verified_at": verifiedAt,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+func (r *userRepository) ClearPhone(id uint) error {
+	result := r.db.Model(&models.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"phone_number":      "",
+		"phone_verified_at": nil,
+		"sms_mfa_enabled":   false,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+func (r *userRepository) SetSMSMFAEnabled(id uint, enabled bool) error {
+	result := r.db.Model(&models.User{}).Where("id = ?", id).Update("sms_mfa_enabled", enabled)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+func (r *userRepository) SetWithdrawalAllowlist(id uint, enabled bool, disablesAt *time.Time) error {
+	result := r.db.Model(&models.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"withdrawal_allowlist_enabled":     enabled,
+		"withdrawal_allowlist_disables_at": disablesAt,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+func (r *userRepository) ListDeletedBefore(cutoff time.Time, limit int) ([]models.User, error) {
+	var users []models.User
+	result := r.db.Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+		Order("deleted_at ASC").
+		Limit(limit).
+		Find(&users)
+	return users, result.Error
+}
+
+func (r *userRepository) PurgeUser(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		subscriptions := tx.Unscoped().Model(&models.WebhookSubscription{}).Select("id").Where("user_id = ?", id)
+		if err := tx.Where("subscription_id IN (?)", subscriptions).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+
+		owned := []interface{}{
+			&models.WebhookSubscription{},
+			&models.Session{},
+			&models.RefreshToken{},
+			&models.RecoveryCode{},
+			&models.PasswordResetToken{},
+			&models.MagicLinkToken{},
+			&models.EmailChangeRequest{},
+			&models.DataExport{},
+			&models.WebAuthnCredential{},
+			&models.WebAuthnChallenge{},
+			&models.SMSCode{},
+			&models.APIKey{},
+			&models.UserIdentity{},
+			&models.WithdrawalAddress{},
+			&models.SpendingLimitOverride{},
+		}
+		for _, model := range owned {
+			if err := tx.Unscoped().Where("user_id = ?", id).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).Delete(&models.User{}).Error
+	})
+}
+
+// emailError turns a unique violation into repo.ErrDuplicateEmail; email is
+// the only unique column users are written with.
+func (r *userRepository) emailError(err error) error {
+	if translator, ok := r.db.Dialector.(gorm.ErrorTranslator); ok && err != nil {
+		if errors.Is(translator.Translate(err), gorm.ErrDuplicatedKey) {
+			return repo.ErrDuplicateEmail
+		}
+	}
+	return err
+}
@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
 	"gorm.io/gorm"
 )
 
@@ -13,6 +14,10 @@ func NewWaitlistRepository(db *gorm.DB) *waitlistRepository {
 	return &waitlistRepository{db: db}
 }
 
+func (w *waitlistRepository) WithTx(tx *gorm.DB) repo.WaitlistRepository {
+	return &waitlistRepository{db: tx}
+}
+
 func (w *waitlistRepository) Create(waitlist *models.Waitlist) error {
 	return w.db.Create(waitlist).Error
 }
@@ -1 +1,73 @@
-package postgres
\ No newline at end of file
+package postgres
+
+import (
+	"errors"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"gorm.io/gorm"
+)
+
+type walletRepository struct {
+	db *gorm.DB
+}
+
+func NewWalletRepository(db *gorm.DB) repo.WalletRepository {
+	return &walletRepository{db: db}
+}
+
+func (r *walletRepository) WithTx(tx *gorm.DB) repo.WalletRepository {
+	return &walletRepository{db: tx}
+}
+
+func (r *walletRepository) Create(wallet *models.Wallet) error {
+	return r.db.Create(wallet).Error
+}
+
+func (r *walletRepository) GetByID(id uint) (*models.Wallet, error) {
+	var wallet models.Wallet
+	result := r.db.First(&wallet, id)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("wallet not found")
+		}
+		return nil, result.Error
+	}
+
+	return &wallet, nil
+}
+
+func (r *walletRepository) GetUserWallet(id, userID uint) (*models.Wallet, error) {
+	var wallet models.Wallet
+	result := r.db.Where("id = ? AND user_id = ?", id, userID).First(&wallet)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("wallet not found")
+		}
+		return nil, result.Error
+	}
+
+	return &wallet, nil
+}
+
+func (r *walletRepository) ListByUser(userID uint) ([]models.Wallet, error) {
+	var wallets []models.Wallet
+	err := r.db.Where("user_id = ?", userID).Order("id").Find(&wallets).Error
+	return wallets, err
+}
+
+func (r *walletRepository) DebitBalance(id uint, amount int64) error {
+	result := r.db.Model(&models.Wallet{}).
+		Where("id = ? AND balance >= ?", id, amount).
+		Update("balance", gorm.Expr("balance - ?", amount))
+
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return repo.ErrInsufficientBalance
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package postgres
+
+import (
+	"errors"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type webAuthnRepository struct {
+	db *gorm.DB
+}
+
+func NewWebAuthnRepository(db *gorm.DB) repo.WebAuthnRepository {
+	return &webAuthnRepository{db: db}
+}
+
+func (r *webAuthnRepository) WithTx(tx *gorm.DB) repo.WebAuthnRepository {
+	return &webAuthnRepository{db: tx}
+}
+
+func (r *webAuthnRepository) CreateCredential(cred *models.WebAuthnCredential) error {
+	return r.db.Create(cred).Error
+}
+
+func (r *webAuthnRepository) ListCredentialsByUser(userID uint) ([]models.WebAuthnCredential, error) {
+	var creds []models.WebAuthnCredential
+	err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&creds).Error
+	return creds, err
+}
+
+func (r *webAuthnRepository) CountCredentialsByUser(userID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.WebAuthnCredential{}).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+func (r *webAuthnRepository) UpdateCredentialUse(id uint, signCount uint32, backupState bool, at time.Time) error {
+	return r.db.Model(&models.WebAuthnCredential{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"sign_count":   signCount,
+		"backup_state": backupState,
+		"last_used_at": at,
+	}).Error
+}
+
+func (r *webAuthnRepository) DeleteCredential(id, userID uint) (bool, error) {
+	result := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.WebAuthnCredential{})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *webAuthnRepository) CreateChallenge(challenge *models.WebAuthnChallenge) error {
+	return r.db.Create(challenge).Error
+}
+
+func (r *webAuthnRepository) GetChallengeByHashForUpdate(hash string) (*models.WebAuthnChallenge, error) {
+	var challenge models.WebAuthnChallenge
+	result := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("token_hash = ?", hash).First(&challenge)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("webauthn challenge not found")
+		}
+		return nil, result.Error
+	}
+
+	return &challenge, nil
+}
+
+func (r *webAuthnRepository) MarkChallengeUsed(id uint, at time.Time) error {
+	return r.db.Model(&models.WebAuthnChallenge{}).Where("id = ?", id).Update("used_at", at).Error
+}
@@ -0,0 +1,158 @@
+package postgres
+
+import (
+	"errors"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type webhookRepository struct {
+	db *gorm.DB
+}
+
+func NewWebhookRepository(db *gorm.DB) repo.WebhookRepository {
+	return &webhookRepository{db: db}
+}
+
+func (r *webhookRepository) CreateSubscription(sub *models.WebhookSubscription) error {
+	return r.db.Create(sub).Error
+}
+
+func (r *webhookRepository) GetSubscription(id, userID uint) (*models.WebhookSubscription, error) {
+	var sub models.WebhookSubscription
+	result := r.db.Where("id = ? AND user_id = ?", id, userID).First(&sub)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("webhook not found")
+		}
+		return nil, result.Error
+	}
+
+	return &sub, nil
+}
+
+func (r *webhookRepository) GetSubscriptionByID(id uint) (*models.WebhookSubscription, error) {
+	var sub models.WebhookSubscription
+	result := r.db.First(&sub, id)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("webhook not found")
+		}
+		return nil, result.Error
+	}
+
+	return &sub, nil
+}
+
+func (r *webhookRepository) ListSubscriptions(userID uint) ([]models.WebhookSubscription, error) {
+	var subs []models.WebhookSubscription
+	err := r.db.Where("user_id = ?", userID).Order("id").Find(&subs).Error
+	return subs, err
+}
+
+func (r *webhookRepository) ListSubscriptionsForEvent(userID uint, eventType string) ([]models.WebhookSubscription, error) {
+	var subs []models.WebhookSubscription
+	err := r.db.Where("user_id = ? AND is_active = ?", userID, true).Find(&subs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	matching := subs[:0]
+	for _, sub := range subs {
+		for _, event := range sub.Events {
+			if event == eventType {
+				matching = append(matching, sub)
+				break
+			}
+		}
+	}
+
+	return matching, nil
+}
+
+func (r *webhookRepository) DeleteSubscription(id, userID uint) error {
+	result := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.WebhookSubscription{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("webhook not found")
+	}
+	return nil
+}
+
+func (r *webhookRepository) CreateDelivery(delivery *models.WebhookDelivery) error {
+	return r.db.Create(delivery).Error
+}
+
+func (r *webhookRepository) GetDelivery(id uint) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	result := r.db.First(&delivery, id)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("delivery not found")
+		}
+		return nil, result.Error
+	}
+
+	return &delivery, nil
+}
+
+func (r *webhookRepository) ListDeliveries(subscriptionID uint, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := r.db.Where("subscription_id = ?", subscriptionID).
+		Order("id DESC").
+		Limit(limit).
+		Find(&deliveries).Error
+	return deliveries, err
+}
+
+// ListSubscriptionIDsForEventID returns the subscriptions that already have
+// a delivery of the event.
+func (r *webhookRepository) ListSubscriptionIDsForEventID(eventID string) ([]uint, error) {
+	var ids []uint
+	err := r.db.Model(&models.WebhookDelivery{}).
+		Where("event_id = ?", eventID).
+		Distinct().
+		Pluck("subscription_id", &ids).Error
+	return ids, err
+}
+
+// ClaimDueDeliveries locks pending deliveries that are due and pushes their
+// next attempt out by lease, so concurrent workers don't pick up the same rows.
+func (r *webhookRepository) ClaimDueDeliveries(now time.Time, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", models.WebhookDeliveryPending, now).
+			Order("next_attempt_at").
+			Limit(limit).
+			Find(&deliveries).Error
+		if err != nil || len(deliveries) == 0 {
+			return err
+		}
+
+		ids := make([]uint, len(deliveries))
+		for i, d := range deliveries {
+			ids[i] = d.ID
+		}
+
+		return tx.Model(&models.WebhookDelivery{}).
+			Where("id IN ?", ids).
+			Update("next_attempt_at", now.Add(lease)).Error
+	})
+
+	return deliveries, err
+}
+
+func (r *webhookRepository) UpdateDelivery(delivery *models.WebhookDelivery) error {
+	return r.db.Save(delivery).Error
+}
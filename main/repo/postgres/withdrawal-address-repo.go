@@ -0,0 +1,70 @@
+package postgres
+
+import (
+	"errors"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"gorm.io/gorm"
+)
+
+type withdrawalAddressRepository struct {
+	db *gorm.DB
+}
+
+func NewWithdrawalAddressRepository(db *gorm.DB) repo.WithdrawalAddressRepository {
+	return &withdrawalAddressRepository{db: db}
+}
+
+func (r *withdrawalAddressRepository) WithTx(tx *gorm.DB) repo.WithdrawalAddressRepository {
+	return &withdrawalAddressRepository{db: tx}
+}
+
+func (r *withdrawalAddressRepository) Create(address *models.WithdrawalAddress) error {
+	return r.db.Create(address).Error
+}
+
+func (r *withdrawalAddressRepository) ListByUser(userID uint) ([]models.WithdrawalAddress, error) {
+	var addresses []models.WithdrawalAddress
+	err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&addresses).Error
+	return addresses, err
+}
+
+func (r *withdrawalAddressRepository) FindByAddress(userID uint, currency, address string) (*models.WithdrawalAddress, error) {
+	var entry models.WithdrawalAddress
+	result := r.db.Where("user_id = ? AND currency = ? AND address = ?", userID, currency, address).First(&entry)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("address not found")
+		}
+		return nil, result.Error
+	}
+
+	return &entry, nil
+}
+
+func (r *withdrawalAddressRepository) GetByCancelTokenHash(hash string) (*models.WithdrawalAddress, error) {
+	var entry models.WithdrawalAddress
+	result := r.db.Where("cancel_token_hash = ?", hash).First(&entry)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errors.New("address not found")
+		}
+		return nil, result.Error
+	}
+
+	return &entry, nil
+}
+
+func (r *withdrawalAddressRepository) Delete(id, userID uint) error {
+	result := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.WithdrawalAddress{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("address not found")
+	}
+	return nil
+}
@@ -1,34 +1,174 @@
 package routes
 
 import (
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/inlovewithgo/transit-backend/main/common/events"
+	"github.com/inlovewithgo/transit-backend/main/common/twilio"
 	"github.com/inlovewithgo/transit-backend/main/config"
+	adminHandlers "github.com/inlovewithgo/transit-backend/main/handlers/admin"
+	allowlistHandlers "github.com/inlovewithgo/transit-backend/main/handlers/allowlist"
 	handlers "github.com/inlovewithgo/transit-backend/main/handlers/api/basic"
+	apiKeyHandlers "github.com/inlovewithgo/transit-backend/main/handlers/apikey"
+	auditHandlers "github.com/inlovewithgo/transit-backend/main/handlers/audit"
 	authHandlers "github.com/inlovewithgo/transit-backend/main/handlers/auth"
+	magicLinkHandlers "github.com/inlovewithgo/transit-backend/main/handlers/magiclink"
+	mfaHandlers "github.com/inlovewithgo/transit-backend/main/handlers/mfa"
+	oidcHandlers "github.com/inlovewithgo/transit-backend/main/handlers/oidc"
+	passwordHandlers "github.com/inlovewithgo/transit-backend/main/handlers/password"
+	phoneHandlers "github.com/inlovewithgo/transit-backend/main/handlers/phone"
+	profileHandlers "github.com/inlovewithgo/transit-backend/main/handlers/profile"
+	sessionHandlers "github.com/inlovewithgo/transit-backend/main/handlers/session"
+	transactionHandlers "github.com/inlovewithgo/transit-backend/main/handlers/transaction"
 	waitlistHandlers "github.com/inlovewithgo/transit-backend/main/handlers/waitlist"
+	walletHandlers "github.com/inlovewithgo/transit-backend/main/handlers/wallet"
+	webAuthnHandlers "github.com/inlovewithgo/transit-backend/main/handlers/webauthn"
+	webhookHandlers "github.com/inlovewithgo/transit-backend/main/handlers/webhook"
 	"github.com/inlovewithgo/transit-backend/main/middlewares"
+	"github.com/inlovewithgo/transit-backend/main/models"
 	"github.com/inlovewithgo/transit-backend/main/repo/postgres"
 	"github.com/inlovewithgo/transit-backend/main/service"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func SetupRoutes(app *fiber.App) {
 	db := config.GetDB()
+	redisClient := config.GetRedis()
+
+	if err := utils.LoadAccessTokenKeys(); err != nil {
+		logger.Log.Fatal("Unable to load JWT signing keys: %v", err)
+	}
 
 	// Repositories
 	userRepo := postgres.NewUserRepository(db)
 	waitlistRepo := postgres.NewWaitlistRepository(db)
+	webhookRepo := postgres.NewWebhookRepository(db)
+	transactionRepo := postgres.NewTransactionRepository(db)
+	outboxRepo := postgres.NewOutboxRepository(db)
+	walletRepo := postgres.NewWalletRepository(db)
+	spendingLimitRepo := postgres.NewSpendingLimitRepository(db)
+	withdrawalAddressRepo := postgres.NewWithdrawalAddressRepository(db)
+	refreshTokenRepo := postgres.NewRefreshTokenRepository(db)
+	sessionRepo := postgres.NewSessionRepository(db)
+	recoveryCodeRepo := postgres.NewRecoveryCodeRepository(db)
+	passwordResetRepo := postgres.NewPasswordResetRepository(db)
+	roleRepo := postgres.NewRoleRepository(db)
+	apiKeyRepo := postgres.NewAPIKeyRepository(db)
+	identityRepo := postgres.NewIdentityRepository(db)
+	magicLinkRepo := postgres.NewMagicLinkRepository(db)
+	emailChangeRepo := postgres.NewEmailChangeRepository(db)
+	dataExportRepo := postgres.NewDataExportRepository(db)
+	webAuthnRepo := postgres.NewWebAuthnRepository(db)
+	auditRepo := postgres.NewAuditRepository(db)
+	smsCodeRepo := postgres.NewSMSCodeRepository(db)
+	transactor := postgres.NewTransactor(db)
+
+	// Event publishing
+	eventPublisher, err := events.NewPublisherFromEnv()
+	if err != nil {
+		logger.Log.Fatal("Unable to configure Kafka: %v", err)
+	}
 
 	// Services
 	mailService := service.NewMailService()
-	authService := service.NewAuthService(userRepo, mailService)
-	waitlistService := service.NewWaitlistService(waitlistRepo, mailService)
+	// SMS is only available when Twilio is enabled and configured.
+	notifier := service.NewNotifier(mailService, twilio.NewClientFromEnv())
+	tokenService := service.NewTokenRevocationService(userRepo, refreshTokenRepo, sessionRepo, apiKeyRepo, transactor, redisClient)
+	mfaService := service.NewMFAService(userRepo, recoveryCodeRepo, transactor, redisClient)
+	loginThrottle := service.NewLoginThrottleService(redisClient, outboxRepo)
+	passwordPolicy := service.NewPasswordPolicy()
+	auditService := service.NewAuditService(auditRepo, transactor)
+	authService := service.NewAuthService(userRepo, refreshTokenRepo, sessionRepo, roleRepo, outboxRepo, transactor, mfaService, loginThrottle, passwordPolicy, webAuthnRepo, auditService)
+	webAuthnService, err := service.NewWebAuthnService(userRepo, webAuthnRepo, transactor, authService)
+	if err != nil {
+		logger.Log.Fatal("Unable to configure passkeys: %v", err)
+	}
+	phoneService := service.NewPhoneService(userRepo, smsCodeRepo, transactor, notifier, authService)
+	oidcService := service.NewOIDCService(userRepo, identityRepo, outboxRepo, transactor, authService)
+	magicLinkService := service.NewMagicLinkService(userRepo, magicLinkRepo, outboxRepo, transactor, authService)
+	sessionService := service.NewSessionService(sessionRepo, tokenService)
+	apiKeyService := service.NewAPIKeyService(apiKeyRepo, userRepo)
+	roleService := service.NewRoleService(roleRepo, userRepo, transactor, tokenService)
+	passwordService := service.NewPasswordService(userRepo, passwordResetRepo, outboxRepo, transactor, tokenService, passwordPolicy, loginThrottle)
+	profileService := service.NewProfileService(userRepo, emailChangeRepo, walletRepo, outboxRepo, transactor, tokenService)
+	dataExportService := service.NewDataExportService(dataExportRepo, userRepo, sessionRepo, walletRepo, transactionRepo, waitlistRepo, apiKeyRepo, identityRepo, withdrawalAddressRepo, auditRepo, outboxRepo, transactor)
+	waitlistService := service.NewWaitlistService(waitlistRepo, outboxRepo, transactor, mailService)
+	webhookService := service.NewWebhookService(webhookRepo)
+	spendingPolicy := service.NewSpendingPolicyService(spendingLimitRepo, transactionRepo, redisClient, service.StaticRateProvider{})
+	transactionService := service.NewTransactionService(transactionRepo, outboxRepo, transactor, spendingPolicy)
+	allowlistService := service.NewWithdrawalAllowlistService(withdrawalAddressRepo, userRepo, outboxRepo, transactor)
+	walletService := service.NewWalletService(walletRepo, userRepo, transactor, transactionService, spendingPolicy, allowlistService, outboxRepo)
+	outboxRelay := service.NewOutboxRelay(outboxRepo, mailService, notifier, eventPublisher, webhookService)
 
 	// Handlers
-	authHandler := authHandlers.NewAuthHandler(authService)
+	authHandler := authHandlers.NewAuthHandler(authService, tokenService)
+	sessionHandler := sessionHandlers.NewSessionHandler(sessionService)
+	oidcHandler := oidcHandlers.NewOIDCHandler(oidcService)
+	magicLinkHandler := magicLinkHandlers.NewMagicLinkHandler(magicLinkService)
+	mfaHandler := mfaHandlers.NewMFAHandler(mfaService, auditService)
+	webAuthnHandler := webAuthnHandlers.NewWebAuthnHandler(webAuthnService, auditService)
+	phoneHandler := phoneHandlers.NewPhoneHandler(phoneService, auditService)
+	passwordHandler := passwordHandlers.NewPasswordHandler(passwordService)
+	profileHandler := profileHandlers.NewProfileHandler(profileService, dataExportService)
 	waitlistHandler := waitlistHandlers.NewWaitlistHandler(waitlistService)
+	webhookHandler := webhookHandlers.NewWebhookHandler(webhookService)
+	transactionHandler := transactionHandlers.NewTransactionHandler(transactionService)
+	walletHandler := walletHandlers.NewWalletHandler(walletService, auditService)
+	limitsHandler := adminHandlers.NewLimitsHandler(spendingPolicy, auditService)
+	usersHandler := adminHandlers.NewUsersHandler(roleService, auditService)
+	adminWaitlistHandler := adminHandlers.NewWaitlistHandler(waitlistService)
+	adminAuditHandler := adminHandlers.NewAuditHandler(auditService)
+	adminTransactionsHandler := adminHandlers.NewTransactionsHandler(transactionService, auditService)
+	auditHandler := auditHandlers.NewAuditHandler(auditService)
+	allowlistHandler := allowlistHandlers.NewAllowlistHandler(allowlistService, auditService)
+	apiKeyHandler := apiKeyHandlers.NewAPIKeyHandler(apiKeyService)
+
+	// Background workers
+	webhookService.StartWorker()
+	outboxRelay.Start()
+	profileService.StartPurger()
+	dataExportService.StartWorker()
 
 	// Rate limiter
-	rateLimiter := middlewares.NewRateLimiter()
+	rateLimiter := middlewares.NewRateLimiter(redisClient)
+
+	authRequired := middlewares.AuthMiddleware(tokenService)
+	// Sensitive actions need a recent password or TOTP confirmation on top
+	// of a normal session.
+	stepUpRequired := middlewares.AuthMiddleware(tokenService, middlewares.RequireStepUp(utils.StepUpMaxAge()))
+	verifiedEmail := middlewares.RequireVerifiedEmail(authService)
+	// Routes scripts may call accept an X-API-Key with the matching scope in
+	// place of a bearer token.
+	readAccess := middlewares.AuthOrAPIKey(authRequired, middlewares.APIKeyMiddleware(apiKeyService, rateLimiter, models.APIKeyScopeRead))
+	sendAccess := middlewares.AuthOrAPIKey(stepUpRequired, middlewares.APIKeyMiddleware(apiKeyService, rateLimiter, models.APIKeyScopeSend))
+	resendVerificationLimit := rateLimiter.Limit("verify-email", 3, time.Hour, func(c *fiber.Ctx) string {
+		userID, _ := middlewares.GetUserID(c)
+		return strconv.FormatUint(uint64(userID), 10)
+	})
+	forgotPasswordLimit := rateLimiter.Limit("forgot-password", 5, time.Hour, func(c *fiber.Ctx) string {
+		return c.IP()
+	})
+	magicLinkIPLimit := rateLimiter.Limit("magic-link-ip", 10, time.Hour, func(c *fiber.Ctx) string {
+		return c.IP()
+	})
+	magicLinkEmailLimit := rateLimiter.Limit("magic-link-email", 3, 15*time.Minute, func(c *fiber.Ctx) string {
+		var req models.MagicLinkRequest
+		_ = c.BodyParser(&req)
+		return utils.HashToken(strings.ToLower(strings.TrimSpace(req.Email)))
+	})
+	smsLoginCodeLimit := rateLimiter.Limit("sms-login-code", 10, time.Hour, func(c *fiber.Ctx) string {
+		return c.IP()
+	})
+	dataExportLimit := rateLimiter.Limit("data-export", 3, 24*time.Hour, func(c *fiber.Ctx) string {
+		userID, _ := middlewares.GetUserID(c)
+		return strconv.FormatUint(uint64(userID), 10)
+	})
 
 	api := app.Group("/api/v1")
 
@@ -44,6 +184,29 @@ func SetupRoutes(app *fiber.App) {
 	{
 		auth.Post("/register", authHandler.Register)
 		auth.Post("/login", authHandler.Login)
+		auth.Post("/refresh", authHandler.Refresh)
+		auth.Get("/unlock", authHandler.UnlockAccount)
+		auth.Post("/mfa/verify", authHandler.VerifyMFA)
+		auth.Post("/mfa/sms/send", smsLoginCodeLimit, phoneHandler.SendLoginCode)
+		auth.Post("/mfa/sms/verify", phoneHandler.VerifyLoginCode)
+		auth.Post("/webauthn/mfa/begin", webAuthnHandler.BeginMFA)
+		auth.Post("/webauthn/mfa/finish", webAuthnHandler.FinishMFA)
+		auth.Post("/webauthn/login/begin", webAuthnHandler.BeginLogin)
+		auth.Post("/webauthn/login/finish", webAuthnHandler.FinishLogin)
+		auth.Post("/magic-link", magicLinkIPLimit, magicLinkEmailLimit, magicLinkHandler.RequestLink)
+		auth.Get("/magic-link/verify", magicLinkHandler.Verify)
+		auth.Get("/oidc/:provider", oidcHandler.Start)
+		auth.Get("/oidc/:provider/callback", oidcHandler.Callback)
+		auth.Post("/step-up", authRequired, authHandler.StepUp)
+		auth.Post("/step-up/sms/send", authRequired, phoneHandler.SendStepUpCode)
+		auth.Post("/step-up/sms/verify", authRequired, phoneHandler.VerifyStepUpCode)
+		auth.Post("/step-up/webauthn/begin", authRequired, webAuthnHandler.BeginStepUp)
+		auth.Post("/step-up/webauthn/finish", authRequired, webAuthnHandler.FinishStepUp)
+		auth.Get("/verify-email", authHandler.VerifyEmail)
+		auth.Post("/verify-email/resend", authRequired, resendVerificationLimit, authHandler.ResendVerification)
+		auth.Post("/forgot-password", forgotPasswordLimit, passwordHandler.ForgotPassword)
+		auth.Post("/reset-password", passwordHandler.ResetPassword)
+		auth.Post("/change-password", authRequired, passwordHandler.ChangePassword)
 	}
 
 	// Waitlist routes with rate limiting
@@ -53,13 +216,102 @@ func SetupRoutes(app *fiber.App) {
 		waitlist.Get("/stats", waitlistHandler.GetWaitlistStats)
 	}
 
-	protected := api.Group("/", middlewares.AuthMiddleware())
+	// Middleware is attached per route: a group on "/" would register it as
+	// a prefix match and put every later route, public or admin, behind it.
+	protected := api.Group("/")
+	{
+		protected.Get("/profile", authRequired, authHandler.GetProfile)
+		protected.Patch("/profile", authRequired, profileHandler.UpdateProfile)
+		protected.Delete("/profile", stepUpRequired, profileHandler.DeleteAccount)
+		protected.Post("/profile/email", stepUpRequired, profileHandler.RequestEmailChange)
+		// Opened from email, so it takes no bearer token.
+		protected.Get("/profile/email/confirm", profileHandler.ConfirmEmailChange)
+		protected.Post("/profile/phone", stepUpRequired, phoneHandler.StartVerification)
+		protected.Post("/profile/phone/verify", authRequired, phoneHandler.ConfirmVerification)
+		protected.Delete("/profile/phone", stepUpRequired, phoneHandler.RemovePhone)
+		protected.Post("/profile/export", authRequired, dataExportLimit, profileHandler.RequestExport)
+		protected.Get("/profile/export/download", profileHandler.DownloadExport)
+		protected.Post("/logout", authRequired, authHandler.Logout)
+		protected.Post("/logout-all", authRequired, authHandler.LogoutAll)
+		protected.Get("/sessions", authRequired, sessionHandler.ListSessions)
+		protected.Delete("/sessions/:id", authRequired, sessionHandler.RevokeSession)
+		protected.Get("/transactions", readAccess, transactionHandler.ListTransactions)
+		protected.Get("/audit", authRequired, auditHandler.ListEvents)
+	}
+
+	mfa := api.Group("/mfa")
+	{
+		mfa.Post("/totp/setup", authRequired, mfaHandler.SetupTOTP)
+		mfa.Post("/totp/confirm", authRequired, mfaHandler.ConfirmTOTP)
+		mfa.Post("/totp/disable", stepUpRequired, mfaHandler.DisableTOTP)
+		mfa.Post("/recovery-codes", stepUpRequired, mfaHandler.RegenerateRecoveryCodes)
+		mfa.Post("/sms/enable", stepUpRequired, phoneHandler.EnableSMSMFA)
+		mfa.Post("/sms/disable", stepUpRequired, phoneHandler.DisableSMSMFA)
+	}
+
+	webAuthn := api.Group("/webauthn")
+	{
+		webAuthn.Post("/register/begin", stepUpRequired, webAuthnHandler.BeginRegistration)
+		webAuthn.Post("/register/finish", stepUpRequired, webAuthnHandler.FinishRegistration)
+		webAuthn.Get("/credentials", authRequired, webAuthnHandler.ListCredentials)
+		webAuthn.Delete("/credentials/:id", stepUpRequired, webAuthnHandler.DeleteCredential)
+	}
+
+	wallets := api.Group("/wallets")
+	{
+		wallets.Get("/", readAccess, walletHandler.ListWallets)
+		wallets.Post("/:id/send", sendAccess, verifiedEmail, walletHandler.Send)
+	}
+
+	apiKeys := api.Group("/api-keys")
+	{
+		apiKeys.Get("/", authRequired, apiKeyHandler.ListKeys)
+		apiKeys.Post("/", stepUpRequired, apiKeyHandler.CreateKey)
+		apiKeys.Delete("/:id", authRequired, apiKeyHandler.RevokeKey)
+	}
+
+	// The cancel link is opened from email, so it sits outside the auth group.
+	// GET only shows a page; the cancellation itself is a POST.
+	api.Get("/withdrawal-addresses/cancel", allowlistHandler.CancelAddressPage)
+	api.Post("/withdrawal-addresses/cancel", allowlistHandler.CancelAddress)
+
+	withdrawalAddresses := api.Group("/withdrawal-addresses")
+	{
+		withdrawalAddresses.Get("/", authRequired, allowlistHandler.GetAllowlist)
+		withdrawalAddresses.Post("/", stepUpRequired, verifiedEmail, allowlistHandler.AddAddress)
+		withdrawalAddresses.Put("/settings", stepUpRequired, allowlistHandler.UpdateSettings)
+		withdrawalAddresses.Delete("/:id", authRequired, allowlistHandler.RemoveAddress)
+	}
+
+	webhooks := api.Group("/webhooks", authRequired)
+	{
+		webhooks.Post("/", webhookHandler.CreateWebhook)
+		webhooks.Get("/", webhookHandler.ListWebhooks)
+		webhooks.Delete("/:id", webhookHandler.DeleteWebhook)
+		webhooks.Get("/:id/deliveries", webhookHandler.ListDeliveries)
+		webhooks.Post("/deliveries/:id/redeliver", webhookHandler.Redeliver)
+	}
+
+	// Operator routes: any signed-in user reaches them, each one then checks
+	// the permission embedded in the access token.
+	admin := api.Group("/admin", authRequired)
 	{
-		protected.Get("/profile", authHandler.GetProfile)
-		protected.Post("/logout", authHandler.Logout)
+		admin.Get("/waitlist", middlewares.RequirePermission(models.PermissionWaitlistRead), adminWaitlistHandler.ListEntries)
+		admin.Get("/roles", middlewares.RequirePermission(models.PermissionUsersRead), usersHandler.ListRoles)
+		admin.Get("/users/:id", middlewares.RequirePermission(models.PermissionUsersRead), usersHandler.GetUser)
+		admin.Put("/users/:id/role", middlewares.RequirePermission(models.PermissionUsersWrite), usersHandler.UpdateRole)
+		admin.Get("/limits/rules", middlewares.RequirePermission(models.PermissionLimitsRead), limitsHandler.ListRules)
+		admin.Put("/limits/rules", middlewares.RequirePermission(models.PermissionLimitsWrite), limitsHandler.UpsertRule)
+		admin.Post("/limits/overrides", middlewares.RequirePermission(models.PermissionLimitsWrite), limitsHandler.CreateOverride)
+		admin.Delete("/limits/overrides/:id", middlewares.RequirePermission(models.PermissionLimitsWrite), limitsHandler.DeleteOverride)
+		admin.Get("/audit", middlewares.RequirePermission(models.PermissionAuditRead), adminAuditHandler.SearchEvents)
+		admin.Get("/audit/verify", middlewares.RequirePermission(models.PermissionAuditRead), adminAuditHandler.VerifyChain)
+		admin.Put("/transactions/:id/status", middlewares.RequirePermission(models.PermissionTransactionsWrite), adminTransactionsHandler.UpdateStatus)
 	}
 
 	app.Get("/health", handlers.BasicHealthCheck)
+	app.Get("/.well-known/jwks.json", authHandler.JWKS)
+	app.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{
 			"message": "Transit Backend API",
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+)
+
+const (
+	apiKeyTag = "trk"
+	// Last-used is written at most this often so busy keys don't turn every
+	// request into a database write.
+	apiKeyTouchInterval = time.Minute
+	apiKeyMaxRateLimit  = 6000
+)
+
+var (
+	ErrInvalidAPIKey      = errors.New("invalid, expired or revoked API key")
+	ErrAPIKeyIPNotAllowed = models.ErrAPIKeyIPNotAllowed
+	ErrAPIKeyNotFound     = errors.New("API key not found")
+)
+
+type APIKeyService struct {
+	apiKeyRepo       repo.APIKeyRepository
+	userRepo         repo.UserRepository
+	maxKeys          int64
+	defaultRateLimit int
+}
+
+func NewAPIKeyService(apiKeyRepo repo.APIKeyRepository, userRepo repo.UserRepository) *APIKeyService {
+	maxKeys, err := strconv.ParseInt(utils.GetENV("API_KEY_MAX_PER_USER", "10"), 10, 64)
+	if err != nil || maxKeys < 1 {
+		maxKeys = 10
+	}
+
+	defaultRateLimit, err := strconv.Atoi(utils.GetENV("API_KEY_DEFAULT_RATE_LIMIT", "60"))
+	if err != nil || defaultRateLimit < 1 {
+		defaultRateLimit = 60
+	}
+
+	return &APIKeyService{
+		apiKeyRepo:       apiKeyRepo,
+		userRepo:         userRepo,
+		maxKeys:          maxKeys,
+		defaultRateLimit: defaultRateLimit,
+	}
+}
+
+// Create issues a key of the form trk_<prefix>_<secret>. The returned string
+// is the only time the full key is available.
+func (s *APIKeyService) Create(userID uint, req *models.CreateAPIKeyRequest) (*models.APIKey, string, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 100 {
+		return nil, "", errors.New("name is required and must be at most 100 characters")
+	}
+
+	scopes, err := normalizeAPIKeyScopes(req.Scopes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	allowedIPs := make([]string, 0, len(req.AllowedIPs))
+	for _, entry := range req.AllowedIPs {
+		entry = strings.TrimSpace(entry)
+		if net.ParseIP(entry) == nil {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				return nil, "", fmt.Errorf("invalid IP address or CIDR range: %q", entry)
+			}
+		}
+		allowedIPs = append(allowedIPs, entry)
+	}
+
+	now := time.Now()
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		return nil, "", errors.New("expires_at must be in the future")
+	}
+
+	rateLimit := req.RateLimit
+	if rateLimit == 0 {
+		rateLimit = s.defaultRateLimit
+	}
+	if rateLimit < 1 || rateLimit > apiKeyMaxRateLimit {
+		return nil, "", fmt.Errorf("rate_limit must be between 1 and %d requests per minute", apiKeyMaxRateLimit)
+	}
+
+	count, err := s.apiKeyRepo.CountActiveByUser(userID, now)
+	if err != nil {
+		logger.Log.Error("Error counting API keys for user %d: %v", userID, err)
+		return nil, "", fmt.Errorf("failed to create API key")
+	}
+	if count >= s.maxKeys {
+		return nil, "", fmt.Errorf("you can have at most %d active API keys", s.maxKeys)
+	}
+
+	publicID, err := utils.GenerateRandomToken(6)
+	if err != nil {
+		logger.Log.Error("Error generating API key: %v", err)
+		return nil, "", fmt.Errorf("failed to create API key")
+	}
+	secret, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		logger.Log.Error("Error generating API key: %v", err)
+		return nil, "", fmt.Errorf("failed to create API key")
+	}
+
+	prefix := apiKeyTag + "_" + publicID
+	key := &models.APIKey{
+		UserID:     userID,
+		Name:       name,
+		Prefix:     prefix,
+		KeyHash:    utils.HashToken(secret),
+		Scopes:     scopes,
+		AllowedIPs: allowedIPs,
+		RateLimit:  rateLimit,
+		ExpiresAt:  req.ExpiresAt,
+	}
+	if err := s.apiKeyRepo.Create(key); err != nil {
+		logger.Log.Error("Error storing API key for user %d: %v", userID, err)
+		return nil, "", fmt.Errorf("failed to create API key")
+	}
+
+	logger.Log.Info("User %d created API key %s with scopes %v", userID, prefix, scopes)
+
+	return key, prefix + "_" + secret, nil
+}
+
+func (s *APIKeyService) List(userID uint) ([]models.APIKey, error) {
+	keys, err := s.apiKeyRepo.ListByUser(userID)
+	if err != nil {
+		logger.Log.Error("Error listing API keys for user %d: %v", userID, err)
+		return nil, fmt.Errorf("failed to list API keys")
+	}
+	return keys, nil
+}
+
+func (s *APIKeyService) Revoke(userID, keyID uint) error {
+	revoked, err := s.apiKeyRepo.Revoke(keyID, userID, time.Now())
+	if err != nil {
+		logger.Log.Error("Error revoking API key %d for user %d: %v", keyID, userID, err)
+		return fmt.Errorf("failed to revoke API key")
+	}
+	if !revoked {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// Authenticate is called by APIKeyMiddleware for every request carrying an
+// X-API-Key header.
+func (s *APIKeyService) Authenticate(rawKey, ip string) (*models.APIKey, *models.User, error) {
+	parts := strings.SplitN(rawKey, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyTag {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	key, err := s.apiKeyRepo.GetByPrefix(parts[0] + "_" + parts[1])
+	if err != nil {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	if subtle.ConstantTimeCompare([]byte(utils.HashToken(parts[2])), []byte(key.KeyHash)) != 1 {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && !key.ExpiresAt.After(now)) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	if !ipAllowed(key.AllowedIPs, ip) {
+		logger.Log.Warn("API key %s used from disallowed IP %s", key.Prefix, ip)
+		return nil, nil, ErrAPIKeyIPNotAllowed
+	}
+
+	user, err := s.userRepo.GetUserByID(key.UserID)
+	if err != nil || !user.IsActive {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval || key.LastUsedIP != ip {
+		if err := s.apiKeyRepo.TouchLastUsed(key.ID, ip, now); err != nil {
+			logger.Log.Warn("Error updating last use of API key %s: %v", key.Prefix, err)
+		}
+	}
+
+	return key, user, nil
+}
+
+func normalizeAPIKeyScopes(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+
+	seen := make(map[string]bool, len(requested))
+	scopes := make([]string, 0, len(requested))
+	for _, scope := range requested {
+		valid := false
+		for _, known := range models.APIKeyScopes {
+			if scope == known {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes, nil
+}
+
+func ipAllowed(allowed []string, ip string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+
+	for _, entry := range allowed {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+			continue
+		}
+		if allowedIP := net.ParseIP(entry); allowedIP != nil && allowedIP.Equal(addr) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+	"gorm.io/gorm"
+)
+
+const (
+	maxAuditPageSize  = 100
+	auditVerifyBatch  = 500
+	auditGenesisHash  = ""
+	auditHashTimeForm = time.RFC3339Nano
+)
+
+// AuditService writes the security audit log and reads it back for users,
+// support and exports.
+type AuditService struct {
+	auditRepo  repo.AuditRepository
+	transactor repo.Transactor
+}
+
+func NewAuditService(auditRepo repo.AuditRepository, transactor repo.Transactor) *AuditService {
+	return &AuditService{
+		auditRepo:  auditRepo,
+		transactor: transactor,
+	}
+}
+
+// Record appends an event, filling in the client details, timestamp and
+// hash chain. The action it describes has already happened, so failures
+// are logged rather than returned.
+func (s *AuditService) Record(client models.ClientInfo, event *models.AuditEvent) {
+	event.ID = 0
+	event.IPAddress = client.IPAddress
+	event.UserAgent = client.UserAgent
+	event.RequestID = client.RequestID
+	if event.ActorType == "" {
+		event.ActorType = models.AuditActorUser
+	}
+	// Postgres keeps microseconds; hash what will be read back.
+	event.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+
+	changes, err := normalizeAuditChanges(event.Changes)
+	if err != nil {
+		logger.Log.Error("Error encoding audit event %s: %v", event.Action, err)
+		return
+	}
+	event.Changes = changes
+
+	err = s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		events := s.auditRepo.WithTx(tx)
+		if err := events.LockChain(); err != nil {
+			return err
+		}
+
+		prev, err := events.LastHash()
+		if err != nil {
+			return err
+		}
+
+		event.PrevHash = prev
+		if event.Hash, err = auditHash(event); err != nil {
+			return err
+		}
+		return events.Append(event)
+	})
+	if err != nil {
+		logger.Log.Error("Error recording audit event %s for user %v: %v", event.Action, event.UserID, err)
+	}
+}
+
+// ListForUser returns the newest events on the user's account first.
+func (s *AuditService) ListForUser(userID uint, action string, limit, offset int) ([]models.AuditEvent, error) {
+	return s.Search(models.AuditEventFilter{
+		UserID: userID,
+		Action: action,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+// Search returns events matching filter, newest first.
+func (s *AuditService) Search(filter models.AuditEventFilter) ([]models.AuditEvent, error) {
+	if filter.Limit < 1 || filter.Limit > maxAuditPageSize {
+		filter.Limit = 50
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	events, err := s.auditRepo.Search(filter)
+	if err != nil {
+		logger.Log.Error("Error searching audit events: %v", err)
+		return nil, fmt.Errorf("failed to search audit events")
+	}
+	return events, nil
+}
+
+// VerifyChain recomputes every hash from the start of the log and reports
+// the first event that doesn't match.
+func (s *AuditService) VerifyChain() (*models.AuditChainReport, error) {
+	report := &models.AuditChainReport{Valid: true}
+	prev := auditGenesisHash
+
+	var afterID uint
+	for {
+		events, err := s.auditRepo.ListAfter(afterID, auditVerifyBatch)
+		if err != nil {
+			logger.Log.Error("Error reading audit events after %d: %v", afterID, err)
+			return nil, fmt.Errorf("failed to verify audit log")
+		}
+
+		for i := range events {
+			event := &events[i]
+			hash, err := auditHash(event)
+			if err != nil || event.PrevHash != prev || event.Hash != hash {
+				report.Valid = false
+				report.BrokenAt = &event.ID
+				logger.Log.Warn("Audit chain broken at event %d", event.ID)
+				return report, nil
+			}
+			prev = event.Hash
+			afterID = event.ID
+			report.Checked++
+		}
+
+		if len(events) < auditVerifyBatch {
+			return report, nil
+		}
+	}
+}
+
+// normalizeAuditChanges round-trips changes through JSON so the hash is
+// computed over the same values VerifyChain will read from the database.
+func normalizeAuditChanges(changes map[string]interface{}) (map[string]interface{}, error) {
+	if changes == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return nil, err
+	}
+
+	var normalized map[string]interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// auditHash is SHA-256 over the previous hash and the event's content,
+// serialized as JSON with a fixed field order. The ID is left out because
+// it is assigned on insert.
+func auditHash(event *models.AuditEvent) (string, error) {
+	content, err := json.Marshal(struct {
+		PrevHash  string                 `json:"prev_hash"`
+		ActorID   *uint                  `json:"actor_id"`
+		ActorType string                 `json:"actor_type"`
+		UserID    *uint                  `json:"user_id"`
+		Action    string                 `json:"action"`
+		Target    string                 `json:"target"`
+		IPAddress string                 `json:"ip_address"`
+		UserAgent string                 `json:"user_agent"`
+		RequestID string                 `json:"request_id"`
+		Changes   map[string]interface{} `json:"changes"`
+		CreatedAt string                 `json:"created_at"`
+	}{
+		PrevHash:  event.PrevHash,
+		ActorID:   event.ActorID,
+		ActorType: event.ActorType,
+		UserID:    event.UserID,
+		Action:    event.Action,
+		Target:    event.Target,
+		IPAddress: event.IPAddress,
+		UserAgent: event.UserAgent,
+		RequestID: event.RequestID,
+		Changes:   event.Changes,
+		CreatedAt: event.CreatedAt.UTC().Format(auditHashTimeForm),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
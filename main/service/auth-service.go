@@ -3,26 +3,86 @@ package service
 import (
 	"errors"
 	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/inlovewithgo/transit-backend/main/models"
 	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
 	"github.com/inlovewithgo/transit-backend/main/utils"
 	"github.com/inlovewithgo/transit-backend/pkg/logger"
+	"gorm.io/gorm"
 )
 
+var (
+	ErrInvalidRefreshToken  = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused   = errors.New("refresh token has already been used; please log in again")
+	ErrInvalidCredentials   = errors.New("invalid password")
+	ErrInvalidVerification  = errors.New("invalid or expired verification link")
+	ErrEmailAlreadyVerified = errors.New("email address is already verified")
+	ErrInvalidMFAToken      = errors.New("invalid or expired MFA token")
+)
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// dummyPasswordHash is compared against when the email is unknown.
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = utils.HashPassword(uuid.NewString())
+	})
+	return dummyHash
+}
+
 type AuthService struct {
 	userRepo    repo.UserRepository
-	mailService *MailService
+	refreshRepo repo.RefreshTokenRepository
+	sessionRepo repo.SessionRepository
+	roleRepo    repo.RoleRepository
+	outboxRepo  repo.OutboxRepository
+	transactor  repo.Transactor
+	mfa         *MFAService
+	throttle    *LoginThrottleService
+	policy      *PasswordPolicy
+	passkeys    repo.WebAuthnRepository
+	audit       *AuditService
+	refreshTTL  time.Duration
+	baseURL     string
 }
 
-func NewAuthService(userRepo repo.UserRepository, mailService *MailService) *AuthService {
+func NewAuthService(userRepo repo.UserRepository, refreshRepo repo.RefreshTokenRepository, sessionRepo repo.SessionRepository, roleRepo repo.RoleRepository, outboxRepo repo.OutboxRepository, transactor repo.Transactor, mfa *MFAService, throttle *LoginThrottleService, policy *PasswordPolicy, passkeys repo.WebAuthnRepository, audit *AuditService) *AuthService {
+	refreshTTL, err := time.ParseDuration(utils.GetENV("REFRESH_TOKEN_TTL", "720h"))
+	if err != nil || refreshTTL <= 0 {
+		logger.Log.Warn("Invalid REFRESH_TOKEN_TTL, using 720h")
+		refreshTTL = 720 * time.Hour
+	}
+
 	return &AuthService{
 		userRepo:    userRepo,
-		mailService: mailService,
+		refreshRepo: refreshRepo,
+		sessionRepo: sessionRepo,
+		roleRepo:    roleRepo,
+		outboxRepo:  outboxRepo,
+		transactor:  transactor,
+		mfa:         mfa,
+		throttle:    throttle,
+		policy:      policy,
+		passkeys:    passkeys,
+		audit:       audit,
+		refreshTTL:  refreshTTL,
+		baseURL:     strings.TrimRight(utils.GetENV("APP_BASE_URL", "http://localhost:3030"), "/"),
 	}
 }
 
-func (s *AuthService) Register(req *models.RegisterRequest) (*models.AuthResponse, error) {
+func (s *AuthService) Register(req *models.RegisterRequest, client models.ClientInfo) (*models.AuthResponse, error) {
+	if err := s.policy.Validate(req.Password, req.Email, req.FirstName, req.LastName); err != nil {
+		return nil, err
+	}
+
 	exists, err := s.userRepo.UserExists(req.Email)
 	if err != nil {
 		logger.Log.Error("Error checking user existence: %v", err)
@@ -30,7 +90,7 @@ func (s *AuthService) Register(req *models.RegisterRequest) (*models.AuthRespons
 	}
 
 	if exists {
-		return nil, errors.New("user with this email already exists")
+		return nil, ErrEmailTaken
 	}
 
 	hashedPassword, err := utils.HashPassword(req.Password)
@@ -47,68 +107,499 @@ func (s *AuthService) Register(req *models.RegisterRequest) (*models.AuthRespons
 		IsActive:  true,
 	}
 
-	err = s.userRepo.CreateUser(user)
+	welcome, err := NewOutboxMessage(models.OutboxKindWelcomeEmail, models.EmailRecipientPayload{
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+	})
+	if err != nil {
+		logger.Log.Error("Error building welcome email: %v", err)
+		return nil, fmt.Errorf("failed to create user")
+	}
+
+	var (
+		session      *models.Session
+		refreshToken string
+	)
+	err = s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		if err := s.userRepo.WithTx(tx).CreateUser(user); err != nil {
+			return err
+		}
+		if err := s.outboxRepo.WithTx(tx).Enqueue(welcome); err != nil {
+			return err
+		}
+		if err := s.enqueueVerificationEmail(tx, user); err != nil {
+			return err
+		}
+		session, refreshToken, err = s.startSession(tx, user.ID, client)
+		return err
+	})
+	if errors.Is(err, repo.ErrDuplicateEmail) {
+		// Registered concurrently since the check above.
+		return nil, ErrEmailTaken
+	}
 	if err != nil {
 		logger.Log.Error("Error creating user: %v", err)
 		return nil, fmt.Errorf("failed to create user")
 	}
 
-	accessToken, err := utils.GenerateAccessToken(user)
+	return s.authResponse(user, session.ID, refreshToken, "User registered successfully")
+}
+
+func (s *AuthService) Login(req *models.LoginRequest, client models.ClientInfo) (*models.AuthResponse, error) {
+	if err := s.throttle.Check(req.Email, client.IPAddress); err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetUserByEmail(req.Email)
 	if err != nil {
-		logger.Log.Error("Error generating access token: %v", err)
-		return nil, fmt.Errorf("failed to generate access token")
+		// Spend the same hashing time as for a real account so response
+		// timing doesn't reveal which emails are registered.
+		utils.CheckPasswordHash(req.Password, dummyPasswordHash())
+		s.throttle.RecordFailure(req.Email, client.IPAddress, nil)
+		s.audit.Record(client, &models.AuditEvent{
+			ActorType: models.AuditActorAnonymous,
+			Action:    models.AuditActionLoginFailed,
+			Target:    models.AuditTarget("email", req.Email),
+			Changes:   map[string]interface{}{"reason": "unknown_email"},
+		})
+		return nil, errors.New("invalid email or password")
+	}
+
+	if !utils.CheckPasswordHash(req.Password, user.Password) {
+		s.throttle.RecordFailure(req.Email, client.IPAddress, user)
+		s.recordLoginFailure(user, client, models.AuditActionLoginFailed, "invalid_password")
+		return nil, errors.New("invalid email or password")
+	}
+
+	if !user.IsActive {
+		s.recordLoginFailure(user, client, models.AuditActionLoginFailed, "account_deactivated")
+		return nil, errors.New("account is deactivated")
+	}
+
+	s.throttle.RecordSuccess(req.Email)
+	s.upgradePasswordHash(user, req.Password)
+
+	return s.StartLogin(user, client, models.LoginMethodPassword)
+}
+
+// upgradePasswordHash rehashes a verified password whose stored hash uses an
+// older algorithm or weaker parameters. Failures only delay the upgrade to
+// the next login.
+func (s *AuthService) upgradePasswordHash(user *models.User, password string) {
+	if !utils.PasswordNeedsRehash(user.Password) {
+		return
+	}
+
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		logger.Log.Error("Error rehashing password for user %d: %v", user.ID, err)
+		return
 	}
 
-	go func() {
-		err := s.mailService.SendWelcomeEmail(user.Email, user.FirstName, user.LastName)
+	if err := s.userRepo.ReplacePasswordHash(user.ID, user.Password, hash); err != nil {
+		logger.Log.Error("Error storing rehashed password for user %d: %v", user.ID, err)
+		return
+	}
+	user.Password = hash
+}
+
+// StartLogin logs in a user whose first factor has already been checked. It
+// returns an MFA challenge when TOTP or SMS codes are enabled and a session
+// otherwise. The challenge lists the second factors the user can answer it
+// with. method is the first factor, for the audit log.
+func (s *AuthService) StartLogin(user *models.User, client models.ClientInfo, method string) (*models.AuthResponse, error) {
+	if user.MFAEnabled() {
+		mfaToken, err := utils.GenerateMFAChallengeToken(user.ID)
 		if err != nil {
-			logger.Log.Error("Failed to send welcome email: %v", err)
+			logger.Log.Error("Error generating MFA challenge: %v", err)
+			return nil, fmt.Errorf("failed to start login")
 		}
-	}()
 
-	user.Password = ""
+		var methods []string
+		if user.TOTPEnabled {
+			methods = append(methods, "totp")
+		}
+		if user.SMSMFAEnabled && user.PhoneVerified() {
+			methods = append(methods, "sms")
+		}
+		if count, err := s.passkeys.CountCredentialsByUser(user.ID); err != nil {
+			logger.Log.Error("Error counting passkeys for user %d: %v", user.ID, err)
+		} else if count > 0 {
+			methods = append(methods, "webauthn")
+		}
 
-	return &models.AuthResponse{
-		User:        *user,
-		AccessToken: accessToken,
-		Message:     "User registered successfully",
-	}, nil
+		return &models.AuthResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			MFAMethods:  methods,
+			Message:     "Two-factor authentication required",
+		}, nil
+	}
+
+	return s.completeLogin(user, client, method)
 }
 
-func (s *AuthService) Login(req *models.LoginRequest) (*models.AuthResponse, error) {
-	user, err := s.userRepo.GetUserByEmail(req.Email)
+// UnlockAccount lifts a login lockout using the link from the unlock email.
+func (s *AuthService) UnlockAccount(token string) error {
+	return s.throttle.Unlock(token)
+}
+
+// VerifyMFA finishes a login that was paused for a second factor. The code
+// can be a TOTP code or a recovery code.
+func (s *AuthService) VerifyMFA(req *models.MFAVerifyRequest, client models.ClientInfo) (*models.AuthResponse, error) {
+	user, err := s.mfaChallengeUser(req.MFAToken)
 	if err != nil {
-		logger.Log.Error("Error getting user by email: %v", err)
-		return nil, errors.New("invalid email or password")
+		return nil, err
 	}
 
-	if !user.IsActive {
-		return nil, errors.New("account is deactivated")
+	if err := s.mfa.VerifyCode(user, req.Code); err != nil {
+		s.recordLoginFailure(user, client, models.AuditActionMFAFailed, models.LoginMethodMFACode)
+		return nil, err
 	}
 
-	if !utils.CheckPasswordHash(req.Password, user.Password) {
-		return nil, errors.New("invalid email or password")
+	return s.completeLogin(user, client, models.LoginMethodMFACode)
+}
+
+// mfaChallengeUser returns the user a pending MFA challenge belongs to.
+func (s *AuthService) mfaChallengeUser(mfaToken string) (*models.User, error) {
+	claims, err := utils.ValidateMFAChallengeToken(mfaToken)
+	if err != nil {
+		return nil, ErrInvalidMFAToken
+	}
+
+	user, err := s.userRepo.GetUserByID(claims.UserID)
+	if err != nil || !user.IsActive || !user.MFAEnabled() {
+		return nil, ErrInvalidMFAToken
 	}
 
-	accessToken, err := utils.GenerateAccessToken(user)
+	return user, nil
+}
+
+// recordLoginFailure audits a failed login attempt on a known account.
+func (s *AuthService) recordLoginFailure(user *models.User, client models.ClientInfo, action, reason string) {
+	s.audit.Record(client, &models.AuditEvent{
+		ActorType: models.AuditActorAnonymous,
+		UserID:    &user.ID,
+		Action:    action,
+		Target:    models.AuditTarget("user", user.ID),
+		Changes:   map[string]interface{}{"reason": reason},
+	})
+}
+
+// completeLogin starts a session, queues the login notification and audits
+// the login with the method that completed it.
+func (s *AuthService) completeLogin(user *models.User, client models.ClientInfo, method string) (*models.AuthResponse, error) {
+	var (
+		session      *models.Session
+		refreshToken string
+	)
+	err := s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		var err error
+		session, refreshToken, err = s.startSession(tx, user.ID, client)
+		if err != nil {
+			return err
+		}
+
+		notification, err := NewOutboxMessage(models.OutboxKindLoginNotification, models.LoginNotificationPayload{
+			Email:     user.Email,
+			FirstName: user.FirstName,
+			LastName:  user.LastName,
+			Device:    session.Device(),
+			IPAddress: session.IPAddress,
+		})
+		if err != nil {
+			return err
+		}
+		return s.outboxRepo.WithTx(tx).Enqueue(notification)
+	})
 	if err != nil {
-		logger.Log.Error("Error generating access token: %v", err)
-		return nil, fmt.Errorf("failed to generate access token")
+		logger.Log.Error("Error starting session for user %d: %v", user.ID, err)
+		return nil, fmt.Errorf("failed to create session")
 	}
 
-	go func() {
-		err := s.mailService.SendLoginNotification(user.Email, user.FirstName, user.LastName)
+	s.audit.Record(client, &models.AuditEvent{
+		ActorID: &user.ID,
+		UserID:  &user.ID,
+		Action:  models.AuditActionLogin,
+		Target:  models.AuditTarget("session", session.ID),
+		Changes: map[string]interface{}{"method": method},
+	})
+
+	return s.authResponse(user, session.ID, refreshToken, "Login successful")
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token in the same family. Each refresh token can be used once; presenting
+// one that was already rotated means it was copied, so the whole family is
+// revoked and the user has to log in again.
+func (s *AuthService) Refresh(refreshToken string, client models.ClientInfo) (*models.AuthResponse, error) {
+	if refreshToken == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	now := time.Now()
+	var (
+		user     *models.User
+		session  *models.Session
+		rotated  string
+		reused   bool
+		familyID string
+	)
+
+	err := s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		tokens := s.refreshRepo.WithTx(tx)
+
+		current, err := tokens.GetByHashForUpdate(utils.HashToken(refreshToken))
 		if err != nil {
-			logger.Log.Error("Failed to send login notification email: %v", err)
+			return ErrInvalidRefreshToken
+		}
+		familyID = current.FamilyID
+
+		if current.RevokedAt != nil || !now.Before(current.ExpiresAt) {
+			return ErrInvalidRefreshToken
 		}
-	}()
+
+		session, err = s.sessionRepo.WithTx(tx).GetByFamilyID(current.FamilyID)
+		if err != nil || session.RevokedAt != nil {
+			return ErrInvalidRefreshToken
+		}
+
+		if current.RotatedAt != nil {
+			reused = true
+			if err := s.sessionRepo.WithTx(tx).Revoke(session.ID, now); err != nil {
+				return err
+			}
+			return tokens.RevokeFamily(current.FamilyID, now)
+		}
+
+		user, err = s.userRepo.WithTx(tx).GetUserByID(current.UserID)
+		if err != nil || !user.IsActive {
+			return ErrInvalidRefreshToken
+		}
+
+		if err := tokens.MarkRotated(current.ID, now); err != nil {
+			return err
+		}
+		if err := s.sessionRepo.WithTx(tx).Touch(session.ID, client.IPAddress, now); err != nil {
+			return err
+		}
+
+		rotated, err = s.createRefreshToken(tokens, user.ID, current.FamilyID)
+		return err
+	})
+	if errors.Is(err, ErrInvalidRefreshToken) {
+		return nil, err
+	}
+	if err != nil {
+		logger.Log.Error("Error refreshing token: %v", err)
+		return nil, fmt.Errorf("failed to refresh token")
+	}
+
+	if reused {
+		logger.Log.Warn("Refresh token reuse detected, revoked token family %s", familyID)
+		return nil, ErrRefreshTokenReused
+	}
+
+	return s.authResponse(user, session.ID, rotated, "Token refreshed")
+}
+
+// startSession records a new device session and issues the first refresh
+// token of its family.
+func (s *AuthService) startSession(tx *gorm.DB, userID uint, client models.ClientInfo) (*models.Session, string, error) {
+	session := newSession(userID, uuid.NewString(), client)
+	if err := s.sessionRepo.WithTx(tx).Create(session); err != nil {
+		return nil, "", err
+	}
+
+	refreshToken, err := s.createRefreshToken(s.refreshRepo.WithTx(tx), userID, session.FamilyID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return session, refreshToken, nil
+}
+
+func (s *AuthService) createRefreshToken(tokens repo.RefreshTokenRepository, userID uint, familyID string) (string, error) {
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	err = tokens.Create(&models.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(s.refreshTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (s *AuthService) authResponse(user *models.User, sessionID uint, refreshToken, message string) (*models.AuthResponse, error) {
+	permissions, err := s.roleRepo.PermissionNames(user.Role)
+	if err != nil {
+		logger.Log.Error("Error loading permissions for role %s: %v", user.Role, err)
+		return nil, fmt.Errorf("failed to generate access token")
+	}
+
+	accessToken, err := utils.GenerateAccessToken(user, sessionID, permissions)
+	if err != nil {
+		logger.Log.Error("Error generating access token: %v", err)
+		return nil, fmt.Errorf("failed to generate access token")
+	}
 
 	user.Password = ""
 
 	return &models.AuthResponse{
-		User:        *user,
-		AccessToken: accessToken,
-		Message:     "Login successful",
+		User:         user,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(utils.AccessTokenTTL().Seconds()),
+		Message:      message,
+	}, nil
+}
+
+// VerifyEmail marks the address in a verification link as confirmed.
+// Following the same link twice is harmless.
+func (s *AuthService) VerifyEmail(token string) (*models.User, error) {
+	claims, err := utils.ValidateEmailVerificationToken(token)
+	if err != nil {
+		return nil, ErrInvalidVerification
+	}
+
+	user, err := s.userRepo.GetUserByID(claims.UserID)
+	if err != nil || !strings.EqualFold(user.Email, claims.Email) {
+		return nil, ErrInvalidVerification
+	}
+
+	if user.EmailVerifiedAt == nil {
+		now := time.Now()
+		if err := s.userRepo.MarkEmailVerified(user.ID, now); err != nil {
+			logger.Log.Error("Error marking email verified for user %d: %v", user.ID, err)
+			return nil, fmt.Errorf("failed to verify email")
+		}
+		user.EmailVerifiedAt = &now
+	}
+
+	user.Password = ""
+	return user, nil
+}
+
+func (s *AuthService) ResendVerificationEmail(userID uint) error {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerifiedAt != nil {
+		return ErrEmailAlreadyVerified
+	}
+
+	if err := s.enqueueVerificationEmail(nil, user); err != nil {
+		logger.Log.Error("Error queueing verification email for user %d: %v", userID, err)
+		return fmt.Errorf("failed to send verification email")
+	}
+
+	return nil
+}
+
+// IsEmailVerified backs the RequireVerifiedEmail middleware.
+func (s *AuthService) IsEmailVerified(userID uint) (bool, error) {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return false, err
+	}
+	return user.EmailVerifiedAt != nil, nil
+}
+
+// enqueueVerificationEmail queues a verification link, inside tx when given.
+func (s *AuthService) enqueueVerificationEmail(tx *gorm.DB, user *models.User) error {
+	token, err := utils.GenerateEmailVerificationToken(user.ID, user.Email)
+	if err != nil {
+		return err
+	}
+
+	msg, err := NewOutboxMessage(models.OutboxKindEmailVerification, models.LinkEmailPayload{
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		URL:       s.baseURL + "/api/v1/auth/verify-email?token=" + url.QueryEscape(token),
+	})
+	if err != nil {
+		return err
+	}
+
+	outbox := s.outboxRepo
+	if tx != nil {
+		outbox = outbox.WithTx(tx)
+	}
+	return outbox.Enqueue(msg)
+}
+
+// StepUp issues an access token for the same session with a fresh step-up
+// claim, after the user re-enters their password or a TOTP code. Wrong
+// passwords count against the same throttle as logins. Users can also step
+// up with an SMS code or a passkey through PhoneService and WebAuthnService.
+func (s *AuthService) StepUp(claims *utils.Claims, req *models.StepUpRequest, client models.ClientInfo) (*models.StepUpResponse, error) {
+	user, err := s.stepUpUser(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case req.Code != "":
+		if !user.TOTPEnabled {
+			return nil, ErrMFANotEnabled
+		}
+		if err := s.mfa.VerifyTOTP(user, req.Code); err != nil {
+			return nil, err
+		}
+	case req.Password != "":
+		if err := s.throttle.Check(user.Email, client.IPAddress); err != nil {
+			return nil, err
+		}
+		if !utils.CheckPasswordHash(req.Password, user.Password) {
+			s.throttle.RecordFailure(user.Email, client.IPAddress, user)
+			return nil, ErrInvalidCredentials
+		}
+		s.throttle.RecordSuccess(user.Email)
+	default:
+		return nil, errors.New("password or code is required")
+	}
+
+	return s.issueStepUp(user, claims.SessionID)
+}
+
+// stepUpUser loads the active user a step-up is for.
+func (s *AuthService) stepUpUser(claims *utils.Claims) (*models.User, error) {
+	user, err := s.userRepo.GetUserByID(claims.UserID)
+	if err != nil || !user.IsActive {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// issueStepUp returns an access token for sessionID marked as stepped up
+// now. Callers must have re-verified the user.
+func (s *AuthService) issueStepUp(user *models.User, sessionID uint) (*models.StepUpResponse, error) {
+	permissions, err := s.roleRepo.PermissionNames(user.Role)
+	if err != nil {
+		logger.Log.Error("Error loading permissions for role %s: %v", user.Role, err)
+		return nil, fmt.Errorf("failed to generate access token")
+	}
+
+	accessToken, err := utils.GenerateStepUpAccessToken(user, sessionID, permissions)
+	if err != nil {
+		logger.Log.Error("Error generating step-up token: %v", err)
+		return nil, fmt.Errorf("failed to generate access token")
+	}
+
+	return &models.StepUpResponse{
+		AccessToken:   accessToken,
+		ExpiresIn:     int64(utils.AccessTokenTTL().Seconds()),
+		StepUpExpires: time.Now().Add(utils.StepUpMaxAge()),
 	}, nil
 }
 
@@ -0,0 +1,471 @@
+package service
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+	"gorm.io/gorm"
+)
+
+const (
+	exportBatchSize       = 5
+	exportPollInterval    = 10 * time.Second
+	exportLease           = 10 * time.Minute
+	exportMaxAttempts     = 5
+	exportRetryBackoff    = time.Minute
+	exportTransactionPage = 500
+)
+
+var (
+	ErrExportInProgress  = errors.New("a data export is already being prepared")
+	ErrInvalidExportLink = errors.New("invalid or expired download link")
+)
+
+// DataExportService builds a ZIP archive of everything held about a user and
+// emails them a download link that expires after DATA_EXPORT_TTL.
+type DataExportService struct {
+	exportRepo      repo.DataExportRepository
+	userRepo        repo.UserRepository
+	sessionRepo     repo.SessionRepository
+	walletRepo      repo.WalletRepository
+	transactionRepo repo.TransactionRepository
+	waitlistRepo    repo.WaitlistRepository
+	apiKeyRepo      repo.APIKeyRepository
+	identityRepo    repo.IdentityRepository
+	addressRepo     repo.WithdrawalAddressRepository
+	auditRepo       repo.AuditRepository
+	outboxRepo      repo.OutboxRepository
+	transactor      repo.Transactor
+	dir             string
+	ttl             time.Duration
+	downloadURL     string
+	stop            chan struct{}
+	stopOnce        sync.Once
+}
+
+func NewDataExportService(
+	exportRepo repo.DataExportRepository,
+	userRepo repo.UserRepository,
+	sessionRepo repo.SessionRepository,
+	walletRepo repo.WalletRepository,
+	transactionRepo repo.TransactionRepository,
+	waitlistRepo repo.WaitlistRepository,
+	apiKeyRepo repo.APIKeyRepository,
+	identityRepo repo.IdentityRepository,
+	addressRepo repo.WithdrawalAddressRepository,
+	auditRepo repo.AuditRepository,
+	outboxRepo repo.OutboxRepository,
+	transactor repo.Transactor,
+) *DataExportService {
+	ttl, err := time.ParseDuration(utils.GetENV("DATA_EXPORT_TTL", "72h"))
+	if err != nil || ttl <= 0 {
+		logger.Log.Warn("Invalid DATA_EXPORT_TTL, using 72h")
+		ttl = 72 * time.Hour
+	}
+
+	baseURL := strings.TrimRight(utils.GetENV("APP_BASE_URL", "http://localhost:3030"), "/")
+
+	return &DataExportService{
+		exportRepo:      exportRepo,
+		userRepo:        userRepo,
+		sessionRepo:     sessionRepo,
+		walletRepo:      walletRepo,
+		transactionRepo: transactionRepo,
+		waitlistRepo:    waitlistRepo,
+		apiKeyRepo:      apiKeyRepo,
+		identityRepo:    identityRepo,
+		addressRepo:     addressRepo,
+		auditRepo:       auditRepo,
+		outboxRepo:      outboxRepo,
+		transactor:      transactor,
+		dir:             utils.GetENV("DATA_EXPORT_DIR", "./exports"),
+		ttl:             ttl,
+		downloadURL:     baseURL + "/api/v1/profile/export/download",
+		stop:            make(chan struct{}),
+	}
+}
+
+// RequestExport queues an export for the user. Only one can be pending at a
+// time.
+func (s *DataExportService) RequestExport(userID uint) (*models.DataExport, error) {
+	if _, err := s.exportRepo.GetPendingByUser(userID); err == nil {
+		return nil, ErrExportInProgress
+	}
+
+	export := &models.DataExport{
+		UserID:        userID,
+		Status:        models.DataExportPending,
+		NextAttemptAt: time.Now(),
+	}
+	if err := s.exportRepo.Create(export); err != nil {
+		logger.Log.Error("Error creating data export for user %d: %v", userID, err)
+		return nil, fmt.Errorf("failed to request data export")
+	}
+
+	return export, nil
+}
+
+// Download returns the archive path for a valid download token.
+func (s *DataExportService) Download(token string) (string, error) {
+	export, err := s.exportRepo.GetReadyByTokenHash(utils.HashToken(token))
+	if err != nil || export.ExpiresAt == nil || time.Now().After(*export.ExpiresAt) {
+		return "", ErrInvalidExportLink
+	}
+
+	if _, err := os.Stat(export.FilePath); err != nil {
+		logger.Log.Error("Data export %d is missing its archive: %v", export.ID, err)
+		return "", ErrInvalidExportLink
+	}
+
+	return export.FilePath, nil
+}
+
+// StartWorker polls for pending exports and removes expired archives.
+func (s *DataExportService) StartWorker() {
+	go func() {
+		ticker := time.NewTicker(exportPollInterval)
+		defer ticker.Stop()
+
+		logger.Log.Info("Data export worker started")
+		for {
+			select {
+			case <-s.stop:
+				logger.Log.Info("Data export worker stopped")
+				return
+			case <-ticker.C:
+				s.ProcessDue()
+				s.RemoveExpired()
+			}
+		}
+	}()
+}
+
+func (s *DataExportService) StopWorker() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// ProcessDue builds every export whose next attempt is due.
+func (s *DataExportService) ProcessDue() {
+	exports, err := s.exportRepo.ClaimDue(time.Now(), exportBatchSize, exportLease)
+	if err != nil {
+		logger.Log.Error("Error claiming data exports: %v", err)
+		return
+	}
+
+	for i := range exports {
+		s.process(&exports[i])
+	}
+}
+
+func (s *DataExportService) process(export *models.DataExport) {
+	export.Attempts++
+
+	err := s.complete(export)
+	if err == nil {
+		logger.Log.Info("Data export %d for user %d is ready", export.ID, export.UserID)
+		return
+	}
+
+	export.LastError = err.Error()
+	if export.Attempts >= exportMaxAttempts {
+		export.Status = models.DataExportFailed
+		logger.Log.Error("Data export %d failed permanently: %v", export.ID, err)
+	} else {
+		export.NextAttemptAt = time.Now().Add(exportRetryBackoff * time.Duration(export.Attempts))
+		logger.Log.Warn("Data export %d failed (attempt %d): %v", export.ID, export.Attempts, err)
+	}
+
+	if err := s.exportRepo.Update(export); err != nil {
+		logger.Log.Error("Error updating data export %d: %v", export.ID, err)
+	}
+}
+
+// complete writes the archive, then marks the export ready and queues the
+// email in one transaction.
+func (s *DataExportService) complete(export *models.DataExport) error {
+	user, err := s.userRepo.GetUserByID(export.UserID)
+	if err != nil {
+		return err
+	}
+
+	path, err := s.writeArchive(export, user)
+	if err != nil {
+		return err
+	}
+
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(s.ttl)
+	msg, err := NewOutboxMessage(models.OutboxKindDataExportReady, models.DataExportReadyPayload{
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		URL:       s.downloadURL + "?token=" + url.QueryEscape(token),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	export.Status = models.DataExportReady
+	export.FilePath = path
+	export.TokenHash = utils.HashToken(token)
+	export.CompletedAt = &now
+	export.ExpiresAt = &expiresAt
+	export.LastError = ""
+
+	err = s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		if err := s.exportRepo.WithTx(tx).Update(export); err != nil {
+			return err
+		}
+		return s.outboxRepo.WithTx(tx).Enqueue(msg)
+	})
+	if err != nil {
+		os.Remove(path)
+		export.Status = models.DataExportPending
+		export.FilePath, export.TokenHash = "", ""
+		export.CompletedAt, export.ExpiresAt = nil, nil
+		return err
+	}
+
+	return nil
+}
+
+// RemoveExpired deletes the archives of expired exports.
+func (s *DataExportService) RemoveExpired() {
+	exports, err := s.exportRepo.ListExpired(time.Now(), exportBatchSize)
+	if err != nil {
+		logger.Log.Error("Error listing expired data exports: %v", err)
+		return
+	}
+
+	for i := range exports {
+		export := &exports[i]
+		if err := os.Remove(export.FilePath); err != nil && !os.IsNotExist(err) {
+			logger.Log.Error("Error removing data export %d: %v", export.ID, err)
+			continue
+		}
+
+		export.Status = models.DataExportExpired
+		export.FilePath = ""
+		export.TokenHash = ""
+		if err := s.exportRepo.Update(export); err != nil {
+			logger.Log.Error("Error updating data export %d: %v", export.ID, err)
+		}
+	}
+}
+
+func (s *DataExportService) writeArchive(export *models.DataExport, user *models.User) (string, error) {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return "", err
+	}
+
+	suffix, err := utils.GenerateRandomToken(8)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(s.dir, fmt.Sprintf("export-%d-%s.zip", export.ID, suffix))
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.writeEntries(zip.NewWriter(file), user)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	return path, nil
+}
+
+// writeEntries adds one file per kind of data to the archive and closes it.
+func (s *DataExportService) writeEntries(zw *zip.Writer, user *models.User) error {
+	if err := writeJSONEntry(zw, "profile.json", user); err != nil {
+		return err
+	}
+
+	sessions, err := s.sessionRepo.ListByUser(user.ID)
+	if err != nil {
+		return err
+	}
+	sessionRows := [][]string{{"id", "created_at", "last_seen_at", "revoked_at", "ip_address", "browser", "os", "device_type", "user_agent"}}
+	for _, session := range sessions {
+		sessionRows = append(sessionRows, []string{
+			strconv.FormatUint(uint64(session.ID), 10),
+			formatTime(&session.CreatedAt),
+			formatTime(&session.LastSeenAt),
+			formatTime(session.RevokedAt),
+			session.IPAddress,
+			session.Browser,
+			session.OS,
+			session.DeviceType,
+			session.UserAgent,
+		})
+	}
+	if err := writeCSVEntry(zw, "sessions.csv", sessionRows); err != nil {
+		return err
+	}
+
+	wallets, err := s.walletRepo.ListByUser(user.ID)
+	if err != nil {
+		return err
+	}
+	walletRows := [][]string{{"id", "currency", "label", "address", "balance", "created_at"}}
+	for _, wallet := range wallets {
+		walletRows = append(walletRows, []string{
+			strconv.FormatUint(uint64(wallet.ID), 10),
+			wallet.Currency,
+			wallet.Label,
+			wallet.Address,
+			strconv.FormatInt(wallet.Balance, 10),
+			formatTime(&wallet.CreatedAt),
+		})
+	}
+	if err := writeCSVEntry(zw, "wallets.csv", walletRows); err != nil {
+		return err
+	}
+
+	transactionRows := [][]string{{"id", "wallet_id", "type", "status", "currency", "amount", "fee", "from_address", "to_address", "tx_hash", "confirmations", "failure_reason", "created_at", "updated_at"}}
+	for offset := 0; ; offset += exportTransactionPage {
+		txs, err := s.transactionRepo.ListByUser(user.ID, exportTransactionPage, offset)
+		if err != nil {
+			return err
+		}
+		for _, tx := range txs {
+			transactionRows = append(transactionRows, []string{
+				strconv.FormatUint(uint64(tx.ID), 10),
+				strconv.FormatUint(uint64(tx.WalletID), 10),
+				tx.Type,
+				tx.Status,
+				tx.Currency,
+				strconv.FormatInt(tx.Amount, 10),
+				strconv.FormatInt(tx.Fee, 10),
+				tx.FromAddress,
+				tx.ToAddress,
+				tx.TxHash,
+				strconv.Itoa(tx.Confirmations),
+				tx.FailureReason,
+				formatTime(&tx.CreatedAt),
+				formatTime(&tx.UpdatedAt),
+			})
+		}
+		if len(txs) < exportTransactionPage {
+			break
+		}
+	}
+	if err := writeCSVEntry(zw, "transactions.csv", transactionRows); err != nil {
+		return err
+	}
+
+	waitlist, err := s.waitlistRepo.GetByEmail(user.Email)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if err := writeJSONEntry(zw, "waitlist.json", waitlist); err != nil {
+		return err
+	}
+
+	keys, err := s.apiKeyRepo.ListByUser(user.ID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSONEntry(zw, "api_keys.json", keys); err != nil {
+		return err
+	}
+
+	identities, err := s.identityRepo.ListByUser(user.ID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSONEntry(zw, "linked_accounts.json", identities); err != nil {
+		return err
+	}
+
+	addresses, err := s.addressRepo.ListByUser(user.ID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSONEntry(zw, "withdrawal_addresses.json", addresses); err != nil {
+		return err
+	}
+
+	events, err := s.auditRepo.ListByUser(user.ID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSONEntry(zw, "audit_events.json", events); err != nil {
+		return err
+	}
+
+	emails, err := s.outboxRepo.ListEmailsTo(user.Email)
+	if err != nil {
+		return err
+	}
+	emailRows := [][]string{{"kind", "status", "created_at", "delivered_at"}}
+	for _, email := range emails {
+		emailRows = append(emailRows, []string{
+			email.Kind,
+			email.Status,
+			formatTime(&email.CreatedAt),
+			formatTime(email.DeliveredAt),
+		})
+	}
+	if err := writeCSVEntry(zw, "email_history.csv", emailRows); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+func writeJSONEntry(zw *zip.Writer, name string, v interface{}) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func writeCSVEntry(zw *zip.Writer, name string, rows [][]string) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,330 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"gorm.io/gorm"
+)
+
+const testJWTSecret = "test-jwt-secret-with-enough-length-for-hs256"
+
+// noTx runs the function without a database; the in-memory repositories
+// ignore the handle they get through WithTx.
+type noTx struct{}
+
+func (noTx) WithinTransaction(fn func(tx *gorm.DB) error) error {
+	return fn(nil)
+}
+
+// memUsers is an in-memory repo.UserRepository. Methods the tests don't use
+// panic through the embedded nil interface.
+type memUsers struct {
+	repo.UserRepository
+
+	mu     sync.Mutex
+	users  map[uint]*models.User
+	nextID uint
+}
+
+func newMemUsers(users ...*models.User) *memUsers {
+	r := &memUsers{users: make(map[uint]*models.User)}
+	for _, u := range users {
+		if err := r.CreateUser(u); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+func (r *memUsers) WithTx(*gorm.DB) repo.UserRepository { return r }
+
+func (r *memUsers) CreateUser(user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range r.users {
+		if strings.EqualFold(u.Email, user.Email) {
+			return repo.ErrDuplicateEmail
+		}
+	}
+	if user.ID == 0 {
+		r.nextID++
+		user.ID = r.nextID
+	} else if user.ID > r.nextID {
+		r.nextID = user.ID
+	}
+	stored := *user
+	r.users[user.ID] = &stored
+	return nil
+}
+
+func (r *memUsers) GetUserByID(id uint) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[id]
+	if !ok {
+		return nil, errors.New("user not found")
+	}
+	found := *u
+	return &found, nil
+}
+
+func (r *memUsers) GetUserByEmail(email string) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range r.users {
+		if strings.EqualFold(u.Email, email) {
+			found := *u
+			return &found, nil
+		}
+	}
+	return nil, errors.New("user not found")
+}
+
+func (r *memUsers) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.users)
+}
+
+func (r *memUsers) update(id uint, fn func(u *models.User)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[id]
+	if !ok {
+		return errors.New("user not found")
+	}
+	fn(u)
+	return nil
+}
+
+func (r *memUsers) UpdateUser(user *models.User) error {
+	return r.update(user.ID, func(u *models.User) { *u = *user })
+}
+
+func (r *memUsers) UpdatePassword(id uint, hash string) error {
+	return r.update(id, func(u *models.User) { u.Password = hash })
+}
+
+func (r *memUsers) IncrementTokenVersion(id uint) error {
+	return r.update(id, func(u *models.User) { u.TokenVersion++ })
+}
+
+func (r *memUsers) AdvanceTOTPStep(id uint, step int64) (bool, error) {
+	advanced := false
+	err := r.update(id, func(u *models.User) {
+		if u.TOTPLastUsedStep < step {
+			u.TOTPLastUsedStep = step
+			advanced = true
+		}
+	})
+	return advanced, err
+}
+
+func (r *memUsers) SetTOTPSecret(id uint, secret string) (bool, error) {
+	stored := false
+	err := r.update(id, func(u *models.User) {
+		if !u.TOTPEnabled {
+			u.TOTPSecret = secret
+			u.TOTPLastUsedStep = 0
+			stored = true
+		}
+	})
+	return stored, err
+}
+
+func (r *memUsers) EnableTOTP(id uint, secret string, step int64) (bool, error) {
+	enabled := false
+	err := r.update(id, func(u *models.User) {
+		if !u.TOTPEnabled && u.TOTPSecret == secret && u.TOTPLastUsedStep < step {
+			u.TOTPEnabled = true
+			u.TOTPLastUsedStep = step
+			enabled = true
+		}
+	})
+	return enabled, err
+}
+
+func (r *memUsers) DisableTOTP(id uint) error {
+	return r.update(id, func(u *models.User) {
+		u.TOTPEnabled = false
+		u.TOTPSecret = ""
+		u.TOTPLastUsedStep = 0
+	})
+}
+
+func (r *memUsers) SetVerifiedPhone(id uint, phone string, verifiedAt time.Time) error {
+	return r.update(id, func(u *models.User) {
+		u.PhoneNumber = phone
+		u.PhoneVerifiedAt = &verifiedAt
+	})
+}
+
+func (r *memUsers) ClearPhone(id uint) error {
+	return r.update(id, func(u *models.User) {
+		u.PhoneNumber = ""
+		u.PhoneVerifiedAt = nil
+		u.SMSMFAEnabled = false
+	})
+}
+
+func (r *memUsers) SetSMSMFAEnabled(id uint, enabled bool) error {
+	return r.update(id, func(u *models.User) { u.SMSMFAEnabled = enabled })
+}
+
+func (r *memUsers) GetUserByWebAuthnHandle(handle []byte) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range r.users {
+		if len(u.WebAuthnHandle) > 0 && string(u.WebAuthnHandle) == string(handle) {
+			found := *u
+			return &found, nil
+		}
+	}
+	return nil, errors.New("user not found")
+}
+
+func (r *memUsers) SetWebAuthnHandle(id uint, handle []byte) error {
+	return r.update(id, func(u *models.User) {
+		if len(u.WebAuthnHandle) == 0 {
+			u.WebAuthnHandle = handle
+		}
+	})
+}
+
+// passkeyCount answers CountCredentialsByUser; StartLogin needs nothing
+// else from the passkey repository.
+type passkeyCount struct {
+	repo.WebAuthnRepository
+	n int64
+}
+
+func (p passkeyCount) CountCredentialsByUser(uint) (int64, error) {
+	return p.n, nil
+}
+
+// memAuthRepos holds the stores completeLogin writes to.
+type memAuthRepos struct {
+	mu       sync.Mutex
+	sessions []models.Session
+	tokens   []models.RefreshToken
+	events   []models.AuditEvent
+}
+
+type memSessions struct {
+	repo.SessionRepository
+	r *memAuthRepos
+}
+
+func (s memSessions) WithTx(*gorm.DB) repo.SessionRepository { return s }
+
+func (s memSessions) Create(session *models.Session) error {
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	session.ID = uint(len(s.r.sessions) + 1)
+	s.r.sessions = append(s.r.sessions, *session)
+	return nil
+}
+
+func (s memSessions) RevokeAllForUser(userID uint, at time.Time) error {
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	for i := range s.r.sessions {
+		if s.r.sessions[i].UserID == userID && s.r.sessions[i].RevokedAt == nil {
+			s.r.sessions[i].RevokedAt = &at
+		}
+	}
+	return nil
+}
+
+type memRefreshTokens struct {
+	repo.RefreshTokenRepository
+	r *memAuthRepos
+}
+
+func (t memRefreshTokens) WithTx(*gorm.DB) repo.RefreshTokenRepository { return t }
+
+func (t memRefreshTokens) Create(token *models.RefreshToken) error {
+	t.r.mu.Lock()
+	defer t.r.mu.Unlock()
+	token.ID = uint(len(t.r.tokens) + 1)
+	t.r.tokens = append(t.r.tokens, *token)
+	return nil
+}
+
+func (t memRefreshTokens) RevokeAllForUser(userID uint, at time.Time) error {
+	t.r.mu.Lock()
+	defer t.r.mu.Unlock()
+	for i := range t.r.tokens {
+		if t.r.tokens[i].UserID == userID && t.r.tokens[i].RevokedAt == nil {
+			t.r.tokens[i].RevokedAt = &at
+		}
+	}
+	return nil
+}
+
+type memAudit struct {
+	repo.AuditRepository
+	r *memAuthRepos
+}
+
+func (a memAudit) WithTx(*gorm.DB) repo.AuditRepository { return a }
+func (a memAudit) LockChain() error                     { return nil }
+
+func (a memAudit) LastHash() (string, error) {
+	a.r.mu.Lock()
+	defer a.r.mu.Unlock()
+	if len(a.r.events) == 0 {
+		return "", nil
+	}
+	return a.r.events[len(a.r.events)-1].Hash, nil
+}
+
+func (a memAudit) Append(event *models.AuditEvent) error {
+	a.r.mu.Lock()
+	defer a.r.mu.Unlock()
+	event.ID = uint(len(a.r.events) + 1)
+	a.r.events = append(a.r.events, *event)
+	return nil
+}
+
+type noRoles struct {
+	repo.RoleRepository
+}
+
+func (noRoles) PermissionNames(string) ([]string, error) { return nil, nil }
+
+// auditActions lists the recorded audit actions in order.
+func (r *memAuthRepos) auditActions() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	actions := make([]string, len(r.events))
+	for i, e := range r.events {
+		actions[i] = e.Action
+	}
+	return actions
+}
+
+// newTestAuthService returns an AuthService that can complete logins
+// against in-memory stores. Access tokens are HS256 with testJWTSecret.
+func newTestAuthService(t *testing.T, users *memUsers, passkeys repo.WebAuthnRepository) (*AuthService, *memAuthRepos) {
+	t.Helper()
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	if err := utils.LoadAccessTokenKeys(); err != nil {
+		t.Fatalf("LoadAccessTokenKeys: %v", err)
+	}
+
+	stores := &memAuthRepos{}
+	if passkeys == nil {
+		passkeys = passkeyCount{}
+	}
+	auth := NewAuthService(users, memRefreshTokens{r: stores}, memSessions{r: stores}, noRoles{}, &memOutboxRepo{}, noTx{},
+		nil, nil, nil, passkeys, NewAuditService(memAudit{r: stores}, noTx{}))
+	return auth, stores
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+	"github.com/inlovewithgo/transit-backend/pkg/metrics"
+)
+
+const (
+	loginFailureWindow = 15 * time.Minute
+	// Failures allowed before delays kick in; each further failure doubles
+	// the wait, up to loginMaxDelay.
+	loginFreeAttempts = 3
+	loginMaxDelay     = time.Minute
+)
+
+var ErrInvalidUnlockToken = errors.New("invalid or expired unlock link")
+
+// LoginThrottledError is returned while an account or IP has to wait before
+// trying again. It reads the same whether or not the account exists.
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return "too many failed login attempts, please try again later"
+}
+
+// LoginThrottleService tracks failed logins per account and per IP in Redis.
+// Accounts are keyed by a hash of the submitted email so unknown addresses
+// are throttled exactly like real ones. Without Redis, throttling is off.
+type LoginThrottleService struct {
+	redis            *redis.Client
+	outboxRepo       repo.OutboxRepository
+	accountThreshold int64
+	ipThreshold      int64
+	lockoutDuration  time.Duration
+	baseURL          string
+}
+
+func NewLoginThrottleService(redisClient *redis.Client, outboxRepo repo.OutboxRepository) *LoginThrottleService {
+	if redisClient == nil {
+		logger.Log.Warn("Login throttling disabled - Redis not available")
+	}
+
+	accountThreshold, err := strconv.ParseInt(utils.GetENV("LOGIN_LOCKOUT_THRESHOLD", "10"), 10, 64)
+	if err != nil || accountThreshold < 1 {
+		accountThreshold = 10
+	}
+
+	ipThreshold, err := strconv.ParseInt(utils.GetENV("LOGIN_IP_THRESHOLD", "50"), 10, 64)
+	if err != nil || ipThreshold < 1 {
+		ipThreshold = 50
+	}
+
+	lockoutDuration, err := time.ParseDuration(utils.GetENV("LOGIN_LOCKOUT_DURATION", "30m"))
+	if err != nil || lockoutDuration <= 0 {
+		lockoutDuration = 30 * time.Minute
+	}
+
+	return &LoginThrottleService{
+		redis:            redisClient,
+		outboxRepo:       outboxRepo,
+		accountThreshold: accountThreshold,
+		ipThreshold:      ipThreshold,
+		lockoutDuration:  lockoutDuration,
+		baseURL:          strings.TrimRight(utils.GetENV("APP_BASE_URL", "http://localhost:3030"), "/"),
+	}
+}
+
+// Check rejects the attempt if the account is locked or waiting out a delay,
+// or if the IP has failed too often.
+func (s *LoginThrottleService) Check(email, ip string) error {
+	if s.redis == nil {
+		return nil
+	}
+
+	ctx := context.Background()
+	account := loginAccountID(email)
+
+	pipe := s.redis.Pipeline()
+	lockTTL := pipe.PTTL(ctx, "login:lock:"+account)
+	delayTTL := pipe.PTTL(ctx, "login:delay:"+account)
+	ipFailures := pipe.Get(ctx, "login:fail:ip:"+ip)
+	ipTTL := pipe.PTTL(ctx, "login:fail:ip:"+ip)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		logger.Log.Error("Error checking login throttle: %v", err)
+		return nil
+	}
+
+	var wait time.Duration
+	if ttl := lockTTL.Val(); ttl > wait {
+		wait = ttl
+	}
+	if ttl := delayTTL.Val(); ttl > wait {
+		wait = ttl
+	}
+	if n, _ := ipFailures.Int64(); n >= s.ipThreshold && ipTTL.Val() > wait {
+		wait = ipTTL.Val()
+	}
+
+	if wait > 0 {
+		metrics.LoginAttempts.WithLabelValues("throttled").Inc()
+		return &LoginThrottledError{RetryAfter: wait}
+	}
+
+	return nil
+}
+
+// RecordFailure counts a failed attempt. user is nil when the email is not
+// registered; it is only used to send the unlock email.
+func (s *LoginThrottleService) RecordFailure(email, ip string, user *models.User) {
+	metrics.LoginAttempts.WithLabelValues("failure").Inc()
+
+	if s.redis == nil {
+		return
+	}
+
+	ctx := context.Background()
+	account := loginAccountID(email)
+
+	pipe := s.redis.Pipeline()
+	accountFailures := pipe.Incr(ctx, "login:fail:acct:"+account)
+	pipe.Expire(ctx, "login:fail:acct:"+account, loginFailureWindow)
+	pipe.Incr(ctx, "login:fail:ip:"+ip)
+	pipe.Expire(ctx, "login:fail:ip:"+ip, loginFailureWindow)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.Log.Error("Error recording failed login: %v", err)
+		return
+	}
+
+	failures := accountFailures.Val()
+	switch {
+	case failures >= s.accountThreshold:
+		s.lock(ctx, account, user)
+	case failures > loginFreeAttempts:
+		delay := time.Duration(math.Pow(2, float64(failures-loginFreeAttempts))) * time.Second
+		if delay > loginMaxDelay {
+			delay = loginMaxDelay
+		}
+		s.redis.Set(ctx, "login:delay:"+account, 1, delay)
+	}
+}
+
+func (s *LoginThrottleService) RecordSuccess(email string) {
+	metrics.LoginAttempts.WithLabelValues("success").Inc()
+
+	if s.redis == nil {
+		return
+	}
+
+	account := loginAccountID(email)
+	s.redis.Del(context.Background(), "login:fail:acct:"+account, "login:delay:"+account)
+}
+
+// Unlock lifts a lockout using the link from the unlock email.
+func (s *LoginThrottleService) Unlock(token string) error {
+	if s.redis == nil || token == "" {
+		return ErrInvalidUnlockToken
+	}
+
+	ctx := context.Background()
+	key := "login:unlock:" + utils.HashToken(token)
+
+	account, err := s.redis.Get(ctx, key).Result()
+	if err != nil {
+		return ErrInvalidUnlockToken
+	}
+
+	if err := s.redis.Del(ctx, key, "login:lock:"+account, "login:fail:acct:"+account, "login:delay:"+account).Err(); err != nil {
+		logger.Log.Error("Error unlocking account: %v", err)
+		return fmt.Errorf("failed to unlock account")
+	}
+
+	return nil
+}
+
+func (s *LoginThrottleService) lock(ctx context.Context, account string, user *models.User) {
+	pipe := s.redis.Pipeline()
+	pipe.Set(ctx, "login:lock:"+account, 1, s.lockoutDuration)
+	pipe.Del(ctx, "login:fail:acct:"+account, "login:delay:"+account)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.Log.Error("Error locking account: %v", err)
+		return
+	}
+
+	metrics.AccountLockouts.Inc()
+
+	if user == nil {
+		return
+	}
+
+	logger.Log.Warn("Account for user %d locked after repeated failed logins", user.ID)
+
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		logger.Log.Error("Error generating unlock token: %v", err)
+		return
+	}
+
+	if err := s.redis.Set(ctx, "login:unlock:"+utils.HashToken(token), account, s.lockoutDuration).Err(); err != nil {
+		logger.Log.Error("Error storing unlock token: %v", err)
+		return
+	}
+
+	msg, err := NewOutboxMessage(models.OutboxKindAccountLocked, models.LinkEmailPayload{
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		URL:       s.baseURL + "/api/v1/auth/unlock?token=" + url.QueryEscape(token),
+	})
+	if err == nil {
+		err = s.outboxRepo.Enqueue(msg)
+	}
+	if err != nil {
+		logger.Log.Error("Failed to queue unlock email for user %d: %v", user.ID, err)
+	}
+}
+
+func loginAccountID(email string) string {
+	return utils.HashToken(strings.ToLower(strings.TrimSpace(email)))
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidMagicLink = errors.New("invalid or expired sign-in link")
+	// ErrMagicLinkWrongBrowser leaves the link unused so it still works in
+	// the browser that requested it.
+	ErrMagicLinkWrongBrowser = errors.New("open the sign-in link in the browser you requested it from")
+)
+
+// MagicLinkService logs users in through a one-time link sent by email.
+type MagicLinkService struct {
+	userRepo      repo.UserRepository
+	magicLinkRepo repo.MagicLinkRepository
+	outboxRepo    repo.OutboxRepository
+	transactor    repo.Transactor
+	auth          *AuthService
+	ttl           time.Duration
+	loginURL      string
+}
+
+func NewMagicLinkService(userRepo repo.UserRepository, magicLinkRepo repo.MagicLinkRepository, outboxRepo repo.OutboxRepository, transactor repo.Transactor, auth *AuthService) *MagicLinkService {
+	ttl, err := time.ParseDuration(utils.GetENV("MAGIC_LINK_TTL", "15m"))
+	if err != nil || ttl <= 0 {
+		logger.Log.Warn("Invalid MAGIC_LINK_TTL, using 15m")
+		ttl = 15 * time.Minute
+	}
+
+	baseURL := strings.TrimRight(utils.GetENV("APP_BASE_URL", "http://localhost:3030"), "/")
+
+	return &MagicLinkService{
+		userRepo:      userRepo,
+		magicLinkRepo: magicLinkRepo,
+		outboxRepo:    outboxRepo,
+		transactor:    transactor,
+		auth:          auth,
+		ttl:           ttl,
+		loginURL:      utils.GetENV("MAGIC_LINK_URL", baseURL+"/api/v1/auth/magic-link/verify"),
+	}
+}
+
+func (s *MagicLinkService) TTL() time.Duration {
+	return s.ttl
+}
+
+// RequestLink emails a sign-in link bound to nonce, which the caller stores
+// in a cookie. Like ForgotPassword it reports success for unknown emails.
+func (s *MagicLinkService) RequestLink(email, nonce string) error {
+	user, err := s.userRepo.GetUserByEmail(strings.TrimSpace(email))
+	if err != nil || !user.IsActive {
+		return nil
+	}
+
+	tokenID, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		logger.Log.Error("Error generating magic link: %v", err)
+		return fmt.Errorf("failed to send sign-in link")
+	}
+
+	token, err := utils.GenerateMagicLinkToken(user.ID, tokenID, s.ttl)
+	if err != nil {
+		logger.Log.Error("Error signing magic link: %v", err)
+		return fmt.Errorf("failed to send sign-in link")
+	}
+
+	msg, err := NewOutboxMessage(models.OutboxKindMagicLink, models.LinkEmailPayload{
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		URL:       s.loginURL + "?token=" + url.QueryEscape(token),
+	})
+	if err != nil {
+		logger.Log.Error("Error building magic link email: %v", err)
+		return fmt.Errorf("failed to send sign-in link")
+	}
+
+	err = s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		links := s.magicLinkRepo.WithTx(tx)
+		// Only the most recent link works.
+		if err := links.InvalidateForUser(user.ID, time.Now()); err != nil {
+			return err
+		}
+		if err := links.Create(&models.MagicLinkToken{
+			UserID:    user.ID,
+			TokenHash: utils.HashToken(tokenID),
+			NonceHash: utils.HashToken(nonce),
+			ExpiresAt: time.Now().Add(s.ttl),
+		}); err != nil {
+			return err
+		}
+		return s.outboxRepo.WithTx(tx).Enqueue(msg)
+	})
+	if err != nil {
+		logger.Log.Error("Error creating magic link for user %d: %v", user.ID, err)
+		return fmt.Errorf("failed to send sign-in link")
+	}
+
+	return nil
+}
+
+// Consume signs the user in if the link is valid, unused and opened with the
+// nonce cookie it was issued for.
+func (s *MagicLinkService) Consume(token, nonce string, client models.ClientInfo) (*models.AuthResponse, error) {
+	claims, err := utils.ValidateMagicLinkToken(token)
+	if err != nil {
+		return nil, ErrInvalidMagicLink
+	}
+
+	var user *models.User
+	err = s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		link, err := s.magicLinkRepo.WithTx(tx).GetByHashForUpdate(utils.HashToken(claims.ID))
+		if err != nil || link.UserID != claims.UserID || link.UsedAt != nil || time.Now().After(link.ExpiresAt) {
+			return ErrInvalidMagicLink
+		}
+
+		if nonce == "" || subtle.ConstantTimeCompare([]byte(utils.HashToken(nonce)), []byte(link.NonceHash)) != 1 {
+			return ErrMagicLinkWrongBrowser
+		}
+
+		user, err = s.userRepo.WithTx(tx).GetUserByID(link.UserID)
+		if err != nil || !user.IsActive {
+			return ErrInvalidMagicLink
+		}
+
+		return s.magicLinkRepo.WithTx(tx).InvalidateForUser(user.ID, time.Now())
+	})
+	if errors.Is(err, ErrInvalidMagicLink) || errors.Is(err, ErrMagicLinkWrongBrowser) {
+		return nil, err
+	}
+	if err != nil {
+		logger.Log.Error("Error consuming magic link: %v", err)
+		return nil, fmt.Errorf("failed to sign in")
+	}
+
+	return s.auth.StartLogin(user, client, models.LoginMethodMagicLink)
+}
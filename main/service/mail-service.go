@@ -5,6 +5,7 @@ package service
 
 import (
     "fmt"
+    "html"
     "os"
 	"time"
 
@@ -90,7 +91,7 @@ func (ms *MailService) SendWelcomeEmail(email, firstName, lastName string) error
     return nil
 }
 
-func (ms *MailService) SendLoginNotification(email, firstName, lastName string) error {
+func (ms *MailService) SendLoginNotification(email, firstName, lastName, device, ipAddress string) error {
     currentTime := time.Now().Format("January 2, 2006 at 3:04 PM MST")
     
     htmlContent := `<!DOCTYPE html>
@@ -132,6 +133,16 @@ func (ms *MailService) SendLoginNotification(email, firstName, lastName string)
                     <span style="color: #333333; font-size: 14px; float: right;">` + currentTime + `</span>
                     <div style="clear: both;"></div>
                 </div>
+                <div style="margin-bottom: 10px;">
+                    <span style="color: #666666; font-size: 14px;">Device:</span>
+                    <span style="color: #333333; font-size: 14px; float: right;">` + html.EscapeString(orUnknown(device)) + `</span>
+                    <div style="clear: both;"></div>
+                </div>
+                <div style="margin-bottom: 10px;">
+                    <span style="color: #666666; font-size: 14px;">IP Address:</span>
+                    <span style="color: #333333; font-size: 14px; float: right;">` + html.EscapeString(orUnknown(ipAddress)) + `</span>
+                    <div style="clear: both;"></div>
+                </div>
                 <div>
                     <span style="color: #666666; font-size: 14px;">Status:</span>
                     <span style="color: #28a745; font-size: 14px; float: right;">✓ Secure</span>
@@ -258,4 +269,186 @@ func (ms *MailService) SendWaitlistConfirmationEmail(email string) error {
 
     logger.Log.Info("Waitlist confirmation email sent successfully to %s", email)
     return nil
-}
\ No newline at end of file
+}
+
+// renderEmail wraps body in the shared Transit email layout. Any user supplied
+// values in body must already be HTML escaped.
+func renderEmail(title, heading, body string) string {
+    return `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>` + title + `</title>
+</head>
+<body style="margin: 0; padding: 40px 20px; background-color: #f5f5f5; font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Arial, sans-serif;">
+    <div style="max-width: 600px; margin: 0 auto; background-color: #ffffff; border-radius: 8px; overflow: hidden; box-shadow: 0 2px 10px rgba(0,0,0,0.1);">
+        <div style="background-color: #000000; padding: 40px 30px; text-align: center;">
+            <h1 style="color: #ffffff; margin: 0; font-size: 24px; font-weight: 600;">` + heading + `</h1>
+        </div>
+        <div style="padding: 40px 30px; text-align: center; color: #333333; font-size: 16px; line-height: 1.5;">
+` + body + `
+            <p style="color: #666666; font-size: 14px; margin: 30px 0 0 0; line-height: 1.5;">
+                If this wasn't you, please contact our support team immediately at <strong>security@yssh.dev</strong>
+            </p>
+        </div>
+    </div>
+</body>
+</html>`
+}
+
+// emailButton renders a call-to-action link in the email layout style.
+func emailButton(href, label string) string {
+    return `<a href="` + html.EscapeString(href) + `" style="display: inline-block; background-color: #000000; color: #ffffff; text-decoration: none; padding: 12px 30px; border-radius: 6px; font-size: 16px; font-weight: 500; margin: 20px 0;">` + html.EscapeString(label) + `</a>`
+}
+
+func (ms *MailService) send(email, subject, htmlContent, kind string) error {
+    params := &resend.SendEmailRequest{
+        From:    "noreply@yssh.dev",
+        To:      []string{email},
+        Subject: subject,
+        Html:    htmlContent,
+    }
+
+    _, err := ms.client.Emails.Send(params)
+    if err != nil {
+        logger.Log.Error("Failed to send %s email to %s: %v", kind, email, err)
+        return err
+    }
+
+    logger.Log.Info("%s email sent successfully to %s", kind, email)
+    return nil
+}
+
+func (ms *MailService) SendWithdrawalAddressAddedEmail(email, firstName, address, label string, activatesAt time.Time, cancelURL string) error {
+    body := `            <p style="margin: 0 0 20px 0;">Hi ` + html.EscapeString(firstName) + `,</p>
+            <p style="margin: 0 0 20px 0;">A new withdrawal address was added to your allowlist:</p>
+            <div style="background-color: #f8f9fa; padding: 20px; border-radius: 6px; margin: 20px 0; text-align: left; font-size: 14px;">
+                <div style="margin-bottom: 10px;"><span style="color: #666666;">Label:</span> ` + html.EscapeString(label) + `</div>
+                <div style="margin-bottom: 10px; word-break: break-all;"><span style="color: #666666;">Address:</span> ` + html.EscapeString(address) + `</div>
+                <div><span style="color: #666666;">Usable from:</span> ` + activatesAt.UTC().Format("January 2, 2006 at 3:04 PM MST") + `</div>
+            </div>
+            <p style="margin: 0 0 10px 0;">If you didn't add this address, cancel it before it becomes active:</p>
+            ` + emailButton(cancelURL, "Cancel this address")
+
+    return ms.send(email, "🔒 New withdrawal address added - Transit",
+        renderEmail("New withdrawal address - Transit", "New withdrawal address 🔒", body),
+        "Withdrawal address confirmation")
+}
+
+func (ms *MailService) SendVerificationEmail(email, firstName, verifyURL string) error {
+    body := `            <p style="margin: 0 0 20px 0;">Hi ` + html.EscapeString(firstName) + `,</p>
+            <p style="margin: 0 0 20px 0;">Please confirm that this is your email address to finish setting up your Transit account.</p>
+            ` + emailButton(verifyURL, "Verify email address") + `
+            <p style="color: #666666; font-size: 14px; margin: 20px 0 0 0;">This link expires in ` + utils.EmailVerificationTTL().String() + `. If you didn't create an account, you can ignore this email.</p>`
+
+    return ms.send(email, "✉️ Verify your email - Transit",
+        renderEmail("Verify your email - Transit", "Verify your email ✉️", body),
+        "Email verification")
+}
+
+func (ms *MailService) SendPasswordResetEmail(email, firstName, resetURL string) error {
+    body := `            <p style="margin: 0 0 20px 0;">Hi ` + html.EscapeString(firstName) + `,</p>
+            <p style="margin: 0 0 20px 0;">We received a request to reset the password for your Transit account.</p>
+            ` + emailButton(resetURL, "Reset password") + `
+            <p style="color: #666666; font-size: 14px; margin: 20px 0 0 0;">This link can be used once and expires soon. If you didn't ask for a reset, you can ignore this email; your password won't change.</p>`
+
+    return ms.send(email, "🔑 Reset your password - Transit",
+        renderEmail("Reset your password - Transit", "Reset your password 🔑", body),
+        "Password reset")
+}
+
+func (ms *MailService) SendPasswordChangedEmail(email, firstName string) error {
+    changedAt := time.Now().UTC().Format("January 2, 2006 at 3:04 PM MST")
+
+    body := `            <p style="margin: 0 0 20px 0;">Hi ` + html.EscapeString(firstName) + `,</p>
+            <p style="margin: 0 0 20px 0;">The password for your Transit account was changed on ` + changedAt + `. All devices have been signed out.</p>
+            <p style="color: #666666; font-size: 14px; margin: 20px 0 0 0;">If this wasn't you, reset your password immediately and contact our support team at <strong>security@yssh.dev</strong></p>`
+
+    return ms.send(email, "⚠️ Your password was changed - Transit",
+        renderEmail("Password changed - Transit", "Password changed ⚠️", body),
+        "Password changed")
+}
+
+func (ms *MailService) SendAccountLockedEmail(email, firstName, unlockURL string) error {
+    body := `            <p style="margin: 0 0 20px 0;">Hi ` + html.EscapeString(firstName) + `,</p>
+            <p style="margin: 0 0 20px 0;">We temporarily locked sign-in to your Transit account after too many failed password attempts. It will unlock automatically, or you can unlock it now if these attempts were yours.</p>
+            ` + emailButton(unlockURL, "Unlock account") + `
+            <p style="color: #666666; font-size: 14px; margin: 20px 0 0 0;">If this wasn't you, someone may be trying to guess your password. Consider changing it once you're signed in, and contact our support team at <strong>security@yssh.dev</strong></p>`
+
+    return ms.send(email, "🔒 Your account was temporarily locked - Transit",
+        renderEmail("Account locked - Transit", "Account temporarily locked 🔒", body),
+        "Account locked")
+}
+
+func (ms *MailService) SendMagicLinkEmail(email, firstName, loginURL string) error {
+    body := `            <p style="margin: 0 0 20px 0;">Hi ` + html.EscapeString(firstName) + `,</p>
+            <p style="margin: 0 0 20px 0;">Use the button below to sign in to Transit. Open it in the same browser you requested it from.</p>
+            ` + emailButton(loginURL, "Sign in") + `
+            <p style="color: #666666; font-size: 14px; margin: 20px 0 0 0;">This link works once and expires in a few minutes. If you didn't ask to sign in, you can ignore this email.</p>`
+
+    return ms.send(email, "✨ Your sign-in link - Transit",
+        renderEmail("Sign in to Transit", "Sign in to Transit ✨", body),
+        "Magic link")
+}
+
+func (ms *MailService) SendEmailChangeConfirmation(email, firstName, newEmail, confirmURL string) error {
+    body := `            <p style="margin: 0 0 20px 0;">Hi ` + html.EscapeString(firstName) + `,</p>
+            <p style="margin: 0 0 20px 0;">We received a request to change the email address of your Transit account to <strong>` + html.EscapeString(newEmail) + `</strong>. The change only takes effect once it has been confirmed from both the current and the new address.</p>
+            ` + emailButton(confirmURL, "Confirm email change") + `
+            <p style="color: #666666; font-size: 14px; margin: 20px 0 0 0;">If you didn't request this, don't click the link and change your password right away.</p>`
+
+    return ms.send(email, "📧 Confirm your new email address - Transit",
+        renderEmail("Confirm your email change", "Confirm your email change 📧", body),
+        "Email change")
+}
+
+func (ms *MailService) SendAccountDeletedEmail(email, firstName string, purgeAt time.Time) error {
+    body := `            <p style="margin: 0 0 20px 0;">Hi ` + html.EscapeString(firstName) + `,</p>
+            <p style="margin: 0 0 20px 0;">Your Transit account has been deleted and you have been signed out everywhere. Your data will be permanently erased on <strong>` + purgeAt.UTC().Format("January 2, 2006") + `</strong>.</p>
+            <p style="color: #666666; font-size: 14px; margin: 20px 0 0 0;">If you didn't do this, contact support before that date so we can restore your account.</p>`
+
+    return ms.send(email, "👋 Your account has been deleted - Transit",
+        renderEmail("Account deleted", "Your account has been deleted 👋", body),
+        "Account deleted")
+}
+
+func (ms *MailService) SendDataExportReadyEmail(email, firstName, downloadURL string, expiresAt time.Time) error {
+    body := `            <p style="margin: 0 0 20px 0;">Hi ` + html.EscapeString(firstName) + `,</p>
+            <p style="margin: 0 0 20px 0;">The copy of your Transit data you asked for is ready. It is a ZIP archive of JSON and CSV files.</p>
+            ` + emailButton(downloadURL, "Download your data") + `
+            <p style="color: #666666; font-size: 14px; margin: 20px 0 0 0;">The link expires on ` + expiresAt.UTC().Format("January 2, 2006 at 15:04 UTC") + `. If you didn't request this export, change your password right away.</p>`
+
+    return ms.send(email, "📦 Your data export is ready - Transit",
+        renderEmail("Your data export is ready", "Your data export is ready 📦", body),
+        "Data export")
+}
+
+// SendNotificationEmail renders a Notifier message: a greeting, the text and
+// an optional box of label/value details.
+func (ms *MailService) SendNotificationEmail(email, firstName, subject, heading, text string, details []NotificationField, kind string) error {
+    body := `            <p style="margin: 0 0 20px 0;">Hi ` + html.EscapeString(firstName) + `,</p>
+            <p style="margin: 0 0 20px 0;">` + html.EscapeString(text) + `</p>`
+
+    if len(details) > 0 {
+        body += `
+            <div style="background-color: #f8f9fa; padding: 20px; border-radius: 6px; margin: 20px 0; text-align: left; font-size: 14px;">`
+        for _, d := range details {
+            body += `
+                <div style="margin-bottom: 10px; word-break: break-all;"><span style="color: #666666;">` + html.EscapeString(d.Label) + `:</span> ` + html.EscapeString(d.Value) + `</div>`
+        }
+        body += `
+            </div>`
+    }
+
+    return ms.send(email, subject+" - Transit",
+        renderEmail(html.EscapeString(subject)+" - Transit", html.EscapeString(heading), body),
+        kind)
+}
+
+func orUnknown(value string) string {
+    if value == "" {
+        return "Unknown"
+    }
+    return value
+}
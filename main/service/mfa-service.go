@@ -0,0 +1,444 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image/png"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"gorm.io/gorm"
+)
+
+const (
+	totpPeriod         = 30
+	recoveryCodeCount  = 10
+	mfaMaxAttempts     = 5
+	mfaAttemptsWindow  = 15 * time.Minute
+	totpQRCodeDiameter = 256
+)
+
+var (
+	ErrInvalidMFACode     = errors.New("invalid authentication code")
+	ErrMFANotEnabled      = errors.New("two-factor authentication is not enabled")
+	ErrMFAAlreadyEnabled  = errors.New("two-factor authentication is already enabled")
+	ErrMFANotSetUp        = errors.New("start two-factor setup before confirming it")
+	ErrTooManyMFAAttempts = errors.New("too many invalid codes, please try again later")
+)
+
+// MFAService manages TOTP enrollment and recovery codes, and checks second
+// factors during login.
+type MFAService struct {
+	userRepo     repo.UserRepository
+	recoveryRepo repo.RecoveryCodeRepository
+	transactor   repo.Transactor
+	redis        *redis.Client
+	// attempts counts wrong codes when Redis is not configured.
+	attempts *mfaAttemptCounter
+	issuer   string
+}
+
+func NewMFAService(userRepo repo.UserRepository, recoveryRepo repo.RecoveryCodeRepository, transactor repo.Transactor, redisClient *redis.Client) *MFAService {
+	if redisClient == nil {
+		logger.Log.Warn("Redis not available - MFA attempt limits are kept per instance")
+	}
+
+	return &MFAService{
+		userRepo:     userRepo,
+		recoveryRepo: recoveryRepo,
+		transactor:   transactor,
+		redis:        redisClient,
+		attempts:     &mfaAttemptCounter{counts: make(map[uint]mfaAttemptCount)},
+		issuer:       utils.GetENV("MFA_ISSUER", "Transit"),
+	}
+}
+
+// SetupTOTP generates a new secret and stores it encrypted. It only takes
+// effect once ConfirmTOTP verifies a code from the authenticator app.
+func (s *MFAService) SetupTOTP(userID uint) (*models.TOTPSetupResponse, error) {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      s.issuer,
+		AccountName: user.Email,
+		Period:      totpPeriod,
+	})
+	if err != nil {
+		logger.Log.Error("Error generating TOTP secret: %v", err)
+		return nil, fmt.Errorf("failed to start two-factor setup")
+	}
+
+	encrypted, err := utils.EncryptSecret(key.Secret())
+	if err != nil {
+		logger.Log.Error("Error encrypting TOTP secret: %v", err)
+		return nil, fmt.Errorf("failed to start two-factor setup")
+	}
+
+	qrCode, err := totpQRCode(key)
+	if err != nil {
+		logger.Log.Error("Error rendering TOTP QR code: %v", err)
+		return nil, fmt.Errorf("failed to start two-factor setup")
+	}
+
+	stored, err := s.userRepo.SetTOTPSecret(userID, encrypted)
+	if err != nil {
+		logger.Log.Error("Error saving TOTP secret for user %d: %v", userID, err)
+		return nil, fmt.Errorf("failed to start two-factor setup")
+	}
+	if !stored {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	return &models.TOTPSetupResponse{
+		Secret:     key.Secret(),
+		OTPAuthURL: key.URL(),
+		QRCode:     qrCode,
+	}, nil
+}
+
+// ConfirmTOTP enables two-factor authentication after checking the first
+// code, and returns a fresh set of recovery codes to show once.
+func (s *MFAService) ConfirmTOTP(userID uint, code string) ([]string, error) {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrMFANotSetUp
+	}
+
+	if err := s.takeAttempt(userID); err != nil {
+		return nil, err
+	}
+
+	step, ok := s.matchTOTP(user, code)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		logger.Log.Error("Error generating recovery codes: %v", err)
+		return nil, fmt.Errorf("failed to enable two-factor authentication")
+	}
+
+	err = s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		// Fails if the code was already used or setup was restarted with a
+		// new secret in the meantime.
+		enabled, err := s.userRepo.WithTx(tx).EnableTOTP(userID, user.TOTPSecret, step)
+		if err != nil {
+			return err
+		}
+		if !enabled {
+			return ErrInvalidMFACode
+		}
+		return s.recoveryRepo.WithTx(tx).ReplaceForUser(userID, hashes)
+	})
+	if errors.Is(err, ErrInvalidMFACode) {
+		return nil, err
+	}
+	if err != nil {
+		logger.Log.Error("Error enabling TOTP for user %d: %v", userID, err)
+		return nil, fmt.Errorf("failed to enable two-factor authentication")
+	}
+
+	s.clearFailures(userID)
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after checking a
+// current TOTP code.
+func (s *MFAService) RegenerateRecoveryCodes(userID uint, code string) ([]string, error) {
+	user, err := s.enabledUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.VerifyTOTP(user, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		logger.Log.Error("Error generating recovery codes: %v", err)
+		return nil, fmt.Errorf("failed to regenerate recovery codes")
+	}
+
+	if err := s.recoveryRepo.ReplaceForUser(userID, hashes); err != nil {
+		logger.Log.Error("Error saving recovery codes for user %d: %v", userID, err)
+		return nil, fmt.Errorf("failed to regenerate recovery codes")
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP turns two-factor authentication off. A TOTP or recovery code is
+// required so a hijacked session alone can't remove it.
+func (s *MFAService) DisableTOTP(userID uint, code string) error {
+	user, err := s.enabledUser(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.VerifyCode(user, code); err != nil {
+		return err
+	}
+
+	err = s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		if err := s.userRepo.WithTx(tx).DisableTOTP(userID); err != nil {
+			return err
+		}
+		return s.recoveryRepo.WithTx(tx).DeleteForUser(userID)
+	})
+	if err != nil {
+		logger.Log.Error("Error disabling TOTP for user %d: %v", userID, err)
+		return fmt.Errorf("failed to disable two-factor authentication")
+	}
+
+	return nil
+}
+
+// VerifyCode accepts either a current TOTP code or an unused recovery code.
+func (s *MFAService) VerifyCode(user *models.User, code string) error {
+	code = normalizeMFACode(code)
+	if isTOTPCode(code) {
+		return s.VerifyTOTP(user, code)
+	}
+
+	if err := s.takeAttempt(user.ID); err != nil {
+		return err
+	}
+
+	used, err := s.recoveryRepo.Consume(user.ID, utils.HashToken(code), time.Now())
+	if err != nil {
+		logger.Log.Error("Error consuming recovery code for user %d: %v", user.ID, err)
+		return fmt.Errorf("failed to verify code")
+	}
+	if !used {
+		return ErrInvalidMFACode
+	}
+
+	logger.Log.Info("Recovery code used by user %d", user.ID)
+	s.clearFailures(user.ID)
+	return nil
+}
+
+// VerifyTOTP checks a TOTP code and records its time step so it can't be
+// replayed. The step only moves forward in the database, so of two requests
+// racing with the same code only one succeeds.
+func (s *MFAService) VerifyTOTP(user *models.User, code string) error {
+	if err := s.takeAttempt(user.ID); err != nil {
+		return err
+	}
+
+	step, ok := s.matchTOTP(user, code)
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	advanced, err := s.userRepo.AdvanceTOTPStep(user.ID, step)
+	if err != nil {
+		logger.Log.Error("Error recording TOTP use for user %d: %v", user.ID, err)
+		return fmt.Errorf("failed to verify code")
+	}
+	if !advanced {
+		return ErrInvalidMFACode
+	}
+	user.TOTPLastUsedStep = step
+
+	s.clearFailures(user.ID)
+	return nil
+}
+
+func (s *MFAService) enabledUser(userID uint) (*models.User, error) {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TOTPEnabled {
+		return nil, ErrMFANotEnabled
+	}
+	return user, nil
+}
+
+// matchTOTP accepts codes from the current step and one step either side to
+// allow for clock drift, but never a step at or before the last one used.
+func (s *MFAService) matchTOTP(user *models.User, code string) (int64, bool) {
+	code = normalizeMFACode(code)
+	if !isTOTPCode(code) || user.TOTPSecret == "" {
+		return 0, false
+	}
+
+	secret, err := utils.DecryptSecret(user.TOTPSecret)
+	if err != nil {
+		logger.Log.Error("Error decrypting TOTP secret for user %d: %v", user.ID, err)
+		return 0, false
+	}
+
+	now := time.Now()
+	for _, skew := range []int64{0, -1, 1} {
+		at := now.Add(time.Duration(skew*totpPeriod) * time.Second)
+		expected, err := totp.GenerateCodeCustom(secret, at, totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return 0, false
+		}
+
+		step := at.Unix() / totpPeriod
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 && step > user.TOTPLastUsedStep {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// mfaTakeAttempt counts an attempt and starts the window on the first one,
+// in a single step so concurrent attempts can't all pass the limit.
+var mfaTakeAttempt = redis.NewScript(`
+local n = redis.call("INCR", KEYS[1])
+if n == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return n
+`)
+
+// takeAttempt counts a code check against the per-user limit before the
+// code is looked at, so a known password can't be used to brute-force the
+// second factor even with parallel requests. A correct code clears the
+// count. Without Redis the count is kept in memory. If Redis fails, codes
+// are refused rather than checked without a limit.
+func (s *MFAService) takeAttempt(userID uint) error {
+	var attempts int64
+	if s.redis == nil {
+		attempts = s.attempts.take(userID)
+	} else {
+		var err error
+		attempts, err = mfaTakeAttempt.Run(context.Background(), s.redis, []string{mfaAttemptsKey(userID)}, mfaAttemptsWindow.Milliseconds()).Int64()
+		if err != nil {
+			logger.Log.Error("Error counting MFA attempts for user %d: %v", userID, err)
+			return fmt.Errorf("failed to verify code")
+		}
+	}
+
+	if attempts > mfaMaxAttempts {
+		return ErrTooManyMFAAttempts
+	}
+	return nil
+}
+
+func (s *MFAService) clearFailures(userID uint) {
+	if s.redis == nil {
+		s.attempts.clear(userID)
+		return
+	}
+	s.redis.Del(context.Background(), mfaAttemptsKey(userID))
+}
+
+// mfaAttemptCounter counts attempts per user for mfaAttemptsWindow from
+// the first one, like the Redis counter.
+type mfaAttemptCounter struct {
+	mu     sync.Mutex
+	counts map[uint]mfaAttemptCount
+}
+
+type mfaAttemptCount struct {
+	n       int64
+	expires time.Time
+}
+
+// take counts an attempt and returns the count including it.
+func (c *mfaAttemptCounter) take(userID uint) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	count, ok := c.counts[userID]
+	if !ok || time.Now().After(count.expires) {
+		count = mfaAttemptCount{expires: time.Now().Add(mfaAttemptsWindow)}
+	}
+	count.n++
+	c.counts[userID] = count
+	return count.n
+}
+
+func (c *mfaAttemptCounter) clear(userID uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.counts, userID)
+}
+
+func mfaAttemptsKey(userID uint) string {
+	return fmt.Sprintf("mfa:attempts:%d", userID)
+}
+
+func totpQRCode(key *otp.Key) (string, error) {
+	img, err := key.Image(totpQRCodeDiameter, totpQRCodeDiameter)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// generateRecoveryCodes returns codes formatted for display ("a1b2c-3d4e5")
+// and the hashes of their normalized form.
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		raw, err := utils.GenerateRandomToken(5)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = utils.HashToken(raw)
+	}
+
+	return codes, hashes, nil
+}
+
+func normalizeMFACode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer(" ", "", "-", "").Replace(code)
+}
+
+func isTOTPCode(code string) bool {
+	if len(code) != 6 {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
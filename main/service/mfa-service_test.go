@@ -0,0 +1,154 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"gorm.io/gorm"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+type noRecoveryCodes struct {
+	repo.RecoveryCodeRepository
+}
+
+func (r noRecoveryCodes) WithTx(*gorm.DB) repo.RecoveryCodeRepository { return r }
+func (noRecoveryCodes) ReplaceForUser(uint, []string) error           { return nil }
+func (noRecoveryCodes) Consume(uint, string, time.Time) (bool, error) { return false, nil }
+
+func newMFATest(t *testing.T) (*MFAService, *memUsers) {
+	t.Helper()
+	t.Setenv("ENCRYPTION_KEY", testEncryptionKey)
+
+	secret, err := utils.EncryptSecret(testTOTPSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := newMemUsers(&models.User{
+		Email:       "ada@example.com",
+		IsActive:    true,
+		TOTPEnabled: true,
+		TOTPSecret:  secret,
+	})
+	return NewMFAService(users, noRecoveryCodes{}, noTx{}, nil), users
+}
+
+func currentTOTP(t *testing.T) string {
+	t.Helper()
+	code, err := totp.GenerateCodeCustom(testTOTPSecret, time.Now(), totp.ValidateOpts{
+		Period:    totpPeriod,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestVerifyTOTPRejectsReplay(t *testing.T) {
+	s, users := newMFATest(t)
+	code := currentTOTP(t)
+
+	if err := s.VerifyTOTP(mustUser(t, users, 1), code); err != nil {
+		t.Fatalf("VerifyTOTP: %v", err)
+	}
+	if err := s.VerifyTOTP(mustUser(t, users, 1), code); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("replayed code error = %v, want ErrInvalidMFACode", err)
+	}
+}
+
+func TestVerifyTOTPAcceptsCodeOnceUnderConcurrency(t *testing.T) {
+	s, users := newMFATest(t)
+	code := currentTOTP(t)
+
+	// Every request loads the user before any of them records the step, as
+	// concurrent logins would.
+	const requests = 8
+	loaded := make([]*models.User, requests)
+	for i := range loaded {
+		loaded[i] = mustUser(t, users, 1)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		accepted int
+	)
+	for _, user := range loaded {
+		wg.Add(1)
+		go func(user *models.User) {
+			defer wg.Done()
+			if s.VerifyTOTP(user, code) == nil {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}(user)
+	}
+	wg.Wait()
+
+	if accepted != 1 {
+		t.Fatalf("code accepted %d times, want once", accepted)
+	}
+}
+
+func TestMFAAttemptsLimitedUnderConcurrency(t *testing.T) {
+	s, users := newMFATest(t)
+	code := currentTOTP(t)
+
+	loaded := make([]*models.User, 4*mfaMaxAttempts)
+	for i := range loaded {
+		loaded[i] = mustUser(t, users, 1)
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		checked int
+	)
+	for _, user := range loaded {
+		wg.Add(1)
+		go func(user *models.User) {
+			defer wg.Done()
+			err := s.VerifyTOTP(user, wrongCode(code))
+			if errors.Is(err, ErrInvalidMFACode) {
+				mu.Lock()
+				checked++
+				mu.Unlock()
+			} else if !errors.Is(err, ErrTooManyMFAAttempts) {
+				t.Errorf("error = %v, want ErrInvalidMFACode or ErrTooManyMFAAttempts", err)
+			}
+		}(user)
+	}
+	wg.Wait()
+
+	if checked != mfaMaxAttempts {
+		t.Fatalf("%d wrong codes checked, want %d", checked, mfaMaxAttempts)
+	}
+}
+
+func TestMFAAttemptsLimitedWithoutRedis(t *testing.T) {
+	s, users := newMFATest(t)
+	code := currentTOTP(t)
+
+	for i := 0; i < mfaMaxAttempts; i++ {
+		if err := s.VerifyTOTP(mustUser(t, users, 1), wrongCode(code)); !errors.Is(err, ErrInvalidMFACode) {
+			t.Fatalf("attempt %d error = %v, want ErrInvalidMFACode", i+1, err)
+		}
+	}
+	if err := s.VerifyTOTP(mustUser(t, users, 1), code); !errors.Is(err, ErrTooManyMFAAttempts) {
+		t.Fatalf("right code after %d failures error = %v, want ErrTooManyMFAAttempts", mfaMaxAttempts, err)
+	}
+	if err := s.VerifyCode(mustUser(t, users, 1), "abcde-12345"); !errors.Is(err, ErrTooManyMFAAttempts) {
+		t.Fatalf("recovery code after lockout error = %v, want ErrTooManyMFAAttempts", err)
+	}
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/common/twilio"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+)
+
+const notifySendTimeout = 15 * time.Second
+
+var (
+	ErrSMSUnavailable       = errors.New("SMS is not available")
+	ErrUnknownNotifyChannel = errors.New("unknown notification channel")
+)
+
+// Notification is a short message to a user. Each channel uses the parts
+// it can carry: email shows the subject, heading and details, SMS only the
+// text.
+type Notification struct {
+	Kind        string
+	Email       string
+	FirstName   string
+	PhoneNumber string
+	Subject     string
+	Heading     string
+	Text        string
+	Details     []NotificationField
+}
+
+type NotificationField struct {
+	Label string
+	Value string
+}
+
+// NotificationChannel delivers notifications over one medium.
+type NotificationChannel interface {
+	Send(ctx context.Context, n *Notification) error
+}
+
+// Notifier routes notifications to the email and SMS channels. The SMS
+// channel is missing when Twilio is disabled or not configured.
+type Notifier struct {
+	channels map[string]NotificationChannel
+}
+
+func NewNotifier(mailService *MailService, sms *twilio.Client) *Notifier {
+	n := &Notifier{channels: make(map[string]NotificationChannel)}
+	if mailService != nil {
+		n.channels[models.NotifyChannelEmail] = &emailChannel{mail: mailService}
+	}
+	if sms != nil {
+		n.channels[models.NotifyChannelSMS] = &smsChannel{client: sms}
+	}
+	return n
+}
+
+// Available reports whether channel can currently deliver.
+func (n *Notifier) Available(channel string) bool {
+	_, ok := n.channels[channel]
+	return ok
+}
+
+// Send delivers n over channel.
+func (n *Notifier) Send(ctx context.Context, channel string, notification *Notification) error {
+	ch, ok := n.channels[channel]
+	if !ok {
+		if channel == models.NotifyChannelSMS {
+			return ErrSMSUnavailable
+		}
+		return fmt.Errorf("%w: %s", ErrUnknownNotifyChannel, channel)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, notifySendTimeout)
+	defer cancel()
+	return ch.Send(ctx, notification)
+}
+
+// SendWithdrawalAlert delivers a queued withdrawal alert. Alerts for a
+// channel that has since become unavailable are dropped, since retrying
+// won't help.
+func (n *Notifier) SendWithdrawalAlert(p models.WithdrawalAlertPayload) error {
+	err := n.Send(context.Background(), p.Channel, &Notification{
+		Kind:        "Withdrawal alert",
+		Email:       p.Email,
+		FirstName:   p.FirstName,
+		PhoneNumber: p.PhoneNumber,
+		Subject:     "Withdrawal requested",
+		Heading:     "Withdrawal requested 💸",
+		Text: fmt.Sprintf("Transit: a withdrawal of %s %s to %s was requested from your account. If this wasn't you, contact security@yssh.dev now.",
+			p.Amount, p.Currency, shortAddress(p.ToAddress)),
+		Details: []NotificationField{
+			{Label: "Amount", Value: p.Amount + " " + p.Currency},
+			{Label: "To", Value: p.ToAddress},
+			{Label: "Transaction", Value: fmt.Sprintf("#%d", p.TransactionID)},
+		},
+	})
+	if errors.Is(err, ErrSMSUnavailable) || errors.Is(err, ErrUnknownNotifyChannel) {
+		logger.Log.Warn("Dropping withdrawal alert for transaction %d: %v", p.TransactionID, err)
+		return nil
+	}
+	return err
+}
+
+type emailChannel struct {
+	mail *MailService
+}
+
+func (c *emailChannel) Send(_ context.Context, n *Notification) error {
+	if n.Email == "" {
+		return errors.New("notification has no email address")
+	}
+	return c.mail.SendNotificationEmail(n.Email, n.FirstName, n.Subject, n.Heading, n.Text, n.Details, n.Kind)
+}
+
+type smsChannel struct {
+	client *twilio.Client
+}
+
+func (c *smsChannel) Send(ctx context.Context, n *Notification) error {
+	if n.PhoneNumber == "" {
+		return errors.New("notification has no phone number")
+	}
+
+	msg, err := c.client.SendSMS(ctx, n.PhoneNumber, n.Text)
+	if err != nil {
+		logger.Log.Error("Failed to send %s SMS to %s: %v", n.Kind, MaskPhoneNumber(n.PhoneNumber), err)
+		return err
+	}
+
+	logger.Log.Info("%s SMS %s queued to %s", n.Kind, msg.SID, MaskPhoneNumber(n.PhoneNumber))
+	return nil
+}
+
+// shortAddress keeps the start and end of a long address, which is what
+// people compare, so an SMS stays within one segment.
+func shortAddress(address string) string {
+	if len(address) <= 16 {
+		return address
+	}
+	return address[:8] + "..." + address[len(address)-6:]
+}
@@ -0,0 +1,330 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+const oidcHTTPTimeout = 10 * time.Second
+
+var (
+	ErrOIDCProviderNotFound = errors.New("unknown login provider")
+	ErrOIDCLoginFailed      = errors.New("login with the provider failed, please try again")
+	ErrOIDCEmailUnverified  = errors.New("the provider did not confirm your email address")
+	// ErrOIDCLinkUnverified stops an unverified local account from being
+	// linked by email: whoever registered it may not own the address.
+	ErrOIDCLinkUnverified = errors.New("an account with this email exists but its email is not verified; log in with your password and verify it first")
+)
+
+// oidcProviderConfig is read from OIDC_<NAME>_ISSUER, _CLIENT_ID and
+// _CLIENT_SECRET for every name in OIDC_PROVIDERS. The discovery document is
+// fetched on first use and cached.
+type oidcProviderConfig struct {
+	name         string
+	issuer       string
+	clientID     string
+	clientSecret string
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+// OIDCService signs users in with external OpenID Connect providers using
+// the authorization code flow with PKCE.
+type OIDCService struct {
+	providers    map[string]*oidcProviderConfig
+	userRepo     repo.UserRepository
+	identityRepo repo.IdentityRepository
+	outboxRepo   repo.OutboxRepository
+	transactor   repo.Transactor
+	auth         *AuthService
+	baseURL      string
+}
+
+func NewOIDCService(userRepo repo.UserRepository, identityRepo repo.IdentityRepository, outboxRepo repo.OutboxRepository, transactor repo.Transactor, auth *AuthService) *OIDCService {
+	providers := map[string]*oidcProviderConfig{}
+	for _, name := range strings.Split(utils.GetENV("OIDC_PROVIDERS", ""), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		cfg := &oidcProviderConfig{
+			name:         name,
+			issuer:       utils.GetENV(prefix+"ISSUER", ""),
+			clientID:     utils.GetENV(prefix+"CLIENT_ID", ""),
+			clientSecret: utils.GetENV(prefix+"CLIENT_SECRET", ""),
+		}
+		if cfg.issuer == "" || cfg.clientID == "" {
+			logger.Log.Warn("OIDC provider %s is missing %sISSUER or %sCLIENT_ID, skipping", name, prefix, prefix)
+			continue
+		}
+		providers[name] = cfg
+	}
+
+	return &OIDCService{
+		providers:    providers,
+		userRepo:     userRepo,
+		identityRepo: identityRepo,
+		outboxRepo:   outboxRepo,
+		transactor:   transactor,
+		auth:         auth,
+		baseURL:      strings.TrimRight(utils.GetENV("APP_BASE_URL", "http://localhost:3030"), "/"),
+	}
+}
+
+// AuthCodeURL starts a login. It returns the provider URL to redirect to and
+// a state token the caller must hand back to Callback, normally via cookie.
+func (s *OIDCService) AuthCodeURL(providerName string) (string, string, error) {
+	cfg, ok := s.providers[providerName]
+	if !ok {
+		return "", "", ErrOIDCProviderNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), oidcHTTPTimeout)
+	defer cancel()
+
+	provider, err := cfg.discover(ctx)
+	if err != nil {
+		logger.Log.Error("OIDC discovery for %s failed: %v", cfg.name, err)
+		return "", "", ErrOIDCLoginFailed
+	}
+
+	state, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return "", "", ErrOIDCLoginFailed
+	}
+	nonce, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return "", "", ErrOIDCLoginFailed
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	stateToken, err := utils.GenerateOIDCStateToken(cfg.name, state, nonce, verifier)
+	if err != nil {
+		logger.Log.Error("Error generating OIDC state: %v", err)
+		return "", "", ErrOIDCLoginFailed
+	}
+
+	authURL := s.oauthConfig(cfg, provider).AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	return authURL, stateToken, nil
+}
+
+// Callback finishes a login: it exchanges the code, verifies the ID token
+// and signs in the linked user, linking or creating one if needed.
+func (s *OIDCService) Callback(providerName, stateToken, state, code string, client models.ClientInfo) (*models.AuthResponse, error) {
+	cfg, ok := s.providers[providerName]
+	if !ok {
+		return nil, ErrOIDCProviderNotFound
+	}
+
+	saved, err := utils.ValidateOIDCStateToken(stateToken)
+	if err != nil || saved.Provider != cfg.name || saved.State == "" || saved.State != state || code == "" {
+		return nil, ErrOIDCLoginFailed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), oidcHTTPTimeout)
+	defer cancel()
+
+	provider, err := cfg.discover(ctx)
+	if err != nil {
+		logger.Log.Error("OIDC discovery for %s failed: %v", cfg.name, err)
+		return nil, ErrOIDCLoginFailed
+	}
+
+	token, err := s.oauthConfig(cfg, provider).Exchange(ctx, code, oauth2.VerifierOption(saved.CodeVerifier))
+	if err != nil {
+		logger.Log.Warn("OIDC code exchange with %s failed: %v", cfg.name, err)
+		return nil, ErrOIDCLoginFailed
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		logger.Log.Warn("OIDC token response from %s has no id_token", cfg.name)
+		return nil, ErrOIDCLoginFailed
+	}
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: cfg.clientID}).Verify(ctx, rawIDToken)
+	if err != nil || idToken.Nonce != saved.Nonce {
+		logger.Log.Warn("OIDC ID token from %s rejected: %v", cfg.name, err)
+		return nil, ErrOIDCLoginFailed
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		GivenName     string `json:"given_name"`
+		FamilyName    string `json:"family_name"`
+		Name          string `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		logger.Log.Warn("OIDC ID token claims from %s unreadable: %v", cfg.name, err)
+		return nil, ErrOIDCLoginFailed
+	}
+
+	user, err := s.resolveUser(cfg.name, idToken.Subject, claims.Email, claims.EmailVerified, claims.GivenName, claims.FamilyName, claims.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.IsActive {
+		return nil, errors.New("account is deactivated")
+	}
+
+	return s.auth.StartLogin(user, client, models.LoginMethodOIDC)
+}
+
+// resolveUser finds the user for an identity: by an existing link, else by
+// verified email, else by creating a new user.
+func (s *OIDCService) resolveUser(provider, subject, email string, emailVerified bool, givenName, familyName, fullName string) (*models.User, error) {
+	now := time.Now()
+
+	if identity, err := s.identityRepo.GetByProviderSubject(provider, subject); err == nil {
+		if err := s.identityRepo.TouchLogin(identity.ID, now); err != nil {
+			logger.Log.Warn("Error updating identity %d: %v", identity.ID, err)
+		}
+		user, err := s.userRepo.GetUserByID(identity.UserID)
+		if err != nil {
+			logger.Log.Error("Identity %d points to missing user %d", identity.ID, identity.UserID)
+			return nil, ErrOIDCLoginFailed
+		}
+		return user, nil
+	}
+
+	email = strings.TrimSpace(email)
+	if email == "" || !emailVerified {
+		return nil, ErrOIDCEmailUnverified
+	}
+
+	identity := &models.UserIdentity{
+		Provider:    provider,
+		Subject:     subject,
+		Email:       email,
+		LastLoginAt: &now,
+	}
+
+	if user, err := s.userRepo.GetUserByEmail(email); err == nil {
+		if user.EmailVerifiedAt == nil {
+			return nil, ErrOIDCLinkUnverified
+		}
+
+		identity.UserID = user.ID
+		if err := s.identityRepo.Create(identity); err != nil {
+			logger.Log.Error("Error linking %s identity to user %d: %v", provider, user.ID, err)
+			return nil, fmt.Errorf("failed to link account")
+		}
+
+		logger.Log.Info("Linked %s identity to user %d", provider, user.ID)
+		return user, nil
+	}
+
+	return s.createUser(identity, givenName, familyName, fullName)
+}
+
+func (s *OIDCService) createUser(identity *models.UserIdentity, givenName, familyName, fullName string) (*models.User, error) {
+	firstName, lastName := givenName, familyName
+	if firstName == "" {
+		parts := strings.SplitN(strings.TrimSpace(fullName), " ", 2)
+		firstName = parts[0]
+		if len(parts) == 2 && lastName == "" {
+			lastName = parts[1]
+		}
+	}
+	if firstName == "" {
+		firstName = strings.SplitN(identity.Email, "@", 2)[0]
+	}
+
+	// The account has no usable password until the user sets one through
+	// the forgot-password flow.
+	secret, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user")
+	}
+	hashedPassword, err := utils.HashPassword(secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user")
+	}
+
+	now := time.Now()
+	user := &models.User{
+		Email:           identity.Email,
+		Password:        hashedPassword,
+		FirstName:       firstName,
+		LastName:        lastName,
+		IsActive:        true,
+		EmailVerifiedAt: &now,
+	}
+
+	welcome, err := NewOutboxMessage(models.OutboxKindWelcomeEmail, models.EmailRecipientPayload{
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+	})
+	if err != nil {
+		logger.Log.Error("Error building welcome email: %v", err)
+		return nil, fmt.Errorf("failed to create user")
+	}
+
+	err = s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		if err := s.userRepo.WithTx(tx).CreateUser(user); err != nil {
+			return err
+		}
+		identity.UserID = user.ID
+		if err := s.identityRepo.WithTx(tx).Create(identity); err != nil {
+			return err
+		}
+		return s.outboxRepo.WithTx(tx).Enqueue(welcome)
+	})
+	if err != nil {
+		logger.Log.Error("Error creating user from %s identity: %v", identity.Provider, err)
+		return nil, fmt.Errorf("failed to create user")
+	}
+
+	logger.Log.Info("Created user %d from %s identity", user.ID, identity.Provider)
+	return user, nil
+}
+
+func (s *OIDCService) oauthConfig(cfg *oidcProviderConfig, provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     cfg.clientID,
+		ClientSecret: cfg.clientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  s.baseURL + "/api/v1/auth/oidc/" + cfg.name + "/callback",
+		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+	}
+}
+
+// discover fetches the provider's discovery document once. Failures are not
+// cached so a provider outage at startup doesn't disable it for good.
+func (cfg *oidcProviderConfig) discover(ctx context.Context) (*oidc.Provider, error) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	if cfg.provider != nil {
+		return cfg.provider, nil
+	}
+
+	// The key set keeps using this client after ctx is done.
+	ctx = oidc.ClientContext(ctx, &http.Client{Timeout: oidcHTTPTimeout})
+	provider, err := oidc.NewProvider(ctx, cfg.issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.provider = provider
+	return provider, nil
+}
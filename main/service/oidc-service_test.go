@@ -0,0 +1,335 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	testOIDCClientID = "transit"
+	testOIDCCode     = "auth-code"
+)
+
+// memIdentities is an in-memory repo.IdentityRepository.
+type memIdentities struct {
+	mu         sync.Mutex
+	identities []models.UserIdentity
+}
+
+func (r *memIdentities) WithTx(*gorm.DB) repo.IdentityRepository { return r }
+
+func (r *memIdentities) Create(identity *models.UserIdentity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	identity.ID = uint(len(r.identities) + 1)
+	r.identities = append(r.identities, *identity)
+	return nil
+}
+
+func (r *memIdentities) GetByProviderSubject(provider, subject string) (*models.UserIdentity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			found := identity
+			return &found, nil
+		}
+	}
+	return nil, errors.New("identity not found")
+}
+
+func (r *memIdentities) TouchLogin(uint, time.Time) error { return nil }
+
+func (r *memIdentities) ListByUser(userID uint) ([]models.UserIdentity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found []models.UserIdentity
+	for _, identity := range r.identities {
+		if identity.UserID == userID {
+			found = append(found, identity)
+		}
+	}
+	return found, nil
+}
+
+// fakeOIDCProvider is an OpenID provider that issues ID tokens for one
+// authorization code. It checks the PKCE verifier against the challenge
+// from the authorization request, as a real provider would.
+type fakeOIDCProvider struct {
+	srv *httptest.Server
+	key *rsa.PrivateKey
+
+	mu        sync.Mutex
+	challenge string
+	nonce     string
+	claims    map[string]interface{}
+	exchanges int
+}
+
+func newFakeOIDCProvider(t *testing.T) *fakeOIDCProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &fakeOIDCProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/token", p.token)
+	p.srv = httptest.NewServer(mux)
+	t.Cleanup(p.srv.Close)
+	return p
+}
+
+func (p *fakeOIDCProvider) discovery(w http.ResponseWriter, _ *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                p.srv.URL,
+		"authorization_endpoint":                p.srv.URL + "/authorize",
+		"token_endpoint":                        p.srv.URL + "/token",
+		"jwks_uri":                              p.srv.URL + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (p *fakeOIDCProvider) jwks(w http.ResponseWriter, _ *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (p *fakeOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.exchanges++
+
+	verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if r.FormValue("code") != testOIDCCode || base64.RawURLEncoding.EncodeToString(verifier[:]) != p.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   p.srv.URL,
+		"aud":   testOIDCClientID,
+		"sub":   "subject-1",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": p.nonce,
+	}
+	for k, v := range p.claims {
+		claims[k] = v
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test"
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+type oidcTest struct {
+	service    *OIDCService
+	provider   *fakeOIDCProvider
+	users      *memUsers
+	identities *memIdentities
+}
+
+func newOIDCTest(t *testing.T, claims map[string]interface{}, users ...*models.User) *oidcTest {
+	t.Helper()
+
+	provider := newFakeOIDCProvider(t)
+	provider.claims = claims
+
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("OIDC_PROVIDERS", "test")
+	t.Setenv("OIDC_TEST_ISSUER", provider.srv.URL)
+	t.Setenv("OIDC_TEST_CLIENT_ID", testOIDCClientID)
+	t.Setenv("OIDC_TEST_CLIENT_SECRET", "client-secret")
+
+	userRepo := newMemUsers(users...)
+	identities := &memIdentities{}
+	auth := &AuthService{userRepo: userRepo, passkeys: passkeyCount{}}
+
+	return &oidcTest{
+		service:    NewOIDCService(userRepo, identities, &memOutboxRepo{}, noTx{}, auth),
+		provider:   provider,
+		users:      userRepo,
+		identities: identities,
+	}
+}
+
+// start begins a login and hands the PKCE challenge and nonce to the
+// provider. It returns the state from the authorization URL and the signed
+// state token the handler keeps in a cookie.
+func (o *oidcTest) start(t *testing.T) (string, string) {
+	t.Helper()
+
+	authURL, stateToken, err := o.service.AuthCodeURL("test")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("authorization URL has no S256 PKCE challenge: %s", authURL)
+	}
+
+	o.provider.mu.Lock()
+	o.provider.challenge = q.Get("code_challenge")
+	o.provider.nonce = q.Get("nonce")
+	o.provider.mu.Unlock()
+
+	return q.Get("state"), stateToken
+}
+
+func verifiedClaims(email string) map[string]interface{} {
+	return map[string]interface{}{"email": email, "email_verified": true, "given_name": "Ada"}
+}
+
+func TestOIDCLinksVerifiedAccount(t *testing.T) {
+	now := time.Now()
+	o := newOIDCTest(t, verifiedClaims("ada@example.com"), &models.User{
+		Email:           "ada@example.com",
+		IsActive:        true,
+		EmailVerifiedAt: &now,
+		TOTPEnabled:     true,
+	})
+
+	state, stateToken := o.start(t)
+	resp, err := o.service.Callback("test", stateToken, state, testOIDCCode, models.ClientInfo{})
+	if err != nil {
+		t.Fatalf("Callback: %v", err)
+	}
+	if !resp.MFARequired {
+		t.Errorf("login with TOTP enabled skipped the second factor")
+	}
+
+	identity, err := o.identities.GetByProviderSubject("test", "subject-1")
+	if err != nil || identity.UserID != 1 {
+		t.Fatalf("identity was not linked to the existing user: %+v %v", identity, err)
+	}
+}
+
+func TestOIDCRejectsStateMismatch(t *testing.T) {
+	o := newOIDCTest(t, verifiedClaims("ada@example.com"))
+
+	_, stateToken := o.start(t)
+	if _, err := o.service.Callback("test", stateToken, "forged-state", testOIDCCode, models.ClientInfo{}); !errors.Is(err, ErrOIDCLoginFailed) {
+		t.Fatalf("Callback error = %v, want ErrOIDCLoginFailed", err)
+	}
+	if o.provider.exchanges != 0 {
+		t.Errorf("code was exchanged despite the state mismatch")
+	}
+
+	// A state token for another provider doesn't match either.
+	other, err := utils.GenerateOIDCStateToken("other", "s", "n", "v")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.service.Callback("test", other, "s", testOIDCCode, models.ClientInfo{}); !errors.Is(err, ErrOIDCLoginFailed) {
+		t.Fatalf("Callback with another provider's state error = %v, want ErrOIDCLoginFailed", err)
+	}
+}
+
+func TestOIDCRejectsPKCEMismatch(t *testing.T) {
+	o := newOIDCTest(t, verifiedClaims("ada@example.com"))
+
+	state, _ := o.start(t)
+	// Same state and nonce, but not the verifier the challenge was made from.
+	forged, err := utils.GenerateOIDCStateToken("test", state, o.provider.nonce, "a-different-code-verifier-of-sufficient-length-0123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := o.service.Callback("test", forged, state, testOIDCCode, models.ClientInfo{}); !errors.Is(err, ErrOIDCLoginFailed) {
+		t.Fatalf("Callback error = %v, want ErrOIDCLoginFailed", err)
+	}
+	if o.users.count() != 0 {
+		t.Errorf("user was created without a valid code exchange")
+	}
+}
+
+func TestOIDCRejectsNonceMismatch(t *testing.T) {
+	claims := verifiedClaims("ada@example.com")
+	claims["nonce"] = "replayed-nonce"
+	o := newOIDCTest(t, claims)
+
+	state, stateToken := o.start(t)
+	if _, err := o.service.Callback("test", stateToken, state, testOIDCCode, models.ClientInfo{}); !errors.Is(err, ErrOIDCLoginFailed) {
+		t.Fatalf("Callback error = %v, want ErrOIDCLoginFailed", err)
+	}
+	if o.users.count() != 0 {
+		t.Errorf("user was created from an ID token with the wrong nonce")
+	}
+}
+
+func TestOIDCRejectsUnverifiedProviderEmail(t *testing.T) {
+	o := newOIDCTest(t, map[string]interface{}{"email": "ada@example.com", "email_verified": false})
+
+	state, stateToken := o.start(t)
+	if _, err := o.service.Callback("test", stateToken, state, testOIDCCode, models.ClientInfo{}); !errors.Is(err, ErrOIDCEmailUnverified) {
+		t.Fatalf("Callback error = %v, want ErrOIDCEmailUnverified", err)
+	}
+	if o.users.count() != 0 || len(o.identities.identities) != 0 {
+		t.Errorf("account was created or linked from an unverified email")
+	}
+}
+
+func TestOIDCRefusesToLinkUnverifiedLocalAccount(t *testing.T) {
+	o := newOIDCTest(t, verifiedClaims("ada@example.com"), &models.User{
+		Email:       "ada@example.com",
+		IsActive:    true,
+		TOTPEnabled: true,
+	})
+
+	state, stateToken := o.start(t)
+	if _, err := o.service.Callback("test", stateToken, state, testOIDCCode, models.ClientInfo{}); !errors.Is(err, ErrOIDCLinkUnverified) {
+		t.Fatalf("Callback error = %v, want ErrOIDCLinkUnverified", err)
+	}
+	if len(o.identities.identities) != 0 {
+		t.Errorf("identity was linked to an account with an unverified email")
+	}
+}
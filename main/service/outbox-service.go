@@ -0,0 +1,345 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/common/events"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+)
+
+const (
+	outboxBatchSize    = 50
+	outboxPollInterval = 2 * time.Second
+	outboxLease        = time.Minute
+	outboxBaseBackoff  = 5 * time.Second
+	outboxMaxBackoff   = time.Hour
+	publishTimeout     = 5 * time.Second
+	outboxSweepEvery   = time.Hour
+)
+
+// NewOutboxMessage encodes payload into a pending outbox message of the given
+// kind. The recipient, channel and ordering key are read from the payload's
+// email, phone_number, channel, topic and key fields.
+func NewOutboxMessage(kind string, payload interface{}) (*models.OutboxMessage, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s outbox payload: %w", kind, err)
+	}
+
+	var meta struct {
+		Email       string `json:"email"`
+		PhoneNumber string `json:"phone_number"`
+		Channel     string `json:"channel"`
+		Topic       string `json:"topic"`
+		Key         string `json:"key"`
+	}
+	// Payloads that aren't objects simply carry no metadata.
+	_ = json.Unmarshal(body, &meta)
+
+	msg := &models.OutboxMessage{
+		Kind:          kind,
+		Payload:       string(body),
+		Channel:       meta.Channel,
+		Status:        models.OutboxStatusPending,
+		NextAttemptAt: time.Now(),
+	}
+
+	switch {
+	case meta.Email != "" && (meta.Channel == "" || meta.Channel == models.NotifyChannelEmail):
+		msg.Channel = models.NotifyChannelEmail
+		msg.Recipient = strings.ToLower(meta.Email)
+	case meta.PhoneNumber != "" && (meta.Channel == "" || meta.Channel == models.NotifyChannelSMS):
+		msg.Channel = models.NotifyChannelSMS
+		msg.Recipient = meta.PhoneNumber
+	}
+
+	if kind == models.OutboxKindEvent && meta.Key != "" {
+		msg.OrderingKey = meta.Topic + "/" + meta.Key
+	}
+
+	return msg, nil
+}
+
+type outboxHandler func(payload []byte) error
+
+// OutboxRelay delivers outbox messages to their destination. Delivery is
+// at-least-once: a message is only marked delivered after its handler
+// succeeds, so a crash in between causes a redelivery.
+type OutboxRelay struct {
+	outboxRepo  repo.OutboxRepository
+	handlers    map[string]outboxHandler
+	maxAttempts int
+	retention   time.Duration
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func NewOutboxRelay(outboxRepo repo.OutboxRepository, mailService *MailService, notifier *Notifier, publisher events.Publisher, webhookService *WebhookService) *OutboxRelay {
+	maxAttempts, err := strconv.Atoi(utils.GetENV("OUTBOX_MAX_ATTEMPTS", "10"))
+	if err != nil || maxAttempts < 1 {
+		maxAttempts = 10
+	}
+
+	retention, err := time.ParseDuration(utils.GetENV("OUTBOX_RETENTION", "2160h"))
+	if err != nil || retention <= 0 {
+		retention = 2160 * time.Hour
+	}
+
+	r := &OutboxRelay{
+		outboxRepo:  outboxRepo,
+		handlers:    make(map[string]outboxHandler),
+		maxAttempts: maxAttempts,
+		retention:   retention,
+		stop:        make(chan struct{}),
+	}
+
+	r.handlers[models.OutboxKindWelcomeEmail] = func(payload []byte) error {
+		var p models.EmailRecipientPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return mailService.SendWelcomeEmail(p.Email, p.FirstName, p.LastName)
+	}
+
+	r.handlers[models.OutboxKindLoginNotification] = func(payload []byte) error {
+		var p models.LoginNotificationPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return mailService.SendLoginNotification(p.Email, p.FirstName, p.LastName, p.Device, p.IPAddress)
+	}
+
+	r.handlers[models.OutboxKindWaitlistConfirmation] = func(payload []byte) error {
+		var p models.EmailRecipientPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return mailService.SendWaitlistConfirmationEmail(p.Email)
+	}
+
+	r.handlers[models.OutboxKindWithdrawalAddress] = func(payload []byte) error {
+		var p models.WithdrawalAddressEmailPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return mailService.SendWithdrawalAddressAddedEmail(p.Email, p.FirstName, p.Address, p.Label, p.ActivatesAt, p.CancelURL)
+	}
+
+	r.handlers[models.OutboxKindEmailVerification] = func(payload []byte) error {
+		var p models.LinkEmailPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return mailService.SendVerificationEmail(p.Email, p.FirstName, p.URL)
+	}
+
+	r.handlers[models.OutboxKindPasswordReset] = func(payload []byte) error {
+		var p models.LinkEmailPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return mailService.SendPasswordResetEmail(p.Email, p.FirstName, p.URL)
+	}
+
+	r.handlers[models.OutboxKindPasswordChanged] = func(payload []byte) error {
+		var p models.EmailRecipientPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return mailService.SendPasswordChangedEmail(p.Email, p.FirstName)
+	}
+
+	r.handlers[models.OutboxKindAccountLocked] = func(payload []byte) error {
+		var p models.LinkEmailPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return mailService.SendAccountLockedEmail(p.Email, p.FirstName, p.URL)
+	}
+
+	r.handlers[models.OutboxKindMagicLink] = func(payload []byte) error {
+		var p models.LinkEmailPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return mailService.SendMagicLinkEmail(p.Email, p.FirstName, p.URL)
+	}
+
+	r.handlers[models.OutboxKindEmailChange] = func(payload []byte) error {
+		var p models.EmailChangePayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return mailService.SendEmailChangeConfirmation(p.Email, p.FirstName, p.NewEmail, p.URL)
+	}
+
+	r.handlers[models.OutboxKindAccountDeleted] = func(payload []byte) error {
+		var p models.AccountDeletedPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return mailService.SendAccountDeletedEmail(p.Email, p.FirstName, p.PurgeAt)
+	}
+
+	r.handlers[models.OutboxKindDataExportReady] = func(payload []byte) error {
+		var p models.DataExportReadyPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return mailService.SendDataExportReadyEmail(p.Email, p.FirstName, p.URL, p.ExpiresAt)
+	}
+
+	r.handlers[models.OutboxKindWithdrawalAlert] = func(payload []byte) error {
+		var p models.WithdrawalAlertPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return notifier.SendWithdrawalAlert(p)
+	}
+
+	r.handlers[models.OutboxKindEvent] = func(payload []byte) error {
+		var p models.EventPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+		defer cancel()
+
+		return publisher.Publish(ctx, events.Message{
+			Topic:   p.Topic,
+			Key:     p.Key,
+			Value:   []byte(p.Value),
+			Headers: p.Headers,
+		})
+	}
+
+	r.handlers[models.OutboxKindWebhook] = func(payload []byte) error {
+		var p struct {
+			EventID    string          `json:"event_id"`
+			OccurredAt time.Time       `json:"occurred_at"`
+			UserID     uint            `json:"user_id"`
+			EventType  string          `json:"event_type"`
+			Data       json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return webhookService.Publish(p.EventID, p.OccurredAt, p.UserID, p.EventType, p.Data)
+	}
+
+	return r
+}
+
+// Start polls for due messages until Stop is called.
+func (r *OutboxRelay) Start() {
+	go func() {
+		ticker := time.NewTicker(outboxPollInterval)
+		defer ticker.Stop()
+		sweep := time.NewTicker(outboxSweepEvery)
+		defer sweep.Stop()
+
+		logger.Log.Info("Outbox relay started")
+		r.Sweep()
+		for {
+			select {
+			case <-r.stop:
+				logger.Log.Info("Outbox relay stopped")
+				return
+			case <-ticker.C:
+				r.ProcessDue()
+			case <-sweep.C:
+				r.Sweep()
+			}
+		}
+	}()
+}
+
+func (r *OutboxRelay) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
+}
+
+func (r *OutboxRelay) ProcessDue() {
+	msgs, err := r.outboxRepo.ClaimDue(time.Now(), outboxBatchSize, outboxLease)
+	if err != nil {
+		logger.Log.Error("Error claiming outbox messages: %v", err)
+		return
+	}
+
+	for i := range msgs {
+		r.deliver(&msgs[i])
+	}
+}
+
+func (r *OutboxRelay) deliver(msg *models.OutboxMessage) {
+	msg.Attempts++
+
+	handler, ok := r.handlers[msg.Kind]
+	if !ok {
+		msg.Status = models.OutboxStatusFailed
+		msg.LastError = "no handler registered for kind " + msg.Kind
+		msg.Payload = models.OutboxRedactedPayload
+		r.save(msg)
+		return
+	}
+
+	if err := handler([]byte(msg.Payload)); err != nil {
+		msg.LastError = err.Error()
+		if msg.Attempts >= r.maxAttempts {
+			msg.Status = models.OutboxStatusFailed
+			msg.Payload = models.OutboxRedactedPayload
+			logger.Log.Error("Outbox message %d (%s) failed after %d attempts: %v", msg.ID, msg.Kind, msg.Attempts, err)
+		} else {
+			msg.NextAttemptAt = time.Now().Add(outboxBackoff(msg.Attempts))
+			logger.Log.Warn("Outbox message %d (%s) attempt %d failed: %v", msg.ID, msg.Kind, msg.Attempts, err)
+		}
+		r.save(msg)
+		return
+	}
+
+	now := time.Now()
+	msg.Status = models.OutboxStatusDelivered
+	msg.DeliveredAt = &now
+	msg.LastError = ""
+	msg.Payload = models.OutboxRedactedPayload
+	r.save(msg)
+}
+
+// Sweep deletes finished messages older than OUTBOX_RETENTION.
+func (r *OutboxRelay) Sweep() {
+	deleted, err := r.outboxRepo.DeleteFinishedBefore(time.Now().Add(-r.retention))
+	if err != nil {
+		logger.Log.Error("Error deleting old outbox messages: %v", err)
+		return
+	}
+	if deleted > 0 {
+		logger.Log.Info("Deleted %d outbox messages older than %v", deleted, r.retention)
+	}
+}
+
+func (r *OutboxRelay) save(msg *models.OutboxMessage) {
+	if err := r.outboxRepo.Update(msg); err != nil {
+		logger.Log.Error("Error updating outbox message %d: %v", msg.ID, err)
+	}
+}
+
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxBaseBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= outboxMaxBackoff {
+			return outboxMaxBackoff
+		}
+	}
+	return backoff
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"gorm.io/gorm"
+)
+
+// memOutboxRepo records saved messages.
+type memOutboxRepo struct {
+	saved []models.OutboxMessage
+}
+
+func (r *memOutboxRepo) WithTx(*gorm.DB) repo.OutboxRepository { return r }
+func (r *memOutboxRepo) Enqueue(msg *models.OutboxMessage) error {
+	r.saved = append(r.saved, *msg)
+	return nil
+}
+func (r *memOutboxRepo) Update(msg *models.OutboxMessage) error {
+	r.saved = append(r.saved, *msg)
+	return nil
+}
+func (r *memOutboxRepo) DeleteFinishedBefore(time.Time) (int64, error) { return 0, nil }
+func (r *memOutboxRepo) CancelPending(kind, recipient, reason string) error {
+	for i := range r.saved {
+		msg := &r.saved[i]
+		if msg.Status == models.OutboxStatusPending && msg.Kind == kind && msg.Recipient == strings.ToLower(recipient) {
+			msg.Status = models.OutboxStatusFailed
+			msg.Payload = models.OutboxRedactedPayload
+			msg.LastError = reason
+		}
+	}
+	return nil
+}
+func (r *memOutboxRepo) ListEmailsTo(string) ([]models.EmailHistoryEntry, error) {
+	return nil, nil
+}
+func (r *memOutboxRepo) ClaimDue(time.Time, int, time.Duration) ([]models.OutboxMessage, error) {
+	return nil, nil
+}
+
+func TestNewOutboxMessageMetadata(t *testing.T) {
+	tests := []struct {
+		name                        string
+		kind                        string
+		payload                     interface{}
+		recipient, channel, orderBy string
+	}{
+		{"email", models.OutboxKindPasswordReset, models.EmailRecipientPayload{Email: "Ada@Example.com"}, "ada@example.com", models.NotifyChannelEmail, ""},
+		{"email alert", models.OutboxKindWithdrawalAlert, models.WithdrawalAlertPayload{Channel: models.NotifyChannelEmail, Email: "ada@example.com"}, "ada@example.com", models.NotifyChannelEmail, ""},
+		{"sms alert", models.OutboxKindWithdrawalAlert, models.WithdrawalAlertPayload{Channel: models.NotifyChannelSMS, PhoneNumber: "+15551234567"}, "+15551234567", models.NotifyChannelSMS, ""},
+		{"event", models.OutboxKindEvent, models.EventPayload{Topic: "transactions", Key: "7"}, "", "", "transactions/7"},
+	}
+
+	for _, tt := range tests {
+		msg, err := NewOutboxMessage(tt.kind, tt.payload)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if msg.Recipient != tt.recipient || msg.Channel != tt.channel || msg.OrderingKey != tt.orderBy {
+			t.Errorf("%s: recipient %q channel %q ordering key %q, want %q %q %q",
+				tt.name, msg.Recipient, msg.Channel, msg.OrderingKey, tt.recipient, tt.channel, tt.orderBy)
+		}
+	}
+}
+
+func TestOutboxRelayRedactsFinishedPayloads(t *testing.T) {
+	outboxRepo := &memOutboxRepo{}
+	relay := &OutboxRelay{
+		outboxRepo:  outboxRepo,
+		maxAttempts: 2,
+		handlers: map[string]outboxHandler{
+			"ok":   func([]byte) error { return nil },
+			"fail": func([]byte) error { return errors.New("boom") },
+		},
+	}
+
+	secret := `{"email":"ada@example.com","reset_url":"https://app/reset?token=secret"}`
+
+	relay.deliver(&models.OutboxMessage{ID: 1, Kind: "ok", Payload: secret})
+	if got := outboxRepo.saved[0]; got.Status != models.OutboxStatusDelivered || got.Payload != models.OutboxRedactedPayload {
+		t.Errorf("delivered message kept status %q payload %q", got.Status, got.Payload)
+	}
+
+	failing := &models.OutboxMessage{ID: 2, Kind: "fail", Payload: secret, Status: models.OutboxStatusPending}
+	relay.deliver(failing)
+	if got := outboxRepo.saved[1]; got.Status != models.OutboxStatusPending || got.Payload != secret {
+		t.Errorf("retried message has status %q payload %q, want it kept for the retry", got.Status, got.Payload)
+	}
+	relay.deliver(failing)
+	if got := outboxRepo.saved[2]; got.Status != models.OutboxStatusFailed || got.Payload != models.OutboxRedactedPayload {
+		t.Errorf("failed message kept status %q payload %q", got.Status, got.Payload)
+	}
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+	"github.com/nbutton23/zxcvbn-go"
+)
+
+// PasswordPolicyError explains why a new password was rejected.
+type PasswordPolicyError struct {
+	Reason string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return e.Reason
+}
+
+// PasswordPolicy decides whether a new password is acceptable: long enough,
+// hard enough to guess (zxcvbn score 0-4), and not in the breached-password
+// corpus.
+type PasswordPolicy struct {
+	minLength int
+	minScore  int
+	breached  *BreachedPasswordChecker
+}
+
+func NewPasswordPolicy() *PasswordPolicy {
+	minLength, err := strconv.Atoi(utils.GetENV("PASSWORD_MIN_LENGTH", "10"))
+	if err != nil || minLength < 8 || minLength > utils.MaxPasswordBytes() {
+		logger.Log.Warn("Invalid PASSWORD_MIN_LENGTH, using 10")
+		minLength = 10
+	}
+
+	minScore, err := strconv.Atoi(utils.GetENV("PASSWORD_MIN_SCORE", "3"))
+	if err != nil || minScore < 0 || minScore > 4 {
+		logger.Log.Warn("Invalid PASSWORD_MIN_SCORE, using 3")
+		minScore = 3
+	}
+
+	return &PasswordPolicy{
+		minLength: minLength,
+		minScore:  minScore,
+		breached:  NewBreachedPasswordChecker(utils.GetENV("PASSWORD_BREACH_CORPUS_DIR", "")),
+	}
+}
+
+// Validate returns a *PasswordPolicyError if password is not acceptable.
+// userInputs such as the email and name count against the strength score.
+func (p *PasswordPolicy) Validate(password string, userInputs ...string) error {
+	if len([]rune(password)) < p.minLength {
+		return &PasswordPolicyError{Reason: fmt.Sprintf("Password must be at least %d characters long", p.minLength)}
+	}
+	if limit := utils.MaxPasswordBytes(); len(password) > limit {
+		return &PasswordPolicyError{Reason: fmt.Sprintf("Password must be at most %d bytes long", limit)}
+	}
+
+	var inputs []string
+	for _, input := range userInputs {
+		if input == "" {
+			continue
+		}
+		inputs = append(inputs, strings.ToLower(input))
+		// The local part of an email is what people tend to reuse.
+		if at := strings.Index(input, "@"); at > 0 {
+			inputs = append(inputs, strings.ToLower(input[:at]))
+		}
+	}
+
+	if zxcvbn.PasswordStrength(password, inputs).Score < p.minScore {
+		return &PasswordPolicyError{Reason: "Password is too easy to guess. Try a longer phrase of unrelated words."}
+	}
+
+	if p.breached.IsBreached(password) {
+		return &PasswordPolicyError{Reason: "This password has appeared in a data breach. Please choose a different one."}
+	}
+
+	return nil
+}
+
+// BreachedPasswordChecker looks passwords up in an offline copy of the Pwned
+// Passwords corpus laid out like its k-anonymity range API: one file per
+// 5-character SHA-1 prefix (ABCDE or ABCDE.txt), each holding SUFFIX:COUNT
+// lines. The password's full hash never leaves the process and only one
+// small file is read per lookup.
+type BreachedPasswordChecker struct {
+	dir string
+}
+
+// NewBreachedPasswordChecker returns a checker for dir. With an empty dir
+// every lookup reports false.
+func NewBreachedPasswordChecker(dir string) *BreachedPasswordChecker {
+	if dir == "" {
+		logger.Log.Warn("PASSWORD_BREACH_CORPUS_DIR is not set, breached-password checks are disabled")
+	} else if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		logger.Log.Warn("PASSWORD_BREACH_CORPUS_DIR %s is not a directory, breached-password checks are disabled", dir)
+		dir = ""
+	}
+
+	return &BreachedPasswordChecker{dir: dir}
+}
+
+// IsBreached reports whether password is in the corpus. Lookup errors are
+// logged and treated as not breached so a damaged corpus doesn't block
+// sign-ups.
+func (c *BreachedPasswordChecker) IsBreached(password string) bool {
+	if c.dir == "" {
+		return false
+	}
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	found, err := c.lookup(prefix, suffix)
+	if err != nil {
+		logger.Log.Error("Error reading breached-password range %s: %v", prefix, err)
+		return false
+	}
+	return found
+}
+
+func (c *BreachedPasswordChecker) lookup(prefix, suffix string) (bool, error) {
+	var (
+		file *os.File
+		err  error
+	)
+	for _, name := range []string{prefix + ".txt", prefix} {
+		file, err = os.Open(filepath.Join(c.dir, name))
+		if err == nil || !errors.Is(err, os.ErrNotExist) {
+			break
+		}
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		candidate, count, _ := strings.Cut(line, ":")
+		if !strings.EqualFold(candidate, suffix) {
+			continue
+		}
+		// Padding entries added by the range API have a count of 0.
+		return count != "0", nil
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidResetToken = errors.New("invalid or expired reset link")
+
+// PasswordService handles forgot/reset and change-password. Every successful
+// change signs the user out everywhere and sends a security notification.
+type PasswordService struct {
+	userRepo   repo.UserRepository
+	resetRepo  repo.PasswordResetRepository
+	outboxRepo repo.OutboxRepository
+	transactor repo.Transactor
+	tokens     *TokenRevocationService
+	policy     *PasswordPolicy
+	throttle   *LoginThrottleService
+	resetTTL   time.Duration
+	resetURL   string
+}
+
+func NewPasswordService(userRepo repo.UserRepository, resetRepo repo.PasswordResetRepository, outboxRepo repo.OutboxRepository, transactor repo.Transactor, tokens *TokenRevocationService, policy *PasswordPolicy, throttle *LoginThrottleService) *PasswordService {
+	resetTTL, err := time.ParseDuration(utils.GetENV("PASSWORD_RESET_TTL", "1h"))
+	if err != nil || resetTTL <= 0 {
+		logger.Log.Warn("Invalid PASSWORD_RESET_TTL, using 1h")
+		resetTTL = time.Hour
+	}
+
+	baseURL := strings.TrimRight(utils.GetENV("APP_BASE_URL", "http://localhost:3030"), "/")
+
+	return &PasswordService{
+		userRepo:   userRepo,
+		resetRepo:  resetRepo,
+		outboxRepo: outboxRepo,
+		transactor: transactor,
+		tokens:     tokens,
+		policy:     policy,
+		throttle:   throttle,
+		resetTTL:   resetTTL,
+		resetURL:   utils.GetENV("PASSWORD_RESET_URL", baseURL+"/reset-password"),
+	}
+}
+
+// ForgotPassword emails a reset link if the address belongs to an active
+// account. It reports success either way so it can't be used to probe
+// which emails are registered.
+func (s *PasswordService) ForgotPassword(email string) error {
+	user, err := s.userRepo.GetUserByEmail(strings.TrimSpace(email))
+	if err != nil || !user.IsActive {
+		return nil
+	}
+
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		logger.Log.Error("Error generating password reset token: %v", err)
+		return fmt.Errorf("failed to start password reset")
+	}
+
+	msg, err := NewOutboxMessage(models.OutboxKindPasswordReset, models.LinkEmailPayload{
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		URL:       s.resetURL + "?token=" + url.QueryEscape(token),
+	})
+	if err != nil {
+		logger.Log.Error("Error building password reset email: %v", err)
+		return fmt.Errorf("failed to start password reset")
+	}
+
+	err = s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		resets := s.resetRepo.WithTx(tx)
+		// Only the most recent link works.
+		if err := s.invalidateResetsTx(tx, user); err != nil {
+			return err
+		}
+		if err := resets.Create(&models.PasswordResetToken{
+			UserID:    user.ID,
+			TokenHash: utils.HashToken(token),
+			ExpiresAt: time.Now().Add(s.resetTTL),
+		}); err != nil {
+			return err
+		}
+		return s.outboxRepo.WithTx(tx).Enqueue(msg)
+	})
+	if err != nil {
+		logger.Log.Error("Error creating password reset for user %d: %v", user.ID, err)
+		return fmt.Errorf("failed to start password reset")
+	}
+
+	return nil
+}
+
+// ResetPassword consumes a reset token and sets the new password.
+func (s *PasswordService) ResetPassword(token, newPassword string) error {
+	if token == "" {
+		return ErrInvalidResetToken
+	}
+
+	var userID uint
+	err := s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		reset, err := s.resetRepo.WithTx(tx).GetByHashForUpdate(utils.HashToken(token))
+		if err != nil || reset.UsedAt != nil || time.Now().After(reset.ExpiresAt) {
+			return ErrInvalidResetToken
+		}
+
+		user, err := s.userRepo.WithTx(tx).GetUserByID(reset.UserID)
+		if err != nil || !user.IsActive {
+			return ErrInvalidResetToken
+		}
+		userID = user.ID
+
+		// A rejected password leaves the link usable for another try.
+		if err := s.policy.Validate(newPassword, user.Email, user.FirstName, user.LastName); err != nil {
+			return err
+		}
+
+		return s.setPasswordTx(tx, user, newPassword)
+	})
+	var policyErr *PasswordPolicyError
+	if errors.Is(err, ErrInvalidResetToken) || errors.As(err, &policyErr) {
+		return err
+	}
+	if err != nil {
+		logger.Log.Error("Error resetting password: %v", err)
+		return fmt.Errorf("failed to reset password")
+	}
+
+	logger.Log.Info("Password reset for user %d", userID)
+	return s.tokens.ClearCachedVersion(userID)
+}
+
+// ChangePassword requires the current password, then replaces it. Wrong
+// current passwords count toward the same lockout as failed logins.
+func (s *PasswordService) ChangePassword(userID uint, currentPassword, newPassword string, client models.ClientInfo) error {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.throttle.Check(user.Email, client.IPAddress); err != nil {
+		return err
+	}
+	if !utils.CheckPasswordHash(currentPassword, user.Password) {
+		s.throttle.RecordFailure(user.Email, client.IPAddress, user)
+		return ErrInvalidCredentials
+	}
+	s.throttle.RecordSuccess(user.Email)
+
+	if err := s.policy.Validate(newPassword, user.Email, user.FirstName, user.LastName); err != nil {
+		return err
+	}
+
+	err = s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		return s.setPasswordTx(tx, user, newPassword)
+	})
+	if err != nil {
+		logger.Log.Error("Error changing password for user %d: %v", userID, err)
+		return fmt.Errorf("failed to change password")
+	}
+
+	return s.tokens.ClearCachedVersion(userID)
+}
+
+// setPasswordTx stores the new hash, revokes every session, token, API key
+// and reset link, and queues the security notification.
+func (s *PasswordService) setPasswordTx(tx *gorm.DB, user *models.User, newPassword string) error {
+	hash, err := utils.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	notification, err := NewOutboxMessage(models.OutboxKindPasswordChanged, models.EmailRecipientPayload{
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.userRepo.WithTx(tx).UpdatePassword(user.ID, hash); err != nil {
+		return err
+	}
+	if err := s.tokens.RevokeAllTx(tx, user.ID); err != nil {
+		return err
+	}
+	if err := s.invalidateResetsTx(tx, user); err != nil {
+		return err
+	}
+	return s.outboxRepo.WithTx(tx).Enqueue(notification)
+}
+
+// invalidateResetsTx expires the user's reset links and drops reset emails
+// still waiting in the outbox, so their URLs aren't kept around.
+func (s *PasswordService) invalidateResetsTx(tx *gorm.DB, user *models.User) error {
+	if err := s.resetRepo.WithTx(tx).InvalidateForUser(user.ID, time.Now()); err != nil {
+		return err
+	}
+	return s.outboxRepo.WithTx(tx).CancelPending(models.OutboxKindPasswordReset, user.Email, "reset link superseded")
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"gorm.io/gorm"
+)
+
+type memResets struct {
+	repo.PasswordResetRepository
+	tokens []models.PasswordResetToken
+}
+
+func (r *memResets) WithTx(*gorm.DB) repo.PasswordResetRepository { return r }
+
+func (r *memResets) Create(token *models.PasswordResetToken) error {
+	r.tokens = append(r.tokens, *token)
+	return nil
+}
+
+func (r *memResets) InvalidateForUser(userID uint, at time.Time) error {
+	for i := range r.tokens {
+		if r.tokens[i].UserID == userID && r.tokens[i].UsedAt == nil {
+			r.tokens[i].UsedAt = &at
+		}
+	}
+	return nil
+}
+
+type memAPIKeys struct {
+	repo.APIKeyRepository
+	keys []models.APIKey
+}
+
+func (r *memAPIKeys) WithTx(*gorm.DB) repo.APIKeyRepository { return r }
+
+func (r *memAPIKeys) RevokeAllForUser(userID uint, at time.Time) error {
+	for i := range r.keys {
+		if r.keys[i].UserID == userID && r.keys[i].RevokedAt == nil {
+			r.keys[i].RevokedAt = &at
+		}
+	}
+	return nil
+}
+
+func TestChangePasswordRevokesKeysAndResetLinks(t *testing.T) {
+	hash, err := utils.HashPassword("old password for ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := newMemUsers(&models.User{ID: 1, Email: "Ada@Example.com", Password: hash, IsActive: true})
+	stores := &memAuthRepos{sessions: []models.Session{{ID: 1, UserID: 1}}}
+	keys := &memAPIKeys{keys: []models.APIKey{{ID: 1, UserID: 1}, {ID: 2, UserID: 2}}}
+	resets := &memResets{}
+	outbox := &memOutboxRepo{}
+	tokens := NewTokenRevocationService(users, memRefreshTokens{r: stores}, memSessions{r: stores}, keys, noTx{}, nil)
+	s := NewPasswordService(users, resets, outbox, noTx{}, tokens, NewPasswordPolicy(), NewLoginThrottleService(nil, outbox))
+
+	if err := s.ForgotPassword("ada@example.com"); err != nil {
+		t.Fatalf("ForgotPassword: %v", err)
+	}
+	if err := s.ChangePassword(1, "old password for ada", "quartz lantern meadow tugboat", models.ClientInfo{IPAddress: "203.0.113.7"}); err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+
+	if keys.keys[0].RevokedAt == nil {
+		t.Error("the user's API key is still active")
+	}
+	if keys.keys[1].RevokedAt != nil {
+		t.Error("another user's API key was revoked")
+	}
+	if stores.sessions[0].RevokedAt == nil {
+		t.Error("the user's session is still active")
+	}
+	if resets.tokens[0].UsedAt == nil {
+		t.Error("the reset link still works")
+	}
+
+	reset := outbox.saved[0]
+	if reset.Kind != models.OutboxKindPasswordReset || reset.Status != models.OutboxStatusFailed || reset.Payload != models.OutboxRedactedPayload {
+		t.Errorf("pending reset email = %s/%s with payload %q, want it cancelled and redacted", reset.Kind, reset.Status, reset.Payload)
+	}
+}
@@ -0,0 +1,437 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/common/twilio"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+	"gorm.io/gorm"
+)
+
+const (
+	smsCodeDigits      = 6
+	smsCodeTTL         = 5 * time.Minute
+	smsCodeMaxAttempts = 5
+	smsResendCooldown  = 60 * time.Second
+	smsHourlyLimit     = 5
+	// Twilio rejects numbers that are malformed or can't receive SMS with
+	// these error codes.
+	twilioErrInvalidTo     = 21211
+	twilioErrUnreachableTo = 21614
+)
+
+var (
+	ErrInvalidPhoneNumber   = errors.New("phone number must be in international format, e.g. +14155550123")
+	ErrPhoneNotVerified     = errors.New("verify a phone number first")
+	ErrPhoneAlreadyVerified = errors.New("this phone number is already verified")
+	ErrInvalidSMSCode       = errors.New("invalid or expired code")
+	ErrSMSRateLimited       = errors.New("too many codes requested, please try again later")
+	ErrSMSMFANotEnabled     = errors.New("SMS two-factor authentication is not enabled")
+	ErrSMSMFAAlreadyEnabled = errors.New("SMS two-factor authentication is already enabled")
+	ErrNoPhoneNumber        = errors.New("no phone number on this account")
+)
+
+// PhoneService verifies users' phone numbers and handles SMS codes as a
+// login second factor. Codes are sent right away rather than through the
+// outbox so they are never stored in plain text.
+type PhoneService struct {
+	userRepo   repo.UserRepository
+	smsRepo    repo.SMSCodeRepository
+	transactor repo.Transactor
+	notifier   *Notifier
+	auth       *AuthService
+}
+
+func NewPhoneService(userRepo repo.UserRepository, smsRepo repo.SMSCodeRepository, transactor repo.Transactor, notifier *Notifier, auth *AuthService) *PhoneService {
+	return &PhoneService{
+		userRepo:   userRepo,
+		smsRepo:    smsRepo,
+		transactor: transactor,
+		notifier:   notifier,
+		auth:       auth,
+	}
+}
+
+// StartVerification texts a code to phone. The number is only saved on the
+// account once the code is confirmed, so a typo can't replace a working one.
+func (s *PhoneService) StartVerification(userID uint, phone string) (*models.SMSCodeSentResponse, error) {
+	phone, err := NormalizePhoneNumber(phone)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	if user.PhoneVerified() && user.PhoneNumber == phone {
+		return nil, ErrPhoneAlreadyVerified
+	}
+
+	return s.sendCode(user, models.SMSCodePurposeVerification, phone,
+		"Your Transit verification code is %s. It expires in 5 minutes.")
+}
+
+// ConfirmVerification checks the code and saves the number it was sent to.
+// It returns the verified number.
+func (s *PhoneService) ConfirmVerification(userID uint, code string) (string, error) {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return "", ErrUserNotFound
+	}
+
+	var phone string
+	err = s.checkCode(user, models.SMSCodePurposeVerification, code, func(tx *gorm.DB, sms *models.SMSCode) error {
+		phone = sms.PhoneNumber
+		return s.userRepo.WithTx(tx).SetVerifiedPhone(userID, phone, time.Now())
+	})
+	if err != nil {
+		return "", err
+	}
+
+	logger.Log.Info("User %d verified phone %s", userID, MaskPhoneNumber(phone))
+	return phone, nil
+}
+
+// RemovePhone deletes the phone number, turning off SMS second factors and
+// alerts with it. It reports whether SMS MFA was on.
+func (s *PhoneService) RemovePhone(userID uint) (bool, error) {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return false, ErrUserNotFound
+	}
+	if user.PhoneNumber == "" {
+		return false, ErrNoPhoneNumber
+	}
+
+	err = s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		if err := s.userRepo.WithTx(tx).ClearPhone(userID); err != nil {
+			return err
+		}
+		codes := s.smsRepo.WithTx(tx)
+		if err := codes.InvalidateForUser(userID, models.SMSCodePurposeLogin, time.Now()); err != nil {
+			return err
+		}
+		return codes.InvalidateForUser(userID, models.SMSCodePurposeStepUp, time.Now())
+	})
+	if err != nil {
+		logger.Log.Error("Error removing phone for user %d: %v", userID, err)
+		return false, fmt.Errorf("failed to remove phone number")
+	}
+
+	return user.SMSMFAEnabled, nil
+}
+
+// EnableSMSMFA makes a code texted to the verified phone an accepted
+// second factor at login.
+func (s *PhoneService) EnableSMSMFA(userID uint) error {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return ErrUserNotFound
+	}
+	if user.SMSMFAEnabled {
+		return ErrSMSMFAAlreadyEnabled
+	}
+	if !user.PhoneVerified() {
+		return ErrPhoneNotVerified
+	}
+
+	return s.setSMSMFA(userID, true)
+}
+
+func (s *PhoneService) DisableSMSMFA(userID uint) error {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return ErrUserNotFound
+	}
+	if !user.SMSMFAEnabled {
+		return ErrSMSMFANotEnabled
+	}
+
+	return s.setSMSMFA(userID, false)
+}
+
+func (s *PhoneService) setSMSMFA(userID uint, enabled bool) error {
+	if err := s.userRepo.SetSMSMFAEnabled(userID, enabled); err != nil {
+		logger.Log.Error("Error updating SMS MFA for user %d: %v", userID, err)
+		return fmt.Errorf("failed to update SMS two-factor authentication")
+	}
+	return nil
+}
+
+// SendLoginCode texts a login code for a pending MFA challenge.
+func (s *PhoneService) SendLoginCode(mfaToken string) (*models.SMSCodeSentResponse, error) {
+	user, err := s.smsChallengeUser(mfaToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.sendCode(user, models.SMSCodePurposeLogin, user.PhoneNumber,
+		"Your Transit login code is %s. Don't share it with anyone, including Transit staff.")
+}
+
+// VerifyLoginCode finishes a login paused for a second factor with a code
+// from SendLoginCode.
+func (s *PhoneService) VerifyLoginCode(mfaToken, code string, client models.ClientInfo) (*models.AuthResponse, error) {
+	user, err := s.smsChallengeUser(mfaToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkCode(user, models.SMSCodePurposeLogin, code, nil); err != nil {
+		if errors.Is(err, ErrInvalidSMSCode) {
+			s.auth.recordLoginFailure(user, client, models.AuditActionMFAFailed, models.LoginMethodSMS)
+		}
+		return nil, err
+	}
+
+	return s.auth.completeLogin(user, client, models.LoginMethodSMS)
+}
+
+// SendStepUpCode texts a code the user can step up with, for users whose
+// second factor is SMS.
+func (s *PhoneService) SendStepUpCode(claims *utils.Claims) (*models.SMSCodeSentResponse, error) {
+	user, err := s.smsStepUpUser(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.sendCode(user, models.SMSCodePurposeStepUp, user.PhoneNumber,
+		"Your Transit confirmation code is %s. Don't share it with anyone, including Transit staff.")
+}
+
+// VerifyStepUpCode steps up the caller's session with a code from
+// SendStepUpCode.
+func (s *PhoneService) VerifyStepUpCode(claims *utils.Claims, code string) (*models.StepUpResponse, error) {
+	user, err := s.smsStepUpUser(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkCode(user, models.SMSCodePurposeStepUp, code, nil); err != nil {
+		return nil, err
+	}
+
+	return s.auth.issueStepUp(user, claims.SessionID)
+}
+
+func (s *PhoneService) smsStepUpUser(claims *utils.Claims) (*models.User, error) {
+	user, err := s.auth.stepUpUser(claims)
+	if err != nil {
+		return nil, err
+	}
+	if !user.SMSMFAEnabled || !user.PhoneVerified() {
+		return nil, ErrSMSMFANotEnabled
+	}
+	return user, nil
+}
+
+func (s *PhoneService) smsChallengeUser(mfaToken string) (*models.User, error) {
+	user, err := s.auth.mfaChallengeUser(mfaToken)
+	if err != nil {
+		return nil, err
+	}
+	if !user.SMSMFAEnabled || !user.PhoneVerified() {
+		return nil, ErrSMSMFANotEnabled
+	}
+	return user, nil
+}
+
+// sendCode replaces any earlier code for purpose with a new one and texts
+// it to phone. format gets the code as its only argument.
+func (s *PhoneService) sendCode(user *models.User, purpose, phone, format string) (*models.SMSCodeSentResponse, error) {
+	if !s.notifier.Available(models.NotifyChannelSMS) {
+		return nil, ErrSMSUnavailable
+	}
+	if err := s.checkSendLimits(user.ID); err != nil {
+		return nil, err
+	}
+
+	code, err := generateSMSCode()
+	if err != nil {
+		logger.Log.Error("Error generating SMS code: %v", err)
+		return nil, fmt.Errorf("failed to send code")
+	}
+
+	now := time.Now()
+	sms := &models.SMSCode{
+		UserID:      user.ID,
+		Purpose:     purpose,
+		PhoneNumber: phone,
+		CodeHash:    smsCodeHash(phone, code),
+		ExpiresAt:   now.Add(smsCodeTTL),
+	}
+
+	err = s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		codes := s.smsRepo.WithTx(tx)
+		if err := codes.InvalidateForUser(user.ID, purpose, now); err != nil {
+			return err
+		}
+		return codes.Create(sms)
+	})
+	if err != nil {
+		logger.Log.Error("Error storing SMS code for user %d: %v", user.ID, err)
+		return nil, fmt.Errorf("failed to send code")
+	}
+
+	err = s.notifier.Send(context.Background(), models.NotifyChannelSMS, &Notification{
+		Kind:        purpose,
+		PhoneNumber: phone,
+		Text:        fmt.Sprintf(format, code),
+	})
+	if err != nil {
+		if markErr := s.smsRepo.MarkUsed(sms.ID, time.Now()); markErr != nil {
+			logger.Log.Warn("Error invalidating unsent SMS code %d: %v", sms.ID, markErr)
+		}
+
+		var apiErr *twilio.APIError
+		if errors.As(err, &apiErr) && (apiErr.Code == twilioErrInvalidTo || apiErr.Code == twilioErrUnreachableTo) {
+			return nil, ErrInvalidPhoneNumber
+		}
+		return nil, fmt.Errorf("failed to send code")
+	}
+
+	return &models.SMSCodeSentResponse{
+		PhoneNumber: MaskPhoneNumber(phone),
+		ExpiresAt:   sms.ExpiresAt,
+		Message:     "Code sent",
+	}, nil
+}
+
+// checkSendLimits keeps SMS costs and spam in check: one code per cooldown
+// and a few per hour, across purposes.
+func (s *PhoneService) checkSendLimits(userID uint) error {
+	now := time.Now()
+
+	last, err := s.smsRepo.LatestCreatedAt(userID)
+	if err != nil {
+		logger.Log.Error("Error reading SMS codes for user %d: %v", userID, err)
+		return fmt.Errorf("failed to send code")
+	}
+	if last != nil && now.Sub(*last) < smsResendCooldown {
+		return ErrSMSRateLimited
+	}
+
+	count, err := s.smsRepo.CountSince(userID, now.Add(-time.Hour))
+	if err != nil {
+		logger.Log.Error("Error counting SMS codes for user %d: %v", userID, err)
+		return fmt.Errorf("failed to send code")
+	}
+	if count >= smsHourlyLimit {
+		return ErrSMSRateLimited
+	}
+	return nil
+}
+
+// checkCode consumes the current code for purpose if it matches, running
+// onMatch in the same transaction. Each wrong guess counts against the code,
+// and the code is burned once it runs out of attempts.
+func (s *PhoneService) checkCode(user *models.User, purpose, code string, onMatch func(tx *gorm.DB, sms *models.SMSCode) error) error {
+	code = normalizeMFACode(code)
+	if len(code) != smsCodeDigits {
+		return ErrInvalidSMSCode
+	}
+
+	matched := false
+	err := s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		codes := s.smsRepo.WithTx(tx)
+		now := time.Now()
+
+		sms, err := codes.GetActiveForUpdate(user.ID, purpose, now)
+		if err != nil {
+			return nil
+		}
+
+		expected := smsCodeHash(sms.PhoneNumber, code)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(sms.CodeHash)) != 1 {
+			if sms.Attempts+1 >= smsCodeMaxAttempts {
+				return codes.MarkUsed(sms.ID, now)
+			}
+			return codes.IncrementAttempts(sms.ID)
+		}
+
+		if err := codes.MarkUsed(sms.ID, now); err != nil {
+			return err
+		}
+		if onMatch != nil {
+			if err := onMatch(tx, sms); err != nil {
+				return err
+			}
+		}
+		matched = true
+		return nil
+	})
+	if err != nil {
+		logger.Log.Error("Error checking SMS code for user %d: %v", user.ID, err)
+		return fmt.Errorf("failed to verify code")
+	}
+	if !matched {
+		return ErrInvalidSMSCode
+	}
+	return nil
+}
+
+// NormalizePhoneNumber returns phone in E.164 format. It accepts the usual
+// separators and a leading 00 in place of +, but requires a country code.
+func NormalizePhoneNumber(phone string) (string, error) {
+	phone = strings.TrimSpace(phone)
+	if strings.HasPrefix(phone, "00") {
+		phone = "+" + phone[2:]
+	}
+	if !strings.HasPrefix(phone, "+") {
+		return "", ErrInvalidPhoneNumber
+	}
+
+	var b strings.Builder
+	b.WriteByte('+')
+	for _, r := range phone[1:] {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", ErrInvalidPhoneNumber
+		}
+	}
+
+	normalized := b.String()
+	digits := len(normalized) - 1
+	if digits < 8 || digits > 15 || normalized[1] == '0' {
+		return "", ErrInvalidPhoneNumber
+	}
+	return normalized, nil
+}
+
+// MaskPhoneNumber hides all but the start and the last four digits of an
+// E.164 number, for logs, responses and the audit log.
+func MaskPhoneNumber(phone string) string {
+	if len(phone) < 8 {
+		return strings.Repeat("*", len(phone))
+	}
+	return phone[:3] + strings.Repeat("*", len(phone)-7) + phone[len(phone)-4:]
+}
+
+func generateSMSCode() (string, error) {
+	limit := big.NewInt(1_000_000)
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", smsCodeDigits, n.Int64()), nil
+}
+
+// smsCodeHash binds a code to the number it was sent to. Codes are short
+// and expire within minutes, so a plain hash is enough.
+func smsCodeHash(phone, code string) string {
+	return utils.HashToken(phone + ":" + code)
+}
@@ -0,0 +1,427 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/common/twilio"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"gorm.io/gorm"
+)
+
+const testPhone = "+14155550123"
+
+// memSMSCodes is an in-memory repo.SMSCodeRepository.
+type memSMSCodes struct {
+	mu    sync.Mutex
+	codes []models.SMSCode
+}
+
+func (r *memSMSCodes) WithTx(*gorm.DB) repo.SMSCodeRepository { return r }
+
+func (r *memSMSCodes) Create(code *models.SMSCode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	code.ID = uint(len(r.codes) + 1)
+	code.CreatedAt = time.Now()
+	r.codes = append(r.codes, *code)
+	return nil
+}
+
+func (r *memSMSCodes) GetActiveForUpdate(userID uint, purpose string, now time.Time) (*models.SMSCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.codes) - 1; i >= 0; i-- {
+		c := r.codes[i]
+		if c.UserID == userID && c.Purpose == purpose && c.UsedAt == nil && c.ExpiresAt.After(now) {
+			return &c, nil
+		}
+	}
+	return nil, errors.New("no active code")
+}
+
+func (r *memSMSCodes) IncrementAttempts(id uint) error {
+	return r.update(id, func(c *models.SMSCode) { c.Attempts++ })
+}
+
+func (r *memSMSCodes) MarkUsed(id uint, at time.Time) error {
+	return r.update(id, func(c *models.SMSCode) { c.UsedAt = &at })
+}
+
+func (r *memSMSCodes) InvalidateForUser(userID uint, purpose string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.codes {
+		if r.codes[i].UserID == userID && r.codes[i].Purpose == purpose && r.codes[i].UsedAt == nil {
+			r.codes[i].UsedAt = &at
+		}
+	}
+	return nil
+}
+
+func (r *memSMSCodes) LatestCreatedAt(userID uint) (*time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var latest *time.Time
+	for _, c := range r.codes {
+		if c.UserID == userID && (latest == nil || c.CreatedAt.After(*latest)) {
+			created := c.CreatedAt
+			latest = &created
+		}
+	}
+	return latest, nil
+}
+
+func (r *memSMSCodes) CountSince(userID uint, since time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	for _, c := range r.codes {
+		if c.UserID == userID && !c.CreatedAt.Before(since) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *memSMSCodes) update(id uint, fn func(c *models.SMSCode)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.codes {
+		if r.codes[i].ID == id {
+			fn(&r.codes[i])
+			return nil
+		}
+	}
+	return errors.New("code not found")
+}
+
+// last returns the newest stored code.
+func (r *memSMSCodes) last() *models.SMSCode {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &r.codes[len(r.codes)-1]
+}
+
+// backdate moves every code's send time back, past the resend cooldown.
+func (r *memSMSCodes) backdate(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.codes {
+		r.codes[i].CreatedAt = r.codes[i].CreatedAt.Add(-d)
+	}
+}
+
+// twilioStandIn answers the Twilio Messages API and keeps what it was sent.
+type twilioStandIn struct {
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	messages []map[string]string
+}
+
+func newTwilioStandIn(t *testing.T) *twilioStandIn {
+	t.Helper()
+	s := &twilioStandIn{}
+	s.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sid, token, _ := r.BasicAuth()
+		if r.Method != http.MethodPost || r.URL.Path != "/2010-04-01/Accounts/ACtest/Messages.json" ||
+			sid != "ACtest" || token != "twilio-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"status":401,"code":20003,"message":"Authenticate"}`))
+			return
+		}
+
+		r.ParseForm()
+		s.mu.Lock()
+		s.messages = append(s.messages, map[string]string{
+			"To":   r.PostForm.Get("To"),
+			"From": r.PostForm.Get("From"),
+			"Body": r.PostForm.Get("Body"),
+		})
+		s.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"sid":"SM1","status":"queued"}`))
+	}))
+	t.Cleanup(s.srv.Close)
+	return s
+}
+
+var smsCodePattern = regexp.MustCompile(`\b\d{6}\b`)
+
+// lastCode returns the code in the last message, checking it went to phone
+// from the configured sender.
+func (s *twilioStandIn) lastCode(t *testing.T, phone string) string {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.messages) == 0 {
+		t.Fatal("no SMS was sent")
+	}
+	msg := s.messages[len(s.messages)-1]
+	if msg["To"] != phone || msg["From"] != "+15005550006" {
+		t.Fatalf("SMS went to %q from %q, want %q from the configured number", msg["To"], msg["From"], phone)
+	}
+	code := smsCodePattern.FindString(msg["Body"])
+	if code == "" {
+		t.Fatalf("SMS body has no code: %q", msg["Body"])
+	}
+	return code
+}
+
+type phoneTest struct {
+	service *PhoneService
+	users   *memUsers
+	codes   *memSMSCodes
+	twilio  *twilioStandIn
+	stores  *memAuthRepos
+}
+
+func newPhoneTest(t *testing.T, users ...*models.User) *phoneTest {
+	t.Helper()
+
+	standIn := newTwilioStandIn(t)
+	client, err := twilio.NewClient(twilio.Config{
+		AccountSID: "ACtest",
+		AuthToken:  "twilio-token",
+		From:       "+15005550006",
+		BaseURL:    standIn.srv.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userRepo := newMemUsers(users...)
+	codes := &memSMSCodes{}
+	auth, stores := newTestAuthService(t, userRepo, nil)
+
+	return &phoneTest{
+		service: NewPhoneService(userRepo, codes, noTx{}, NewNotifier(nil, client), auth),
+		users:   userRepo,
+		codes:   codes,
+		twilio:  standIn,
+		stores:  stores,
+	}
+}
+
+// wrongCode returns a well-formed code other than code.
+func wrongCode(code string) string {
+	if code == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
+
+func TestPhoneVerificationConfirm(t *testing.T) {
+	p := newPhoneTest(t, activeUser("ada@example.com"))
+
+	resp, err := p.service.StartVerification(1, "+1 (415) 555-0123")
+	if err != nil {
+		t.Fatalf("StartVerification: %v", err)
+	}
+	if resp.PhoneNumber != MaskPhoneNumber(testPhone) {
+		t.Errorf("response shows %q, want the masked number", resp.PhoneNumber)
+	}
+	code := p.twilio.lastCode(t, testPhone)
+
+	if _, err := p.service.ConfirmVerification(1, wrongCode(code)); !errors.Is(err, ErrInvalidSMSCode) {
+		t.Fatalf("ConfirmVerification with a wrong code error = %v, want ErrInvalidSMSCode", err)
+	}
+	if user := mustUser(t, p.users, 1); user.PhoneVerified() {
+		t.Fatal("phone was verified by a wrong code")
+	}
+
+	phone, err := p.service.ConfirmVerification(1, code)
+	if err != nil || phone != testPhone {
+		t.Fatalf("ConfirmVerification = %q, %v", phone, err)
+	}
+	if user := mustUser(t, p.users, 1); !user.PhoneVerified() || user.PhoneNumber != testPhone {
+		t.Fatalf("phone not saved as verified: %q", user.PhoneNumber)
+	}
+
+	if _, err := p.service.ConfirmVerification(1, code); !errors.Is(err, ErrInvalidSMSCode) {
+		t.Fatalf("reused code error = %v, want ErrInvalidSMSCode", err)
+	}
+}
+
+func TestPhoneVerificationExpiry(t *testing.T) {
+	p := newPhoneTest(t, activeUser("ada@example.com"))
+
+	if _, err := p.service.StartVerification(1, testPhone); err != nil {
+		t.Fatal(err)
+	}
+	code := p.twilio.lastCode(t, testPhone)
+	p.codes.update(p.codes.last().ID, func(c *models.SMSCode) { c.ExpiresAt = time.Now().Add(-time.Second) })
+
+	if _, err := p.service.ConfirmVerification(1, code); !errors.Is(err, ErrInvalidSMSCode) {
+		t.Fatalf("expired code error = %v, want ErrInvalidSMSCode", err)
+	}
+	if mustUser(t, p.users, 1).PhoneVerified() {
+		t.Fatal("phone was verified by an expired code")
+	}
+}
+
+func TestPhoneVerificationAttemptLockout(t *testing.T) {
+	p := newPhoneTest(t, activeUser("ada@example.com"))
+
+	if _, err := p.service.StartVerification(1, testPhone); err != nil {
+		t.Fatal(err)
+	}
+	code := p.twilio.lastCode(t, testPhone)
+
+	for i := 0; i < smsCodeMaxAttempts; i++ {
+		if _, err := p.service.ConfirmVerification(1, wrongCode(code)); !errors.Is(err, ErrInvalidSMSCode) {
+			t.Fatalf("attempt %d error = %v, want ErrInvalidSMSCode", i+1, err)
+		}
+	}
+	if p.codes.last().UsedAt == nil {
+		t.Fatalf("code still usable after %d wrong guesses", smsCodeMaxAttempts)
+	}
+	if _, err := p.service.ConfirmVerification(1, code); !errors.Is(err, ErrInvalidSMSCode) {
+		t.Fatalf("right code after lockout error = %v, want ErrInvalidSMSCode", err)
+	}
+
+	// A new code can't be requested right away.
+	if _, err := p.service.StartVerification(1, testPhone); !errors.Is(err, ErrSMSRateLimited) {
+		t.Fatalf("immediate resend error = %v, want ErrSMSRateLimited", err)
+	}
+	p.codes.backdate(smsResendCooldown)
+	if _, err := p.service.StartVerification(1, testPhone); err != nil {
+		t.Fatalf("resend after the cooldown: %v", err)
+	}
+	if _, err := p.service.ConfirmVerification(1, p.twilio.lastCode(t, testPhone)); err != nil {
+		t.Fatalf("ConfirmVerification with the new code: %v", err)
+	}
+}
+
+func TestSMSLoginSecondFactor(t *testing.T) {
+	verifiedAt := time.Now()
+	p := newPhoneTest(t, &models.User{
+		Email:           "ada@example.com",
+		IsActive:        true,
+		PhoneNumber:     testPhone,
+		PhoneVerifiedAt: &verifiedAt,
+		SMSMFAEnabled:   true,
+	})
+
+	start, err := p.service.auth.StartLogin(mustUser(t, p.users, 1), models.ClientInfo{}, models.LoginMethodPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !start.MFARequired || len(start.MFAMethods) != 1 || start.MFAMethods[0] != "sms" {
+		t.Fatalf("StartLogin = %+v, want an SMS second factor", start)
+	}
+
+	if _, err := p.service.SendLoginCode("not-a-token"); !errors.Is(err, ErrInvalidMFAToken) {
+		t.Fatalf("SendLoginCode with a bad token error = %v, want ErrInvalidMFAToken", err)
+	}
+	if _, err := p.service.SendLoginCode(start.MFAToken); err != nil {
+		t.Fatalf("SendLoginCode: %v", err)
+	}
+	code := p.twilio.lastCode(t, testPhone)
+
+	if _, err := p.service.VerifyLoginCode(start.MFAToken, wrongCode(code), models.ClientInfo{}); !errors.Is(err, ErrInvalidSMSCode) {
+		t.Fatalf("VerifyLoginCode with a wrong code error = %v, want ErrInvalidSMSCode", err)
+	}
+	resp, err := p.service.VerifyLoginCode(start.MFAToken, code, models.ClientInfo{})
+	if err != nil {
+		t.Fatalf("VerifyLoginCode: %v", err)
+	}
+	if resp.AccessToken == "" || resp.MFARequired {
+		t.Fatalf("login not completed: %+v", resp)
+	}
+
+	actions := p.stores.auditActions()
+	if len(actions) != 2 || actions[0] != models.AuditActionMFAFailed || actions[1] != models.AuditActionLogin {
+		t.Errorf("audit actions = %v, want a failed MFA attempt then a login", actions)
+	}
+	if _, err := p.service.VerifyLoginCode(start.MFAToken, code, models.ClientInfo{}); !errors.Is(err, ErrInvalidSMSCode) {
+		t.Fatalf("reused login code error = %v, want ErrInvalidSMSCode", err)
+	}
+}
+
+func TestSMSLoginRequiresEnabledFactor(t *testing.T) {
+	verifiedAt := time.Now()
+	p := newPhoneTest(t, &models.User{
+		Email:           "ada@example.com",
+		IsActive:        true,
+		PhoneNumber:     testPhone,
+		PhoneVerifiedAt: &verifiedAt,
+		TOTPEnabled:     true,
+	})
+
+	start, err := p.service.auth.StartLogin(mustUser(t, p.users, 1), models.ClientInfo{}, models.LoginMethodPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.service.SendLoginCode(start.MFAToken); !errors.Is(err, ErrSMSMFANotEnabled) {
+		t.Fatalf("SendLoginCode error = %v, want ErrSMSMFANotEnabled", err)
+	}
+	if len(p.twilio.messages) != 0 {
+		t.Error("an SMS was sent for a user without SMS two-factor")
+	}
+}
+
+func TestSMSStepUp(t *testing.T) {
+	verifiedAt := time.Now()
+	p := newPhoneTest(t, &models.User{
+		Email:           "ada@example.com",
+		IsActive:        true,
+		PhoneNumber:     testPhone,
+		PhoneVerifiedAt: &verifiedAt,
+		SMSMFAEnabled:   true,
+	})
+	claims := &utils.Claims{UserID: 1, SessionID: 7}
+
+	// A login code can't be used to step up.
+	start, err := p.service.auth.StartLogin(mustUser(t, p.users, 1), models.ClientInfo{}, models.LoginMethodPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.service.SendLoginCode(start.MFAToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.service.VerifyStepUpCode(claims, p.twilio.lastCode(t, testPhone)); !errors.Is(err, ErrInvalidSMSCode) {
+		t.Fatalf("VerifyStepUpCode with a login code error = %v, want ErrInvalidSMSCode", err)
+	}
+
+	p.codes.backdate(smsResendCooldown)
+	if _, err := p.service.SendStepUpCode(claims); err != nil {
+		t.Fatalf("SendStepUpCode: %v", err)
+	}
+	resp, err := p.service.VerifyStepUpCode(claims, p.twilio.lastCode(t, testPhone))
+	if err != nil {
+		t.Fatalf("VerifyStepUpCode: %v", err)
+	}
+
+	stepped, err := utils.ValidateAccessToken(resp.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stepped.UserID != 1 || stepped.SessionID != 7 || stepped.StepUpAt == 0 {
+		t.Errorf("step-up token claims = %+v, want a fresh step-up for session 7", stepped)
+	}
+}
+
+func TestSMSStepUpRequiresEnabledFactor(t *testing.T) {
+	verifiedAt := time.Now()
+	p := newPhoneTest(t, &models.User{
+		Email:           "ada@example.com",
+		IsActive:        true,
+		PhoneNumber:     testPhone,
+		PhoneVerifiedAt: &verifiedAt,
+	})
+
+	if _, err := p.service.SendStepUpCode(&utils.Claims{UserID: 1}); !errors.Is(err, ErrSMSMFANotEnabled) {
+		t.Fatalf("SendStepUpCode error = %v, want ErrSMSMFANotEnabled", err)
+	}
+}
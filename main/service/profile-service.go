@@ -0,0 +1,395 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+	"gorm.io/gorm"
+)
+
+const (
+	emailChangeTTL      = 24 * time.Hour
+	purgeInterval       = time.Hour
+	purgeBatchSize      = 100
+	maxProfileNameLen   = 100
+	maxPreferenceLength = 32
+)
+
+var (
+	ErrInvalidProfile          = errors.New("invalid profile")
+	ErrInvalidEmail            = errors.New("invalid email address")
+	ErrEmailUnchanged          = errors.New("new email is the same as the current one")
+	ErrEmailTaken              = errors.New("email is already in use")
+	ErrInvalidEmailChangeToken = errors.New("invalid or expired email change link")
+	ErrWalletsNotEmpty         = errors.New("withdraw all funds before deleting your account")
+)
+
+// ProfileService lets users edit their profile, change their email address
+// and delete their account. Deleted accounts are soft-deleted and purged for
+// good once the grace period has passed.
+type ProfileService struct {
+	userRepo        repo.UserRepository
+	emailChangeRepo repo.EmailChangeRepository
+	walletRepo      repo.WalletRepository
+	outboxRepo      repo.OutboxRepository
+	transactor      repo.Transactor
+	tokens          *TokenRevocationService
+	deletionGrace   time.Duration
+	confirmURL      string
+	stop            chan struct{}
+	stopOnce        sync.Once
+}
+
+func NewProfileService(userRepo repo.UserRepository, emailChangeRepo repo.EmailChangeRepository, walletRepo repo.WalletRepository, outboxRepo repo.OutboxRepository, transactor repo.Transactor, tokens *TokenRevocationService) *ProfileService {
+	grace, err := time.ParseDuration(utils.GetENV("ACCOUNT_DELETION_GRACE", "720h"))
+	if err != nil || grace < 0 {
+		logger.Log.Warn("Invalid ACCOUNT_DELETION_GRACE, using 720h")
+		grace = 720 * time.Hour
+	}
+
+	baseURL := strings.TrimRight(utils.GetENV("APP_BASE_URL", "http://localhost:3030"), "/")
+
+	return &ProfileService{
+		userRepo:        userRepo,
+		emailChangeRepo: emailChangeRepo,
+		walletRepo:      walletRepo,
+		outboxRepo:      outboxRepo,
+		transactor:      transactor,
+		tokens:          tokens,
+		deletionGrace:   grace,
+		confirmURL:      baseURL + "/api/v1/profile/email/confirm",
+		stop:            make(chan struct{}),
+	}
+}
+
+// UpdateProfile changes the fields present in req and returns the updated user.
+func (s *ProfileService) UpdateProfile(userID uint, req *models.UpdateProfileRequest) (*models.User, error) {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	firstName, lastName, prefs := user.FirstName, user.LastName, user.Preferences
+	if req.FirstName != nil {
+		firstName = strings.TrimSpace(*req.FirstName)
+	}
+	if req.LastName != nil {
+		lastName = strings.TrimSpace(*req.LastName)
+	}
+	if req.Preferences != nil {
+		prefs = *req.Preferences
+	}
+
+	if firstName == "" || lastName == "" {
+		return nil, fmt.Errorf("%w: first and last name can't be empty", ErrInvalidProfile)
+	}
+	if len(firstName) > maxProfileNameLen || len(lastName) > maxProfileNameLen {
+		return nil, fmt.Errorf("%w: names must be at most %d characters", ErrInvalidProfile, maxProfileNameLen)
+	}
+	if err := validatePreferences(&prefs); err != nil {
+		return nil, err
+	}
+	if prefs.SMSWithdrawalAlerts && !user.Preferences.SMSWithdrawalAlerts && !user.PhoneVerified() {
+		return nil, fmt.Errorf("%w: verify a phone number before turning on SMS withdrawal alerts", ErrInvalidProfile)
+	}
+
+	if err := s.userRepo.UpdateProfile(userID, firstName, lastName, prefs); err != nil {
+		logger.Log.Error("Error updating profile of user %d: %v", userID, err)
+		return nil, fmt.Errorf("failed to update profile")
+	}
+
+	user.FirstName, user.LastName, user.Preferences = firstName, lastName, prefs
+	return user, nil
+}
+
+func validatePreferences(prefs *models.UserPreferences) error {
+	prefs.Language = strings.TrimSpace(prefs.Language)
+	prefs.Timezone = strings.TrimSpace(prefs.Timezone)
+	prefs.DisplayCurrency = strings.ToUpper(strings.TrimSpace(prefs.DisplayCurrency))
+
+	if len(prefs.Language) > maxPreferenceLength || len(prefs.DisplayCurrency) > maxPreferenceLength {
+		return fmt.Errorf("%w: preference values must be at most %d characters", ErrInvalidProfile, maxPreferenceLength)
+	}
+	if prefs.Timezone != "" {
+		if _, err := time.LoadLocation(prefs.Timezone); err != nil {
+			return fmt.Errorf("%w: unknown timezone %q", ErrInvalidProfile, prefs.Timezone)
+		}
+	}
+	if prefs.DisplayCurrency != "" && len(prefs.DisplayCurrency) != 3 {
+		return fmt.Errorf("%w: display_currency must be a 3-letter currency code", ErrInvalidProfile)
+	}
+
+	return nil
+}
+
+// RequestEmailChange sends a confirmation link to both the current and the
+// new address. The change is applied once both have been opened, so neither
+// a stolen session nor a typo can move the account to another mailbox.
+func (s *ProfileService) RequestEmailChange(userID uint, newEmail string) error {
+	newEmail = strings.TrimSpace(newEmail)
+	if !isValidEmail(newEmail) {
+		return ErrInvalidEmail
+	}
+
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return ErrUserNotFound
+	}
+	if strings.EqualFold(user.Email, newEmail) {
+		return ErrEmailUnchanged
+	}
+
+	exists, err := s.userRepo.UserExists(newEmail)
+	if err != nil {
+		logger.Log.Error("Error checking user existence: %v", err)
+		return fmt.Errorf("failed to request email change")
+	}
+	if exists {
+		return ErrEmailTaken
+	}
+
+	oldToken, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		logger.Log.Error("Error generating email change token: %v", err)
+		return fmt.Errorf("failed to request email change")
+	}
+	newToken, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		logger.Log.Error("Error generating email change token: %v", err)
+		return fmt.Errorf("failed to request email change")
+	}
+
+	var msgs []*models.OutboxMessage
+	for _, recipient := range []struct{ email, token string }{{user.Email, oldToken}, {newEmail, newToken}} {
+		msg, err := NewOutboxMessage(models.OutboxKindEmailChange, models.EmailChangePayload{
+			Email:     recipient.email,
+			FirstName: user.FirstName,
+			NewEmail:  newEmail,
+			URL:       s.confirmURL + "?token=" + url.QueryEscape(recipient.token),
+		})
+		if err != nil {
+			logger.Log.Error("Error building email change email: %v", err)
+			return fmt.Errorf("failed to request email change")
+		}
+		msgs = append(msgs, msg)
+	}
+
+	err = s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		changes := s.emailChangeRepo.WithTx(tx)
+		// Only the most recent request can be confirmed.
+		if err := changes.CancelForUser(userID, time.Now()); err != nil {
+			return err
+		}
+		if err := changes.Create(&models.EmailChangeRequest{
+			UserID:       userID,
+			NewEmail:     newEmail,
+			OldTokenHash: utils.HashToken(oldToken),
+			NewTokenHash: utils.HashToken(newToken),
+			ExpiresAt:    time.Now().Add(emailChangeTTL),
+		}); err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if err := s.outboxRepo.WithTx(tx).Enqueue(msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Log.Error("Error creating email change for user %d: %v", userID, err)
+		return fmt.Errorf("failed to request email change")
+	}
+
+	return nil
+}
+
+// ConfirmEmailChange records one side of an email change. It reports whether
+// the change is now complete, in which case the new address is verified and
+// all existing tokens are invalidated.
+func (s *ProfileService) ConfirmEmailChange(token string) (bool, error) {
+	var (
+		userID    uint
+		completed bool
+		taken     bool
+	)
+	err := s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		changes := s.emailChangeRepo.WithTx(tx)
+		change, err := changes.GetByTokenHashForUpdate(utils.HashToken(token))
+		if err != nil || change.CompletedAt != nil || change.CancelledAt != nil || time.Now().After(change.ExpiresAt) {
+			return ErrInvalidEmailChangeToken
+		}
+
+		now := time.Now()
+		if change.OldTokenHash == utils.HashToken(token) {
+			if change.OldConfirmedAt == nil {
+				change.OldConfirmedAt = &now
+			}
+		} else if change.NewConfirmedAt == nil {
+			change.NewConfirmedAt = &now
+		}
+
+		if change.OldConfirmedAt != nil && change.NewConfirmedAt != nil {
+			users := s.userRepo.WithTx(tx)
+			exists, err := users.UserExists(change.NewEmail)
+			if err != nil {
+				return err
+			}
+			if exists {
+				// The address was claimed after the request was made.
+				change.CancelledAt = &now
+				taken = true
+				return changes.Update(change)
+			}
+
+			if err := users.UpdateEmail(change.UserID, change.NewEmail, now); err != nil {
+				if errors.Is(err, repo.ErrDuplicateEmail) {
+					// Claimed concurrently. The failed update aborted the
+					// transaction, so the change is cancelled on the next
+					// confirmation instead.
+					return ErrEmailTaken
+				}
+				return err
+			}
+			if err := users.IncrementTokenVersion(change.UserID); err != nil {
+				return err
+			}
+			change.CompletedAt = &now
+			userID, completed = change.UserID, true
+		}
+
+		return changes.Update(change)
+	})
+	if errors.Is(err, ErrInvalidEmailChangeToken) || errors.Is(err, ErrEmailTaken) {
+		return false, err
+	}
+	if err != nil {
+		logger.Log.Error("Error confirming email change: %v", err)
+		return false, fmt.Errorf("failed to confirm email change")
+	}
+	if taken {
+		return false, ErrEmailTaken
+	}
+
+	if completed {
+		_ = s.tokens.ClearCachedVersion(userID)
+	}
+
+	return completed, nil
+}
+
+// DeleteAccount soft-deletes the user and signs them out everywhere. It is
+// refused while any wallet still holds funds.
+func (s *ProfileService) DeleteAccount(userID uint) (time.Time, error) {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return time.Time{}, ErrUserNotFound
+	}
+
+	purgeAt := time.Now().Add(s.deletionGrace)
+	msg, err := NewOutboxMessage(models.OutboxKindAccountDeleted, models.AccountDeletedPayload{
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		PurgeAt:   purgeAt,
+	})
+	if err != nil {
+		logger.Log.Error("Error building account deleted email: %v", err)
+		return time.Time{}, fmt.Errorf("failed to delete account")
+	}
+
+	err = s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		// Withdrawals lock the user too, so none can change a balance between
+		// this check and the deletion.
+		if _, err := s.userRepo.WithTx(tx).GetUserByIDForUpdate(userID); err != nil {
+			return err
+		}
+		wallets, err := s.walletRepo.WithTx(tx).ListByUser(userID)
+		if err != nil {
+			return err
+		}
+		for _, wallet := range wallets {
+			if wallet.Balance != 0 {
+				return ErrWalletsNotEmpty
+			}
+		}
+
+		if err := s.tokens.RevokeAllTx(tx, userID); err != nil {
+			return err
+		}
+		if err := s.emailChangeRepo.WithTx(tx).CancelForUser(userID, time.Now()); err != nil {
+			return err
+		}
+		if err := s.userRepo.WithTx(tx).DeleteUser(userID); err != nil {
+			return err
+		}
+		return s.outboxRepo.WithTx(tx).Enqueue(msg)
+	})
+	if errors.Is(err, ErrWalletsNotEmpty) {
+		return time.Time{}, err
+	}
+	if err != nil {
+		logger.Log.Error("Error deleting account of user %d: %v", userID, err)
+		return time.Time{}, fmt.Errorf("failed to delete account")
+	}
+
+	_ = s.tokens.ClearCachedVersion(userID)
+	logger.Log.Info("User %d deleted their account, purge scheduled for %s", userID, purgeAt.Format(time.RFC3339))
+	return purgeAt, nil
+}
+
+// StartPurger periodically erases accounts whose grace period has ended.
+func (s *ProfileService) StartPurger() {
+	go func() {
+		ticker := time.NewTicker(purgeInterval)
+		defer ticker.Stop()
+
+		logger.Log.Info("Account purger started")
+		s.PurgeDeleted()
+		for {
+			select {
+			case <-s.stop:
+				logger.Log.Info("Account purger stopped")
+				return
+			case <-ticker.C:
+				s.PurgeDeleted()
+			}
+		}
+	}()
+}
+
+func (s *ProfileService) StopPurger() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// PurgeDeleted permanently removes every account deleted longer ago than the
+// grace period.
+func (s *ProfileService) PurgeDeleted() {
+	cutoff := time.Now().Add(-s.deletionGrace)
+	for {
+		users, err := s.userRepo.ListDeletedBefore(cutoff, purgeBatchSize)
+		if err != nil {
+			logger.Log.Error("Error listing deleted accounts: %v", err)
+			return
+		}
+
+		for _, user := range users {
+			if err := s.userRepo.PurgeUser(user.ID); err != nil {
+				logger.Log.Error("Error purging user %d: %v", user.ID, err)
+				return
+			}
+			logger.Log.Info("Purged deleted user %d", user.ID)
+		}
+
+		if len(users) < purgeBatchSize {
+			return
+		}
+	}
+}
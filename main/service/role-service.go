@@ -0,0 +1,82 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrRoleNotFound = errors.New("role not found")
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// RoleService lets operators inspect roles and move users between them.
+type RoleService struct {
+	roleRepo   repo.RoleRepository
+	userRepo   repo.UserRepository
+	transactor repo.Transactor
+	tokens     *TokenRevocationService
+}
+
+func NewRoleService(roleRepo repo.RoleRepository, userRepo repo.UserRepository, transactor repo.Transactor, tokens *TokenRevocationService) *RoleService {
+	return &RoleService{
+		roleRepo:   roleRepo,
+		userRepo:   userRepo,
+		transactor: transactor,
+		tokens:     tokens,
+	}
+}
+
+func (s *RoleService) ListRoles() ([]models.Role, error) {
+	roles, err := s.roleRepo.List()
+	if err != nil {
+		logger.Log.Error("Error listing roles: %v", err)
+		return nil, fmt.Errorf("failed to list roles")
+	}
+	return roles, nil
+}
+
+func (s *RoleService) GetUser(userID uint) (*models.User, error) {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// AssignRole changes a user's role. Access tokens carry the old permissions,
+// so the token version is bumped: clients get a 401 and pick up the new
+// role on refresh.
+func (s *RoleService) AssignRole(userID uint, role string) (*models.User, error) {
+	if _, err := s.roleRepo.GetByName(role); err != nil {
+		return nil, ErrRoleNotFound
+	}
+	if _, err := s.GetUser(userID); err != nil {
+		return nil, err
+	}
+
+	err := s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		users := s.userRepo.WithTx(tx)
+		if err := users.UpdateRole(userID, role); err != nil {
+			return err
+		}
+		return users.IncrementTokenVersion(userID)
+	})
+	if err != nil {
+		logger.Log.Error("Error assigning role %s to user %d: %v", role, userID, err)
+		return nil, fmt.Errorf("failed to assign role")
+	}
+
+	if err := s.tokens.ClearCachedVersion(userID); err != nil {
+		logger.Log.Warn("Role change for user %d may take up to the cache TTL to apply", userID)
+	}
+
+	logger.Log.Info("User %d assigned role %s", userID, role)
+
+	return s.GetUser(userID)
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+	"github.com/mssola/useragent"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+type SessionService struct {
+	sessionRepo repo.SessionRepository
+	tokens      *TokenRevocationService
+}
+
+func NewSessionService(sessionRepo repo.SessionRepository, tokens *TokenRevocationService) *SessionService {
+	return &SessionService{
+		sessionRepo: sessionRepo,
+		tokens:      tokens,
+	}
+}
+
+// ListSessions returns the user's active sessions, flagging the one the
+// request was made from.
+func (s *SessionService) ListSessions(userID, currentSessionID uint) ([]models.Session, error) {
+	sessions, err := s.sessionRepo.ListActiveByUser(userID)
+	if err != nil {
+		logger.Log.Error("Error listing sessions for user %d: %v", userID, err)
+		return nil, fmt.Errorf("failed to list sessions")
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentSessionID
+	}
+
+	return sessions, nil
+}
+
+func (s *SessionService) RevokeSession(userID, sessionID uint) error {
+	return s.tokens.RevokeSession(userID, sessionID)
+}
+
+func newSession(userID uint, familyID string, client models.ClientInfo) *models.Session {
+	ua := useragent.New(client.UserAgent)
+	browser, _ := ua.Browser()
+
+	return &models.Session{
+		UserID:     userID,
+		FamilyID:   familyID,
+		IPAddress:  client.IPAddress,
+		UserAgent:  truncate(client.UserAgent, 512),
+		Browser:    browser,
+		OS:         ua.OS(),
+		DeviceType: deviceType(ua, client.UserAgent),
+		LastSeenAt: time.Now(),
+	}
+}
+
+// deviceType is a best-effort guess; user agents can't reliably tell
+// tablets apart, so only the common markers are checked.
+func deviceType(ua *useragent.UserAgent, raw string) string {
+	switch {
+	case raw == "":
+		return models.DeviceTypeUnknown
+	case ua.Bot():
+		return models.DeviceTypeBot
+	case strings.Contains(raw, "iPad") || strings.Contains(raw, "Tablet") ||
+		(strings.Contains(raw, "Android") && !strings.Contains(raw, "Mobile")):
+		return models.DeviceTypeTablet
+	case ua.Mobile():
+		return models.DeviceTypeMobile
+	default:
+		return models.DeviceTypeDesktop
+	}
+}
+
+func truncate(value string, max int) string {
+	if len(value) <= max {
+		return value
+	}
+	return value[:max]
+}
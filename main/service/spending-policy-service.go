@@ -0,0 +1,528 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+	"gorm.io/gorm"
+)
+
+const (
+	limitUnitBase = "base"
+	limitUnitFiat = "fiat_cents"
+)
+
+var ErrLimitsUnavailable = errors.New("unable to evaluate spending limits right now")
+
+// currencyDecimals maps a currency to the number of base units per whole coin.
+var currencyDecimals = map[string]int{
+	"LTC": 8,
+}
+
+// formatCoinAmount renders a base-unit amount in whole coins, e.g. 1500000
+// litoshi as "0.015". Unknown currencies are shown in base units.
+func formatCoinAmount(currency string, amount int64) string {
+	decimals, ok := currencyDecimals[strings.ToUpper(currency)]
+	if !ok || decimals == 0 {
+		return strconv.FormatInt(amount, 10)
+	}
+
+	unit := int64(math.Pow10(decimals))
+	frac := strings.TrimRight(fmt.Sprintf("%0*d", decimals, amount%unit), "0")
+	if frac == "" {
+		return strconv.FormatInt(amount/unit, 10)
+	}
+	return strconv.FormatInt(amount/unit, 10) + "." + frac
+}
+
+// RateProvider converts an amount in base units into fiat cents.
+type RateProvider interface {
+	FiatCents(currency string, amount int64) (int64, error)
+}
+
+// StaticRateProvider reads fiat rates from FIAT_RATE_<CURRENCY> environment
+// variables, e.g. FIAT_RATE_LTC=85.40 for 85.40 USD per LTC.
+type StaticRateProvider struct{}
+
+func (StaticRateProvider) FiatCents(currency string, amount int64) (int64, error) {
+	raw := utils.GetENV("FIAT_RATE_"+strings.ToUpper(currency), "")
+	if raw == "" {
+		return 0, fmt.Errorf("no fiat rate configured for %s", currency)
+	}
+
+	rate, err := strconv.ParseFloat(raw, 64)
+	if err != nil || rate <= 0 {
+		return 0, fmt.Errorf("invalid fiat rate for %s", currency)
+	}
+
+	decimals, ok := currencyDecimals[strings.ToUpper(currency)]
+	if !ok {
+		return 0, fmt.Errorf("unsupported currency %s", currency)
+	}
+
+	whole := float64(amount) / math.Pow10(decimals)
+	return int64(math.Round(whole * rate * 100)), nil
+}
+
+// LimitExceededError is returned when a withdrawal would break a spending limit.
+type LimitExceededError struct {
+	Detail models.LimitExceededDetail
+}
+
+func (e *LimitExceededError) Error() string {
+	if e.Detail.Period == models.LimitPeriodTransaction {
+		return fmt.Sprintf("amount exceeds the per-transaction %s limit", e.Detail.Unit)
+	}
+	return fmt.Sprintf("amount exceeds the %s %s %s limit", e.Detail.Scope, e.Detail.Period, e.Detail.Unit)
+}
+
+// incrIfCached adds ARGV[1] to a running total only while it is cached. A
+// total that was dropped in the meantime is rebuilt from the database on the
+// next check rather than restarted from this one withdrawal.
+var incrIfCached = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return redis.call("INCRBY", KEYS[1], ARGV[1])
+end
+return false
+`)
+
+// SpendingReservation holds the running-total increments made for a
+// withdrawal so they can be rolled back if the withdrawal isn't recorded.
+type SpendingReservation struct {
+	redis *redis.Client
+	keys  map[string]int64
+}
+
+func (r *SpendingReservation) Release() {
+	if r == nil || r.redis == nil {
+		return
+	}
+
+	ctx := context.Background()
+	for key, amount := range r.keys {
+		if err := incrIfCached.Run(ctx, r.redis, []string{key}, -amount).Err(); err != nil && !errors.Is(err, redis.Nil) {
+			logger.Log.Error("Failed to release spending reservation on %s: %v", key, err)
+		}
+	}
+}
+
+// SpendingPolicyService keeps a running total per user or wallet, period
+// and unit in Redis so most checks don't sum the withdrawals table. The
+// database stays authoritative: a missing total is rebuilt from it, and
+// checks read it directly when Redis is unavailable.
+type SpendingPolicyService struct {
+	limitRepo repo.SpendingLimitRepository
+	txRepo    repo.TransactionRepository
+	redis     *redis.Client
+	rates     RateProvider
+}
+
+func NewSpendingPolicyService(limitRepo repo.SpendingLimitRepository, txRepo repo.TransactionRepository, redisClient *redis.Client, rates RateProvider) *SpendingPolicyService {
+	s := &SpendingPolicyService{
+		limitRepo: limitRepo,
+		txRepo:    txRepo,
+		redis:     redisClient,
+		rates:     rates,
+	}
+
+	s.seedDefaultRules()
+	return s
+}
+
+// defaultSpendingRules are installed on first start so a fresh database is
+// never without limits. Admins adjust them through the admin API.
+var defaultSpendingRules = []models.SpendingRule{
+	{Tier: models.UserTierStandard, Currency: "LTC", Scope: models.LimitScopeUser, Period: models.LimitPeriodTransaction, MaxAmount: 5_00000000, MaxFiatCents: 500_00},
+	{Tier: models.UserTierStandard, Currency: "LTC", Scope: models.LimitScopeUser, Period: models.LimitPeriodDaily, MaxAmount: 10_00000000, MaxFiatCents: 1_000_00},
+	{Tier: models.UserTierStandard, Currency: "LTC", Scope: models.LimitScopeUser, Period: models.LimitPeriodMonthly, MaxAmount: 100_00000000, MaxFiatCents: 10_000_00},
+	{Tier: models.UserTierVerified, Currency: "LTC", Scope: models.LimitScopeUser, Period: models.LimitPeriodTransaction, MaxAmount: 50_00000000, MaxFiatCents: 5_000_00},
+	{Tier: models.UserTierVerified, Currency: "LTC", Scope: models.LimitScopeUser, Period: models.LimitPeriodDaily, MaxAmount: 100_00000000, MaxFiatCents: 10_000_00},
+	{Tier: models.UserTierVerified, Currency: "LTC", Scope: models.LimitScopeUser, Period: models.LimitPeriodMonthly, MaxAmount: 1_000_00000000, MaxFiatCents: 100_000_00},
+	{Tier: models.UserTierBusiness, Currency: "LTC", Scope: models.LimitScopeUser, Period: models.LimitPeriodDaily, MaxAmount: 1_000_00000000, MaxFiatCents: 100_000_00},
+	{Tier: models.UserTierBusiness, Currency: "LTC", Scope: models.LimitScopeUser, Period: models.LimitPeriodMonthly, MaxAmount: 10_000_00000000, MaxFiatCents: 1_000_000_00},
+}
+
+func (s *SpendingPolicyService) seedDefaultRules() {
+	count, err := s.limitRepo.CountRules()
+	if err != nil {
+		logger.Log.Error("Failed to count spending rules: %v", err)
+		return
+	}
+	if count > 0 {
+		return
+	}
+
+	for _, rule := range defaultSpendingRules {
+		rule := rule
+		if err := s.limitRepo.UpsertRule(&rule); err != nil {
+			logger.Log.Error("Failed to seed spending rule %s/%s/%s: %v", rule.Tier, rule.Scope, rule.Period, err)
+		}
+	}
+	logger.Log.Info("Seeded default spending rules")
+}
+
+func (s *SpendingPolicyService) ListRules() ([]models.SpendingRule, error) {
+	return s.limitRepo.ListRules()
+}
+
+func (s *SpendingPolicyService) UpsertRule(rule *models.SpendingRule) error {
+	if err := validateLimitShape(rule.Scope, rule.Period); err != nil {
+		return err
+	}
+	if rule.Tier == "" || rule.Currency == "" {
+		return errors.New("tier and currency are required")
+	}
+	if rule.MaxAmount < 0 || rule.MaxFiatCents < 0 {
+		return errors.New("limits cannot be negative")
+	}
+
+	rule.Currency = strings.ToUpper(rule.Currency)
+	return s.limitRepo.UpsertRule(rule)
+}
+
+func (s *SpendingPolicyService) CreateOverride(req *models.SpendingLimitOverrideRequest, createdBy string) (*models.SpendingLimitOverride, error) {
+	if req.UserID == 0 {
+		return nil, errors.New("user_id is required")
+	}
+	if err := validateLimitShape(req.Scope, req.Period); err != nil {
+		return nil, err
+	}
+	if req.Scope == models.LimitScopeWallet && req.WalletID == nil {
+		return nil, errors.New("wallet_id is required for wallet scoped overrides")
+	}
+	if req.MaxAmount < 0 || req.MaxFiatCents < 0 {
+		return nil, errors.New("limits cannot be negative")
+	}
+	// An override replaces the tier rule, where 0 means unlimited. Lifting
+	// the coin limit entirely is never what an override is for.
+	if req.MaxAmount == 0 {
+		return nil, errors.New("max_amount must be greater than zero")
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		return nil, errors.New("reason is required")
+	}
+
+	override := &models.SpendingLimitOverride{
+		UserID:       req.UserID,
+		WalletID:     req.WalletID,
+		Scope:        req.Scope,
+		Period:       req.Period,
+		MaxAmount:    req.MaxAmount,
+		MaxFiatCents: req.MaxFiatCents,
+		Reason:       req.Reason,
+		CreatedBy:    createdBy,
+		ExpiresAt:    req.ExpiresAt,
+	}
+
+	if err := s.limitRepo.CreateOverride(override); err != nil {
+		logger.Log.Error("Error creating spending limit override: %v", err)
+		return nil, fmt.Errorf("failed to create override")
+	}
+
+	logger.Log.Info("Spending limit override %d created for user %d by %s: %s", override.ID, override.UserID, createdBy, override.Reason)
+	return override, nil
+}
+
+func (s *SpendingPolicyService) DeleteOverride(id uint) error {
+	return s.limitRepo.DeleteOverride(id)
+}
+
+// CheckWithdrawal checks a withdrawal against every applicable limit, adds
+// it to the running totals and returns its fiat value to store with it, or
+// 0 if no rate is known. It must run in the transaction that records the
+// withdrawal, after the user's row is locked, so concurrent withdrawals are
+// checked one after another against totals that include each other. Call
+// Release on the reservation if that transaction doesn't commit.
+func (s *SpendingPolicyService) CheckWithdrawal(db *gorm.DB, user *models.User, wallet *models.Wallet, amount int64) (int64, *SpendingReservation, error) {
+	rules, err := s.effectiveRules(user, wallet)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	needsFiat := false
+	for _, rule := range rules {
+		if rule.MaxFiatCents > 0 {
+			needsFiat = true
+			break
+		}
+	}
+
+	fiat, err := s.rates.FiatCents(wallet.Currency, amount)
+	if err != nil {
+		if needsFiat {
+			// Fail closed: without a rate we can't prove the fiat limit holds.
+			logger.Log.Error("Fiat conversion failed for %s: %v", wallet.Currency, err)
+			return 0, nil, ErrLimitsUnavailable
+		}
+		fiat = 0
+	}
+
+	txRepo := s.txRepo.WithTx(db)
+	totals := make(map[string]models.WithdrawalTotals)
+	increments := make(map[string]int64)
+	now := time.Now().UTC()
+
+	for _, rule := range rules {
+		checks := []struct {
+			unit      string
+			limit     int64
+			requested int64
+		}{
+			{limitUnitBase, rule.MaxAmount, amount},
+			{limitUnitFiat, rule.MaxFiatCents, fiat},
+		}
+
+		for _, check := range checks {
+			if check.limit <= 0 {
+				continue
+			}
+
+			if rule.Period == models.LimitPeriodTransaction {
+				if check.requested > check.limit {
+					return 0, nil, limitExceeded(rule, check.unit, check.limit, 0, check.requested, nil)
+				}
+				continue
+			}
+
+			key, used, err := s.used(txRepo, totals, user, wallet, rule, check.unit, now)
+			if err != nil {
+				return 0, nil, err
+			}
+
+			if used+check.requested > check.limit {
+				resetsAt := periodEnd(rule.Period, now)
+				return 0, nil, limitExceeded(rule, check.unit, check.limit, used, check.requested, &resetsAt)
+			}
+			increments[key] = check.requested
+		}
+	}
+
+	return fiat, s.reserve(increments), nil
+}
+
+// reserve adds the withdrawal to the cached running totals.
+func (s *SpendingPolicyService) reserve(increments map[string]int64) *SpendingReservation {
+	reservation := &SpendingReservation{redis: s.redis, keys: make(map[string]int64)}
+	if s.redis == nil {
+		return reservation
+	}
+
+	ctx := context.Background()
+	for key, amount := range increments {
+		if amount == 0 {
+			continue
+		}
+		err := incrIfCached.Run(ctx, s.redis, []string{key}, amount).Err()
+		if err == nil {
+			reservation.keys[key] = amount
+			continue
+		}
+		if !errors.Is(err, redis.Nil) {
+			// The total may now be short; drop it so the next check
+			// rebuilds it from the database.
+			logger.Log.Error("Redis error updating spending total %s: %v", key, err)
+			s.redis.Del(ctx, key)
+		}
+	}
+	return reservation
+}
+
+// used returns the running-total key for the rule's scope, period and unit
+// and how much has been withdrawn against it in the current period. The
+// cached total is used when there is one; otherwise the withdrawals are
+// summed and the cache is seeded. totals caches the sums across the rules
+// of one check.
+func (s *SpendingPolicyService) used(txRepo repo.TransactionRepository, totals map[string]models.WithdrawalTotals, user *models.User, wallet *models.Wallet, rule models.SpendingRule, unit string, now time.Time) (string, int64, error) {
+	scopeID := user.ID
+	walletFilter := uint(0)
+	if rule.Scope == models.LimitScopeWallet {
+		scopeID = wallet.ID
+		walletFilter = wallet.ID
+	}
+	start := periodStart(rule.Period, now)
+	key := spendingKey(rule.Scope, scopeID, rule.Period, start, unit)
+
+	ctx := context.Background()
+	if s.redis != nil {
+		cached, err := s.redis.Get(ctx, key).Int64()
+		if err == nil {
+			return key, cached, nil
+		}
+		if !errors.Is(err, redis.Nil) {
+			logger.Log.Warn("Redis error reading spending total %s, using the database: %v", key, err)
+		}
+	}
+
+	sumKey := fmt.Sprintf("%d:%s", walletFilter, rule.Period)
+	sum, ok := totals[sumKey]
+	if !ok {
+		var err error
+		sum, err = txRepo.SumWithdrawals(user.ID, walletFilter, start)
+		if err != nil {
+			logger.Log.Error("Error summing withdrawals for user %d: %v", user.ID, err)
+			return "", 0, ErrLimitsUnavailable
+		}
+		totals[sumKey] = sum
+	}
+
+	used := sum.Amount
+	if unit == limitUnitFiat {
+		used = sum.FiatCents
+		if sum.UnpricedAmount > 0 {
+			// Withdrawals made without a rate are valued at the current one.
+			unpriced, err := s.rates.FiatCents(wallet.Currency, sum.UnpricedAmount)
+			if err != nil {
+				return "", 0, ErrLimitsUnavailable
+			}
+			used += unpriced
+		}
+	}
+
+	if s.redis != nil {
+		ttl := time.Until(periodEnd(rule.Period, now))
+		if err := s.redis.SetNX(ctx, key, used, ttl).Err(); err != nil {
+			logger.Log.Warn("Redis error seeding spending total %s: %v", key, err)
+		}
+	}
+	return key, used, nil
+}
+
+// ForgetWithdrawal drops the cached running totals a withdrawal counted
+// toward, so a failed one stops counting. They are rebuilt from the
+// database, which leaves failed withdrawals out.
+func (s *SpendingPolicyService) ForgetWithdrawal(tx *models.Transaction) {
+	if s.redis == nil {
+		return
+	}
+
+	created := tx.CreatedAt.UTC()
+	var keys []string
+	for _, period := range []string{models.LimitPeriodDaily, models.LimitPeriodMonthly} {
+		start := periodStart(period, created)
+		for _, unit := range []string{limitUnitBase, limitUnitFiat} {
+			keys = append(keys,
+				spendingKey(models.LimitScopeUser, tx.UserID, period, start, unit),
+				spendingKey(models.LimitScopeWallet, tx.WalletID, period, start, unit))
+		}
+	}
+
+	if err := s.redis.Del(context.Background(), keys...).Err(); err != nil {
+		logger.Log.Error("Failed to drop spending totals for transaction %d: %v", tx.ID, err)
+	}
+}
+
+func spendingKey(scope string, scopeID uint, period string, start time.Time, unit string) string {
+	return fmt.Sprintf("spend:%s:%d:%s:%s:%s", scope, scopeID, period, start.Format("20060102"), unit)
+}
+
+// effectiveRules returns the tier rules for the user with any active
+// overrides applied. Wallet-specific overrides win over user-wide ones.
+func (s *SpendingPolicyService) effectiveRules(user *models.User, wallet *models.Wallet) ([]models.SpendingRule, error) {
+	tier := user.Tier
+	if tier == "" {
+		tier = models.UserTierStandard
+	}
+
+	rules, err := s.limitRepo.ListRulesForTier(tier, wallet.Currency)
+	if err != nil {
+		logger.Log.Error("Error loading spending rules for tier %s: %v", tier, err)
+		return nil, ErrLimitsUnavailable
+	}
+
+	overrides, err := s.limitRepo.ActiveOverrides(user.ID, time.Now())
+	if err != nil {
+		logger.Log.Error("Error loading spending overrides for user %d: %v", user.ID, err)
+		return nil, ErrLimitsUnavailable
+	}
+
+	type ruleKey struct{ scope, period string }
+	byKey := make(map[ruleKey]models.SpendingRule)
+	for _, rule := range rules {
+		byKey[ruleKey{rule.Scope, rule.Period}] = rule
+	}
+
+	walletSpecific := make(map[ruleKey]bool)
+	for _, o := range overrides {
+		if o.WalletID != nil && *o.WalletID != wallet.ID {
+			continue
+		}
+
+		k := ruleKey{o.Scope, o.Period}
+		if walletSpecific[k] {
+			continue
+		}
+		if o.WalletID != nil {
+			walletSpecific[k] = true
+		}
+
+		byKey[k] = models.SpendingRule{
+			Tier:         tier,
+			Currency:     wallet.Currency,
+			Scope:        o.Scope,
+			Period:       o.Period,
+			MaxAmount:    o.MaxAmount,
+			MaxFiatCents: o.MaxFiatCents,
+		}
+	}
+
+	effective := make([]models.SpendingRule, 0, len(byKey))
+	for _, rule := range byKey {
+		effective = append(effective, rule)
+	}
+	return effective, nil
+}
+
+func limitExceeded(rule models.SpendingRule, unit string, limit, used, requested int64, resetsAt *time.Time) *LimitExceededError {
+	remaining := limit - used
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &LimitExceededError{Detail: models.LimitExceededDetail{
+		Scope:     rule.Scope,
+		Period:    rule.Period,
+		Unit:      unit,
+		Limit:     limit,
+		Used:      used,
+		Requested: requested,
+		Remaining: remaining,
+		ResetsAt:  resetsAt,
+	}}
+}
+
+func validateLimitShape(scope, period string) error {
+	if scope != models.LimitScopeUser && scope != models.LimitScopeWallet {
+		return fmt.Errorf("scope must be %q or %q", models.LimitScopeUser, models.LimitScopeWallet)
+	}
+
+	switch period {
+	case models.LimitPeriodTransaction, models.LimitPeriodDaily, models.LimitPeriodMonthly:
+		return nil
+	}
+	return fmt.Errorf("period must be %q, %q or %q", models.LimitPeriodTransaction, models.LimitPeriodDaily, models.LimitPeriodMonthly)
+}
+
+func periodStart(period string, now time.Time) time.Time {
+	if period == models.LimitPeriodMonthly {
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func periodEnd(period string, now time.Time) time.Time {
+	start := periodStart(period, now)
+	if period == models.LimitPeriodMonthly {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
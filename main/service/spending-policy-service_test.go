@@ -0,0 +1,170 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"gorm.io/gorm"
+)
+
+// memLimits is an in-memory repo.SpendingLimitRepository.
+type memLimits struct {
+	rules     []models.SpendingRule
+	overrides []models.SpendingLimitOverride
+}
+
+func (r *memLimits) ListRules() ([]models.SpendingRule, error) { return r.rules, nil }
+func (r *memLimits) CountRules() (int64, error)                { return int64(len(r.rules)), nil }
+func (r *memLimits) DeleteOverride(uint) error                 { return nil }
+
+func (r *memLimits) ListRulesForTier(tier, currency string) ([]models.SpendingRule, error) {
+	var rules []models.SpendingRule
+	for _, rule := range r.rules {
+		if rule.Tier == tier && rule.Currency == currency {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+func (r *memLimits) UpsertRule(rule *models.SpendingRule) error {
+	r.rules = append(r.rules, *rule)
+	return nil
+}
+
+func (r *memLimits) CreateOverride(override *models.SpendingLimitOverride) error {
+	override.ID = uint(len(r.overrides) + 1)
+	r.overrides = append(r.overrides, *override)
+	return nil
+}
+
+func (r *memLimits) ActiveOverrides(userID uint, now time.Time) ([]models.SpendingLimitOverride, error) {
+	var active []models.SpendingLimitOverride
+	for _, o := range r.overrides {
+		if o.UserID == userID && (o.ExpiresAt == nil || o.ExpiresAt.After(now)) {
+			active = append(active, o)
+		}
+	}
+	return active, nil
+}
+
+// memTransactions sums withdrawals like the SQL in the postgres repository.
+type memTransactions struct {
+	repo.TransactionRepository
+	txs []models.Transaction
+}
+
+func (r *memTransactions) WithTx(*gorm.DB) repo.TransactionRepository { return r }
+
+func (r *memTransactions) SumWithdrawals(userID, walletID uint, since time.Time) (models.WithdrawalTotals, error) {
+	var totals models.WithdrawalTotals
+	for _, tx := range r.txs {
+		if tx.UserID != userID || tx.Type != models.TransactionTypeSend || tx.Status == models.TransactionStatusFailed ||
+			tx.CreatedAt.Before(since) || (walletID != 0 && tx.WalletID != walletID) {
+			continue
+		}
+		totals.Amount += tx.Amount
+		totals.FiatCents += tx.FiatCents
+		if tx.FiatCents == 0 {
+			totals.UnpricedAmount += tx.Amount
+		}
+	}
+	return totals, nil
+}
+
+// centsPerCoin values one whole coin at a fixed number of cents.
+type centsPerCoin int64
+
+func (c centsPerCoin) FiatCents(_ string, amount int64) (int64, error) {
+	return amount * int64(c) / 1_00000000, nil
+}
+
+func newSpendingTest(t *testing.T, rules ...models.SpendingRule) (*SpendingPolicyService, *memTransactions, *memLimits) {
+	t.Helper()
+	limits := &memLimits{rules: rules}
+	txs := &memTransactions{}
+	return NewSpendingPolicyService(limits, txs, nil, centsPerCoin(100_00)), txs, limits
+}
+
+func dailyRule(maxAmount, maxFiatCents int64) models.SpendingRule {
+	return models.SpendingRule{
+		Tier:         models.UserTierStandard,
+		Currency:     "LTC",
+		Scope:        models.LimitScopeUser,
+		Period:       models.LimitPeriodDaily,
+		MaxAmount:    maxAmount,
+		MaxFiatCents: maxFiatCents,
+	}
+}
+
+func TestCheckWithdrawalCountsEarlierSends(t *testing.T) {
+	s, txs, _ := newSpendingTest(t, dailyRule(10_00000000, 0))
+	user := &models.User{ID: 1}
+	wallet := &models.Wallet{ID: 1, Currency: "LTC"}
+
+	fiat, _, err := s.CheckWithdrawal(nil, user, wallet, 6_00000000)
+	if err != nil {
+		t.Fatalf("CheckWithdrawal: %v", err)
+	}
+	if fiat != 600_00 {
+		t.Errorf("fiat value = %d, want 60000", fiat)
+	}
+	txs.txs = append(txs.txs, models.Transaction{
+		UserID: 1, WalletID: 1, Type: models.TransactionTypeSend, Status: models.TransactionStatusPending,
+		Amount: 6_00000000, FiatCents: fiat, CreatedAt: time.Now(),
+	})
+
+	var limitErr *LimitExceededError
+	if _, _, err := s.CheckWithdrawal(nil, user, wallet, 6_00000000); !errors.As(err, &limitErr) {
+		t.Fatalf("second withdrawal error = %v, want LimitExceededError", err)
+	}
+	if limitErr.Detail.Used != 6_00000000 || limitErr.Detail.Remaining != 4_00000000 {
+		t.Errorf("limit detail = %+v", limitErr.Detail)
+	}
+
+	// A failed withdrawal stops counting.
+	txs.txs[0].Status = models.TransactionStatusFailed
+	if _, _, err := s.CheckWithdrawal(nil, user, wallet, 6_00000000); err != nil {
+		t.Fatalf("withdrawal after the earlier one failed: %v", err)
+	}
+}
+
+func TestCheckWithdrawalUsesStoredFiatValues(t *testing.T) {
+	s, txs, _ := newSpendingTest(t, dailyRule(0, 1_000_00))
+	user := &models.User{ID: 1}
+	wallet := &models.Wallet{ID: 1, Currency: "LTC"}
+
+	// 900.00 spent when the coin was worth more than it is now.
+	txs.txs = append(txs.txs, models.Transaction{
+		UserID: 1, WalletID: 1, Type: models.TransactionTypeSend, Status: models.TransactionStatusConfirmed,
+		Amount: 3_00000000, FiatCents: 900_00, CreatedAt: time.Now(),
+	})
+
+	var limitErr *LimitExceededError
+	if _, _, err := s.CheckWithdrawal(nil, user, wallet, 2_00000000); !errors.As(err, &limitErr) {
+		t.Fatalf("CheckWithdrawal error = %v, want LimitExceededError", err)
+	}
+	if limitErr.Detail.Used != 900_00 {
+		t.Errorf("used = %d, want the stored 90000", limitErr.Detail.Used)
+	}
+}
+
+func TestCreateOverrideRejectsZeroMaxAmount(t *testing.T) {
+	s, _, limits := newSpendingTest(t)
+
+	_, err := s.CreateOverride(&models.SpendingLimitOverrideRequest{
+		UserID: 1,
+		Scope:  models.LimitScopeUser,
+		Period: models.LimitPeriodDaily,
+		Reason: "block withdrawals",
+	}, "admin@example.com")
+	if err == nil {
+		t.Fatal("override with max_amount 0 was accepted")
+	}
+	if len(limits.overrides) != 0 {
+		t.Error("override was stored")
+	}
+}
@@ -0,0 +1,239 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+	"gorm.io/gorm"
+)
+
+const tokenVersionCacheTTL = 5 * time.Minute
+
+var ErrRevocationUnavailable = errors.New("token revocation is temporarily unavailable")
+
+// TokenRevocationService decides whether an otherwise valid access token may
+// still be used. Single tokens are denylisted by jti in Redis until they
+// expire; all of a user's tokens are invalidated at once by bumping the
+// token version stored on the user. Revoking a session denylists its ID for
+// as long as access tokens issued for it can live.
+type TokenRevocationService struct {
+	userRepo    repo.UserRepository
+	refreshRepo repo.RefreshTokenRepository
+	sessionRepo repo.SessionRepository
+	apiKeyRepo  repo.APIKeyRepository
+	transactor  repo.Transactor
+	redis       *redis.Client
+}
+
+func NewTokenRevocationService(userRepo repo.UserRepository, refreshRepo repo.RefreshTokenRepository, sessionRepo repo.SessionRepository, apiKeyRepo repo.APIKeyRepository, transactor repo.Transactor, redisClient *redis.Client) *TokenRevocationService {
+	return &TokenRevocationService{
+		userRepo:    userRepo,
+		refreshRepo: refreshRepo,
+		sessionRepo: sessionRepo,
+		apiKeyRepo:  apiKeyRepo,
+		transactor:  transactor,
+		redis:       redisClient,
+	}
+}
+
+// IsRevoked is called by AuthMiddleware on every authenticated request.
+func (s *TokenRevocationService) IsRevoked(claims *utils.Claims) (bool, error) {
+	// Tokens issued before revocation support have no jti and can't be
+	// denylisted individually, so they are no longer accepted.
+	if claims.ID == "" {
+		return true, nil
+	}
+
+	if s.redis != nil {
+		keys := []string{revokedTokenKey(claims.ID)}
+		if claims.SessionID != 0 {
+			keys = append(keys, revokedSessionKey(claims.SessionID))
+		}
+
+		denied, err := s.redis.Exists(context.Background(), keys...).Result()
+		if err != nil {
+			return true, err
+		}
+		if denied > 0 {
+			return true, nil
+		}
+	}
+
+	version, err := s.tokenVersion(claims.UserID)
+	if err != nil {
+		return true, err
+	}
+
+	return claims.TokenVersion != version, nil
+}
+
+// Logout ends the session the access token belongs to and denylists the
+// token itself. Tokens without a session fall back to revoking the refresh
+// token family passed in, if any.
+func (s *TokenRevocationService) Logout(claims *utils.Claims, refreshToken string) error {
+	if claims.SessionID != 0 {
+		if err := s.RevokeSession(claims.UserID, claims.SessionID); err != nil && !errors.Is(err, ErrSessionNotFound) {
+			return err
+		}
+	} else if refreshToken != "" {
+		token, err := s.refreshRepo.GetByHashForUpdate(utils.HashToken(refreshToken))
+		if err == nil && token.UserID == claims.UserID {
+			if err := s.refreshRepo.RevokeFamily(token.FamilyID, time.Now()); err != nil {
+				logger.Log.Error("Error revoking refresh tokens on logout for user %d: %v", claims.UserID, err)
+				return fmt.Errorf("failed to log out")
+			}
+		}
+	}
+
+	return s.RevokeToken(claims)
+}
+
+// RevokeSession signs a single device out: its refresh tokens stop working
+// and access tokens issued for it are rejected.
+func (s *TokenRevocationService) RevokeSession(userID, sessionID uint) error {
+	now := time.Now()
+
+	err := s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		session, err := s.sessionRepo.WithTx(tx).GetByID(sessionID, userID)
+		if err != nil {
+			return ErrSessionNotFound
+		}
+		if err := s.sessionRepo.WithTx(tx).Revoke(session.ID, now); err != nil {
+			return err
+		}
+		return s.refreshRepo.WithTx(tx).RevokeFamily(session.FamilyID, now)
+	})
+	if errors.Is(err, ErrSessionNotFound) {
+		return err
+	}
+	if err != nil {
+		logger.Log.Error("Error revoking session %d for user %d: %v", sessionID, userID, err)
+		return fmt.Errorf("failed to revoke session")
+	}
+
+	if s.redis == nil {
+		logger.Log.Warn("Redis unavailable, access tokens for session %d stay valid until they expire", sessionID)
+		return nil
+	}
+
+	if err := s.redis.Set(context.Background(), revokedSessionKey(sessionID), 1, utils.AccessTokenTTL()).Err(); err != nil {
+		logger.Log.Error("Error denylisting session %d: %v", sessionID, err)
+		return ErrRevocationUnavailable
+	}
+
+	return nil
+}
+
+func (s *TokenRevocationService) RevokeToken(claims *utils.Claims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+
+	ttl := time.Until(claims.ExpiresAt.Time)
+	if ttl <= 0 {
+		return nil
+	}
+
+	if s.redis == nil {
+		return ErrRevocationUnavailable
+	}
+
+	if err := s.redis.Set(context.Background(), revokedTokenKey(claims.ID), 1, ttl).Err(); err != nil {
+		logger.Log.Error("Error denylisting token for user %d: %v", claims.UserID, err)
+		return ErrRevocationUnavailable
+	}
+
+	return nil
+}
+
+// LogoutAll invalidates every access and refresh token and every API key the
+// user holds.
+func (s *TokenRevocationService) LogoutAll(userID uint) error {
+	err := s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		return s.RevokeAllTx(tx, userID)
+	})
+	if err != nil {
+		logger.Log.Error("Error revoking all tokens for user %d: %v", userID, err)
+		return fmt.Errorf("failed to log out of all sessions")
+	}
+
+	return s.ClearCachedVersion(userID)
+}
+
+// RevokeAllTx invalidates every token, session and API key of the user
+// inside an existing transaction. Callers must call ClearCachedVersion after commit.
+func (s *TokenRevocationService) RevokeAllTx(tx *gorm.DB, userID uint) error {
+	now := time.Now()
+	if err := s.userRepo.WithTx(tx).IncrementTokenVersion(userID); err != nil {
+		return err
+	}
+	if err := s.sessionRepo.WithTx(tx).RevokeAllForUser(userID, now); err != nil {
+		return err
+	}
+	if err := s.refreshRepo.WithTx(tx).RevokeAllForUser(userID, now); err != nil {
+		return err
+	}
+	return s.apiKeyRepo.WithTx(tx).RevokeAllForUser(userID, now)
+}
+
+// ClearCachedVersion drops the cached token version so a bump made by
+// RevokeAllTx takes effect immediately.
+func (s *TokenRevocationService) ClearCachedVersion(userID uint) error {
+	if s.redis == nil {
+		return nil
+	}
+
+	if err := s.redis.Del(context.Background(), tokenVersionKey(userID)).Err(); err != nil {
+		logger.Log.Error("Error clearing cached token version for user %d: %v", userID, err)
+		return ErrRevocationUnavailable
+	}
+
+	return nil
+}
+
+func (s *TokenRevocationService) tokenVersion(userID uint) (int, error) {
+	ctx := context.Background()
+
+	if s.redis != nil {
+		cached, err := s.redis.Get(ctx, tokenVersionKey(userID)).Result()
+		if err == nil {
+			if version, err := strconv.Atoi(cached); err == nil {
+				return version, nil
+			}
+		} else if err != redis.Nil {
+			logger.Log.Warn("Error reading cached token version for user %d: %v", userID, err)
+		}
+	}
+
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if s.redis != nil {
+		if err := s.redis.Set(ctx, tokenVersionKey(userID), user.TokenVersion, tokenVersionCacheTTL).Err(); err != nil {
+			logger.Log.Warn("Error caching token version for user %d: %v", userID, err)
+		}
+	}
+
+	return user.TokenVersion, nil
+}
+
+func revokedTokenKey(jti string) string {
+	return "auth:revoked:" + jti
+}
+
+func revokedSessionKey(sessionID uint) string {
+	return fmt.Sprintf("auth:revoked_session:%d", sessionID)
+}
+
+func tokenVersionKey(userID uint) string {
+	return fmt.Sprintf("auth:token_version:%d", userID)
+}
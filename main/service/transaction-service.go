@@ -0,0 +1,205 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrTransactionNotFound     = errors.New("transaction not found")
+	ErrInvalidStatusTransition = errors.New("invalid status transition")
+)
+
+type TransactionService struct {
+	txRepo         repo.TransactionRepository
+	outboxRepo     repo.OutboxRepository
+	transactor     repo.Transactor
+	spendingPolicy *SpendingPolicyService
+	topic          string
+}
+
+func NewTransactionService(txRepo repo.TransactionRepository, outboxRepo repo.OutboxRepository, transactor repo.Transactor, spendingPolicy *SpendingPolicyService) *TransactionService {
+	return &TransactionService{
+		txRepo:         txRepo,
+		outboxRepo:     outboxRepo,
+		transactor:     transactor,
+		spendingPolicy: spendingPolicy,
+		topic:          utils.GetENV("KAFKA_TOPIC_TRANSACTIONS", "transactions"),
+	}
+}
+
+func (s *TransactionService) CreateTransaction(tx *models.Transaction) error {
+	err := s.transactor.WithinTransaction(func(db *gorm.DB) error {
+		return s.CreateTransactionTx(db, tx)
+	})
+	if err != nil {
+		logger.Log.Error("Error creating transaction: %v", err)
+		return fmt.Errorf("failed to create transaction")
+	}
+
+	return nil
+}
+
+// CreateTransactionTx records tx and its events inside an existing database
+// transaction, for callers that need to write other rows atomically with it.
+func (s *TransactionService) CreateTransactionTx(db *gorm.DB, tx *models.Transaction) error {
+	if tx.Status == "" {
+		tx.Status = models.TransactionStatusPending
+	}
+
+	if err := s.txRepo.WithTx(db).Create(tx); err != nil {
+		return err
+	}
+
+	msgs, err := s.outboxMessages(models.TransactionEventCreated, "", tx)
+	if err != nil {
+		return err
+	}
+
+	return s.enqueue(db, msgs)
+}
+
+// UpdateStatus applies a status change reported by the chain watcher and
+// publishes it. It is the only place a transaction's status changes after
+// creation. Updates that don't change anything are ignored so replays from
+// the watcher are harmless.
+func (s *TransactionService) UpdateStatus(id uint, update models.TransactionStatusUpdate) (*models.Transaction, string, error) {
+	var (
+		tx       *models.Transaction
+		previous string
+	)
+
+	err := s.transactor.WithinTransaction(func(db *gorm.DB) error {
+		txRepo := s.txRepo.WithTx(db)
+
+		var err error
+		tx, err = txRepo.GetByIDForUpdate(id)
+		if err != nil {
+			return ErrTransactionNotFound
+		}
+
+		previous = tx.Status
+		if !models.CanTransition(previous, update.Status) {
+			return fmt.Errorf("%w: %s to %s", ErrInvalidStatusTransition, previous, update.Status)
+		}
+		if previous == update.Status && tx.Confirmations == update.Confirmations && (update.TxHash == "" || update.TxHash == tx.TxHash) {
+			return nil
+		}
+
+		tx.Status = update.Status
+		tx.Confirmations = update.Confirmations
+		if update.TxHash != "" {
+			tx.TxHash = update.TxHash
+		}
+		if update.FailureReason != "" {
+			tx.FailureReason = update.FailureReason
+		}
+
+		msgs, err := s.outboxMessages(models.TransactionEventStatusChanged, previous, tx)
+		if err != nil {
+			return err
+		}
+		if err := txRepo.Update(tx); err != nil {
+			return err
+		}
+		return s.enqueue(db, msgs)
+	})
+	if err != nil {
+		if errors.Is(err, ErrTransactionNotFound) || errors.Is(err, ErrInvalidStatusTransition) {
+			return nil, "", err
+		}
+		logger.Log.Error("Error updating transaction %d: %v", id, err)
+		return nil, "", fmt.Errorf("failed to update transaction")
+	}
+
+	// A failed send no longer counts toward spending limits.
+	if previous != tx.Status && tx.Status == models.TransactionStatusFailed && tx.Type == models.TransactionTypeSend {
+		s.spendingPolicy.ForgetWithdrawal(tx)
+	}
+
+	return tx, previous, nil
+}
+
+func (s *TransactionService) ListUserTransactions(userID uint, limit, offset int) ([]models.Transaction, error) {
+	return s.txRepo.ListByUser(userID, limit, offset)
+}
+
+// outboxMessages builds the Kafka event and any webhook notifications for a
+// transaction state change.
+func (s *TransactionService) outboxMessages(eventType, previousStatus string, tx *models.Transaction) ([]*models.OutboxMessage, error) {
+	event := models.TransactionEvent{
+		SchemaVersion:  models.TransactionEventSchemaVersion,
+		EventID:        uuid.NewString(),
+		EventType:      eventType,
+		OccurredAt:     time.Now().UTC(),
+		PreviousStatus: previousStatus,
+		Transaction:    *tx,
+	}
+
+	value, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	published, err := NewOutboxMessage(models.OutboxKindEvent, models.EventPayload{
+		Topic: s.topic,
+		Key:   strconv.FormatUint(uint64(tx.WalletID), 10),
+		Value: string(value),
+		Headers: map[string]string{
+			"event_type":     eventType,
+			"schema_version": strconv.Itoa(models.TransactionEventSchemaVersion),
+			"content_type":   "application/json",
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := []*models.OutboxMessage{published}
+
+	var webhookEvent string
+	switch {
+	case eventType == models.TransactionEventCreated && tx.Type == models.TransactionTypeReceive:
+		webhookEvent = models.WebhookEventDepositDetected
+	case previousStatus != tx.Status && tx.Status == models.TransactionStatusConfirmed:
+		webhookEvent = models.WebhookEventTransactionConfirmed
+	case previousStatus != tx.Status && tx.Status == models.TransactionStatusFailed:
+		webhookEvent = models.WebhookEventTransactionFailed
+	}
+
+	if webhookEvent != "" {
+		notify, err := NewOutboxMessage(models.OutboxKindWebhook, models.WebhookPayload{
+			EventID:    "evt_" + event.EventID,
+			OccurredAt: event.OccurredAt,
+			UserID:     tx.UserID,
+			EventType:  webhookEvent,
+			Data:       tx,
+		})
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, notify)
+	}
+
+	return msgs, nil
+}
+
+func (s *TransactionService) enqueue(db *gorm.DB, msgs []*models.OutboxMessage) error {
+	outbox := s.outboxRepo.WithTx(db)
+	for _, msg := range msgs {
+		if err := outbox.Enqueue(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/inlovewithgo/transit-backend/main/models"
@@ -12,12 +13,16 @@ import (
 
 type WaitlistService struct {
 	waitlistRepo repo.WaitlistRepository
+	outboxRepo   repo.OutboxRepository
+	transactor   repo.Transactor
 	mailService  *MailService
 }
 
-func NewWaitlistService(waitlistRepo repo.WaitlistRepository, mailService *MailService) *WaitlistService {
+func NewWaitlistService(waitlistRepo repo.WaitlistRepository, outboxRepo repo.OutboxRepository, transactor repo.Transactor, mailService *MailService) *WaitlistService {
 	return &WaitlistService{
 		waitlistRepo: waitlistRepo,
+		outboxRepo:   outboxRepo,
+		transactor:   transactor,
 		mailService:  mailService,
 	}
 }
@@ -66,7 +71,23 @@ func (ws *WaitlistService) JoinWaitlist(email, ipAddress, userAgent string) (*mo
 		waitlistEntry.Status = "subscribed"
 	}
 
-	err = ws.waitlistRepo.Create(waitlistEntry)
+	err = ws.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		if err := ws.waitlistRepo.WithTx(tx).Create(waitlistEntry); err != nil {
+			return err
+		}
+
+		if waitlistEntry.Status != "subscribed" {
+			return nil
+		}
+
+		confirmation, err := NewOutboxMessage(models.OutboxKindWaitlistConfirmation, models.EmailRecipientPayload{
+			Email: email,
+		})
+		if err != nil {
+			return err
+		}
+		return ws.outboxRepo.WithTx(tx).Enqueue(confirmation)
+	})
 	if err != nil {
 		logger.Log.Error("Failed to save waitlist entry: %v", err)
 		return &models.WaitlistResponse{
@@ -75,14 +96,6 @@ func (ws *WaitlistService) JoinWaitlist(email, ipAddress, userAgent string) (*mo
 		}, err
 	}
 
-	if waitlistEntry.Status == "subscribed" {
-		go func() {
-			if err := ws.mailService.SendWaitlistConfirmationEmail(email); err != nil {
-				logger.Log.Error("Failed to send confirmation email to %s: %v", email, err)
-			}
-		}()
-	}
-
 	response := &models.WaitlistResponse{
 		Email: email,
 	}
@@ -111,6 +124,15 @@ func (ws *WaitlistService) GetWaitlistStats() (map[string]interface{}, error) {
 	}, nil
 }
 
+func (ws *WaitlistService) ListEntries() ([]models.Waitlist, error) {
+	entries, err := ws.waitlistRepo.GetAll()
+	if err != nil {
+		logger.Log.Error("Error listing waitlist entries: %v", err)
+		return nil, fmt.Errorf("failed to list waitlist entries")
+	}
+	return entries, nil
+}
+
 func isValidEmail(email string) bool {
 	if len(email) < 5 || len(email) > 254 {
 		return false
@@ -1 +1,166 @@
-package service
\ No newline at end of file
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrWalletNotFound = errors.New("wallet not found")
+	// API key sends skip step-up, so they may only go to addresses the user
+	// already approved with one.
+	ErrAPIKeySendNeedsAllowlist = errors.New("sending with an API key requires the withdrawal allowlist to be enabled")
+)
+
+type WalletService struct {
+	walletRepo         repo.WalletRepository
+	userRepo           repo.UserRepository
+	transactor         repo.Transactor
+	transactionService *TransactionService
+	spendingPolicy     *SpendingPolicyService
+	allowlist          *WithdrawalAllowlistService
+	outboxRepo         repo.OutboxRepository
+}
+
+func NewWalletService(walletRepo repo.WalletRepository, userRepo repo.UserRepository, transactor repo.Transactor, transactionService *TransactionService, spendingPolicy *SpendingPolicyService, allowlist *WithdrawalAllowlistService, outboxRepo repo.OutboxRepository) *WalletService {
+	return &WalletService{
+		walletRepo:         walletRepo,
+		userRepo:           userRepo,
+		transactor:         transactor,
+		transactionService: transactionService,
+		spendingPolicy:     spendingPolicy,
+		allowlist:          allowlist,
+		outboxRepo:         outboxRepo,
+	}
+}
+
+func (s *WalletService) ListWallets(userID uint) ([]models.Wallet, error) {
+	return s.walletRepo.ListByUser(userID)
+}
+
+// Send validates a withdrawal against the address allowlist and spending
+// policy, reserves the balance and records a pending send transaction.
+// Signing and broadcasting happen asynchronously once the transaction is
+// picked up by the chain client. The user is alerted by email, and by SMS
+// if they opted in. Sends made with an API key are refused unless the
+// allowlist is active.
+func (s *WalletService) Send(userID, walletID uint, req *models.SendRequest, viaAPIKey bool) (*models.Transaction, error) {
+	req.ToAddress = strings.TrimSpace(req.ToAddress)
+	if req.ToAddress == "" {
+		return nil, errors.New("destination address is required")
+	}
+	if req.Amount <= 0 {
+		return nil, errors.New("amount must be greater than zero")
+	}
+
+	wallet, err := s.walletRepo.GetUserWallet(walletID, userID)
+	if err != nil {
+		return nil, ErrWalletNotFound
+	}
+
+	if req.ToAddress == wallet.Address {
+		return nil, errors.New("cannot send to the same wallet")
+	}
+
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		logger.Log.Error("Error loading user %d for send: %v", userID, err)
+		return nil, fmt.Errorf("failed to process withdrawal")
+	}
+
+	if viaAPIKey && !allowlistActive(user, time.Now()) {
+		return nil, ErrAPIKeySendNeedsAllowlist
+	}
+	if err := s.allowlist.CheckDestination(user, wallet.Currency, req.ToAddress); err != nil {
+		return nil, err
+	}
+
+	tx := &models.Transaction{
+		UserID:      userID,
+		WalletID:    wallet.ID,
+		Type:        models.TransactionTypeSend,
+		Status:      models.TransactionStatusPending,
+		Currency:    wallet.Currency,
+		Amount:      req.Amount,
+		FromAddress: wallet.Address,
+		ToAddress:   req.ToAddress,
+	}
+
+	var reservation *SpendingReservation
+	err = s.transactor.WithinTransaction(func(db *gorm.DB) error {
+		// Locking the user makes their withdrawals check spending limits
+		// one at a time.
+		locked, err := s.userRepo.WithTx(db).GetUserByIDForUpdate(userID)
+		if err != nil {
+			return err
+		}
+		fiat, held, err := s.spendingPolicy.CheckWithdrawal(db, locked, wallet, req.Amount)
+		if err != nil {
+			return err
+		}
+		tx.FiatCents = fiat
+		reservation = held
+
+		if err := s.walletRepo.WithTx(db).DebitBalance(wallet.ID, req.Amount); err != nil {
+			return err
+		}
+		if err := s.transactionService.CreateTransactionTx(db, tx); err != nil {
+			return err
+		}
+		return s.enqueueWithdrawalAlerts(db, user, tx)
+	})
+	if err != nil {
+		reservation.Release()
+		var limitErr *LimitExceededError
+		if errors.As(err, &limitErr) || errors.Is(err, ErrLimitsUnavailable) || errors.Is(err, repo.ErrInsufficientBalance) {
+			return nil, err
+		}
+		logger.Log.Error("Error recording withdrawal from wallet %d: %v", wallet.ID, err)
+		return nil, fmt.Errorf("failed to process withdrawal")
+	}
+
+	logger.Log.Info("Withdrawal %d of %d %s queued from wallet %d", tx.ID, tx.Amount, tx.Currency, wallet.ID)
+	return tx, nil
+}
+
+// enqueueWithdrawalAlerts queues one alert per channel the user gets
+// withdrawal alerts on.
+func (s *WalletService) enqueueWithdrawalAlerts(db *gorm.DB, user *models.User, tx *models.Transaction) error {
+	alert := models.WithdrawalAlertPayload{
+		Channel:       models.NotifyChannelEmail,
+		Email:         user.Email,
+		FirstName:     user.FirstName,
+		TransactionID: tx.ID,
+		Amount:        formatCoinAmount(tx.Currency, tx.Amount),
+		Currency:      tx.Currency,
+		ToAddress:     tx.ToAddress,
+	}
+	alerts := []models.WithdrawalAlertPayload{alert}
+
+	if user.Preferences.SMSWithdrawalAlerts && user.PhoneVerified() {
+		sms := alert
+		sms.Channel = models.NotifyChannelSMS
+		sms.Email = ""
+		sms.PhoneNumber = user.PhoneNumber
+		alerts = append(alerts, sms)
+	}
+
+	outbox := s.outboxRepo.WithTx(db)
+	for _, payload := range alerts {
+		msg, err := NewOutboxMessage(models.OutboxKindWithdrawalAlert, payload)
+		if err != nil {
+			return err
+		}
+		if err := outbox.Enqueue(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+)
+
+type oneWallet struct {
+	repo.WalletRepository
+	wallet models.Wallet
+}
+
+func (w oneWallet) GetUserWallet(id, userID uint) (*models.Wallet, error) {
+	if id != w.wallet.ID || userID != w.wallet.UserID {
+		return nil, errors.New("wallet not found")
+	}
+	found := w.wallet
+	return &found, nil
+}
+
+// noAddresses is an allowlist with no entries.
+type noAddresses struct {
+	repo.WithdrawalAddressRepository
+}
+
+func (noAddresses) FindByAddress(uint, string, string) (*models.WithdrawalAddress, error) {
+	return nil, errors.New("withdrawal address not found")
+}
+
+func TestAPIKeySendNeedsAllowlist(t *testing.T) {
+	user := &models.User{ID: 1, Email: "a@example.com", IsActive: true}
+	users := newMemUsers(user)
+	wallets := oneWallet{wallet: models.Wallet{ID: 1, UserID: 1, Currency: "LTC", Address: "ltc1from", Balance: 10_00000000}}
+	allowlist := NewWithdrawalAllowlistService(noAddresses{}, users, &memOutboxRepo{}, noTx{})
+	s := NewWalletService(wallets, users, noTx{}, nil, nil, allowlist, &memOutboxRepo{})
+
+	req := &models.SendRequest{ToAddress: "ltc1to", Amount: 1_00000000}
+	if _, err := s.Send(1, 1, req, true); !errors.Is(err, ErrAPIKeySendNeedsAllowlist) {
+		t.Fatalf("API key send without an allowlist: error = %v, want ErrAPIKeySendNeedsAllowlist", err)
+	}
+
+	if err := users.update(1, func(u *models.User) { u.WithdrawalAllowlistEnabled = true }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Send(1, 1, req, true); !errors.Is(err, ErrDestinationNotAllowlisted) {
+		t.Fatalf("API key send to an unlisted address: error = %v, want ErrDestinationNotAllowlisted", err)
+	}
+}
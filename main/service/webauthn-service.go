@@ -0,0 +1,537 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+	"gorm.io/gorm"
+)
+
+const (
+	webAuthnChallengeTTL  = 5 * time.Minute
+	webAuthnHandleLength  = 32
+	maxPasskeysPerUser    = 10
+	maxPasskeyNameLength  = 64
+	defaultPasskeyName    = "Passkey"
+	webAuthnDisplayFormat = "%s %s"
+)
+
+var (
+	ErrInvalidWebAuthnChallenge = errors.New("invalid or expired passkey challenge")
+	ErrPasskeyVerification      = errors.New("passkey verification failed")
+	// ErrPasskeyCloned is returned when the signature counter went backwards,
+	// which means the key may have been copied.
+	ErrPasskeyCloned   = errors.New("this passkey looks cloned and can't be used; sign in another way and remove it")
+	ErrPasskeyNotFound = errors.New("passkey not found")
+	ErrTooManyPasskeys = fmt.Errorf("you can register at most %d passkeys", maxPasskeysPerUser)
+)
+
+// WebAuthnService registers passkeys and verifies them for passwordless
+// login or as the second factor of a password login.
+type WebAuthnService struct {
+	webAuthn     *webauthn.WebAuthn
+	userRepo     repo.UserRepository
+	webAuthnRepo repo.WebAuthnRepository
+	transactor   repo.Transactor
+	auth         *AuthService
+}
+
+func NewWebAuthnService(userRepo repo.UserRepository, webAuthnRepo repo.WebAuthnRepository, transactor repo.Transactor, auth *AuthService) (*WebAuthnService, error) {
+	baseURL := strings.TrimRight(utils.GetENV("APP_BASE_URL", "http://localhost:3030"), "/")
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid APP_BASE_URL: %w", err)
+	}
+
+	var origins []string
+	for _, origin := range strings.Split(utils.GetENV("WEBAUTHN_RP_ORIGINS", parsed.Scheme+"://"+parsed.Host), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:                  utils.GetENV("WEBAUTHN_RP_ID", parsed.Hostname()),
+		RPDisplayName:         utils.GetENV("WEBAUTHN_RP_NAME", "Transit"),
+		RPOrigins:             origins,
+		AttestationPreference: protocol.PreferNoAttestation,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationPreferred,
+		},
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: webAuthnChallengeTTL, TimeoutUVD: webAuthnChallengeTTL},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: webAuthnChallengeTTL, TimeoutUVD: webAuthnChallengeTTL},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebAuthn configuration: %w", err)
+	}
+
+	return &WebAuthnService{
+		webAuthn:     wa,
+		userRepo:     userRepo,
+		webAuthnRepo: webAuthnRepo,
+		transactor:   transactor,
+		auth:         auth,
+	}, nil
+}
+
+// webAuthnUser adapts a user and their passkeys to webauthn.User.
+type webAuthnUser struct {
+	user  *models.User
+	creds []models.WebAuthnCredential
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return u.user.WebAuthnHandle
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return strings.TrimSpace(fmt.Sprintf(webAuthnDisplayFormat, u.user.FirstName, u.user.LastName))
+}
+
+func (u *webAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	creds := make([]webauthn.Credential, len(u.creds))
+	for i, c := range u.creds {
+		transports := make([]protocol.AuthenticatorTransport, len(c.Transports))
+		for j, t := range c.Transports {
+			transports[j] = protocol.AuthenticatorTransport(t)
+		}
+		creds[i] = webauthn.Credential{
+			ID:              c.CredentialID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				UserPresent:    true,
+				UserVerified:   c.UserVerified,
+				BackupEligible: c.BackupEligible,
+				BackupState:    c.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: c.SignCount,
+			},
+		}
+	}
+	return creds
+}
+
+func (u *webAuthnUser) credential(id []byte) *models.WebAuthnCredential {
+	for i := range u.creds {
+		if bytes.Equal(u.creds[i].CredentialID, id) {
+			return &u.creds[i]
+		}
+	}
+	return nil
+}
+
+func (s *WebAuthnService) loadUser(user *models.User) (*webAuthnUser, error) {
+	creds, err := s.webAuthnRepo.ListCredentialsByUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &webAuthnUser{user: user, creds: creds}, nil
+}
+
+// ListCredentials returns the user's passkeys.
+func (s *WebAuthnService) ListCredentials(userID uint) ([]models.WebAuthnCredential, error) {
+	creds, err := s.webAuthnRepo.ListCredentialsByUser(userID)
+	if err != nil {
+		logger.Log.Error("Error listing passkeys for user %d: %v", userID, err)
+		return nil, fmt.Errorf("failed to list passkeys")
+	}
+	return creds, nil
+}
+
+// DeleteCredential removes one of the user's passkeys.
+func (s *WebAuthnService) DeleteCredential(userID, id uint) error {
+	deleted, err := s.webAuthnRepo.DeleteCredential(id, userID)
+	if err != nil {
+		logger.Log.Error("Error deleting passkey %d of user %d: %v", id, userID, err)
+		return fmt.Errorf("failed to delete passkey")
+	}
+	if !deleted {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+// BeginRegistration starts adding a passkey to the signed-in user's account.
+func (s *WebAuthnService) BeginRegistration(userID uint) (*models.WebAuthnBeginResponse, error) {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	if len(user.WebAuthnHandle) == 0 {
+		handle := make([]byte, webAuthnHandleLength)
+		if _, err := rand.Read(handle); err != nil {
+			logger.Log.Error("Error generating passkey user handle: %v", err)
+			return nil, fmt.Errorf("failed to start passkey registration")
+		}
+		if err := s.userRepo.SetWebAuthnHandle(user.ID, handle); err != nil {
+			logger.Log.Error("Error storing passkey user handle for user %d: %v", user.ID, err)
+			return nil, fmt.Errorf("failed to start passkey registration")
+		}
+		// Re-read in case a concurrent request set the handle first.
+		if user, err = s.userRepo.GetUserByID(userID); err != nil {
+			return nil, ErrUserNotFound
+		}
+	}
+
+	waUser, err := s.loadUser(user)
+	if err != nil {
+		logger.Log.Error("Error loading passkeys for user %d: %v", user.ID, err)
+		return nil, fmt.Errorf("failed to start passkey registration")
+	}
+	if len(waUser.creds) >= maxPasskeysPerUser {
+		return nil, ErrTooManyPasskeys
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(waUser.creds))
+	for _, cred := range waUser.WebAuthnCredentials() {
+		exclusions = append(exclusions, cred.Descriptor())
+	}
+
+	options, session, err := s.webAuthn.BeginRegistration(waUser, webauthn.WithExclusions(exclusions))
+	if err != nil {
+		logger.Log.Error("Error starting passkey registration: %v", err)
+		return nil, fmt.Errorf("failed to start passkey registration")
+	}
+
+	return s.saveChallenge(user.ID, models.WebAuthnCeremonyRegistration, session, options)
+}
+
+// FinishRegistration verifies the authenticator's response and stores the
+// new passkey.
+func (s *WebAuthnService) FinishRegistration(userID uint, req *models.WebAuthnFinishRequest) (*models.WebAuthnCredential, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = defaultPasskeyName
+	}
+	if len(name) > maxPasskeyNameLength {
+		name = name[:maxPasskeyNameLength]
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(req.Credential))
+	if err != nil {
+		return nil, ErrPasskeyVerification
+	}
+
+	var stored *models.WebAuthnCredential
+	err = s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		session, err := s.consumeChallenge(tx, req.ChallengeID, models.WebAuthnCeremonyRegistration, userID)
+		if err != nil {
+			return err
+		}
+
+		user, err := s.userRepo.WithTx(tx).GetUserByID(userID)
+		if err != nil {
+			return ErrUserNotFound
+		}
+		waUser := &webAuthnUser{user: user}
+
+		cred, err := s.webAuthn.CreateCredential(waUser, *session, parsed)
+		if err != nil {
+			logger.Log.Warn("Passkey registration failed for user %d: %v", userID, err)
+			return ErrPasskeyVerification
+		}
+
+		transports := make([]string, len(cred.Transport))
+		for i, t := range cred.Transport {
+			transports[i] = string(t)
+		}
+		stored = &models.WebAuthnCredential{
+			UserID:          userID,
+			Name:            name,
+			CredentialID:    cred.ID,
+			PublicKey:       cred.PublicKey,
+			AttestationType: cred.AttestationType,
+			Transports:      transports,
+			AAGUID:          cred.Authenticator.AAGUID,
+			SignCount:       cred.Authenticator.SignCount,
+			UserVerified:    cred.Flags.UserVerified,
+			BackupEligible:  cred.Flags.BackupEligible,
+			BackupState:     cred.Flags.BackupState,
+		}
+		return s.webAuthnRepo.WithTx(tx).CreateCredential(stored)
+	})
+	if errors.Is(err, ErrInvalidWebAuthnChallenge) || errors.Is(err, ErrPasskeyVerification) || errors.Is(err, ErrUserNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		logger.Log.Error("Error registering passkey for user %d: %v", userID, err)
+		return nil, fmt.Errorf("failed to register passkey")
+	}
+
+	logger.Log.Info("User %d registered passkey %d", userID, stored.ID)
+	return stored, nil
+}
+
+// BeginLogin starts a passwordless login. The browser offers any passkey it
+// holds for this site, so no email is needed and none is revealed.
+func (s *WebAuthnService) BeginLogin() (*models.WebAuthnBeginResponse, error) {
+	options, session, err := s.webAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		logger.Log.Error("Error starting passkey login: %v", err)
+		return nil, fmt.Errorf("failed to start passkey login")
+	}
+
+	return s.saveChallenge(0, models.WebAuthnCeremonyLogin, session, options)
+}
+
+// FinishLogin signs the user in with a passkey. User verification is
+// required, so the passkey alone counts as two factors and no further MFA
+// challenge is issued.
+func (s *WebAuthnService) FinishLogin(req *models.WebAuthnFinishRequest, client models.ClientInfo) (*models.AuthResponse, error) {
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(req.Credential))
+	if err != nil {
+		return nil, ErrPasskeyVerification
+	}
+
+	user, err := s.verifyAssertion(req.ChallengeID, models.WebAuthnCeremonyLogin, 0, parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.auth.completeLogin(user, client, models.LoginMethodPasskey)
+}
+
+// BeginMFA starts a passkey assertion that answers the MFA challenge of a
+// password login.
+func (s *WebAuthnService) BeginMFA(mfaToken string) (*models.WebAuthnBeginResponse, error) {
+	user, err := s.auth.mfaChallengeUser(mfaToken)
+	if err != nil {
+		return nil, err
+	}
+
+	waUser, err := s.loadUser(user)
+	if err != nil {
+		logger.Log.Error("Error loading passkeys for user %d: %v", user.ID, err)
+		return nil, fmt.Errorf("failed to start passkey verification")
+	}
+	if len(waUser.creds) == 0 {
+		return nil, ErrPasskeyNotFound
+	}
+
+	options, session, err := s.webAuthn.BeginLogin(waUser)
+	if err != nil {
+		logger.Log.Error("Error starting passkey verification: %v", err)
+		return nil, fmt.Errorf("failed to start passkey verification")
+	}
+
+	return s.saveChallenge(user.ID, models.WebAuthnCeremonyMFA, session, options)
+}
+
+// FinishMFA completes a password login paused for a second factor.
+func (s *WebAuthnService) FinishMFA(req *models.WebAuthnFinishRequest, client models.ClientInfo) (*models.AuthResponse, error) {
+	user, err := s.auth.mfaChallengeUser(req.MFAToken)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(req.Credential))
+	if err != nil {
+		return nil, ErrPasskeyVerification
+	}
+
+	if _, err := s.verifyAssertion(req.ChallengeID, models.WebAuthnCeremonyMFA, user.ID, parsed); err != nil {
+		if !errors.Is(err, ErrInvalidWebAuthnChallenge) {
+			s.auth.recordLoginFailure(user, client, models.AuditActionMFAFailed, models.LoginMethodPasskeyMFA)
+		}
+		return nil, err
+	}
+
+	return s.auth.completeLogin(user, client, models.LoginMethodPasskeyMFA)
+}
+
+// BeginStepUp starts a passkey assertion that steps up the caller's
+// session.
+func (s *WebAuthnService) BeginStepUp(claims *utils.Claims) (*models.WebAuthnBeginResponse, error) {
+	user, err := s.auth.stepUpUser(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	waUser, err := s.loadUser(user)
+	if err != nil {
+		logger.Log.Error("Error loading passkeys for user %d: %v", user.ID, err)
+		return nil, fmt.Errorf("failed to start passkey verification")
+	}
+	if len(waUser.creds) == 0 {
+		return nil, ErrPasskeyNotFound
+	}
+
+	options, session, err := s.webAuthn.BeginLogin(waUser)
+	if err != nil {
+		logger.Log.Error("Error starting passkey verification: %v", err)
+		return nil, fmt.Errorf("failed to start passkey verification")
+	}
+
+	return s.saveChallenge(user.ID, models.WebAuthnCeremonyStepUp, session, options)
+}
+
+// FinishStepUp steps up the caller's session with a passkey assertion.
+func (s *WebAuthnService) FinishStepUp(claims *utils.Claims, req *models.WebAuthnFinishRequest) (*models.StepUpResponse, error) {
+	user, err := s.auth.stepUpUser(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(req.Credential))
+	if err != nil {
+		return nil, ErrPasskeyVerification
+	}
+
+	if _, err := s.verifyAssertion(req.ChallengeID, models.WebAuthnCeremonyStepUp, user.ID, parsed); err != nil {
+		return nil, err
+	}
+
+	return s.auth.issueStepUp(user, claims.SessionID)
+}
+
+// verifyAssertion consumes the challenge, checks the assertion and the
+// signature counter, and records the use. userID is 0 for discoverable
+// logins, where the user is found from the credential's user handle.
+func (s *WebAuthnService) verifyAssertion(challengeID, ceremony string, userID uint, parsed *protocol.ParsedCredentialAssertionData) (*models.User, error) {
+	var (
+		waUser *webAuthnUser
+		cloned bool
+	)
+	err := s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		session, err := s.consumeChallenge(tx, challengeID, ceremony, userID)
+		if err != nil {
+			return err
+		}
+
+		var cred *webauthn.Credential
+		if userID == 0 {
+			cred, err = s.webAuthn.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+				user, err := s.userRepo.WithTx(tx).GetUserByWebAuthnHandle(userHandle)
+				if err != nil {
+					return nil, err
+				}
+				waUser, err = s.loadUser(user)
+				return waUser, err
+			}, *session, parsed)
+		} else {
+			var user *models.User
+			if user, err = s.userRepo.WithTx(tx).GetUserByID(userID); err != nil {
+				return ErrPasskeyVerification
+			}
+			if waUser, err = s.loadUser(user); err != nil {
+				return err
+			}
+			cred, err = s.webAuthn.ValidateLogin(waUser, *session, parsed)
+		}
+		if err != nil {
+			logger.Log.Warn("Passkey assertion failed: %v", err)
+			return ErrPasskeyVerification
+		}
+		if !waUser.user.IsActive {
+			return ErrPasskeyVerification
+		}
+
+		stored := waUser.credential(cred.ID)
+		if stored == nil {
+			return ErrPasskeyVerification
+		}
+		if cred.Authenticator.CloneWarning {
+			cloned = true
+			return nil
+		}
+
+		return s.webAuthnRepo.WithTx(tx).UpdateCredentialUse(stored.ID, cred.Authenticator.SignCount, cred.Flags.BackupState, time.Now())
+	})
+	if errors.Is(err, ErrInvalidWebAuthnChallenge) || errors.Is(err, ErrPasskeyVerification) {
+		return nil, err
+	}
+	if err != nil {
+		logger.Log.Error("Error verifying passkey: %v", err)
+		return nil, fmt.Errorf("failed to verify passkey")
+	}
+	// The challenge stays consumed; the stored counter is left as it was.
+	if cloned {
+		logger.Log.Warn("Passkey signature counter went backwards for user %d, rejecting", waUser.user.ID)
+		return nil, ErrPasskeyCloned
+	}
+
+	return waUser.user, nil
+}
+
+func (s *WebAuthnService) saveChallenge(userID uint, ceremony string, session *webauthn.SessionData, options interface{}) (*models.WebAuthnBeginResponse, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		logger.Log.Error("Error encoding passkey challenge: %v", err)
+		return nil, fmt.Errorf("failed to start passkey ceremony")
+	}
+
+	challengeID, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		logger.Log.Error("Error generating passkey challenge ID: %v", err)
+		return nil, fmt.Errorf("failed to start passkey ceremony")
+	}
+
+	if err := s.webAuthnRepo.CreateChallenge(&models.WebAuthnChallenge{
+		TokenHash:   utils.HashToken(challengeID),
+		UserID:      userID,
+		Ceremony:    ceremony,
+		SessionData: string(data),
+		ExpiresAt:   time.Now().Add(webAuthnChallengeTTL),
+	}); err != nil {
+		logger.Log.Error("Error storing passkey challenge: %v", err)
+		return nil, fmt.Errorf("failed to start passkey ceremony")
+	}
+
+	return &models.WebAuthnBeginResponse{
+		ChallengeID: challengeID,
+		Options:     options,
+	}, nil
+}
+
+// consumeChallenge marks the challenge used and returns its session data. It
+// must match the ceremony and, for registration and MFA, the user.
+func (s *WebAuthnService) consumeChallenge(tx *gorm.DB, challengeID, ceremony string, userID uint) (*webauthn.SessionData, error) {
+	if challengeID == "" {
+		return nil, ErrInvalidWebAuthnChallenge
+	}
+
+	challenges := s.webAuthnRepo.WithTx(tx)
+	challenge, err := challenges.GetChallengeByHashForUpdate(utils.HashToken(challengeID))
+	if err != nil || challenge.UsedAt != nil || challenge.Ceremony != ceremony ||
+		challenge.UserID != userID || time.Now().After(challenge.ExpiresAt) {
+		return nil, ErrInvalidWebAuthnChallenge
+	}
+
+	if err := challenges.MarkChallengeUsed(challenge.ID, time.Now()); err != nil {
+		return nil, err
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(challenge.SessionData), &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
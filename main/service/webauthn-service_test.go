@@ -0,0 +1,511 @@
+package service
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	testRPID   = "transit.test"
+	testOrigin = "https://transit.test"
+)
+
+// memWebAuthnRepo is an in-memory repo.WebAuthnRepository.
+type memWebAuthnRepo struct {
+	mu         sync.Mutex
+	creds      []models.WebAuthnCredential
+	challenges []models.WebAuthnChallenge
+}
+
+func (r *memWebAuthnRepo) WithTx(*gorm.DB) repo.WebAuthnRepository { return r }
+
+func (r *memWebAuthnRepo) CreateCredential(cred *models.WebAuthnCredential) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cred.ID = uint(len(r.creds) + 1)
+	r.creds = append(r.creds, *cred)
+	return nil
+}
+
+func (r *memWebAuthnRepo) ListCredentialsByUser(userID uint) ([]models.WebAuthnCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var creds []models.WebAuthnCredential
+	for _, c := range r.creds {
+		if c.UserID == userID {
+			creds = append(creds, c)
+		}
+	}
+	return creds, nil
+}
+
+func (r *memWebAuthnRepo) CountCredentialsByUser(userID uint) (int64, error) {
+	creds, err := r.ListCredentialsByUser(userID)
+	return int64(len(creds)), err
+}
+
+func (r *memWebAuthnRepo) UpdateCredentialUse(id uint, signCount uint32, backupState bool, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.creds {
+		if r.creds[i].ID == id {
+			r.creds[i].SignCount = signCount
+			r.creds[i].BackupState = backupState
+			r.creds[i].LastUsedAt = &at
+		}
+	}
+	return nil
+}
+
+func (r *memWebAuthnRepo) DeleteCredential(id, userID uint) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, c := range r.creds {
+		if c.ID == id && c.UserID == userID {
+			r.creds = append(r.creds[:i], r.creds[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memWebAuthnRepo) CreateChallenge(challenge *models.WebAuthnChallenge) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	challenge.ID = uint(len(r.challenges) + 1)
+	r.challenges = append(r.challenges, *challenge)
+	return nil
+}
+
+func (r *memWebAuthnRepo) GetChallengeByHashForUpdate(hash string) (*models.WebAuthnChallenge, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.challenges {
+		if c.TokenHash == hash {
+			found := c
+			return &found, nil
+		}
+	}
+	return nil, errors.New("challenge not found")
+}
+
+func (r *memWebAuthnRepo) MarkChallengeUsed(id uint, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.challenges {
+		if r.challenges[i].ID == id {
+			r.challenges[i].UsedAt = &at
+		}
+	}
+	return nil
+}
+
+// softAuthenticator is a P-256 passkey that answers WebAuthn ceremonies the
+// way a platform authenticator would, with "none" attestation.
+type softAuthenticator struct {
+	key       *ecdsa.PrivateKey
+	credID    []byte
+	handle    []byte
+	signCount uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credID := make([]byte, 16)
+	rand.Read(credID)
+	return &softAuthenticator{key: key, credID: credID, signCount: 1}
+}
+
+var b64 = base64.RawURLEncoding
+
+// options reads the challenge and, for registration, the user handle from
+// the options the server sent.
+func options(t *testing.T, begin *models.WebAuthnBeginResponse) (challenge string, userHandle []byte) {
+	t.Helper()
+	raw, err := json.Marshal(begin.Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var opts struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+			User      struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"publicKey"`
+	}
+	if err := json.Unmarshal(raw, &opts); err != nil {
+		t.Fatal(err)
+	}
+	if opts.PublicKey.User.ID != "" {
+		if userHandle, err = b64.DecodeString(opts.PublicKey.User.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return opts.PublicKey.Challenge, userHandle
+}
+
+func (a *softAuthenticator) clientData(ceremony, challenge string) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    testOrigin,
+	})
+	return data
+}
+
+func (a *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	var data bytes.Buffer
+	data.Write(rpIDHash[:])
+	data.WriteByte(flags)
+	binary.Write(&data, binary.BigEndian, a.signCount)
+	data.Write(attested)
+	return data.Bytes()
+}
+
+// create answers navigator.credentials.create().
+func (a *softAuthenticator) create(t *testing.T, begin *models.WebAuthnBeginResponse) json.RawMessage {
+	t.Helper()
+	challenge, handle := options(t, begin)
+	a.handle = handle
+
+	coseKey, err := cbor.Marshal(map[int]interface{}{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+		-3: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var attested bytes.Buffer
+	attested.Write(make([]byte, 16)) // AAGUID
+	binary.Write(&attested, binary.BigEndian, uint16(len(a.credID)))
+	attested.Write(a.credID)
+	attested.Write(coseKey)
+
+	// User present, user verified, attested credential data included.
+	attestation, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(0x45, attested.Bytes()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.credential(map[string]string{
+		"clientDataJSON":    b64.EncodeToString(a.clientData("webauthn.create", challenge)),
+		"attestationObject": b64.EncodeToString(attestation),
+	})
+}
+
+// get answers navigator.credentials.get().
+func (a *softAuthenticator) get(t *testing.T, begin *models.WebAuthnBeginResponse) json.RawMessage {
+	t.Helper()
+	challenge, _ := options(t, begin)
+
+	clientData := a.clientData("webauthn.get", challenge)
+	authData := a.authData(0x05, nil) // user present and verified
+	clientHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.credential(map[string]string{
+		"clientDataJSON":    b64.EncodeToString(clientData),
+		"authenticatorData": b64.EncodeToString(authData),
+		"signature":         b64.EncodeToString(signature),
+		"userHandle":        b64.EncodeToString(a.handle),
+	})
+}
+
+func (a *softAuthenticator) credential(response map[string]string) json.RawMessage {
+	raw, _ := json.Marshal(map[string]interface{}{
+		"id":       b64.EncodeToString(a.credID),
+		"rawId":    b64.EncodeToString(a.credID),
+		"type":     "public-key",
+		"response": response,
+	})
+	return raw
+}
+
+type webAuthnTest struct {
+	service *WebAuthnService
+	users   *memUsers
+	repo    *memWebAuthnRepo
+	stores  *memAuthRepos
+}
+
+func newWebAuthnTest(t *testing.T, users ...*models.User) *webAuthnTest {
+	t.Helper()
+	t.Setenv("APP_BASE_URL", testOrigin)
+
+	userRepo := newMemUsers(users...)
+	webAuthnRepo := &memWebAuthnRepo{}
+	auth, stores := newTestAuthService(t, userRepo, webAuthnRepo)
+
+	s, err := NewWebAuthnService(userRepo, webAuthnRepo, noTx{}, auth)
+	if err != nil {
+		t.Fatalf("NewWebAuthnService: %v", err)
+	}
+	return &webAuthnTest{service: s, users: userRepo, repo: webAuthnRepo, stores: stores}
+}
+
+// register adds a passkey for userID and returns its authenticator.
+func (w *webAuthnTest) register(t *testing.T, userID uint) *softAuthenticator {
+	t.Helper()
+	authenticator := newSoftAuthenticator(t)
+
+	begin, err := w.service.BeginRegistration(userID)
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+	cred, err := w.service.FinishRegistration(userID, &models.WebAuthnFinishRequest{
+		ChallengeID: begin.ChallengeID,
+		Name:        "Laptop",
+		Credential:  authenticator.create(t, begin),
+	})
+	if err != nil {
+		t.Fatalf("FinishRegistration: %v", err)
+	}
+	if !bytes.Equal(cred.CredentialID, authenticator.credID) || cred.SignCount != 1 {
+		t.Fatalf("stored credential doesn't match the authenticator: %+v", cred)
+	}
+	return authenticator
+}
+
+func (w *webAuthnTest) login(t *testing.T, authenticator *softAuthenticator) (*models.AuthResponse, error) {
+	t.Helper()
+	begin, err := w.service.BeginLogin()
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	return w.service.FinishLogin(&models.WebAuthnFinishRequest{
+		ChallengeID: begin.ChallengeID,
+		Credential:  authenticator.get(t, begin),
+	}, models.ClientInfo{})
+}
+
+func activeUser(email string) *models.User {
+	return &models.User{Email: email, FirstName: "Ada", IsActive: true}
+}
+
+func TestWebAuthnRegisterAndLogin(t *testing.T) {
+	w := newWebAuthnTest(t, activeUser("ada@example.com"))
+	authenticator := w.register(t, 1)
+
+	authenticator.signCount = 2
+	resp, err := w.login(t, authenticator)
+	if err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	if resp.AccessToken == "" || resp.RefreshToken == "" || resp.User.ID != 1 {
+		t.Fatalf("login didn't start a session for user 1: %+v", resp)
+	}
+	if got := w.repo.creds[0].SignCount; got != 2 {
+		t.Errorf("stored sign count = %d, want 2", got)
+	}
+	if actions := w.stores.auditActions(); len(actions) != 1 || actions[0] != models.AuditActionLogin {
+		t.Errorf("audit actions = %v, want one login", actions)
+	}
+}
+
+func TestWebAuthnChallengeCannotBeReused(t *testing.T) {
+	w := newWebAuthnTest(t, activeUser("ada@example.com"))
+
+	authenticator := newSoftAuthenticator(t)
+	begin, err := w.service.BeginRegistration(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &models.WebAuthnFinishRequest{ChallengeID: begin.ChallengeID, Credential: authenticator.create(t, begin)}
+	if _, err := w.service.FinishRegistration(1, req); err != nil {
+		t.Fatalf("FinishRegistration: %v", err)
+	}
+	if _, err := w.service.FinishRegistration(1, req); !errors.Is(err, ErrInvalidWebAuthnChallenge) {
+		t.Fatalf("second FinishRegistration error = %v, want ErrInvalidWebAuthnChallenge", err)
+	}
+
+	login, err := w.service.BeginLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator.signCount = 2
+	assertion := &models.WebAuthnFinishRequest{ChallengeID: login.ChallengeID, Credential: authenticator.get(t, login)}
+	if _, err := w.service.FinishLogin(assertion, models.ClientInfo{}); err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	if _, err := w.service.FinishLogin(assertion, models.ClientInfo{}); !errors.Is(err, ErrInvalidWebAuthnChallenge) {
+		t.Fatalf("replayed FinishLogin error = %v, want ErrInvalidWebAuthnChallenge", err)
+	}
+}
+
+func TestWebAuthnChallengeBoundToUserAndCeremony(t *testing.T) {
+	w := newWebAuthnTest(t, activeUser("ada@example.com"), activeUser("bob@example.com"))
+	authenticator := w.register(t, 1)
+
+	// A registration challenge issued to user 1 can't be finished by user 2.
+	begin, err := w.service.BeginRegistration(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newSoftAuthenticator(t)
+	if _, err := w.service.FinishRegistration(2, &models.WebAuthnFinishRequest{
+		ChallengeID: begin.ChallengeID,
+		Credential:  other.create(t, begin),
+	}); !errors.Is(err, ErrInvalidWebAuthnChallenge) {
+		t.Fatalf("FinishRegistration by another user error = %v, want ErrInvalidWebAuthnChallenge", err)
+	}
+
+	// A registration challenge can't be used to log in.
+	begin, err = w.service.BeginRegistration(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator.signCount = 2
+	if _, err := w.service.FinishLogin(&models.WebAuthnFinishRequest{
+		ChallengeID: begin.ChallengeID,
+		Credential:  authenticator.get(t, begin),
+	}, models.ClientInfo{}); !errors.Is(err, ErrInvalidWebAuthnChallenge) {
+		t.Fatalf("FinishLogin with a registration challenge error = %v, want ErrInvalidWebAuthnChallenge", err)
+	}
+
+	// An MFA challenge for user 1 can't answer user 2's MFA challenge.
+	w.users.update(1, func(u *models.User) { u.TOTPEnabled = true })
+	w.users.update(2, func(u *models.User) { u.TOTPEnabled = true })
+	adaStart, err := w.service.auth.StartLogin(mustUser(t, w.users, 1), models.ClientInfo{}, models.LoginMethodPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobStart, err := w.service.auth.StartLogin(mustUser(t, w.users, 2), models.ClientInfo{}, models.LoginMethodPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfa, err := w.service.BeginMFA(adaStart.MFAToken)
+	if err != nil {
+		t.Fatalf("BeginMFA: %v", err)
+	}
+	if _, err := w.service.FinishMFA(&models.WebAuthnFinishRequest{
+		ChallengeID: mfa.ChallengeID,
+		MFAToken:    bobStart.MFAToken,
+		Credential:  authenticator.get(t, mfa),
+	}, models.ClientInfo{}); !errors.Is(err, ErrInvalidWebAuthnChallenge) {
+		t.Fatalf("FinishMFA with another user's challenge error = %v, want ErrInvalidWebAuthnChallenge", err)
+	}
+
+	// Nor can user 1's own registration challenge finish their MFA.
+	begin, err = w.service.BeginRegistration(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.service.FinishMFA(&models.WebAuthnFinishRequest{
+		ChallengeID: begin.ChallengeID,
+		MFAToken:    adaStart.MFAToken,
+		Credential:  authenticator.get(t, begin),
+	}, models.ClientInfo{}); !errors.Is(err, ErrInvalidWebAuthnChallenge) {
+		t.Fatalf("FinishMFA with a registration challenge error = %v, want ErrInvalidWebAuthnChallenge", err)
+	}
+	if len(w.stores.sessions) != 0 {
+		t.Errorf("a session was started by a mismatched ceremony")
+	}
+}
+
+func TestWebAuthnRejectsClonedAuthenticator(t *testing.T) {
+	w := newWebAuthnTest(t, activeUser("ada@example.com"))
+	authenticator := w.register(t, 1)
+
+	authenticator.signCount = 5
+	if _, err := w.login(t, authenticator); err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+
+	// A copy of the key that is behind on its counter.
+	authenticator.signCount = 3
+	if _, err := w.login(t, authenticator); !errors.Is(err, ErrPasskeyCloned) {
+		t.Fatalf("FinishLogin with a lower counter error = %v, want ErrPasskeyCloned", err)
+	}
+	if got := w.repo.creds[0].SignCount; got != 5 {
+		t.Errorf("stored sign count = %d after a clone warning, want it left at 5", got)
+	}
+	if len(w.stores.sessions) != 1 {
+		t.Errorf("got %d sessions, want only the first login's", len(w.stores.sessions))
+	}
+}
+
+func TestWebAuthnStepUp(t *testing.T) {
+	w := newWebAuthnTest(t, activeUser("ada@example.com"), activeUser("bob@example.com"))
+	authenticator := w.register(t, 1)
+
+	if _, err := w.service.BeginStepUp(&utils.Claims{UserID: 2}); !errors.Is(err, ErrPasskeyNotFound) {
+		t.Fatalf("BeginStepUp without passkeys error = %v, want ErrPasskeyNotFound", err)
+	}
+
+	claims := &utils.Claims{UserID: 1, SessionID: 7}
+	begin, err := w.service.BeginStepUp(claims)
+	if err != nil {
+		t.Fatalf("BeginStepUp: %v", err)
+	}
+	authenticator.signCount = 2
+	req := &models.WebAuthnFinishRequest{ChallengeID: begin.ChallengeID, Credential: authenticator.get(t, begin)}
+
+	// The challenge can't be used to log in.
+	if _, err := w.service.FinishLogin(req, models.ClientInfo{}); !errors.Is(err, ErrInvalidWebAuthnChallenge) {
+		t.Fatalf("FinishLogin with a step-up challenge error = %v, want ErrInvalidWebAuthnChallenge", err)
+	}
+
+	begin, err = w.service.BeginStepUp(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator.signCount = 3
+	resp, err := w.service.FinishStepUp(claims, &models.WebAuthnFinishRequest{
+		ChallengeID: begin.ChallengeID,
+		Credential:  authenticator.get(t, begin),
+	})
+	if err != nil {
+		t.Fatalf("FinishStepUp: %v", err)
+	}
+
+	stepped, err := utils.ValidateAccessToken(resp.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stepped.UserID != 1 || stepped.SessionID != 7 || stepped.StepUpAt == 0 {
+		t.Errorf("step-up token claims = %+v, want a fresh step-up for session 7", stepped)
+	}
+}
+
+func mustUser(t *testing.T, users *memUsers, id uint) *models.User {
+	t.Helper()
+	user, err := users.GetUserByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
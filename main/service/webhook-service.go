@@ -0,0 +1,436 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+)
+
+const (
+	webhookBatchSize     = 20
+	webhookPollInterval  = 5 * time.Second
+	webhookBaseBackoff   = 30 * time.Second
+	webhookMaxBackoff    = 6 * time.Hour
+	webhookMaxErrorBytes = 512
+	webhookSecretPrefix  = "whsec_"
+)
+
+var (
+	ErrWebhookURLNotAllowed = errors.New("url must be an absolute https URL on a public host")
+	errWebhookTargetBlocked = errors.New("webhook target resolves to a non-public address")
+)
+
+// webhookBlockedPrefixes are non-public ranges that netip's Is* helpers
+// don't cover.
+var webhookBlockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+type WebhookService struct {
+	webhookRepo repo.WebhookRepository
+	httpClient  *http.Client
+	maxAttempts int
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func NewWebhookService(webhookRepo repo.WebhookRepository) *WebhookService {
+	maxAttempts, err := strconv.Atoi(utils.GetENV("WEBHOOK_MAX_ATTEMPTS", "8"))
+	if err != nil || maxAttempts < 1 {
+		maxAttempts = 8
+	}
+
+	timeout, err := strconv.Atoi(utils.GetENV("WEBHOOK_TIMEOUT_SECONDS", "10"))
+	if err != nil || timeout < 1 {
+		timeout = 10
+	}
+
+	return &WebhookService{
+		webhookRepo: webhookRepo,
+		httpClient:  webhookHTTPClient(time.Duration(timeout) * time.Second),
+		maxAttempts: maxAttempts,
+		stop:        make(chan struct{}),
+	}
+}
+
+func (s *WebhookService) CreateSubscription(userID uint, req *models.CreateWebhookRequest) (*models.WebhookSubscriptionResponse, error) {
+	parsed, err := url.Parse(req.URL)
+	if err != nil || !isAllowedWebhookURL(parsed) {
+		return nil, ErrWebhookURLNotAllowed
+	}
+
+	if len(req.Events) == 0 {
+		return nil, errors.New("at least one event type is required")
+	}
+
+	for _, event := range req.Events {
+		if !isKnownWebhookEvent(event) {
+			return nil, fmt.Errorf("unknown event type: %s", event)
+		}
+	}
+
+	secret, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		logger.Log.Error("Error generating webhook secret: %v", err)
+		return nil, fmt.Errorf("failed to generate webhook secret")
+	}
+
+	secret = webhookSecretPrefix + secret
+	encrypted, err := utils.EncryptSecret(secret)
+	if err != nil {
+		logger.Log.Error("Error encrypting webhook secret: %v", err)
+		return nil, fmt.Errorf("failed to generate webhook secret")
+	}
+
+	sub := &models.WebhookSubscription{
+		UserID:   userID,
+		URL:      parsed.String(),
+		Secret:   encrypted,
+		Events:   req.Events,
+		IsActive: true,
+	}
+
+	if err := s.webhookRepo.CreateSubscription(sub); err != nil {
+		logger.Log.Error("Error creating webhook subscription: %v", err)
+		return nil, fmt.Errorf("failed to create webhook")
+	}
+
+	// The secret is only ever returned once, at creation time.
+	return &models.WebhookSubscriptionResponse{
+		WebhookSubscription: *sub,
+		Secret:              secret,
+	}, nil
+}
+
+func (s *WebhookService) ListSubscriptions(userID uint) ([]models.WebhookSubscription, error) {
+	return s.webhookRepo.ListSubscriptions(userID)
+}
+
+func (s *WebhookService) DeleteSubscription(userID, id uint) error {
+	return s.webhookRepo.DeleteSubscription(id, userID)
+}
+
+func (s *WebhookService) ListDeliveries(userID, subscriptionID uint) ([]models.WebhookDelivery, error) {
+	if _, err := s.webhookRepo.GetSubscription(subscriptionID, userID); err != nil {
+		return nil, err
+	}
+	return s.webhookRepo.ListDeliveries(subscriptionID, 100)
+}
+
+// Publish queues an event for every active subscription of the user that
+// listens to eventType. Delivery happens asynchronously from the worker.
+// Publishing the same eventID again only queues it for subscriptions that
+// don't have it yet, so the outbox can safely retry after a partial failure.
+func (s *WebhookService) Publish(eventID string, occurredAt time.Time, userID uint, eventType string, data interface{}) error {
+	subs, err := s.webhookRepo.ListSubscriptionsForEvent(userID, eventType)
+	if err != nil {
+		logger.Log.Error("Error loading webhook subscriptions for user %d: %v", userID, err)
+		return err
+	}
+
+	if len(subs) == 0 {
+		return nil
+	}
+
+	queued, err := s.webhookRepo.ListSubscriptionIDsForEventID(eventID)
+	if err != nil {
+		logger.Log.Error("Error loading deliveries of webhook event %s: %v", eventID, err)
+		return err
+	}
+	done := make(map[uint]bool, len(queued))
+	for _, id := range queued {
+		done[id] = true
+	}
+
+	event := models.WebhookEvent{
+		ID:        eventID,
+		Type:      eventType,
+		CreatedAt: occurredAt.UTC(),
+		Data:      data,
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook event: %w", err)
+	}
+
+	for _, sub := range subs {
+		if done[sub.ID] {
+			continue
+		}
+		delivery := &models.WebhookDelivery{
+			SubscriptionID: sub.ID,
+			EventID:        event.ID,
+			EventType:      eventType,
+			Payload:        string(payload),
+			Status:         models.WebhookDeliveryPending,
+			NextAttemptAt:  time.Now(),
+		}
+		if err := s.webhookRepo.CreateDelivery(delivery); err != nil {
+			logger.Log.Error("Error queueing webhook delivery for subscription %d: %v", sub.ID, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Redeliver queues a fresh copy of a past delivery. The event ID is kept so
+// receivers can de-duplicate.
+func (s *WebhookService) Redeliver(userID, deliveryID uint) (*models.WebhookDelivery, error) {
+	original, err := s.webhookRepo.GetDelivery(deliveryID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.webhookRepo.GetSubscription(original.SubscriptionID, userID); err != nil {
+		return nil, errors.New("delivery not found")
+	}
+
+	delivery := &models.WebhookDelivery{
+		SubscriptionID: original.SubscriptionID,
+		EventID:        original.EventID,
+		EventType:      original.EventType,
+		Payload:        original.Payload,
+		Status:         models.WebhookDeliveryPending,
+		NextAttemptAt:  time.Now(),
+	}
+
+	if err := s.webhookRepo.CreateDelivery(delivery); err != nil {
+		logger.Log.Error("Error queueing webhook redelivery: %v", err)
+		return nil, fmt.Errorf("failed to queue redelivery")
+	}
+
+	return delivery, nil
+}
+
+// StartWorker polls for due deliveries until StopWorker is called.
+func (s *WebhookService) StartWorker() {
+	go func() {
+		ticker := time.NewTicker(webhookPollInterval)
+		defer ticker.Stop()
+
+		logger.Log.Info("Webhook delivery worker started")
+		for {
+			select {
+			case <-s.stop:
+				logger.Log.Info("Webhook delivery worker stopped")
+				return
+			case <-ticker.C:
+				s.ProcessDue()
+			}
+		}
+	}()
+}
+
+func (s *WebhookService) StopWorker() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// ProcessDue attempts up to webhookBatchSize due deliveries. Each one is
+// claimed right before it is sent, so its lease only has to cover a single
+// request and can't run out while earlier ones in the batch are in flight.
+func (s *WebhookService) ProcessDue() {
+	lease := s.httpClient.Timeout * 2
+	for i := 0; i < webhookBatchSize; i++ {
+		deliveries, err := s.webhookRepo.ClaimDueDeliveries(time.Now(), 1, lease)
+		if err != nil {
+			logger.Log.Error("Error claiming webhook deliveries: %v", err)
+			return
+		}
+		if len(deliveries) == 0 {
+			return
+		}
+
+		s.attempt(&deliveries[0])
+	}
+}
+
+func (s *WebhookService) attempt(delivery *models.WebhookDelivery) {
+	delivery.Attempts++
+
+	sub, err := s.webhookRepo.GetSubscriptionByID(delivery.SubscriptionID)
+	if err != nil {
+		// Subscription was removed; nothing left to deliver to.
+		delivery.Status = models.WebhookDeliveryDead
+		delivery.LastError = "subscription no longer exists"
+		s.saveDelivery(delivery)
+		return
+	}
+
+	statusCode, err := s.send(sub, delivery)
+	delivery.LastStatusCode = statusCode
+
+	if err == nil {
+		now := time.Now()
+		delivery.Status = models.WebhookDeliveryDelivered
+		delivery.DeliveredAt = &now
+		delivery.LastError = ""
+		s.saveDelivery(delivery)
+		return
+	}
+
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= s.maxAttempts {
+		delivery.Status = models.WebhookDeliveryDead
+		logger.Log.Warn("Webhook delivery %d moved to dead-letter after %d attempts: %v", delivery.ID, delivery.Attempts, err)
+	} else {
+		delivery.NextAttemptAt = time.Now().Add(webhookBackoff(delivery.Attempts))
+	}
+
+	s.saveDelivery(delivery)
+}
+
+func (s *WebhookService) send(sub *models.WebhookSubscription, delivery *models.WebhookDelivery) (int, error) {
+	secret, err := utils.DecryptSecret(sub.Secret)
+	if err != nil {
+		return 0, fmt.Errorf("unreadable signing secret: %w", err)
+	}
+
+	body := []byte(delivery.Payload)
+	now := time.Now()
+
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Transit-Webhooks/1.0")
+	req.Header.Set("X-Transit-Event", delivery.EventType)
+	req.Header.Set("X-Transit-Event-Id", delivery.EventID)
+	req.Header.Set("X-Transit-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Transit-Timestamp", strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set("X-Transit-Signature", utils.SignWebhookPayload(secret, now, body))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, webhookMaxErrorBytes))
+		return resp.StatusCode, fmt.Errorf("receiver responded with %d: %s", resp.StatusCode, snippet)
+	}
+
+	return resp.StatusCode, nil
+}
+
+func (s *WebhookService) saveDelivery(delivery *models.WebhookDelivery) {
+	if err := s.webhookRepo.UpdateDelivery(delivery); err != nil {
+		logger.Log.Error("Error updating webhook delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// webhookBackoff doubles the wait after every failed attempt, capped at webhookMaxBackoff.
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookBaseBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= webhookMaxBackoff {
+			return webhookMaxBackoff
+		}
+	}
+	return backoff
+}
+
+// isAllowedWebhookURL rejects URLs that obviously point inside the network.
+// Hostnames are checked again after DNS resolution by webhookHTTPClient.
+func isAllowedWebhookURL(u *url.URL) bool {
+	if u.Scheme != "https" || u.User != nil {
+		return false
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "" || host == "localhost" || strings.HasSuffix(host, ".localhost") ||
+		strings.HasSuffix(host, ".local") || strings.HasSuffix(host, ".internal") {
+		return false
+	}
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return isPublicAddr(addr)
+	}
+	return true
+}
+
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range webhookBlockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// webhookHTTPClient only connects to public addresses. The check runs on
+// the address actually dialed, so a hostname that resolves (or re-resolves,
+// as in DNS rebinding) to an internal address is refused too. Proxies and
+// redirects are disabled since they would bypass it.
+func webhookHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || !isPublicAddr(addr) {
+				return errWebhookTargetBlocked
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func isKnownWebhookEvent(event string) bool {
+	for _, known := range models.WebhookEvents {
+		if known == event {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/inlovewithgo/transit-backend/main/models"
+	repo "github.com/inlovewithgo/transit-backend/main/repo/interface"
+	"github.com/inlovewithgo/transit-backend/main/utils"
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+	"gorm.io/gorm"
+)
+
+var ErrDestinationNotAllowlisted = errors.New("destination address is not an active entry on your withdrawal allowlist")
+
+type WithdrawalAllowlistService struct {
+	addressRepo repo.WithdrawalAddressRepository
+	userRepo    repo.UserRepository
+	outboxRepo  repo.OutboxRepository
+	transactor  repo.Transactor
+	cooldown    time.Duration
+	baseURL     string
+}
+
+func NewWithdrawalAllowlistService(addressRepo repo.WithdrawalAddressRepository, userRepo repo.UserRepository, outboxRepo repo.OutboxRepository, transactor repo.Transactor) *WithdrawalAllowlistService {
+	cooldown, err := time.ParseDuration(utils.GetENV("WITHDRAWAL_ALLOWLIST_COOLDOWN", "24h"))
+	if err != nil || cooldown < 0 {
+		logger.Log.Warn("Invalid WITHDRAWAL_ALLOWLIST_COOLDOWN, using 24h")
+		cooldown = 24 * time.Hour
+	}
+
+	return &WithdrawalAllowlistService{
+		addressRepo: addressRepo,
+		userRepo:    userRepo,
+		outboxRepo:  outboxRepo,
+		transactor:  transactor,
+		cooldown:    cooldown,
+		baseURL:     strings.TrimRight(utils.GetENV("APP_BASE_URL", "http://localhost:3030"), "/"),
+	}
+}
+
+func (s *WithdrawalAllowlistService) Status(userID uint) (*models.WithdrawalAllowlistStatus, error) {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses, err := s.addressRepo.ListByUser(userID)
+	if err != nil {
+		logger.Log.Error("Error listing withdrawal addresses for user %d: %v", userID, err)
+		return nil, fmt.Errorf("failed to load withdrawal allowlist")
+	}
+
+	status := &models.WithdrawalAllowlistStatus{
+		Enabled:   allowlistActive(user, time.Now()),
+		Addresses: addresses,
+	}
+	if status.Enabled {
+		status.DisablesAt = user.WithdrawalAllowlistDisablesAt
+	}
+
+	return status, nil
+}
+
+// AddAddress allowlists a destination. It becomes usable after the cooling-off
+// period, and the owner is emailed a link to cancel it in the meantime.
+func (s *WithdrawalAllowlistService) AddAddress(userID uint, req *models.AddWithdrawalAddressRequest) (*models.WithdrawalAddress, error) {
+	address := strings.TrimSpace(req.Address)
+	if address == "" || len(address) > 128 || strings.ContainsAny(address, " \t\r\n") {
+		return nil, errors.New("a valid address is required")
+	}
+
+	currency := strings.ToUpper(strings.TrimSpace(req.Currency))
+	if currency == "" {
+		currency = "LTC"
+	}
+	if _, ok := currencyDecimals[currency]; !ok {
+		return nil, fmt.Errorf("unsupported currency %s", currency)
+	}
+
+	if _, err := s.addressRepo.FindByAddress(userID, currency, address); err == nil {
+		return nil, errors.New("address is already on your allowlist")
+	}
+
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	cancelToken, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		logger.Log.Error("Error generating allowlist cancel token: %v", err)
+		return nil, fmt.Errorf("failed to add address")
+	}
+
+	entry := &models.WithdrawalAddress{
+		UserID:          userID,
+		Currency:        currency,
+		Address:         address,
+		Label:           strings.TrimSpace(req.Label),
+		ActivatesAt:     time.Now().Add(s.cooldown),
+		CancelTokenHash: utils.HashToken(cancelToken),
+	}
+
+	email, err := NewOutboxMessage(models.OutboxKindWithdrawalAddress, models.WithdrawalAddressEmailPayload{
+		Email:       user.Email,
+		FirstName:   user.FirstName,
+		Address:     entry.Address,
+		Label:       entry.Label,
+		ActivatesAt: entry.ActivatesAt,
+		CancelURL:   s.baseURL + "/api/v1/withdrawal-addresses/cancel?token=" + url.QueryEscape(cancelToken),
+	})
+	if err != nil {
+		logger.Log.Error("Error building allowlist email: %v", err)
+		return nil, fmt.Errorf("failed to add address")
+	}
+
+	err = s.transactor.WithinTransaction(func(tx *gorm.DB) error {
+		if err := s.addressRepo.WithTx(tx).Create(entry); err != nil {
+			return err
+		}
+		return s.outboxRepo.WithTx(tx).Enqueue(email)
+	})
+	if err != nil {
+		logger.Log.Error("Error adding withdrawal address for user %d: %v", userID, err)
+		return nil, fmt.Errorf("failed to add address")
+	}
+
+	return entry, nil
+}
+
+// RemoveAddress takes effect immediately since it only narrows where funds can go.
+func (s *WithdrawalAllowlistService) RemoveAddress(userID, id uint) error {
+	return s.addressRepo.Delete(id, userID)
+}
+
+// CancelByToken removes an address using the link from the confirmation email.
+func (s *WithdrawalAllowlistService) CancelByToken(token string) (*models.WithdrawalAddress, error) {
+	if token == "" {
+		return nil, errors.New("invalid or expired cancel link")
+	}
+
+	entry, err := s.addressRepo.GetByCancelTokenHash(utils.HashToken(token))
+	if err != nil {
+		return nil, errors.New("invalid or expired cancel link")
+	}
+
+	if err := s.addressRepo.Delete(entry.ID, entry.UserID); err != nil {
+		logger.Log.Error("Error cancelling withdrawal address %d: %v", entry.ID, err)
+		return nil, fmt.Errorf("failed to cancel address")
+	}
+
+	logger.Log.Info("Withdrawal address %d cancelled via email link for user %d", entry.ID, entry.UserID)
+	return entry, nil
+}
+
+// SetEnabled turns allowlist-only withdrawals on immediately. Turning them
+// off is delayed by the cooling-off period so a stolen session can't simply
+// disable the protection and withdraw.
+func (s *WithdrawalAllowlistService) SetEnabled(userID uint, enabled bool) (*models.WithdrawalAllowlistStatus, error) {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	switch {
+	case enabled:
+		err = s.userRepo.SetWithdrawalAllowlist(userID, true, nil)
+	case allowlistActive(user, now) && user.WithdrawalAllowlistDisablesAt == nil:
+		disablesAt := now.Add(s.cooldown)
+		err = s.userRepo.SetWithdrawalAllowlist(userID, true, &disablesAt)
+	case !allowlistActive(user, now):
+		err = s.userRepo.SetWithdrawalAllowlist(userID, false, nil)
+	}
+	if err != nil {
+		logger.Log.Error("Error updating allowlist setting for user %d: %v", userID, err)
+		return nil, fmt.Errorf("failed to update allowlist setting")
+	}
+
+	return s.Status(userID)
+}
+
+// CheckDestination rejects a withdrawal destination that isn't an active
+// allowlist entry when the user has allowlist-only withdrawals turned on.
+func (s *WithdrawalAllowlistService) CheckDestination(user *models.User, currency, address string) error {
+	now := time.Now()
+	if !allowlistActive(user, now) {
+		return nil
+	}
+
+	entry, err := s.addressRepo.FindByAddress(user.ID, currency, address)
+	if err != nil || !entry.IsActive(now) {
+		return ErrDestinationNotAllowlisted
+	}
+
+	return nil
+}
+
+func allowlistActive(user *models.User, now time.Time) bool {
+	if !user.WithdrawalAllowlistEnabled {
+		return false
+	}
+	return user.WithdrawalAllowlistDisablesAt == nil || now.Before(*user.WithdrawalAllowlistDisablesAt)
+}
@@ -0,0 +1,228 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// accessTokenKey is one asymmetric key used for access tokens. Only the
+// signing key has a private half; keys kept for rotation are public only.
+type accessTokenKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	public  crypto.PublicKey
+	private crypto.Signer
+}
+
+type accessTokenKeySet struct {
+	signing      *accessTokenKey
+	verification map[string]*accessTokenKey
+}
+
+var (
+	accessKeysOnce sync.Once
+	accessKeys     *accessTokenKeySet
+	accessKeysErr  error
+)
+
+// LoadAccessTokenKeys reads JWT_SIGNING_KEY_FILE and JWT_VERIFICATION_KEY_FILES.
+// When no signing key is configured access tokens fall back to HS256 with
+// JWT_SECRET and the JWKS is empty. Call it at startup to fail fast on a bad
+// key; later calls return the cached result.
+func LoadAccessTokenKeys() error {
+	accessKeysOnce.Do(func() {
+		accessKeys, accessKeysErr = loadAccessTokenKeySet()
+	})
+	return accessKeysErr
+}
+
+func loadAccessTokenKeySet() (*accessTokenKeySet, error) {
+	set := &accessTokenKeySet{verification: map[string]*accessTokenKey{}}
+
+	signingFile := GetENV("JWT_SIGNING_KEY_FILE", "")
+	if signingFile == "" {
+		return set, nil
+	}
+
+	signing, err := loadPrivateKeyFile(signingFile)
+	if err != nil {
+		return nil, fmt.Errorf("JWT_SIGNING_KEY_FILE: %w", err)
+	}
+	set.signing = signing
+	set.verification[signing.kid] = signing
+
+	// Public keys of retired signing keys stay valid until tokens they
+	// signed have expired.
+	for _, path := range strings.Split(GetENV("JWT_VERIFICATION_KEY_FILES", ""), ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		key, err := loadPublicKeyFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("JWT_VERIFICATION_KEY_FILES %s: %w", path, err)
+		}
+		set.verification[key.kid] = key
+	}
+
+	return set, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	return block, nil
+}
+
+func loadPrivateKeyFile(path string) (*accessTokenKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", parsed)
+	}
+
+	key, err := newAccessTokenKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	key.private = signer
+	return key, nil
+}
+
+func loadPublicKeyFile(path string) (*accessTokenKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newAccessTokenKey(parsed)
+}
+
+func newAccessTokenKey(public crypto.PublicKey) (*accessTokenKey, error) {
+	var method jwt.SigningMethod
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA keys must be at least 2048 bits")
+		}
+		method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", public)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+
+	// The kid is derived from the key itself so every service computes the
+	// same one without extra configuration.
+	sum := sha256.Sum256(der)
+	return &accessTokenKey{
+		kid:    base64.RawURLEncoding.EncodeToString(sum[:12]),
+		method: method,
+		public: public,
+	}, nil
+}
+
+// JWK is the public half of an access token key in RFC 7517 form.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// AccessTokenJWKS lists every key access tokens may currently be verified
+// with, signing key first.
+func AccessTokenJWKS() (*JWKSet, error) {
+	if err := LoadAccessTokenKeys(); err != nil {
+		return nil, err
+	}
+
+	set := &JWKSet{Keys: []JWK{}}
+	if accessKeys.signing != nil {
+		set.Keys = append(set.Keys, accessKeys.signing.jwk())
+	}
+	for kid, key := range accessKeys.verification {
+		if accessKeys.signing != nil && kid == accessKeys.signing.kid {
+			continue
+		}
+		set.Keys = append(set.Keys, key.jwk())
+	}
+
+	return set, nil
+}
+
+func (k *accessTokenKey) jwk() JWK {
+	jwk := JWK{Use: "sig", Alg: k.method.Alg(), Kid: k.kid}
+	switch public := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+	return jwk
+}
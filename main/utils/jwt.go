@@ -1,32 +1,86 @@
 package utils
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"fmt"
 	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/inlovewithgo/transit-backend/main/models"
 )
 
 type Claims struct {
-	UserID uint   `json:"user_id"`
-	Email  string `json:"email"`
+	UserID       uint   `json:"user_id"`
+	Email        string `json:"email"`
+	TokenVersion int    `json:"ver"`
+	SessionID    uint   `json:"sid,omitempty"`
+	// StepUpAt is the unix time the user last re-proved their identity with
+	// this session. Sensitive routes require it to be recent.
+	StepUpAt int64 `json:"stepup_at,omitempty"`
+	// Role and Permissions are copied from the database when the token is
+	// issued. Changing a user's role bumps TokenVersion so they refresh.
+	Role        string   `json:"role,omitempty"`
+	Permissions []string `json:"perms,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateAccessToken(user *models.User) (string, error) {
-	secretKey := os.Getenv("JWT_SECRET")
-	if secretKey == "" {
-		return "", fmt.Errorf("JWT_SECRET environment variable is required")
+func (c *Claims) HasPermission(permission string) bool {
+	for _, p := range c.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// AccessTokenTTL is how long access tokens stay valid. They are meant to be
+// short-lived and renewed with a refresh token.
+func AccessTokenTTL() time.Duration {
+	ttl, err := time.ParseDuration(GetENV("JWT_EXPIRATION", "15m"))
+	if err != nil || ttl <= 0 {
+		return 15 * time.Minute
+	}
+	return ttl
+}
+
+// StepUpMaxAge is how long a step-up stays valid for sensitive routes.
+func StepUpMaxAge() time.Duration {
+	maxAge, err := time.ParseDuration(GetENV("STEP_UP_MAX_AGE", "5m"))
+	if err != nil || maxAge <= 0 {
+		return 5 * time.Minute
+	}
+	return maxAge
+}
+
+func GenerateAccessToken(user *models.User, sessionID uint, permissions []string) (string, error) {
+	return generateAccessToken(user, sessionID, permissions, 0)
+}
+
+// GenerateStepUpAccessToken issues an access token carrying a fresh StepUpAt.
+func GenerateStepUpAccessToken(user *models.User, sessionID uint, permissions []string) (string, error) {
+	return generateAccessToken(user, sessionID, permissions, time.Now().Unix())
+}
+
+func generateAccessToken(user *models.User, sessionID uint, permissions []string, stepUpAt int64) (string, error) {
+	if err := LoadAccessTokenKeys(); err != nil {
+		return "", err
 	}
 
 	// Create claims with user data
 	claims := Claims{
-		UserID: user.ID,
-		Email:  user.Email,
+		UserID:       user.ID,
+		Email:        user.Email,
+		TokenVersion: user.TokenVersion,
+		SessionID:    sessionID,
+		StepUpAt:     stepUpAt,
+		Role:         user.Role,
+		Permissions:  permissions,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)), // 24 hours
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(AccessTokenTTL())),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 			Issuer:    "transit-backend",
@@ -34,32 +88,290 @@ func GenerateAccessToken(user *models.User) (string, error) {
 		},
 	}
 
-	// Create token with claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	// Sign with the configured asymmetric key so other services can verify
+	// against the JWKS, or with JWT_SECRET when none is configured
+	if signing := accessKeys.signing; signing != nil {
+		token := jwt.NewWithClaims(signing.method, claims)
+		token.Header["kid"] = signing.kid
+		return token.SignedString(signing.private)
+	}
 
-	// Sign token with secret
-	tokenString, err := token.SignedString([]byte(secretKey))
-	if err != nil {
-		return "", err
+	secretKey := os.Getenv("JWT_SECRET")
+	if secretKey == "" {
+		return "", fmt.Errorf("JWT_SECRET environment variable is required")
 	}
 
-	return tokenString, nil
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secretKey))
 }
 
-func ValidateAccessToken(tokenString string) (*Claims, error) {
+// MFAChallengeClaims identify a user who passed the password check but still
+// has to present a second factor. Like all single-purpose tokens they are
+// signed with a key derived from JWT_SECRET, so they can never be accepted
+// as access tokens.
+type MFAChallengeClaims struct {
+	UserID uint `json:"user_id"`
+	jwt.RegisteredClaims
+}
+
+const mfaChallengeTTL = 5 * time.Minute
+
+// purposeKey derives a signing key for one kind of single-purpose token.
+func purposeKey(purpose string) ([]byte, error) {
 	secretKey := os.Getenv("JWT_SECRET")
 	if secretKey == "" {
 		return nil, fmt.Errorf("JWT_SECRET environment variable is required")
 	}
 
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil), nil
+}
+
+func GenerateMFAChallengeToken(userID uint) (string, error) {
+	key, err := purposeKey("mfa-challenge")
+	if err != nil {
+		return "", err
+	}
+
+	claims := MFAChallengeClaims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(mfaChallengeTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    "transit-backend",
+			Audience:  jwt.ClaimStrings{"mfa"},
+			Subject:   fmt.Sprintf("%d", userID),
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+}
+
+func ValidateMFAChallengeToken(tokenString string) (*MFAChallengeClaims, error) {
+	key, err := purposeKey("mfa-challenge")
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &MFAChallengeClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key, nil
+	}, jwt.WithAudience("mfa"))
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(*MFAChallengeClaims); ok && token.Valid {
+		return claims, nil
+	}
+
+	return nil, fmt.Errorf("invalid token")
+}
+
+// EmailVerificationClaims bind a verification link to the address it was
+// sent to, so it stops working if the email changes.
+type EmailVerificationClaims struct {
+	UserID uint   `json:"user_id"`
+	Email  string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+func EmailVerificationTTL() time.Duration {
+	ttl, err := time.ParseDuration(GetENV("EMAIL_VERIFICATION_TTL", "24h"))
+	if err != nil || ttl <= 0 {
+		return 24 * time.Hour
+	}
+	return ttl
+}
+
+func GenerateEmailVerificationToken(userID uint, email string) (string, error) {
+	key, err := purposeKey("email-verification")
+	if err != nil {
+		return "", err
+	}
+
+	claims := EmailVerificationClaims{
+		UserID: userID,
+		Email:  email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(EmailVerificationTTL())),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    "transit-backend",
+			Audience:  jwt.ClaimStrings{"email-verification"},
+			Subject:   fmt.Sprintf("%d", userID),
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+}
+
+func ValidateEmailVerificationToken(tokenString string) (*EmailVerificationClaims, error) {
+	key, err := purposeKey("email-verification")
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &EmailVerificationClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key, nil
+	}, jwt.WithAudience("email-verification"))
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(*EmailVerificationClaims); ok && token.Valid {
+		return claims, nil
+	}
+
+	return nil, fmt.Errorf("invalid token")
+}
+
+// MagicLinkClaims identify a passwordless login link. ID (jti) is looked up
+// in the database to make the link single-use.
+type MagicLinkClaims struct {
+	UserID uint `json:"user_id"`
+	jwt.RegisteredClaims
+}
+
+func GenerateMagicLinkToken(userID uint, tokenID string, ttl time.Duration) (string, error) {
+	key, err := purposeKey("magic-link")
+	if err != nil {
+		return "", err
+	}
+
+	claims := MagicLinkClaims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    "transit-backend",
+			Audience:  jwt.ClaimStrings{"magic-link"},
+			Subject:   fmt.Sprintf("%d", userID),
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+}
+
+func ValidateMagicLinkToken(tokenString string) (*MagicLinkClaims, error) {
+	key, err := purposeKey("magic-link")
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &MagicLinkClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key, nil
+	}, jwt.WithAudience("magic-link"))
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(*MagicLinkClaims); ok && token.Valid && claims.ID != "" {
+		return claims, nil
+	}
+
+	return nil, fmt.Errorf("invalid token")
+}
+
+// OIDCStateClaims carry what the callback of an OpenID Connect login needs
+// to finish it. They are kept in a cookie on the browser that started the
+// login, which also ties the callback to that browser.
+type OIDCStateClaims struct {
+	Provider     string `json:"provider"`
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+	jwt.RegisteredClaims
+}
+
+const OIDCStateTTL = 10 * time.Minute
+
+func GenerateOIDCStateToken(provider, state, nonce, codeVerifier string) (string, error) {
+	key, err := purposeKey("oidc-state")
+	if err != nil {
+		return "", err
+	}
+
+	claims := OIDCStateClaims{
+		Provider:     provider,
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(OIDCStateTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    "transit-backend",
+			Audience:  jwt.ClaimStrings{"oidc-state"},
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+}
+
+func ValidateOIDCStateToken(tokenString string) (*OIDCStateClaims, error) {
+	key, err := purposeKey("oidc-state")
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &OIDCStateClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key, nil
+	}, jwt.WithAudience("oidc-state"))
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(*OIDCStateClaims); ok && token.Valid {
+		return claims, nil
+	}
+
+	return nil, fmt.Errorf("invalid token")
+}
+
+// ValidateAccessToken accepts tokens signed by any configured verification
+// key, looked up by kid. HS256 is only accepted while no asymmetric signing
+// key is configured.
+func ValidateAccessToken(tokenString string) (*Claims, error) {
+	if err := LoadAccessTokenKeys(); err != nil {
+		return nil, err
+	}
+
 	// Parse token
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		if accessKeys.signing != nil {
+			kid, _ := token.Header["kid"].(string)
+			key, ok := accessKeys.verification[kid]
+			if !ok {
+				return nil, fmt.Errorf("unknown signing key %q", kid)
+			}
+			if token.Method.Alg() != key.method.Alg() {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return key.public, nil
+		}
+
 		// Validate signing method
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
+		secretKey := os.Getenv("JWT_SECRET")
+		if secretKey == "" {
+			return nil, fmt.Errorf("JWT_SECRET environment variable is required")
+		}
 		return []byte(secretKey), nil
-	})
+	}, jwt.WithValidMethods([]string{"HS256", "RS256", "EdDSA"}))
 
 	if err != nil {
 		return nil, err
@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/inlovewithgo/transit-backend/pkg/logger"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	PasswordAlgorithmArgon2id = "argon2id"
+	PasswordAlgorithmBcrypt   = "bcrypt"
+
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+	// maxArgon2PasswordBytes only bounds hashing work; argon2 itself has no
+	// input limit.
+	maxArgon2PasswordBytes = 256
+	maxBcryptPasswordBytes = 72
+)
+
+var errMalformedHash = errors.New("malformed password hash")
+
+// PasswordHashParams selects how new passwords are hashed. Stored hashes
+// made with other parameters keep verifying and are upgraded on login.
+type PasswordHashParams struct {
+	Algorithm   string
+	BcryptCost  int
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+}
+
+var (
+	passwordParamsOnce sync.Once
+	passwordParams     PasswordHashParams
+)
+
+// CurrentPasswordHashParams reads PASSWORD_HASH_ALGORITHM and the matching
+// cost settings once.
+func CurrentPasswordHashParams() PasswordHashParams {
+	passwordParamsOnce.Do(func() {
+		passwordParams = PasswordHashParams{
+			Algorithm:   strings.ToLower(GetENV("PASSWORD_HASH_ALGORITHM", PasswordAlgorithmArgon2id)),
+			BcryptCost:  envInt("PASSWORD_BCRYPT_COST", 12, bcrypt.MinCost, bcrypt.MaxCost),
+			Memory:      uint32(envInt("ARGON2_MEMORY_KIB", 64*1024, 8*1024, 4*1024*1024)),
+			Iterations:  uint32(envInt("ARGON2_ITERATIONS", 3, 1, 100)),
+			Parallelism: uint8(envInt("ARGON2_PARALLELISM", 4, 1, 64)),
+		}
+		if passwordParams.Algorithm != PasswordAlgorithmArgon2id && passwordParams.Algorithm != PasswordAlgorithmBcrypt {
+			logger.Log.Warn("Unknown PASSWORD_HASH_ALGORITHM %q, using argon2id", passwordParams.Algorithm)
+			passwordParams.Algorithm = PasswordAlgorithmArgon2id
+		}
+	})
+	return passwordParams
+}
+
+func envInt(key string, def, min, max int) int {
+	value, err := strconv.Atoi(GetENV(key, strconv.Itoa(def)))
+	if err != nil || value < min || value > max {
+		logger.Log.Warn("Invalid %s, using %d", key, def)
+		return def
+	}
+	return value
+}
+
+// MaxPasswordBytes is the longest password the configured algorithm accepts.
+func MaxPasswordBytes() int {
+	if CurrentPasswordHashParams().Algorithm == PasswordAlgorithmBcrypt {
+		return maxBcryptPasswordBytes
+	}
+	return maxArgon2PasswordBytes
+}
+
+// HashPassword hashes a password with the configured algorithm. Argon2id
+// hashes use the PHC string format:
+//
+//	$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+func HashPassword(password string) (string, error) {
+	params := CurrentPasswordHashParams()
+	if params.Algorithm == PasswordAlgorithmBcrypt {
+		bytes, err := bcrypt.GenerateFromPassword([]byte(password), params.BcryptCost)
+		return string(bytes), err
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, argon2KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// CheckPasswordHash compares a password with an Argon2id or bcrypt hash.
+func CheckPasswordHash(password, hash string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		h, err := parseArgon2Hash(hash)
+		if err != nil {
+			return false
+		}
+		key := argon2.IDKey([]byte(password), h.salt, h.iterations, h.memory, h.parallelism, uint32(len(h.key)))
+		return subtle.ConstantTimeCompare(key, h.key) == 1
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	return err == nil
+}
+
+// PasswordNeedsRehash reports whether hash was made with a different
+// algorithm or weaker parameters than HashPassword currently uses.
+func PasswordNeedsRehash(hash string) bool {
+	params := CurrentPasswordHashParams()
+
+	if strings.HasPrefix(hash, "$argon2id$") {
+		if params.Algorithm != PasswordAlgorithmArgon2id {
+			return true
+		}
+		h, err := parseArgon2Hash(hash)
+		if err != nil {
+			return true
+		}
+		return h.version != argon2.Version ||
+			h.memory != params.Memory ||
+			h.iterations != params.Iterations ||
+			h.parallelism != params.Parallelism ||
+			len(h.key) != argon2KeyLength
+	}
+
+	if params.Algorithm != PasswordAlgorithmBcrypt {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < params.BcryptCost
+}
+
+type argon2Hash struct {
+	version     int
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func parseArgon2Hash(hash string) (*argon2Hash, error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != PasswordAlgorithmArgon2id {
+		return nil, errMalformedHash
+	}
+
+	var h argon2Hash
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &h.version); err != nil {
+		return nil, errMalformedHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.iterations, &h.parallelism); err != nil {
+		return nil, errMalformedHash
+	}
+	if h.iterations == 0 || h.parallelism == 0 {
+		return nil, errMalformedHash
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, errMalformedHash
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.key) == 0 {
+		return nil, errMalformedHash
+	}
+
+	return &h, nil
+}
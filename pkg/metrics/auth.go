@@ -0,0 +1,24 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// LoginAttempts counts login outcomes: success, failure, throttled.
+	LoginAttempts = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_login_attempts_total",
+			Help: "Total number of login attempts by outcome",
+		},
+		[]string{"result"},
+	)
+
+	AccountLockouts = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "auth_account_lockouts_total",
+			Help: "Total number of temporary account lockouts after repeated failed logins",
+		},
+	)
+)